@@ -0,0 +1,70 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+)
+
+// ActorHeader is the request header that names who is making a change, when the authentication middleware
+// has not put an actor in the request context.
+const ActorHeader = "X-Admin-Actor"
+
+// Middleware authenticates requests to the admin API before passing them on to next. It should put the name
+// of the authenticated user in the request context with ContextWithActor, so that changes to dead-lettered
+// records are audited against them.
+type Middleware func(next http.Handler) http.Handler
+
+type actorKey struct{}
+
+// ContextWithActor returns a copy of ctx that holds the name of the user making the request.
+func ContextWithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFromContext returns the name of the user making the request, or an empty string if there is none.
+func ActorFromContext(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+// NoAuth lets every request through. Only use it if the admin API is protected in some other way, e.g. by
+// the middleware of the router it is mounted on.
+func NoAuth() Middleware {
+	return func(next http.Handler) http.Handler {
+		return next
+	}
+}
+
+// BasicAuth only lets through requests with the username and password of one of users, which is indexed by
+// username. The username is the actor of any changes made by the request.
+func BasicAuth(realm string, users map[string]string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, pass, ok := r.BasicAuth()
+			expected, found := users[user]
+			if !ok || !found || subtle.ConstantTimeCompare([]byte(pass), []byte(expected)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Basic realm="`+realm+`"`)
+				writeError(w, http.StatusUnauthorized, "unauthorized")
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(ContextWithActor(r.Context(), user)))
+		})
+	}
+}
+
+// BearerToken only lets through requests with the token in their Authorization header, and none if the token
+// is empty. As the token does not identify a user, changes are made by the actor named in the ActorHeader.
+func BearerToken(token string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if token == "" || subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) != 1 {
+				writeError(w, http.StatusUnauthorized, "unauthorized")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
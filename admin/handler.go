@@ -0,0 +1,401 @@
+package admin
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/revdaalex/kafka-consumer-go/config"
+	"github.com/revdaalex/kafka-consumer-go/data/deadletter"
+	"github.com/revdaalex/kafka-consumer-go/data/retry/stats"
+	"github.com/revdaalex/kafka-consumer-go/status"
+)
+
+// maxBodySize is the largest request body that is read, which limits the size of an edited payload.
+const maxBodySize = 10 << 20
+
+type deadLetterRepo interface {
+	List(ctx context.Context, f deadletter.Filter) ([]deadletter.Record, error)
+	Get(ctx context.Context, id int64) (deadletter.Record, error)
+	Requeue(ctx context.Context, id int64, payload []byte, actor string) error
+	Delete(ctx context.Context, id int64, actor string) error
+	Purge(ctx context.Context, f deadletter.Filter, actor string) (int64, error)
+	AuditLog(ctx context.Context, retryID int64, limit int) ([]deadletter.AuditEntry, error)
+}
+
+type statsRepo interface {
+	Counts(ctx context.Context) ([]stats.Count, error)
+}
+
+type handler struct {
+	cfg *config.Config
+	// deadLetters and stats are nil unless retries are kept in a database
+	deadLetters deadLetterRepo
+	stats       statsRepo
+}
+
+// NewHandler returns the admin API for the consumer configured by cfg, which can be mounted on any path with
+// http.StripPrefix. Every request is passed through auth first. The retry and dead-letter endpoints are only
+// available when retries are kept in a database, rather than in Kafka or a custom retry store.
+func NewHandler(cfg *config.Config, auth Middleware) (http.Handler, error) {
+	if auth == nil {
+		return nil, errors.New("admin: an authentication middleware is required, use NoAuth() if the API is protected elsewhere")
+	}
+
+	h := &handler{cfg: cfg}
+	if cfg.UseDBForRetryQueue && cfg.RetryStore == nil {
+		db, err := cfg.DB()
+		if err != nil {
+			return nil, fmt.Errorf("admin: error connecting to database: %w", err)
+		}
+		h.deadLetters = deadletter.NewRepositoryForDriver(db, cfg.DBDriver())
+		h.stats = stats.NewRepository(db)
+	}
+
+	return auth(h.routes()), nil
+}
+
+func (h *handler) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", h.getOnly(h.status))
+	mux.HandleFunc("/topics", h.getOnly(h.topics))
+	mux.HandleFunc("/retries", h.getOnly(h.withDB(h.retries)))
+	mux.HandleFunc("/deadletters", h.getOnly(h.withDB(h.listDeadLetters)))
+	mux.HandleFunc("/deadletters/", h.withDB(h.deadLetter))
+	return mux
+}
+
+func (h *handler) status(w http.ResponseWriter, _ *http.Request) {
+	retries := "kafka"
+	switch {
+	case h.cfg.UseDBForRetryQueue && h.cfg.RetryStore != nil:
+		retries = "store"
+	case h.cfg.UseDBForRetryQueue:
+		retries = "database"
+	}
+
+	writeJSON(w, http.StatusOK, struct {
+		Group    string          `json:"group"`
+		WorkerID string          `json:"worker_id,omitempty"`
+		Retries  string          `json:"retries"`
+		Consumer status.Snapshot `json:"consumer"`
+	}{
+		Group:    h.cfg.Group,
+		WorkerID: h.cfg.WorkerID,
+		Retries:  retries,
+		Consumer: h.cfg.Status().Snapshot(),
+	})
+}
+
+func (h *handler) topics(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"topics": newTopicChains(h.cfg)})
+}
+
+func (h *handler) retries(w http.ResponseWriter, r *http.Request) {
+	counts, err := h.stats.Counts(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"topics":  stats.ByState(counts),
+		"backlog": stats.Backlog(counts),
+	})
+}
+
+func (h *handler) listDeadLetters(w http.ResponseWriter, r *http.Request) {
+	f, err := filterFromQuery(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	recs, err := h.deadLetters.List(r.Context(), f)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	res := struct {
+		Records   []record `json:"records"`
+		NextAfter int64    `json:"next_after,omitempty"`
+	}{Records: []record{}}
+
+	for _, rec := range recs {
+		res.Records = append(res.Records, newRecord(rec))
+	}
+
+	if len(recs) > 0 && len(recs) == f.Limit {
+		res.NextAfter = recs[len(recs)-1].ID
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+// deadLetter routes the requests for a single dead-lettered record, for purging and for the audit log.
+func (h *handler) deadLetter(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/deadletters/"), "/")
+
+	switch {
+	case path == "purge":
+		h.postOnly(h.purge)(w, r)
+	case path == "audit":
+		h.getOnly(h.auditLog)(w, r)
+	case strings.HasSuffix(path, "/requeue"):
+		id, ok := recordID(w, strings.TrimSuffix(path, "/requeue"))
+		if !ok {
+			return
+		}
+		h.postOnly(func(w http.ResponseWriter, r *http.Request) {
+			h.requeue(w, r, id)
+		})(w, r)
+	default:
+		id, ok := recordID(w, path)
+		if !ok {
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			h.getDeadLetter(w, r, id)
+		case http.MethodDelete:
+			h.deleteDeadLetter(w, r, id)
+		default:
+			w.Header().Set("Allow", "GET, DELETE")
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	}
+}
+
+func (h *handler) getDeadLetter(w http.ResponseWriter, r *http.Request, id int64) {
+	rec, err := h.deadLetters.Get(r.Context(), id)
+	if err != nil {
+		writeRepoError(w, err)
+		return
+	}
+
+	entries, err := h.deadLetters.AuditLog(r.Context(), id, deadletter.MaxListLimit)
+	if err != nil {
+		writeRepoError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"record": newRecord(rec),
+		"audit":  newAuditEntries(entries),
+	})
+}
+
+func (h *handler) deleteDeadLetter(w http.ResponseWriter, r *http.Request, id int64) {
+	if err := h.deadLetters.Delete(r.Context(), id, actor(r)); err != nil {
+		writeRepoError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// requeueRequest holds an optional edited payload, as text or base64 encoded.
+type requeueRequest struct {
+	Payload       *string `json:"payload"`
+	PayloadBase64 *string `json:"payload_base64"`
+}
+
+func (h *handler) requeue(w http.ResponseWriter, r *http.Request, id int64) {
+	var req requeueRequest
+	if err := decodeBody(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var payload []byte
+	switch {
+	case req.Payload != nil && req.PayloadBase64 != nil:
+		writeError(w, http.StatusBadRequest, "only one of payload and payload_base64 can be given")
+		return
+	case req.Payload != nil:
+		payload = []byte(*req.Payload)
+	case req.PayloadBase64 != nil:
+		var err error
+		if payload, err = base64.StdEncoding.DecodeString(*req.PayloadBase64); err != nil {
+			writeError(w, http.StatusBadRequest, "payload_base64 is not valid base64")
+			return
+		}
+	}
+
+	if err := h.deadLetters.Requeue(r.Context(), id, payload, actor(r)); err != nil {
+		writeRepoError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// purgeRequest selects the records to purge. As purging cannot be undone, All must be set to purge every
+// dead-lettered record.
+type purgeRequest struct {
+	Topic         string    `json:"topic"`
+	ErrorContains string    `json:"error_contains"`
+	From          time.Time `json:"from"`
+	To            time.Time `json:"to"`
+	All           bool      `json:"all"`
+}
+
+func (h *handler) purge(w http.ResponseWriter, r *http.Request) {
+	var req purgeRequest
+	if err := decodeBody(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	f := deadletter.Filter{Topic: req.Topic, ErrorContains: req.ErrorContains, From: req.From, To: req.To}
+	if f == (deadletter.Filter{}) && !req.All {
+		writeError(w, http.StatusBadRequest, "a filter is required, or all must be true to purge every dead-lettered record")
+		return
+	}
+
+	n, err := h.deadLetters.Purge(r.Context(), f, actor(r))
+	if err != nil {
+		writeRepoError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]int64{"purged": n})
+}
+
+func (h *handler) auditLog(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	retryID, err := intParam(q.Get("retry_id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "retry_id must be a number")
+		return
+	}
+
+	limit, err := intParam(q.Get("limit"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "limit must be a number")
+		return
+	}
+
+	entries, err := h.deadLetters.AuditLog(r.Context(), retryID, int(limit))
+	if err != nil {
+		writeRepoError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"entries": newAuditEntries(entries)})
+}
+
+// withDB only calls next if retries are kept in a database.
+func (h *handler) withDB(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.deadLetters == nil || h.stats == nil {
+			writeError(w, http.StatusNotImplemented, "retries are not kept in a database")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// recordID parses the ID of a record from path, responding with not found if it is not an ID.
+func recordID(w http.ResponseWriter, path string) (int64, bool) {
+	id, err := strconv.ParseInt(path, 10, 64)
+	if err != nil || id <= 0 {
+		writeError(w, http.StatusNotFound, "not found")
+		return 0, false
+	}
+	return id, true
+}
+
+func (h *handler) getOnly(next http.HandlerFunc) http.HandlerFunc {
+	return allowMethod(http.MethodGet, next)
+}
+
+func (h *handler) postOnly(next http.HandlerFunc) http.HandlerFunc {
+	return allowMethod(http.MethodPost, next)
+}
+
+func allowMethod(method string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// actor returns who is making the request, preferring the actor set by the authentication middleware.
+func actor(r *http.Request) string {
+	if a := ActorFromContext(r.Context()); a != "" {
+		return a
+	}
+	return strings.TrimSpace(r.Header.Get(ActorHeader))
+}
+
+func writeRepoError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, deadletter.ErrNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, deadletter.ErrNoActor):
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("%s, set the %s header", err, ActorHeader))
+	default:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+// decodeBody decodes a JSON request body into v, leaving v as it is if the body is empty.
+func decodeBody(r *http.Request, v interface{}) error {
+	err := json.NewDecoder(io.LimitReader(r.Body, maxBodySize)).Decode(v)
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("invalid request body: %w", err)
+	}
+	return nil
+}
+
+func filterFromQuery(r *http.Request) (deadletter.Filter, error) {
+	q := r.URL.Query()
+	f := deadletter.Filter{Topic: q.Get("topic"), ErrorContains: q.Get("error")}
+
+	var err error
+	if f.From, err = timeParam(q.Get("from")); err != nil {
+		return f, errors.New("from must be an RFC 3339 time")
+	}
+	if f.To, err = timeParam(q.Get("to")); err != nil {
+		return f, errors.New("to must be an RFC 3339 time")
+	}
+	if f.AfterID, err = intParam(q.Get("after")); err != nil {
+		return f, errors.New("after must be a number")
+	}
+
+	limit, err := intParam(q.Get("limit"))
+	if err != nil {
+		return f, errors.New("limit must be a number")
+	}
+
+	f.Limit = deadletter.Filter{Limit: int(limit)}.PageSize()
+	return f, nil
+}
+
+func intParam(v string) (int64, error) {
+	if v == "" {
+		return 0, nil
+	}
+	return strconv.ParseInt(v, 10, 64)
+}
+
+func timeParam(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
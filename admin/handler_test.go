@@ -0,0 +1,287 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-test/deep"
+
+	"github.com/revdaalex/kafka-consumer-go/config"
+	"github.com/revdaalex/kafka-consumer-go/data"
+)
+
+func TestNewHandler(t *testing.T) {
+	t.Run("it requires an authentication middleware", func(t *testing.T) {
+		if _, err := NewHandler(newKafkaConfigForTests(t), nil); err == nil {
+			t.Error("expected an error without an authentication middleware")
+		}
+	})
+
+	t.Run("it authenticates every request", func(t *testing.T) {
+		h, err := NewHandler(newKafkaConfigForTests(t), BasicAuth("admin", map[string]string{"jane": "secret"}))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		req := httptest.NewRequest(http.MethodGet, "/status", nil)
+		req.SetBasicAuth("jane", "wrong")
+		if res := serve(h, req); res.Code != http.StatusUnauthorized {
+			t.Errorf("expected an unauthorized response, got %d", res.Code)
+		}
+
+		req.SetBasicAuth("jane", "secret")
+		if res := serve(h, req); res.Code != http.StatusOK {
+			t.Errorf("expected an OK response, got %d", res.Code)
+		}
+	})
+}
+
+func TestHandler_Topics(t *testing.T) {
+	h, _ := NewHandler(newKafkaConfigForTests(t), NoAuth())
+
+	res := serve(h, httptest.NewRequest(http.MethodGet, "/topics", nil))
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected an OK response, got %d", res.Code)
+	}
+
+	var got struct {
+		Topics []topicChain `json:"topics"`
+	}
+	decode(t, res, &got)
+
+	exp := []topicChain{{
+		Name: "product",
+		Chain: []chainTopic{
+			{Name: "product", Key: "product"},
+			{Name: "retry1.group.product", Key: "product", DelaySeconds: 120},
+			{Name: "deadLetter.group.product", Key: "product"},
+		},
+	}}
+	if diff := deep.Equal(exp, got.Topics); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestHandler_Status(t *testing.T) {
+	cfg := newKafkaConfigForTests(t)
+	cfg.Status().Started()
+	cfg.Status().AddClaims(map[string][]int32{"product": {0, 1}})
+	h, _ := NewHandler(cfg, NoAuth())
+
+	res := serve(h, httptest.NewRequest(http.MethodGet, "/status", nil))
+
+	var got struct {
+		Group    string `json:"group"`
+		Retries  string `json:"retries"`
+		Consumer struct {
+			State  string             `json:"state"`
+			Claims map[string][]int32 `json:"claims"`
+		} `json:"consumer"`
+	}
+	decode(t, res, &got)
+
+	if got.Group != "group" || got.Retries != "kafka" || got.Consumer.State != "running" || len(got.Consumer.Claims["product"]) != 2 {
+		t.Errorf("unexpected status: %+v", got)
+	}
+}
+
+func TestHandler_DeadLetters(t *testing.T) {
+	cfg := newDBConfigForTests(t)
+	db, _ := cfg.DB()
+	_, err := db.Exec(`INSERT INTO kafka_consumer_retries(topic, payload, payload_headers, payload_key, kafka_offset, kafka_partition, attempts, deadlettered, errored, last_error)
+		VALUES
+			('product', '{"sku":"SKU-1"}', '[{"key":"Zm9v","value":"YmFy"}]', 'SKU-1', 1, 0, 2, true, true, 'timeout'),
+			('product', '{"sku":"SKU-2"}', '[]', 'SKU-2', 2, 0, 2, true, true, 'invalid'),
+			('product', '{"sku":"SKU-3"}', '[]', 'SKU-3', 3, 0, 1, false, true, 'timeout');`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := NewHandler(cfg, BasicAuth("admin", map[string]string{"jane": "secret"}))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	request := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.SetBasicAuth("jane", "secret")
+		return serve(h, req)
+	}
+
+	t.Run("it counts the retries by topic and state", func(t *testing.T) {
+		res := request(http.MethodGet, "/retries", "")
+
+		var got struct {
+			Topics  map[string]map[string]int64 `json:"topics"`
+			Backlog map[string]map[string]int64 `json:"backlog"`
+		}
+		decode(t, res, &got)
+
+		if got.Topics["product"]["deadlettered"] != 2 || got.Topics["product"]["pending"] != 1 || got.Backlog["product"]["1"] != 1 {
+			t.Errorf("unexpected counts: %+v", got)
+		}
+	})
+
+	t.Run("it lists dead-lettered records in pages", func(t *testing.T) {
+		res := request(http.MethodGet, "/deadletters?topic=product&limit=1", "")
+
+		var got struct {
+			Records   []record `json:"records"`
+			NextAfter int64    `json:"next_after"`
+		}
+		decode(t, res, &got)
+
+		if len(got.Records) != 1 || got.Records[0].Key != "SKU-1" || got.NextAfter != got.Records[0].ID {
+			t.Fatalf("unexpected page: %+v", got)
+		}
+
+		if exp := []header{{Key: "foo", Value: "bar"}}; deep.Equal(exp, got.Records[0].Headers) != nil {
+			t.Errorf("expected the headers to be decoded, got %+v", got.Records[0].Headers)
+		}
+
+		res = request(http.MethodGet, "/deadletters?limit=1&after=1", "")
+		decode(t, res, &got)
+
+		if len(got.Records) != 1 || got.Records[0].Key != "SKU-2" {
+			t.Errorf("unexpected second page: %+v", got)
+		}
+	})
+
+	t.Run("it rejects invalid filters", func(t *testing.T) {
+		if res := request(http.MethodGet, "/deadletters?from=yesterday", ""); res.Code != http.StatusBadRequest {
+			t.Errorf("expected a bad request response, got %d", res.Code)
+		}
+	})
+
+	t.Run("it requeues a record with an edited payload and audits it", func(t *testing.T) {
+		if res := request(http.MethodPost, "/deadletters/1/requeue", `{"payload":"{\"sku\":\"SKU-1b\"}"}`); res.Code != http.StatusNoContent {
+			t.Fatalf("expected a no content response, got %d: %s", res.Code, res.Body)
+		}
+
+		if res := request(http.MethodGet, "/deadletters/1", ""); res.Code != http.StatusNotFound {
+			t.Errorf("expected a requeued record not to be found, got %d", res.Code)
+		}
+
+		res := request(http.MethodGet, "/deadletters/audit?retry_id=1", "")
+
+		var got struct {
+			Entries []auditEntry `json:"entries"`
+		}
+		decode(t, res, &got)
+
+		if len(got.Entries) != 1 || got.Entries[0].Action != "requeue" || got.Entries[0].Actor != "jane" {
+			t.Errorf("unexpected audit log: %+v", got)
+		}
+	})
+
+	t.Run("it requires a filter to purge records", func(t *testing.T) {
+		if res := request(http.MethodPost, "/deadletters/purge", `{}`); res.Code != http.StatusBadRequest {
+			t.Errorf("expected a bad request response, got %d", res.Code)
+		}
+
+		res := request(http.MethodPost, "/deadletters/purge", `{"topic":"product"}`)
+
+		var got map[string]int64
+		decode(t, res, &got)
+
+		if got["purged"] != 1 {
+			t.Errorf("expected 1 record to be purged, got %+v", got)
+		}
+	})
+
+	t.Run("it only allows the methods of each endpoint", func(t *testing.T) {
+		if res := request(http.MethodPost, "/deadletters/2", ""); res.Code != http.StatusMethodNotAllowed {
+			t.Errorf("expected a method not allowed response, got %d", res.Code)
+		}
+
+		if res := request(http.MethodGet, "/deadletters/2/requeue", ""); res.Code != http.StatusMethodNotAllowed {
+			t.Errorf("expected a method not allowed response, got %d", res.Code)
+		}
+	})
+}
+
+func TestHandler_DeadLettersWithoutDB(t *testing.T) {
+	h, _ := NewHandler(newKafkaConfigForTests(t), NoAuth())
+
+	if res := serve(h, httptest.NewRequest(http.MethodGet, "/deadletters", nil)); res.Code != http.StatusNotImplemented {
+		t.Errorf("expected a not implemented response, got %d", res.Code)
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	var gotActor string
+	h := BearerToken("token")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotActor = actor(r)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/status", nil)
+	if res := serve(h, req); res.Code != http.StatusUnauthorized {
+		t.Errorf("expected an unauthorized response, got %d", res.Code)
+	}
+
+	req.Header.Set("Authorization", "Bearer token")
+	req.Header.Set(ActorHeader, "jane")
+	if res := serve(h, req); res.Code != http.StatusOK || gotActor != "jane" {
+		t.Errorf("expected the request to be made by the actor in the header, got %d and '%s'", res.Code, gotActor)
+	}
+}
+
+func newKafkaConfigForTests(t *testing.T) *config.Config {
+	t.Helper()
+
+	cfg, err := config.NewBuilder().
+		SetKafkaHost([]string{"broker"}).
+		SetKafkaGroup("group").
+		SetSourceTopics([]string{"product"}).
+		SetRetryIntervals([]int{120}).
+		Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func newDBConfigForTests(t *testing.T) *config.Config {
+	t.Helper()
+
+	cfg, err := config.NewBuilder().
+		SetKafkaHost([]string{"broker"}).
+		SetKafkaGroup("group").
+		SetSourceTopics([]string{"product"}).
+		SetRetryIntervals([]int{120}).
+		UseDbForRetries(true).
+		SetDBDriver(data.DriverSQLite).
+		SetDBSchema(":memory:").
+		Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := cfg.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	if err := data.MigrateSQLiteDatabase(db); err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func serve(h http.Handler, req *http.Request) *httptest.ResponseRecorder {
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, req)
+	return res
+}
+
+func decode(t *testing.T, res *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		t.Fatalf("unable to decode response %d: %s", res.Code, err)
+	}
+}
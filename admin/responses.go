@@ -0,0 +1,145 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+	"unicode/utf8"
+
+	"github.com/revdaalex/kafka-consumer-go/config"
+	"github.com/revdaalex/kafka-consumer-go/data/deadletter"
+	"github.com/revdaalex/kafka-consumer-go/data/retry/model"
+)
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+type topicChain struct {
+	Name  string         `json:"name"`
+	Chain []chainTopic   `json:"chain"`
+	DB    []chainDBRetry `json:"db_retries,omitempty"`
+}
+
+type chainTopic struct {
+	Name         string  `json:"name"`
+	Key          string  `json:"key"`
+	DelaySeconds float64 `json:"delay_seconds"`
+}
+
+type chainDBRetry struct {
+	Sequence        uint8   `json:"sequence"`
+	IntervalSeconds float64 `json:"interval_seconds"`
+	BatchSize       int     `json:"batch_size"`
+}
+
+type header struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// record is a dead-lettered record in a response. Its payload is included as text if it is valid UTF-8,
+// and is always included base64 encoded.
+type record struct {
+	ID             int64      `json:"id"`
+	Topic          string     `json:"topic"`
+	Key            string     `json:"key"`
+	Payload        string     `json:"payload,omitempty"`
+	PayloadBase64  []byte     `json:"payload_base64"`
+	ContentType    string     `json:"content_type"`
+	Headers        []header   `json:"headers"`
+	KafkaPartition int32      `json:"kafka_partition"`
+	KafkaOffset    int64      `json:"kafka_offset"`
+	KafkaTimestamp *time.Time `json:"kafka_timestamp,omitempty"`
+	Attempts       uint8      `json:"attempts"`
+	LastError      string     `json:"last_error"`
+	CreatedAt      time.Time  `json:"created_at"`
+	DeadletteredAt time.Time  `json:"deadlettered_at"`
+}
+
+type auditEntry struct {
+	ID        int64     `json:"id"`
+	Action    string    `json:"action"`
+	Actor     string    `json:"actor"`
+	RetryID   int64     `json:"retry_id,omitempty"`
+	Topic     string    `json:"topic,omitempty"`
+	Affected  int64     `json:"affected"`
+	Details   string    `json:"details"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func newTopicChains(cfg *config.Config) []topicChain {
+	chains := []topicChain{}
+	for _, name := range cfg.MainTopics() {
+		main, ok := cfg.TopicMap[config.TopicKey(name)]
+		if !ok {
+			continue
+		}
+
+		c := topicChain{Name: name, Chain: []chainTopic{}}
+		for t := main; t != nil; t = t.Next {
+			c.Chain = append(c.Chain, chainTopic{Name: t.Name, Key: string(t.Key), DelaySeconds: t.Delay.Seconds()})
+		}
+
+		if cfg.UseDBForRetryQueue {
+			for _, rc := range cfg.DBRetries[name] {
+				c.DB = append(c.DB, chainDBRetry{Sequence: rc.Sequence, IntervalSeconds: rc.Interval.Seconds(), BatchSize: cfg.DBRetries.BatchSizeForTopic(name)})
+			}
+		}
+
+		chains = append(chains, c)
+	}
+	return chains
+}
+
+func newRecord(rec deadletter.Record) record {
+	r := record{
+		ID:             rec.ID,
+		Topic:          rec.Topic,
+		Key:            string(rec.PayloadKey),
+		PayloadBase64:  rec.Payload,
+		ContentType:    rec.ContentType,
+		Headers:        []header{},
+		KafkaPartition: rec.KafkaPartition,
+		KafkaOffset:    rec.KafkaOffset,
+		Attempts:       rec.Attempts,
+		LastError:      rec.LastError,
+		CreatedAt:      rec.CreatedAt,
+		DeadletteredAt: rec.DeadletteredAt,
+	}
+
+	if utf8.Valid(rec.Payload) {
+		r.Payload = string(rec.Payload)
+	}
+
+	if !rec.KafkaTimestamp.IsZero() {
+		ts := rec.KafkaTimestamp
+		r.KafkaTimestamp = &ts
+	}
+
+	if headers, err := model.DecodeHeaders(rec.PayloadHeaders); err == nil {
+		for _, h := range headers {
+			r.Headers = append(r.Headers, header{Key: string(h.Key), Value: string(h.Value)})
+		}
+	}
+
+	return r
+}
+
+func newAuditEntries(entries []deadletter.AuditEntry) []auditEntry {
+	res := []auditEntry{}
+	for _, e := range entries {
+		res = append(res, auditEntry(e))
+	}
+	return res
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, errorResponse{Error: msg})
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/revdaalex/kafka-consumer-go/data/retry/stats"
+)
+
+func (c *cli) backlog(ctx context.Context, args []string) error {
+	cmd := c.newCommand("backlog", "[flags]", "Prints the number of DB retries of each topic that are waiting for, or being processed at, each retry interval, along with the parked and dead-lettered retries.")
+	if _, err := cmd.parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := cmd.dbConfig()
+	if err != nil {
+		return err
+	}
+	db, err := cfg.DB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	counts, err := stats.NewRepository(db).Counts(ctx)
+	if err != nil {
+		return err
+	}
+	backlog := stats.Backlog(counts)
+	byState := stats.ByState(counts)
+
+	// the configured topics come first, in order, followed by any others that have retries
+	topics := cfg.MainTopics()
+	var others []string
+	for topic := range byState {
+		if _, ok := cfg.DBRetries[topic]; !ok {
+			others = append(others, topic)
+		}
+	}
+	sort.Strings(others)
+	topics = append(topics, others...)
+
+	w := newTabWriter(c.stdout)
+	fmt.Fprintln(w, "TOPIC\tSEQUENCE\tINTERVAL\tRETRIES")
+	for _, topic := range topics {
+		sequences := map[uint8]bool{}
+		for _, rc := range cfg.DBRetries[topic] {
+			sequences[rc.Sequence] = true
+			fmt.Fprintf(w, "%s\t%d\t%s\t%d\n", topic, rc.Sequence, rc.Interval, backlog[topic][rc.Sequence])
+		}
+
+		var unknown []int
+		for seq := range backlog[topic] {
+			if !sequences[seq] {
+				unknown = append(unknown, int(seq))
+			}
+		}
+		sort.Ints(unknown)
+		for _, seq := range unknown {
+			fmt.Fprintf(w, "%s\t%d\t-\t%d\n", topic, seq, backlog[topic][uint8(seq)])
+		}
+
+		fmt.Fprintf(w, "%s\tparked\t-\t%d\n", topic, byState[topic][stats.StateParked])
+		fmt.Fprintf(w, "%s\tdeadlettered\t-\t%d\n", topic, byState[topic][stats.StateDeadlettered])
+	}
+	return w.Flush()
+}
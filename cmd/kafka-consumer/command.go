@@ -0,0 +1,145 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/revdaalex/kafka-consumer-go/config"
+)
+
+const envPrefix = "KAFKA_CONSUMER_"
+
+// command holds the flags of a command, which include the flags of the consumer configuration.
+type command struct {
+	*flag.FlagSet
+	c *cli
+
+	kafkaHost         string
+	retryKafkaHost    string
+	kafkaGroup        string
+	topics            string
+	retryIntervals    string
+	kafkaSASLUser     string
+	kafkaSASLPassword string
+	tlsEnable         bool
+	tlsSkipVerifyPeer bool
+	dbRetries         bool
+	dbDriver          string
+	dbHost            string
+	dbPort            int
+	dbSchema          string
+	dbUser            string
+	dbPass            string
+}
+
+func (c *cli) newCommand(name, args, description string) *command {
+	cmd := &command{FlagSet: flag.NewFlagSet(name, flag.ContinueOnError), c: c}
+	cmd.SetOutput(c.stderr)
+	cmd.Usage = func() {
+		fmt.Fprintf(c.stderr, "Usage: kafka-consumer %s %s\n\n%s\n\nFlags:\n", name, args, description)
+		cmd.PrintDefaults()
+	}
+
+	cmd.StringVar(&cmd.kafkaHost, "kafka-host", "", "comma separated `hosts` of the Kafka cluster that the main topics are on")
+	cmd.StringVar(&cmd.retryKafkaHost, "retry-kafka-host", "", "comma separated `hosts` of the Kafka cluster that the retry topics are on, if different")
+	cmd.StringVar(&cmd.kafkaGroup, "kafka-group", "", "the consumer `group`")
+	cmd.StringVar(&cmd.topics, "topics", "", "comma separated main `topics`")
+	cmd.StringVar(&cmd.retryIntervals, "retry-intervals", "", "comma separated retry `intervals` in seconds")
+	cmd.StringVar(&cmd.kafkaSASLUser, "kafka-sasl-user", "", "the `user` for SASL/PLAIN authentication with Kafka")
+	cmd.StringVar(&cmd.kafkaSASLPassword, "kafka-sasl-password", "", "the `password` for SASL/PLAIN authentication with Kafka")
+	cmd.BoolVar(&cmd.tlsEnable, "tls-enable", false, "connect to Kafka and the database with TLS")
+	cmd.BoolVar(&cmd.tlsSkipVerifyPeer, "tls-skip-verify-peer", false, "do not verify the TLS certificates of Kafka and the database")
+	cmd.BoolVar(&cmd.dbRetries, "db-retries", false, "retries are kept in the database")
+	cmd.StringVar(&cmd.dbDriver, "db-driver", "postgres", "the database `driver`, either postgres, mysql or sqlite")
+	cmd.StringVar(&cmd.dbHost, "db-host", "", "the database `host`")
+	cmd.IntVar(&cmd.dbPort, "db-port", 5432, "the database `port`")
+	cmd.StringVar(&cmd.dbSchema, "db-schema", "", "the database `schema`, or the path of the database file with sqlite")
+	cmd.StringVar(&cmd.dbUser, "db-user", "", "the database `user`")
+	cmd.StringVar(&cmd.dbPass, "db-pass", "", "the database `password`")
+
+	return cmd
+}
+
+// parse sets the flags from the environment and then from args, and returns the positional arguments. Unlike
+// flag.FlagSet.Parse, flags may come after positional arguments.
+func (cmd *command) parse(args []string) ([]string, error) {
+	var err error
+	cmd.VisitAll(func(f *flag.Flag) {
+		name := envPrefix + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		if v, ok := cmd.c.lookupEnv(name); ok && err == nil {
+			if setErr := f.Value.Set(v); setErr != nil {
+				err = fmt.Errorf("invalid value '%s' for %s: %w", v, name, setErr)
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var positional []string
+	for {
+		if err := cmd.Parse(args); err != nil {
+			if err == flag.ErrHelp {
+				return nil, err
+			}
+			return nil, errUsage
+		}
+
+		args = cmd.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// config builds the consumer configuration from the flags.
+func (cmd *command) config() (*config.Config, error) {
+	intervals, err := parseIntervals(cmd.retryIntervals)
+	if err != nil {
+		return nil, err
+	}
+
+	return config.NewBuilder().
+		SetKafkaHost(splitList(cmd.kafkaHost)).
+		SetRetryKafkaHost(splitList(cmd.retryKafkaHost)).
+		SetKafkaGroup(cmd.kafkaGroup).
+		SetSourceTopics(splitList(cmd.topics)).
+		SetRetryIntervals(intervals).
+		SetKafkaSASL(cmd.kafkaSASLUser, cmd.kafkaSASLPassword).
+		EnableTLS(cmd.tlsEnable).
+		SkipTLSVerifyPeer(cmd.tlsSkipVerifyPeer).
+		UseDbForRetries(cmd.dbRetries).
+		SetDBDriver(cmd.dbDriver).
+		SetDBHost(cmd.dbHost).
+		SetDBPort(cmd.dbPort).
+		SetDBSchema(cmd.dbSchema).
+		SetDBUser(cmd.dbUser).
+		SetDBPass(cmd.dbPass).
+		Config()
+}
+
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func parseIntervals(s string) ([]int, error) {
+	var intervals []int
+	for _, item := range splitList(s) {
+		i, err := strconv.Atoi(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid retry interval '%s': %w", item, err)
+		}
+		intervals = append(intervals, i)
+	}
+	return intervals, nil
+}
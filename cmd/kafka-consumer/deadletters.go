@@ -0,0 +1,370 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/revdaalex/kafka-consumer-go/config"
+	"github.com/revdaalex/kafka-consumer-go/data/deadletter"
+	"github.com/revdaalex/kafka-consumer-go/data/retry/model"
+)
+
+const deadLettersUsage = `Usage: kafka-consumer deadletters <subcommand> [flags]
+
+Subcommands:
+  list                 list dead-lettered records
+  show <id>            print a dead-lettered record and its audit log
+  requeue <id>         retry a dead-lettered record again, optionally with an edited payload
+  delete <id>          delete a dead-lettered record
+  purge                delete the dead-lettered records matching a filter
+  audit                print the audit log of changes to dead-lettered records
+
+Run 'kafka-consumer deadletters <subcommand> -h' for the flags of a subcommand.
+`
+
+// errDBRetriesRequired is returned by commands that read the retries table, when retries are not kept in the database.
+var errDBRetriesRequired = errors.New("retries are not kept in the database, set -db-retries to use this command")
+
+func (c *cli) deadLetters(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		fmt.Fprint(c.stderr, deadLettersUsage)
+		return errUsage
+	}
+
+	switch args[0] {
+	case "list":
+		return c.listDeadLetters(ctx, args[1:])
+	case "show":
+		return c.showDeadLetter(ctx, args[1:])
+	case "requeue":
+		return c.requeueDeadLetter(ctx, args[1:])
+	case "delete":
+		return c.deleteDeadLetter(ctx, args[1:])
+	case "purge":
+		return c.purgeDeadLetters(ctx, args[1:])
+	case "audit":
+		return c.deadLetterAuditLog(ctx, args[1:])
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(c.stdout, deadLettersUsage)
+		return nil
+	default:
+		fmt.Fprintf(c.stderr, "unknown subcommand '%s'\n\n%s", args[0], deadLettersUsage)
+		return errUsage
+	}
+}
+
+// filterFlags are the flags that select dead-lettered records.
+type filterFlags struct {
+	topic         string
+	errorContains string
+	from          string
+	to            string
+}
+
+func (f *filterFlags) register(cmd *command) {
+	cmd.StringVar(&f.topic, "topic", "", "only records of the main `topic`")
+	cmd.StringVar(&f.errorContains, "error", "", "only records whose last error contains the `text`, ignoring case")
+	cmd.StringVar(&f.from, "from", "", "only records dead-lettered at or after the `time`, in RFC 3339 format")
+	cmd.StringVar(&f.to, "to", "", "only records dead-lettered before the `time`, in RFC 3339 format")
+}
+
+func (f *filterFlags) filter() (deadletter.Filter, error) {
+	filter := deadletter.Filter{Topic: f.topic, ErrorContains: f.errorContains}
+
+	var err error
+	if filter.From, err = parseTime("from", f.from); err != nil {
+		return filter, err
+	}
+	if filter.To, err = parseTime("to", f.to); err != nil {
+		return filter, err
+	}
+	return filter, nil
+}
+
+func (c *cli) listDeadLetters(ctx context.Context, args []string) error {
+	cmd := c.newCommand("deadletters list", "[flags]", "Lists dead-lettered records, oldest first. Use -after with the last ID listed for the next page.")
+	var ff filterFlags
+	ff.register(cmd)
+	after := cmd.Int64("after", 0, "only records after the `id`")
+	limit := cmd.Int("limit", deadletter.DefaultListLimit, "the most `records` to list")
+	if _, err := cmd.parse(args); err != nil {
+		return err
+	}
+
+	filter, err := ff.filter()
+	if err != nil {
+		return err
+	}
+	filter.AfterID = *after
+	filter.Limit = *limit
+
+	repo, closeDB, err := cmd.deadLetterRepository()
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+
+	records, err := repo.List(ctx, filter)
+	if err != nil {
+		return err
+	}
+
+	w := newTabWriter(c.stdout)
+	fmt.Fprintln(w, "ID\tTOPIC\tKEY\tATTEMPTS\tDEADLETTERED AT\tLAST ERROR")
+	for _, rec := range records {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%s\t%s\n", rec.ID, rec.Topic, rec.PayloadKey, rec.Attempts, formatTime(rec.DeadletteredAt), rec.LastError)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if len(records) == filter.PageSize() {
+		fmt.Fprintf(c.stdout, "\nThere may be more records, use -after %d for the next page.\n", records[len(records)-1].ID)
+	}
+	return nil
+}
+
+func (c *cli) showDeadLetter(ctx context.Context, args []string) error {
+	cmd := c.newCommand("deadletters show", "<id> [flags]", "Prints a dead-lettered record, along with its audit log.")
+	id, err := cmd.parseID(args)
+	if err != nil {
+		return err
+	}
+
+	repo, closeDB, err := cmd.deadLetterRepository()
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+
+	rec, err := repo.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	entries, err := repo.AuditLog(ctx, id, 0)
+	if err != nil {
+		return err
+	}
+
+	w := newTabWriter(c.stdout)
+	fmt.Fprintf(w, "ID:\t%d\n", rec.ID)
+	fmt.Fprintf(w, "Topic:\t%s\n", rec.Topic)
+	fmt.Fprintf(w, "Key:\t%s\n", rec.PayloadKey)
+	fmt.Fprintf(w, "Partition:\t%d\n", rec.KafkaPartition)
+	fmt.Fprintf(w, "Offset:\t%d\n", rec.KafkaOffset)
+	fmt.Fprintf(w, "Timestamp:\t%s\n", formatTime(rec.KafkaTimestamp))
+	fmt.Fprintf(w, "Attempts:\t%d\n", rec.Attempts)
+	fmt.Fprintf(w, "Created at:\t%s\n", formatTime(rec.CreatedAt))
+	fmt.Fprintf(w, "Dead-lettered at:\t%s\n", formatTime(rec.DeadletteredAt))
+	fmt.Fprintf(w, "Last error:\t%s\n", rec.LastError)
+	if headers, err := model.DecodeHeaders(rec.PayloadHeaders); err == nil {
+		for _, h := range headers {
+			fmt.Fprintf(w, "Header:\t%s=%s\n", h.Key, h.Value)
+		}
+	}
+	if rec.ContentType != "" {
+		fmt.Fprintf(w, "Content type:\t%s\n", rec.ContentType)
+	}
+	if utf8.Valid(rec.Payload) {
+		fmt.Fprintf(w, "Payload:\t%s\n", rec.Payload)
+	} else {
+		fmt.Fprintf(w, "Payload (base64):\t%s\n", base64.StdEncoding.EncodeToString(rec.Payload))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if len(entries) > 0 {
+		fmt.Fprintln(c.stdout)
+		return c.printAuditLog(entries)
+	}
+	return nil
+}
+
+func (c *cli) requeueDeadLetter(ctx context.Context, args []string) error {
+	cmd := c.newCommand("deadletters requeue", "<id> [flags]", "Retries a dead-lettered record again from the first retry interval. The change is recorded in the audit log.")
+	actor := cmd.actorFlag()
+	payloadFile := cmd.String("payload-file", "", "replace the payload with the contents of the `file`, or of stdin if it is -")
+	id, err := cmd.parseID(args)
+	if err != nil {
+		return err
+	}
+
+	var payload []byte
+	switch *payloadFile {
+	case "":
+	case "-":
+		payload, err = ioutil.ReadAll(c.stdin)
+	default:
+		payload, err = ioutil.ReadFile(*payloadFile)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to read the payload: %w", err)
+	}
+
+	repo, closeDB, err := cmd.deadLetterRepository()
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+
+	if err := repo.Requeue(ctx, id, payload, *actor); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.stdout, "Requeued dead-lettered record %d.\n", id)
+	return nil
+}
+
+func (c *cli) deleteDeadLetter(ctx context.Context, args []string) error {
+	cmd := c.newCommand("deadletters delete", "<id> [flags]", "Deletes a dead-lettered record. The change is recorded in the audit log.")
+	actor := cmd.actorFlag()
+	id, err := cmd.parseID(args)
+	if err != nil {
+		return err
+	}
+
+	repo, closeDB, err := cmd.deadLetterRepository()
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+
+	if err := repo.Delete(ctx, id, *actor); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.stdout, "Deleted dead-lettered record %d.\n", id)
+	return nil
+}
+
+func (c *cli) purgeDeadLetters(ctx context.Context, args []string) error {
+	cmd := c.newCommand("deadletters purge", "[flags]", "Deletes the dead-lettered records that match the filter, or every dead-lettered record with -all. The change is recorded in the audit log.")
+	var ff filterFlags
+	ff.register(cmd)
+	actor := cmd.actorFlag()
+	all := cmd.Bool("all", false, "delete every dead-lettered record, which is required when there is no filter")
+	if _, err := cmd.parse(args); err != nil {
+		return err
+	}
+
+	filter, err := ff.filter()
+	if err != nil {
+		return err
+	}
+	if filter == (deadletter.Filter{}) && !*all {
+		return errors.New("a filter or -all is required to purge dead-lettered records")
+	}
+
+	repo, closeDB, err := cmd.deadLetterRepository()
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+
+	purged, err := repo.Purge(ctx, filter, *actor)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.stdout, "Purged %d dead-lettered records matching %s.\n", purged, filter)
+	return nil
+}
+
+func (c *cli) deadLetterAuditLog(ctx context.Context, args []string) error {
+	cmd := c.newCommand("deadletters audit", "[flags]", "Prints the audit log of changes to dead-lettered records, newest first.")
+	id := cmd.Int64("id", 0, "only changes to the record with the `id`")
+	limit := cmd.Int("limit", deadletter.DefaultListLimit, "the most `entries` to print")
+	if _, err := cmd.parse(args); err != nil {
+		return err
+	}
+
+	repo, closeDB, err := cmd.deadLetterRepository()
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+
+	entries, err := repo.AuditLog(ctx, *id, *limit)
+	if err != nil {
+		return err
+	}
+	return c.printAuditLog(entries)
+}
+
+func (c *cli) printAuditLog(entries []deadletter.AuditEntry) error {
+	w := newTabWriter(c.stdout)
+	fmt.Fprintln(w, "AT\tACTION\tACTOR\tRECORD\tTOPIC\tAFFECTED\tDETAILS")
+	for _, e := range entries {
+		record := "-"
+		if e.RetryID != 0 {
+			record = strconv.FormatInt(e.RetryID, 10)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%s\n", formatTime(e.CreatedAt), e.Action, e.Actor, record, orNone(e.Topic), e.Affected, e.Details)
+	}
+	return w.Flush()
+}
+
+// actorFlag registers the flag for who is making a change to dead-lettered records, which defaults to the user
+// running the command.
+func (cmd *command) actorFlag() *string {
+	user, _ := cmd.c.lookupEnv("USER")
+	return cmd.String("actor", user, "the `name` that the change is recorded against in the audit log")
+}
+
+// parseID parses the flags of a command that takes the ID of a dead-lettered record.
+func (cmd *command) parseID(args []string) (int64, error) {
+	positional, err := cmd.parse(args)
+	if err != nil {
+		return 0, err
+	}
+	if len(positional) != 1 {
+		cmd.Usage()
+		return 0, errUsage
+	}
+
+	id, err := strconv.ParseInt(positional[0], 10, 64)
+	if err != nil || id < 1 {
+		return 0, fmt.Errorf("invalid record ID '%s'", positional[0])
+	}
+	return id, nil
+}
+
+// deadLetterRepository returns the repository of dead-lettered records, and a function that closes its database.
+func (cmd *command) deadLetterRepository() (deadletter.Repository, func(), error) {
+	cfg, err := cmd.dbConfig()
+	if err != nil {
+		return deadletter.Repository{}, nil, err
+	}
+	db, err := cfg.DB()
+	if err != nil {
+		return deadletter.Repository{}, nil, err
+	}
+	return deadletter.NewRepositoryForDriver(db, cfg.DBDriver()), func() { _ = db.Close() }, nil
+}
+
+// dbConfig builds the consumer configuration, which must keep retries in the database.
+func (cmd *command) dbConfig() (*config.Config, error) {
+	cfg, err := cmd.config()
+	if err != nil {
+		return nil, err
+	}
+	if !cfg.UseDBForRetryQueue {
+		return nil, errDBRetriesRequired
+	}
+	return cfg, nil
+}
+
+func parseTime(name, value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid -%s time '%s', expected RFC 3339 format", name, value)
+	}
+	return t, nil
+}
@@ -0,0 +1,86 @@
+// Command kafka-consumer is a tool for operating consumers built with this module. It reads the same configuration
+// as config.Builder, from flags or KAFKA_CONSUMER_* environment variables, and can migrate the database, print the
+// topic chains, manage dead-lettered DB retries, report the DB retry backlog and replay deadLetter topics.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// errUsage is returned when a command is used incorrectly, after its usage has been printed.
+var errUsage = errors.New("invalid usage")
+
+const usage = `Usage: kafka-consumer <command> [flags]
+
+Commands:
+  migrate up|status|partition  apply or inspect the database migrations
+  topics                       print the retry and deadLetter topics of each main topic
+  deadletters <subcommand>     list, show, requeue, delete or purge dead-lettered DB retries, or read their audit log
+  backlog                      print the number of DB retries waiting for each retry interval
+  replay                       publish the messages of a deadLetter topic back to their main topic
+
+Every flag can also be set with an environment variable, e.g. -kafka-host with KAFKA_CONSUMER_KAFKA_HOST.
+Run 'kafka-consumer <command> -h' for the flags of a command.
+`
+
+type cli struct {
+	stdin     io.Reader
+	stdout    io.Writer
+	stderr    io.Writer
+	lookupEnv func(key string) (string, bool)
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	c := &cli{stdin: os.Stdin, stdout: os.Stdout, stderr: os.Stderr, lookupEnv: os.LookupEnv}
+	os.Exit(c.run(ctx, os.Args[1:]))
+}
+
+// run runs the command in args and returns the exit code.
+func (c *cli) run(ctx context.Context, args []string) int {
+	err := c.dispatch(ctx, args)
+	switch {
+	case err == nil, errors.Is(err, flag.ErrHelp):
+		return 0
+	case errors.Is(err, errUsage):
+		return 2
+	default:
+		fmt.Fprintf(c.stderr, "kafka-consumer: %s\n", err)
+		return 1
+	}
+}
+
+func (c *cli) dispatch(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		fmt.Fprint(c.stderr, usage)
+		return errUsage
+	}
+
+	switch args[0] {
+	case "migrate":
+		return c.migrate(ctx, args[1:])
+	case "topics":
+		return c.topics(args[1:])
+	case "deadletters":
+		return c.deadLetters(ctx, args[1:])
+	case "backlog":
+		return c.backlog(ctx, args[1:])
+	case "replay":
+		return c.replay(ctx, args[1:])
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(c.stdout, usage)
+		return nil
+	default:
+		fmt.Fprintf(c.stderr, "unknown command '%s'\n\n%s", args[0], usage)
+		return errUsage
+	}
+}
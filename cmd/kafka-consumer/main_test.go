@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/revdaalex/kafka-consumer-go/data"
+)
+
+func TestCommand_Config(t *testing.T) {
+	c, _, _ := newCLIForTests(map[string]string{
+		"KAFKA_CONSUMER_KAFKA_HOST":      "broker1, broker2",
+		"KAFKA_CONSUMER_KAFKA_GROUP":     "env-group",
+		"KAFKA_CONSUMER_TOPICS":          "product",
+		"KAFKA_CONSUMER_RETRY_INTERVALS": "120,300",
+	})
+
+	cmd := c.newCommand("topics", "", "")
+	if _, err := cmd.parse([]string{"-kafka-group", "flag-group"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	cfg, err := cmd.config()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if cfg.Group != "flag-group" {
+		t.Errorf("expected the flag to take precedence over the environment, got group '%s'", cfg.Group)
+	}
+	if len(cfg.Kafka.Host) != 2 || cfg.Kafka.Host[1] != "broker2" {
+		t.Errorf("expected the hosts to be read from the environment, got %v", cfg.Kafka.Host)
+	}
+	if len(cfg.DerivedTopics("product")) != 3 {
+		t.Errorf("expected 2 retry topics and a deadLetter topic, got %d topics", len(cfg.DerivedTopics("product")))
+	}
+}
+
+func TestCommand_Parse(t *testing.T) {
+	c, _, _ := newCLIForTests(nil)
+
+	cmd := c.newCommand("deadletters show", "", "")
+	actor := cmd.actorFlag()
+	positional, err := cmd.parse([]string{"12", "-actor", "jane"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(positional) != 1 || positional[0] != "12" || *actor != "jane" {
+		t.Errorf("expected flags to be parsed after positional arguments, got %v and actor '%s'", positional, *actor)
+	}
+
+	c, _, _ = newCLIForTests(map[string]string{"KAFKA_CONSUMER_DB_PORT": "port"})
+	if _, err := c.newCommand("backlog", "", "").parse(nil); err == nil {
+		t.Error("expected an error for an invalid environment variable")
+	}
+}
+
+func TestCLI_Topics(t *testing.T) {
+	c, stdout, _ := newCLIForTests(nil)
+
+	code := c.run(context.Background(), []string{"topics", "-kafka-host", "broker", "-kafka-group", "group", "-topics", "product", "-retry-intervals", "120"})
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d", code)
+	}
+
+	exp := "product\n  -> retry1.group.product  delay 2m0s\n  -> deadLetter.group.product\n"
+	if stdout.String() != exp {
+		t.Errorf("unexpected output:\n%s", stdout)
+	}
+}
+
+func TestCLI_Usage(t *testing.T) {
+	c, _, stderr := newCLIForTests(nil)
+
+	if code := c.run(context.Background(), []string{"unknown"}); code != 2 {
+		t.Errorf("expected exit code 2, got %d", code)
+	}
+	if !strings.Contains(stderr.String(), "unknown command 'unknown'") {
+		t.Errorf("expected the command to be reported, got %s", stderr)
+	}
+
+	if code := c.run(context.Background(), []string{"migrate", "sideways"}); code != 2 {
+		t.Errorf("expected exit code 2 for an unknown migrate action, got %d", code)
+	}
+}
+
+func TestCLI_DeadLetters(t *testing.T) {
+	env := map[string]string{
+		"USER":                           "jane",
+		"KAFKA_CONSUMER_KAFKA_HOST":      "broker",
+		"KAFKA_CONSUMER_KAFKA_GROUP":     "group",
+		"KAFKA_CONSUMER_TOPICS":          "product",
+		"KAFKA_CONSUMER_RETRY_INTERVALS": "120",
+		"KAFKA_CONSUMER_DB_RETRIES":      "true",
+		"KAFKA_CONSUMER_DB_DRIVER":       data.DriverSQLite,
+		"KAFKA_CONSUMER_DB_SCHEMA":       filepath.Join(t.TempDir(), "retries.db"),
+	}
+
+	run := func(t *testing.T, args ...string) string {
+		t.Helper()
+
+		c, stdout, stderr := newCLIForTests(env)
+		if code := c.run(context.Background(), args); code != 0 {
+			t.Fatalf("expected exit code 0, got %d: %s", code, stderr)
+		}
+		return stdout.String()
+	}
+
+	t.Run("it migrates the database", func(t *testing.T) {
+		if out := run(t, "migrate", "status"); strings.Contains(out, "Pending:  none") {
+			t.Errorf("expected migrations to be pending, got:\n%s", out)
+		}
+		if out := run(t, "migrate", "up"); !strings.Contains(out, "Pending:  none") {
+			t.Errorf("expected no migrations to be pending, got:\n%s", out)
+		}
+	})
+
+	db, err := data.NewSQLiteDB(env["KAFKA_CONSUMER_DB_SCHEMA"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`INSERT INTO kafka_consumer_retries(topic, payload, payload_headers, payload_key, kafka_offset, kafka_partition, attempts, deadlettered, errored, last_error)
+		VALUES
+			('product', '{"sku":"SKU-1"}', '[]', 'SKU-1', 1, 0, 2, true, true, 'timeout'),
+			('product', '{"sku":"SKU-2"}', '[]', 'SKU-2', 2, 0, 2, true, true, 'invalid'),
+			('product', '{"sku":"SKU-3"}', '[]', 'SKU-3', 3, 0, 1, false, true, 'timeout');`)
+	_ = db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("it reports the backlog", func(t *testing.T) {
+		out := run(t, "backlog")
+		// the columns are compared without their padding
+		fields := strings.Join(strings.Fields(out), " ")
+		for _, row := range []string{"product 1 2m0s 1", "product parked - 0", "product deadlettered - 2"} {
+			if !strings.Contains(fields, row) {
+				t.Errorf("expected the output to contain the row '%s', got:\n%s", row, out)
+			}
+		}
+	})
+
+	t.Run("it lists dead-lettered records", func(t *testing.T) {
+		out := run(t, "deadletters", "list", "-error", "invalid")
+		if !strings.Contains(out, "SKU-2") || strings.Contains(out, "SKU-1") {
+			t.Errorf("expected only the invalid record to be listed, got:\n%s", out)
+		}
+	})
+
+	t.Run("it requeues a record with an edited payload", func(t *testing.T) {
+		c, stdout, stderr := newCLIForTests(env)
+		c.stdin = strings.NewReader(`{"sku":"SKU-1b"}`)
+		if code := c.run(context.Background(), []string{"deadletters", "requeue", "1", "-payload-file", "-"}); code != 0 {
+			t.Fatalf("expected exit code 0, got %d: %s", code, stderr)
+		}
+		if stdout.String() != "Requeued dead-lettered record 1.\n" {
+			t.Errorf("unexpected output: %s", stdout)
+		}
+
+		if out := run(t, "deadletters", "audit", "-id", "1"); !strings.Contains(out, "requeue") || !strings.Contains(out, "jane") {
+			t.Errorf("expected the requeue to be audited against the user, got:\n%s", out)
+		}
+	})
+
+	t.Run("it requires a filter to purge records", func(t *testing.T) {
+		c, _, stderr := newCLIForTests(env)
+		if code := c.run(context.Background(), []string{"deadletters", "purge"}); code != 1 {
+			t.Errorf("expected exit code 1, got %d", code)
+		}
+		if !strings.Contains(stderr.String(), "a filter or -all is required") {
+			t.Errorf("unexpected error: %s", stderr)
+		}
+
+		if out := run(t, "deadletters", "purge", "-all"); out != "Purged 1 dead-lettered records matching all dead-lettered records.\n" {
+			t.Errorf("unexpected output: %s", out)
+		}
+	})
+
+	t.Run("it reports records that are not found", func(t *testing.T) {
+		c, _, stderr := newCLIForTests(env)
+		if code := c.run(context.Background(), []string{"deadletters", "show", "2"}); code != 1 {
+			t.Errorf("expected exit code 1, got %d", code)
+		}
+		if !strings.Contains(stderr.String(), "not found") {
+			t.Errorf("unexpected error: %s", stderr)
+		}
+	})
+}
+
+func TestCLI_DeadLettersWithoutDBRetries(t *testing.T) {
+	c, _, stderr := newCLIForTests(nil)
+
+	code := c.run(context.Background(), []string{"deadletters", "list", "-kafka-host", "broker", "-kafka-group", "group", "-topics", "product"})
+	if code != 1 || !strings.Contains(stderr.String(), "-db-retries") {
+		t.Errorf("expected DB retries to be required, got %d: %s", code, stderr)
+	}
+}
+
+func newCLIForTests(env map[string]string) (*cli, *bytes.Buffer, *bytes.Buffer) {
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	return &cli{
+		stdin:  strings.NewReader(""),
+		stdout: stdout,
+		stderr: stderr,
+		lookupEnv: func(key string) (string, bool) {
+			v, ok := env[key]
+			return v, ok
+		},
+	}, stdout, stderr
+}
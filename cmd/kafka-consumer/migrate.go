@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/revdaalex/kafka-consumer-go/config"
+	"github.com/revdaalex/kafka-consumer-go/data"
+	"github.com/revdaalex/kafka-consumer-go/data/retry"
+)
+
+func (c *cli) migrate(ctx context.Context, args []string) error {
+	cmd := c.newCommand("migrate", "up|status|partition [flags]", "Applies the migrations that are pending, or prints which migrations have been applied. Partition also converts the Postgres retries table into one partitioned by when retries were created.")
+	interval := cmd.Duration("interval", 24*time.Hour, "the `interval` of creation times held by each partition, with partition")
+	positional, err := cmd.parse(args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 || (positional[0] != "up" && positional[0] != "status" && positional[0] != "partition") {
+		cmd.Usage()
+		return errUsage
+	}
+
+	cfg, err := cmd.config()
+	if err != nil {
+		return err
+	}
+	db, err := cfg.DB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	switch positional[0] {
+	case "up":
+		if err := data.MigrateDatabaseForDriver(db, cfg.DBDriver(), cfg.DBSchema()); err != nil {
+			return err
+		}
+	case "partition":
+		if cfg.DBDriver() != data.DriverPostgres {
+			return errors.New("only the retries table of the postgres driver can be partitioned")
+		}
+		if err := data.MigrateDatabaseForDriver(db, cfg.DBDriver(), cfg.DBSchema()); err != nil {
+			return err
+		}
+		if err := retry.PartitionRetriesTable(ctx, db, *interval); err != nil {
+			return err
+		}
+
+		rm := retry.NewManagerForDriver(cfg.DBRetries, db, cfg.DBDriver())
+		rm.SetPartitions(config.DBRetryPartitions{Interval: *interval})
+		if err := rm.CreatePartitions(ctx); err != nil {
+			return err
+		}
+		fmt.Fprintf(c.stdout, "The retries table is partitioned by %s.\n", *interval)
+	}
+
+	status, err := data.MigrationStatusForDriver(db, cfg.DBDriver(), cfg.DBSchema())
+	if err != nil {
+		return err
+	}
+
+	w := newTabWriter(c.stdout)
+	fmt.Fprintf(w, "Version:\t%d\n", status.Version)
+	fmt.Fprintf(w, "Latest:\t%d\n", status.Latest)
+	fmt.Fprintf(w, "Dirty:\t%t\n", status.Dirty)
+	pending := make([]string, len(status.Pending))
+	for i, v := range status.Pending {
+		pending[i] = fmt.Sprint(v)
+	}
+	fmt.Fprintf(w, "Pending:\t%s\n", orNone(strings.Join(pending, ", ")))
+	return w.Flush()
+}
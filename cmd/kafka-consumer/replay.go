@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/IBM/sarama"
+
+	"github.com/revdaalex/kafka-consumer-go/config"
+)
+
+// nextTimeRetryHeader delays the processing of a message on a retry topic, it is not kept on replayed messages
+// so that they are processed straight away.
+const nextTimeRetryHeader = "NextTimeRetry"
+
+// defaultReplayIdleTimeout is how long a replay waits for the next message of a partition before assuming that
+// it has read every message, as the last offsets of a partition may not hold messages, e.g. transaction markers.
+const defaultReplayIdleTimeout = 10 * time.Second
+
+func (c *cli) replay(ctx context.Context, args []string) error {
+	cmd := c.newCommand("replay", "-topic <topic> [flags]", "Publishes the messages of a deadLetter topic back to the main topic of its chain, to be processed again. Only the messages on the topic when the replay starts are published.")
+	from := cmd.String("topic", "", "the deadLetter, or retry, `topic` to replay")
+	to := cmd.String("to", "", "the `topic` to publish the messages to, by default the main topic of the chain")
+	since := cmd.String("since", "", "only replay messages published at or after the `time`, in RFC 3339 format")
+	limit := cmd.Int("limit", 0, "the most `messages` to replay, or 0 for all of them")
+	dryRun := cmd.Bool("dry-run", false, "only print the messages that would be replayed")
+	if _, err := cmd.parse(args); err != nil {
+		return err
+	}
+	if *from == "" {
+		cmd.Usage()
+		return errUsage
+	}
+
+	cfg, err := cmd.config()
+	if err != nil {
+		return err
+	}
+
+	r := replay{from: *from, to: *to, limit: *limit, dryRun: *dryRun, idleTimeout: defaultReplayIdleTimeout, out: c.stdout}
+	if r.since, err = parseTime("since", *since); err != nil {
+		return err
+	}
+	if r.to == "" {
+		if r.to, err = mainTopicOf(cfg, r.from); err != nil {
+			return err
+		}
+	}
+
+	client, err := sarama.NewClient(cfg.RetryKafka.Host, config.NewSaramaConfigForCluster(cfg.RetryKafka))
+	if err != nil {
+		return fmt.Errorf("unable to connect to the retry Kafka cluster: %w", err)
+	}
+	defer client.Close()
+
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		return fmt.Errorf("unable to create the consumer: %w", err)
+	}
+	defer consumer.Close()
+	r.client = client
+	r.consumer = saramaConsumer{consumer}
+
+	if !r.dryRun {
+		producer, err := sarama.NewSyncProducer(cfg.Kafka.Host, config.NewSaramaConfigForCluster(cfg.Kafka))
+		if err != nil {
+			return fmt.Errorf("unable to create the producer: %w", err)
+		}
+		defer producer.Close()
+		r.producer = producer
+	}
+
+	return r.run(ctx)
+}
+
+// mainTopicOf returns the main topic of the chain that a retry or deadLetter topic is in.
+func mainTopicOf(cfg *config.Config, topic string) (string, error) {
+	for _, main := range cfg.MainTopics() {
+		for _, t := range cfg.DerivedTopics(main) {
+			if t.Name == topic {
+				return main, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("topic '%s' is not a retry or deadLetter topic of the configured main topics, set -to to replay it", topic)
+}
+
+// offsetClient finds the offsets of the partitions of a topic, as sarama.Client does.
+type offsetClient interface {
+	Partitions(topic string) ([]int32, error)
+	GetOffset(topic string, partitionID int32, time int64) (int64, error)
+}
+
+// partitionConsumer consumes the messages of a partition, as sarama.PartitionConsumer does.
+type partitionConsumer interface {
+	Messages() <-chan *sarama.ConsumerMessage
+	Errors() <-chan *sarama.ConsumerError
+	Close() error
+}
+
+type consumer interface {
+	ConsumePartition(topic string, partition int32, offset int64) (partitionConsumer, error)
+}
+
+type saramaConsumer struct {
+	sarama.Consumer
+}
+
+func (c saramaConsumer) ConsumePartition(topic string, partition int32, offset int64) (partitionConsumer, error) {
+	return c.Consumer.ConsumePartition(topic, partition, offset)
+}
+
+// replay publishes the messages of a topic to another topic.
+type replay struct {
+	from        string
+	to          string
+	since       time.Time
+	limit       int
+	dryRun      bool
+	idleTimeout time.Duration
+
+	client   offsetClient
+	consumer consumer
+	producer sarama.SyncProducer
+	out      io.Writer
+
+	replayed int
+}
+
+func (r *replay) run(ctx context.Context) error {
+	partitions, err := r.client.Partitions(r.from)
+	if err != nil {
+		return fmt.Errorf("unable to read the partitions of %s: %w", r.from, err)
+	}
+
+	for _, p := range partitions {
+		if r.limit > 0 && r.replayed >= r.limit {
+			break
+		}
+		if err := r.replayPartition(ctx, p); err != nil {
+			return err
+		}
+	}
+
+	if r.dryRun {
+		fmt.Fprintf(r.out, "Would replay %d messages from %s to %s.\n", r.replayed, r.from, r.to)
+	} else {
+		fmt.Fprintf(r.out, "Replayed %d messages from %s to %s.\n", r.replayed, r.from, r.to)
+	}
+	return nil
+}
+
+func (r *replay) replayPartition(ctx context.Context, partition int32) error {
+	// the end is taken before consuming, so that messages published during the replay are not replayed
+	end, err := r.client.GetOffset(r.from, partition, sarama.OffsetNewest)
+	if err != nil {
+		return fmt.Errorf("unable to read the newest offset of %s/%d: %w", r.from, partition, err)
+	}
+
+	start := sarama.OffsetOldest
+	if !r.since.IsZero() {
+		start = r.since.UnixNano() / int64(time.Millisecond)
+	}
+	if start, err = r.client.GetOffset(r.from, partition, start); err != nil {
+		return fmt.Errorf("unable to read the starting offset of %s/%d: %w", r.from, partition, err)
+	}
+	if start < 0 || start >= end {
+		return nil
+	}
+
+	pc, err := r.consumer.ConsumePartition(r.from, partition, start)
+	if err != nil {
+		return fmt.Errorf("unable to consume %s/%d: %w", r.from, partition, err)
+	}
+	defer pc.Close()
+
+	idle := time.NewTimer(r.idleTimeout)
+	defer idle.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-idle.C:
+			return nil
+		case cErr := <-pc.Errors():
+			return fmt.Errorf("unable to consume %s/%d: %w", r.from, partition, cErr.Err)
+		case msg := <-pc.Messages():
+			if msg.Offset >= end {
+				return nil
+			}
+			if err := r.publish(msg); err != nil {
+				return err
+			}
+			if msg.Offset >= end-1 || (r.limit > 0 && r.replayed >= r.limit) {
+				return nil
+			}
+
+			if !idle.Stop() {
+				<-idle.C
+			}
+			idle.Reset(r.idleTimeout)
+		}
+	}
+}
+
+func (r *replay) publish(msg *sarama.ConsumerMessage) error {
+	r.replayed++
+	if r.dryRun {
+		fmt.Fprintf(r.out, "%s/%d@%d key=%s\n", msg.Topic, msg.Partition, msg.Offset, msg.Key)
+		return nil
+	}
+
+	pm := &sarama.ProducerMessage{Topic: r.to, Value: sarama.ByteEncoder(msg.Value)}
+	if msg.Key != nil {
+		pm.Key = sarama.ByteEncoder(msg.Key)
+	}
+	for _, h := range msg.Headers {
+		if h != nil && string(h.Key) != nextTimeRetryHeader {
+			pm.Headers = append(pm.Headers, *h)
+		}
+	}
+
+	if _, _, err := r.producer.SendMessage(pm); err != nil {
+		return fmt.Errorf("unable to publish %s/%d@%d to %s, %d messages were replayed: %w", msg.Topic, msg.Partition, msg.Offset, r.to, r.replayed-1, err)
+	}
+	return nil
+}
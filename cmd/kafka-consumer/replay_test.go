@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+
+	"github.com/revdaalex/kafka-consumer-go/test/saramatest"
+)
+
+func TestReplay(t *testing.T) {
+	newMessages := func() map[int32][]*sarama.ConsumerMessage {
+		return map[int32][]*sarama.ConsumerMessage{
+			0: {
+				{Topic: "deadLetter", Partition: 0, Offset: 0, Key: []byte("SKU-1"), Value: []byte("1"), Timestamp: time.Unix(100, 0), Headers: []*sarama.RecordHeader{
+					{Key: []byte(nextTimeRetryHeader), Value: []byte("1")},
+					{Key: []byte("trace"), Value: []byte("abc")},
+				}},
+				{Topic: "deadLetter", Partition: 0, Offset: 1, Key: []byte("SKU-2"), Value: []byte("2"), Timestamp: time.Unix(200, 0)},
+			},
+			1: {
+				{Topic: "deadLetter", Partition: 1, Offset: 0, Value: []byte("3"), Timestamp: time.Unix(300, 0)},
+			},
+		}
+	}
+
+	t.Run("it publishes every message to the target topic without the retry delay", func(t *testing.T) {
+		p := saramatest.NewMockSyncProducer()
+		r := newReplayForTests(newMessages(), p)
+
+		if err := r.run(context.Background()); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		sent := p.GetMessagesSent("product")
+		if len(sent) != 3 {
+			t.Fatalf("expected 3 messages to be replayed, got %d", len(sent))
+		}
+		if len(sent[0].Headers) != 1 || string(sent[0].Headers[0].Key) != "trace" {
+			t.Errorf("expected only the trace header to be kept, got %+v", sent[0].Headers)
+		}
+		if sent[2].Key != nil {
+			t.Errorf("expected a message without a key to be replayed without one, got %v", sent[2].Key)
+		}
+		if out := r.out.(*bytes.Buffer).String(); out != "Replayed 3 messages from deadLetter to product.\n" {
+			t.Errorf("unexpected output: %s", out)
+		}
+	})
+
+	t.Run("it only replays messages since the given time, up to the limit", func(t *testing.T) {
+		p := saramatest.NewMockSyncProducer()
+		r := newReplayForTests(newMessages(), p)
+		r.since = time.Unix(150, 0)
+		r.limit = 1
+
+		if err := r.run(context.Background()); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		sent := p.GetMessagesSent("product")
+		if len(sent) != 1 || string(sent[0].Key.(sarama.ByteEncoder)) != "SKU-2" {
+			t.Errorf("expected only SKU-2 to be replayed, got %+v", sent)
+		}
+	})
+
+	t.Run("it does not publish anything in a dry run", func(t *testing.T) {
+		r := newReplayForTests(newMessages(), nil)
+		r.dryRun = true
+
+		if err := r.run(context.Background()); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if r.replayed != 3 {
+			t.Errorf("expected 3 messages to be listed, got %d", r.replayed)
+		}
+	})
+
+	t.Run("it stops when a partition has no more messages", func(t *testing.T) {
+		msgs := newMessages()
+		// the newest offset is past the last message, as it would be after a transaction marker
+		msgs[0] = msgs[0][:1]
+		r := newReplayForTests(msgs, saramatest.NewMockSyncProducer())
+		r.client.(*fakeOffsetClient).newest[0] = 2
+
+		if err := r.run(context.Background()); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if r.replayed != 2 {
+			t.Errorf("expected 2 messages to be replayed, got %d", r.replayed)
+		}
+	})
+
+	t.Run("it reports messages that could not be published", func(t *testing.T) {
+		p := saramatest.NewMockSyncProducer()
+		p.ReturnErrorOnSend()
+		r := newReplayForTests(newMessages(), p)
+
+		if err := r.run(context.Background()); err == nil {
+			t.Error("expected an error when a message cannot be published")
+		}
+	})
+}
+
+func TestMainTopicOf(t *testing.T) {
+	c, _, _ := newCLIForTests(nil)
+	cmd := c.newCommand("replay", "", "")
+	if _, err := cmd.parse([]string{"-kafka-host", "broker", "-kafka-group", "group", "-topics", "product,price", "-retry-intervals", "120"}); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := cmd.config()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := mainTopicOf(cfg, "deadLetter.group.price"); err != nil || got != "price" {
+		t.Errorf("expected the main topic to be price, got '%s' and %v", got, err)
+	}
+	if _, err := mainTopicOf(cfg, "deadLetter.other.price"); err == nil {
+		t.Error("expected an error for a topic that is not in a chain")
+	}
+}
+
+func newReplayForTests(msgs map[int32][]*sarama.ConsumerMessage, p sarama.SyncProducer) *replay {
+	c := &fakeOffsetClient{msgs: msgs, newest: map[int32]int64{}}
+	for partition, m := range msgs {
+		c.newest[partition] = int64(len(m))
+	}
+
+	return &replay{
+		from:        "deadLetter",
+		to:          "product",
+		idleTimeout: 50 * time.Millisecond,
+		client:      c,
+		consumer:    fakeConsumer{msgs: msgs},
+		producer:    p,
+		out:         &bytes.Buffer{},
+	}
+}
+
+type fakeOffsetClient struct {
+	msgs   map[int32][]*sarama.ConsumerMessage
+	newest map[int32]int64
+}
+
+func (c *fakeOffsetClient) Partitions(topic string) ([]int32, error) {
+	return []int32{0, 1}, nil
+}
+
+func (c *fakeOffsetClient) GetOffset(topic string, partition int32, t int64) (int64, error) {
+	switch t {
+	case sarama.OffsetNewest:
+		return c.newest[partition], nil
+	case sarama.OffsetOldest:
+		return 0, nil
+	}
+
+	for _, m := range c.msgs[partition] {
+		if m.Timestamp.UnixNano()/int64(time.Millisecond) >= t {
+			return m.Offset, nil
+		}
+	}
+	return -1, nil
+}
+
+type fakeConsumer struct {
+	msgs map[int32][]*sarama.ConsumerMessage
+}
+
+func (c fakeConsumer) ConsumePartition(topic string, partition int32, offset int64) (partitionConsumer, error) {
+	pc := &fakePartitionConsumer{messages: make(chan *sarama.ConsumerMessage, len(c.msgs[partition])), errors: make(chan *sarama.ConsumerError)}
+	for _, m := range c.msgs[partition] {
+		if m.Offset >= offset {
+			pc.messages <- m
+		}
+	}
+	return pc, nil
+}
+
+type fakePartitionConsumer struct {
+	messages chan *sarama.ConsumerMessage
+	errors   chan *sarama.ConsumerError
+}
+
+func (pc *fakePartitionConsumer) Messages() <-chan *sarama.ConsumerMessage {
+	return pc.messages
+}
+
+func (pc *fakePartitionConsumer) Errors() <-chan *sarama.ConsumerError {
+	return pc.errors
+}
+
+func (pc *fakePartitionConsumer) Close() error {
+	return nil
+}
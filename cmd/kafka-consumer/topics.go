@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+)
+
+func (c *cli) topics(args []string) error {
+	cmd := c.newCommand("topics", "[flags]", "Prints the chain of retry and deadLetter topics of each main topic, along with the DB retry intervals if retries are kept in the database.")
+	if _, err := cmd.parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := cmd.config()
+	if err != nil {
+		return err
+	}
+
+	w := newTabWriter(c.stdout)
+	for _, name := range cfg.MainTopics() {
+		fmt.Fprintf(w, "%s\n", name)
+		for _, t := range cfg.DerivedTopics(name) {
+			if t.Delay > 0 {
+				fmt.Fprintf(w, "  -> %s\tdelay %s\n", t.Name, t.Delay)
+			} else {
+				fmt.Fprintf(w, "  -> %s\n", t.Name)
+			}
+		}
+
+		if cfg.UseDBForRetryQueue {
+			for _, rc := range cfg.DBRetries[name] {
+				fmt.Fprintf(w, "  db retry %d\tinterval %s, batch size %d\n", rc.Sequence, rc.Interval, cfg.DBRetries.BatchSizeForTopic(name))
+			}
+		}
+	}
+	return w.Flush()
+}
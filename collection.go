@@ -18,12 +18,12 @@ type collection interface {
 	close()
 }
 
-func connectToKafka(cfg *config.Config, saramaCfg *sarama.Config, logger log.Logger) (sarama.ConsumerGroup, error) {
+func connectToKafka(cluster config.KafkaCluster, group string, saramaCfg *sarama.Config, logger log.Logger) (sarama.ConsumerGroup, error) {
 	var cl sarama.ConsumerGroup
 	var err error
 
 	for i := 0; i < maxConnectionAttempts; i++ {
-		cl, err = sarama.NewConsumerGroup(cfg.Host, cfg.Group, saramaCfg)
+		cl, err = sarama.NewConsumerGroup(cluster.Host, group, saramaCfg)
 		if err == nil {
 			break
 		}
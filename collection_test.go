@@ -13,10 +13,13 @@ import (
 type testKafkaConnector struct {
 	consumerGroup sarama.ConsumerGroup
 	willError     bool
+	// connectedTo records the hosts of each cluster connected to, in order
+	connectedTo [][]string
 }
 
 // connectToKafka satisfies the kafkaConnector type and is used from tests
-func (t testKafkaConnector) connectToKafka(cfg *config.Config, saramaCfg *sarama.Config, logger log.Logger) (sarama.ConsumerGroup, error) {
+func (t *testKafkaConnector) connectToKafka(cluster config.KafkaCluster, group string, saramaCfg *sarama.Config, logger log.Logger) (sarama.ConsumerGroup, error) {
+	t.connectedTo = append(t.connectedTo, cluster.Host)
 	if t.willError {
 		return nil, errors.New("oops")
 	}
@@ -1,6 +1,13 @@
 package config
 
-import "time"
+import (
+	"time"
+
+	"github.com/IBM/sarama"
+
+	"github.com/revdaalex/kafka-consumer-go/data/retry/store"
+	"github.com/revdaalex/kafka-consumer-go/status"
+)
 
 type Builder struct {
 	kafkaHost           []string
@@ -15,10 +22,41 @@ type Builder struct {
 	dBPass              string
 	dBDriver            string
 	useDbForRetries     bool
+	blockingRetries     bool
+	dbNotifications     bool
 	maintenanceInterval time.Duration
 	topicNameGenerator  topicNameGenerator
 	tlsEnable           bool
 	tlsSkipVerifyPeer   bool
+
+	// optional settings for the Kafka clusters, the retry cluster inherits any that are not set for it
+	kafkaSASLUser          string
+	kafkaSASLPassword      string
+	retryKafkaSASLUser     string
+	retryKafkaSASLPassword string
+	retryTLSEnable         *bool
+	retryTLSSkipVerifyPeer *bool
+
+	topicCreation TopicCreation
+	preflight     Preflight
+
+	retryPartitioner         sarama.PartitionerConstructor
+	keepRetryPartition       bool
+	asyncRetryProducer       AsyncRetryProducer
+	retryPublishErrorHandler RetryPublishErrorHandler
+	exactlyOnceRetries       bool
+	transactionalID          string
+	retryStore               store.Store
+	dbRetryBatchSizes        map[string]int
+	dbRetryWorkers           int
+	workerID                 string
+	dbRetryRetention         map[store.RetryState]time.Duration
+	dbRetryTopicRetention    map[string]map[store.RetryState]time.Duration
+	archiveExpiredToTable    bool
+	archiveExpiredToDir      string
+	maintenanceReportHandler MaintenanceReportHandler
+	dbRetryPartitions        DBRetryPartitions
+	publishDBDeadLetters     bool
 }
 
 func NewBuilder() *Builder {
@@ -27,6 +65,7 @@ func NewBuilder() *Builder {
 		maintenanceInterval: defaultMaintenanceInterval,
 		dBPort:              5432,
 		dBDriver:            "postgres",
+		dbRetryWorkers:      DefaultDBRetryWorkers,
 	}
 }
 
@@ -80,11 +119,131 @@ func (cb *Builder) SetDBPass(pass string) *Builder {
 	return cb
 }
 
+// SetDBDriver sets the database engine that retries are kept in, either "postgres", "mysql" or "sqlite".
+// With "sqlite", the DB schema is the path of the database file and the other DB settings are not used.
+func (cb *Builder) SetDBDriver(driver string) *Builder {
+	cb.dBDriver = driver
+	return cb
+}
+
 func (cb *Builder) UseDbForRetries(useDbForRetries bool) *Builder {
 	cb.useDbForRetries = useDbForRetries
 	return cb
 }
 
+// UseBlockingRetriesPerKey will hold back messages for a key while an earlier message with the
+// same key is waiting to be retried, so that messages for a key are always processed in order.
+// This requires database retries to be enabled.
+func (cb *Builder) UseBlockingRetriesPerKey(blockingRetries bool) *Builder {
+	cb.blockingRetries = blockingRetries
+	return cb
+}
+
+// SetRetryStore sets the store that database retries are kept in, instead of the Postgres database
+// configured with the DB settings. This also enables database retries. If blocking retries per key are
+// used, the store must be a store.KeyBlockingStore.
+func (cb *Builder) SetRetryStore(s store.Store) *Builder {
+	cb.retryStore = s
+	cb.useDbForRetries = true
+	return cb
+}
+
+// UseDBRetryNotifications wakes DB retry processors when Postgres notifies them that retries have been
+// published or have errored, rather than having every processor poll the database every few seconds.
+// The processors still poll once a minute in case a notification is missed. This requires database
+// retries to be kept in Postgres.
+func (cb *Builder) UseDBRetryNotifications(notifications bool) *Builder {
+	cb.dbNotifications = notifications
+	return cb
+}
+
+// SetDBRetryBatchSize sets the most DB retries of the source topic that are claimed and processed at once.
+// Defaults to DefaultDBRetryBatchSize.
+func (cb *Builder) SetDBRetryBatchSize(topic string, size int) *Builder {
+	if cb.dbRetryBatchSizes == nil {
+		cb.dbRetryBatchSizes = map[string]int{}
+	}
+	cb.dbRetryBatchSizes[topic] = size
+	return cb
+}
+
+// SetDBRetryWorkers sets how many retries from a batch of DB retries are handled at once by each retry
+// processor. Retries are handled one at a time by default, so raise this if your handler is slow and
+// does not mind being called concurrently. Defaults to DefaultDBRetryWorkers.
+func (cb *Builder) SetDBRetryWorkers(workers int) *Builder {
+	cb.dbRetryWorkers = workers
+	return cb
+}
+
+// SetWorkerID sets the ID recorded against the DB retries that this instance of the consumer claims, so that
+// you can see which instance is processing them. It should be unique to each instance. Defaults to the
+// hostname and process ID.
+func (cb *Builder) SetWorkerID(id string) *Builder {
+	cb.workerID = id
+	return cb
+}
+
+// SetDBRetryRetention sets how long maintenance keeps DB retries in the state for, for every topic without its own
+// retention for the state. A period of zero keeps them forever. Successful retries are kept for
+// DefaultSuccessfulRetention, and retries in other states forever, by default.
+func (cb *Builder) SetDBRetryRetention(state store.RetryState, period time.Duration) *Builder {
+	if cb.dbRetryRetention == nil {
+		cb.dbRetryRetention = map[store.RetryState]time.Duration{}
+	}
+	cb.dbRetryRetention[state] = period
+	return cb
+}
+
+// SetDBRetryRetentionForTopic sets how long maintenance keeps DB retries of the source topic in the state for.
+func (cb *Builder) SetDBRetryRetentionForTopic(topic string, state store.RetryState, period time.Duration) *Builder {
+	if cb.dbRetryTopicRetention == nil {
+		cb.dbRetryTopicRetention = map[string]map[store.RetryState]time.Duration{}
+	}
+	if cb.dbRetryTopicRetention[topic] == nil {
+		cb.dbRetryTopicRetention[topic] = map[store.RetryState]time.Duration{}
+	}
+	cb.dbRetryTopicRetention[topic][state] = period
+	return cb
+}
+
+// ArchiveExpiredDBRetriesToTable copies DB retries to the kafka_consumer_retries_archive table before maintenance
+// deletes them.
+func (cb *Builder) ArchiveExpiredDBRetriesToTable(archive bool) *Builder {
+	cb.archiveExpiredToTable = archive
+	return cb
+}
+
+// ArchiveExpiredDBRetriesToDir writes DB retries to a JSON lines file per day in dir before maintenance deletes them.
+func (cb *Builder) ArchiveExpiredDBRetriesToDir(dir string) *Builder {
+	cb.archiveExpiredToDir = dir
+	return cb
+}
+
+// SetMaintenanceReportHandler sets a handler that is called with what each run of the DB retry maintenance removed,
+// e.g. to record metrics.
+func (cb *Builder) SetMaintenanceReportHandler(h MaintenanceReportHandler) *Builder {
+	cb.maintenanceReportHandler = h
+	return cb
+}
+
+// PartitionDBRetries partitions the Postgres retries table by when retries were created, with a partition for
+// each interval. Maintenance creates partitions ahead of time, and drops partitions once their interval ended
+// longer than retention ago and they hold no pending retries, rather than deleting retries. A retention of zero
+// keeps the partitions. The table is converted when the consumer starts, if it is not partitioned yet.
+func (cb *Builder) PartitionDBRetries(interval, retention time.Duration) *Builder {
+	cb.dbRetryPartitions = DBRetryPartitions{Interval: interval, Retention: retention}
+	return cb
+}
+
+// PublishDBDeadLettersToKafka also publishes DB retries to the deadLetter topic of their source topic once they
+// are dead-lettered, with their retry metadata in the message headers. Dead letters are marked in the database
+// when they are published, so each is published by a single instance of the consumer, and only published again
+// if the consumer stops before it could mark them. This requires database retries to be enabled.
+func (cb *Builder) PublishDBDeadLettersToKafka(publish bool) *Builder {
+	cb.publishDBDeadLetters = publish
+	return cb
+}
+
 func (cb *Builder) SetMaintenanceInterval(interval time.Duration) *Builder {
 	cb.maintenanceInterval = interval
 	return cb
@@ -100,6 +259,137 @@ func (cb *Builder) SkipTLSVerifyPeer(tlsSkipVerifyPeer bool) *Builder {
 	return cb
 }
 
+// SetKafkaSASL enables SASL/PLAIN authentication with the given credentials for the main Kafka cluster.
+// These are also used for the retry cluster unless SetRetryKafkaSASL is used.
+func (cb *Builder) SetKafkaSASL(user, password string) *Builder {
+	cb.kafkaSASLUser = user
+	cb.kafkaSASLPassword = password
+	return cb
+}
+
+// SetRetryKafkaSASL enables SASL/PLAIN authentication with the given credentials for the retry Kafka cluster.
+func (cb *Builder) SetRetryKafkaSASL(user, password string) *Builder {
+	cb.retryKafkaSASLUser = user
+	cb.retryKafkaSASLPassword = password
+	return cb
+}
+
+// EnableRetryTLS overrides whether TLS is used for the retry Kafka cluster, which otherwise follows EnableTLS.
+func (cb *Builder) EnableRetryTLS(tlsEnable bool) *Builder {
+	cb.retryTLSEnable = &tlsEnable
+	return cb
+}
+
+// SkipRetryTLSVerifyPeer overrides peer verification for the retry Kafka cluster, which otherwise follows SkipTLSVerifyPeer.
+func (cb *Builder) SkipRetryTLSVerifyPeer(tlsSkipVerifyPeer bool) *Builder {
+	cb.retryTLSSkipVerifyPeer = &tlsSkipVerifyPeer
+	return cb
+}
+
+// EnableTopicCreation will create any missing retry and deadLetter topics on startup, with
+// the same number of partitions as their source topic.
+func (cb *Builder) EnableTopicCreation(enable bool) *Builder {
+	cb.topicCreation.Enable = enable
+	return cb
+}
+
+// TopicCreationDryRun will only report the topics that would be created, without creating them.
+func (cb *Builder) TopicCreationDryRun(dryRun bool) *Builder {
+	cb.topicCreation.DryRun = dryRun
+	return cb
+}
+
+// SetTopicReplicationFactor sets the replication factor of created topics, which otherwise
+// matches the replication factor of their source topic.
+func (cb *Builder) SetTopicReplicationFactor(replicationFactor int16) *Builder {
+	cb.topicCreation.ReplicationFactor = replicationFactor
+	return cb
+}
+
+// SetTopicRetention sets the retention of created topics, which otherwise uses the broker default.
+func (cb *Builder) SetTopicRetention(retention time.Duration) *Builder {
+	cb.topicCreation.Retention = retention
+	return cb
+}
+
+// EnablePreflightCheck will check on startup that every topic in the topic chains exists, has the same number
+// of partitions as its source topic and can be produced to. Any problems found are logged.
+func (cb *Builder) EnablePreflightCheck(enable bool) *Builder {
+	cb.preflight.Enable = enable
+	return cb
+}
+
+// FailOnPreflightProblems will stop the consumer from starting if the preflight check finds any problems.
+// This also enables the preflight check.
+func (cb *Builder) FailOnPreflightProblems(fail bool) *Builder {
+	cb.preflight.FailOnProblem = fail
+	if fail {
+		cb.preflight.Enable = true
+	}
+	return cb
+}
+
+// SetRetryPartitioner sets the partitioner used when publishing retries and deadLetters to Kafka. Messages are
+// published with their original key, and by default the partition is chosen from a hash of the key.
+func (cb *Builder) SetRetryPartitioner(partitioner sarama.PartitionerConstructor) *Builder {
+	cb.retryPartitioner = partitioner
+	return cb
+}
+
+// KeepRetryPartition will publish retries and deadLetters to the same partition number that the original
+// message was consumed from. The retry and deadLetter topics must have at least as many partitions as their
+// source topic. This cannot be used together with SetRetryPartitioner.
+func (cb *Builder) KeepRetryPartition(keep bool) *Builder {
+	cb.keepRetryPartition = keep
+	return cb
+}
+
+// UseAsyncRetryProducer will publish retries and deadLetters to Kafka with an idempotent async producer that
+// sends them in batches, instead of waiting for each one to be acknowledged.
+func (cb *Builder) UseAsyncRetryProducer(async bool) *Builder {
+	cb.asyncRetryProducer.Enable = async
+	return cb
+}
+
+// SetRetryProducerBatching sets how many messages, or how long, the async retry producer waits for before
+// sending a batch, whichever comes first.
+func (cb *Builder) SetRetryProducerBatching(flushMessages int, flushFrequency time.Duration) *Builder {
+	cb.asyncRetryProducer.FlushMessages = flushMessages
+	cb.asyncRetryProducer.FlushFrequency = flushFrequency
+	return cb
+}
+
+// SetRetryProducerDeliveryAttempts sets how many times the async retry producer will attempt to publish a failure
+// before passing it to the retry publish error handler. The producer is idempotent, so it always makes at least two.
+func (cb *Builder) SetRetryProducerDeliveryAttempts(attempts int) *Builder {
+	cb.asyncRetryProducer.MaxDeliveryAttempts = attempts
+	return cb
+}
+
+// SetRetryPublishErrorHandler sets a handler for failures that could not be published to Kafka for retry.
+// Without a handler, these failures are only logged.
+func (cb *Builder) SetRetryPublishErrorHandler(h RetryPublishErrorHandler) *Builder {
+	cb.retryPublishErrorHandler = h
+	return cb
+}
+
+// UseExactlyOnceRetries will publish each retry in a Kafka transaction together with the offset commit of the
+// message that failed, so that a crash can neither duplicate nor lose a retry. Retry topics are then consumed
+// with read_committed isolation. This cannot be used with database retries, the async retry producer or a
+// separate retry cluster.
+func (cb *Builder) UseExactlyOnceRetries(exactlyOnce bool) *Builder {
+	cb.exactlyOnceRetries = exactlyOnce
+	return cb
+}
+
+// SetTransactionalID sets the transactional ID of the retry producer used for exactly-once retries. It must be
+// unique to each instance of your consumer, and stay the same when that instance restarts. Defaults to the
+// Kafka group and the hostname.
+func (cb *Builder) SetTransactionalID(id string) *Builder {
+	cb.transactionalID = id
+	return cb
+}
+
 func (cb *Builder) SetTopicNameGenerator(tng topicNameGenerator) *Builder {
 	cb.topicNameGenerator = tng
 	return cb
@@ -107,7 +397,9 @@ func (cb *Builder) SetTopicNameGenerator(tng topicNameGenerator) *Builder {
 
 func (cb *Builder) Config() (*Config, error) {
 	c := &Config{
-		services: map[string]interface{}{},
+		services: map[string]interface{}{
+			"status": status.NewTracker(),
+		},
 	}
 	if err := c.loadFromBuilder(cb); err != nil {
 		return nil, err
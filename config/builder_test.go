@@ -1,11 +1,18 @@
 package config
 
 import (
+	"context"
 	"fmt"
 	"testing"
 	"time"
 
+	"github.com/IBM/sarama"
 	"github.com/go-test/deep"
+
+	failuremodel "github.com/revdaalex/kafka-consumer-go/data/failure/model"
+	"github.com/revdaalex/kafka-consumer-go/data/retry/model"
+	"github.com/revdaalex/kafka-consumer-go/data/retry/store"
+	"github.com/revdaalex/kafka-consumer-go/status"
 )
 
 func init() {
@@ -18,6 +25,7 @@ func TestNewBuilder(t *testing.T) {
 		maintenanceInterval: time.Hour * 1,
 		topicNameGenerator:  defaultTopicNameGenerator,
 		dBDriver:            "postgres",
+		dbRetryWorkers:      1,
 	}
 
 	got := NewBuilder()
@@ -43,12 +51,26 @@ func TestBuilder_Config(t *testing.T) {
 			Next:  expDeadLetterProduct,
 		}
 		expMainProduct := &KafkaTopic{
-			Name: "product",
-			Key:  "product",
-			Next: expRetry1Product,
+			Name:        "product",
+			Key:         "product",
+			Next:        expRetry1Product,
+			IsMainTopic: true,
 		}
 		exp := &Config{
-			Host:             []string{"broker1", "broker2"},
+			Kafka: KafkaCluster{
+				Host:              []string{"broker1", "broker2"},
+				TLSEnable:         true,
+				TLSSkipVerifyPeer: true,
+				SASLUser:          "kafka-user",
+				SASLPassword:      "kafka-pass",
+			},
+			RetryKafka: KafkaCluster{
+				Host:              []string{"retry-broker1"},
+				TLSEnable:         false,
+				TLSSkipVerifyPeer: true,
+				SASLUser:          "retry-user",
+				SASLPassword:      "retry-pass",
+			},
 			Group:            "group",
 			ConsumableTopics: []*KafkaTopic{expMainProduct, expRetry1Product},
 			TopicMap: map[TopicKey]*KafkaTopic{
@@ -59,9 +81,10 @@ func TestBuilder_Config(t *testing.T) {
 			DBRetries: map[string][]*DBTopicRetry{
 				"product": {
 					{
-						Interval: time.Duration(120000000000),
-						Sequence: 1,
-						Key:      "product",
+						Interval:  time.Duration(120000000000),
+						Sequence:  1,
+						Key:       "product",
+						BatchSize: 100,
 					},
 				},
 			},
@@ -74,15 +97,44 @@ func TestBuilder_Config(t *testing.T) {
 				Pass:   "pass",
 			},
 			MaintenanceInterval: time.Hour * 2,
-			TLSEnable:           true,
-			TLSSkipVerifyPeer:   true,
-			UseDBForRetryQueue:  true,
-			services:            map[string]interface{}{},
+			TopicCreation: TopicCreation{
+				Enable:            true,
+				DryRun:            true,
+				ReplicationFactor: 2,
+				Retention:         time.Hour * 24,
+			},
+			Preflight: Preflight{
+				Enable:        true,
+				FailOnProblem: true,
+			},
+			KeepRetryPartition: true,
+			AsyncRetryProducer: AsyncRetryProducer{
+				Enable:              true,
+				FlushMessages:       50,
+				FlushFrequency:      time.Second,
+				MaxDeliveryAttempts: 5,
+			},
+			TLSEnable:             true,
+			TLSSkipVerifyPeer:     true,
+			UseDBForRetryQueue:    true,
+			BlockingRetriesPerKey: true,
+			DBRetryNotifications:  true,
+			DBRetryWorkers:        4,
+			PublishDBDeadLetters:  true,
+			WorkerID:              "worker-1",
+			services:              map[string]interface{}{"status": status.NewTracker()},
+			DBRetryRetention: DBRetryRetention{
+				Periods: map[store.RetryState]time.Duration{store.StateSuccessful: time.Hour},
+			},
 		}
 
 		c, err := NewBuilder().
 			SetTopicNameGenerator(dummyGenerator).
 			SetKafkaHost([]string{"broker1", "broker2"}).
+			SetKafkaSASL("kafka-user", "kafka-pass").
+			SetRetryKafkaHost([]string{"retry-broker1"}).
+			SetRetryKafkaSASL("retry-user", "retry-pass").
+			EnableRetryTLS(false).
 			SetKafkaGroup("group").
 			SetSourceTopics([]string{"product"}).
 			SetRetryIntervals([]int{120}).
@@ -92,9 +144,24 @@ func TestBuilder_Config(t *testing.T) {
 			SetDBSchema("schema").
 			SetDBPort(15432).
 			UseDbForRetries(true).
+			UseBlockingRetriesPerKey(true).
+			UseDBRetryNotifications(true).
+			SetDBRetryBatchSize("product", 100).
+			SetDBRetryWorkers(4).
+			SetWorkerID("worker-1").
+			PublishDBDeadLettersToKafka(true).
 			EnableTLS(true).
 			SkipTLSVerifyPeer(true).
-			SetMaintenanceInterval(time.Hour * 2).
+			SetMaintenanceInterval(time.Hour*2).
+			EnableTopicCreation(true).
+			TopicCreationDryRun(true).
+			SetTopicReplicationFactor(2).
+			SetTopicRetention(time.Hour*24).
+			FailOnPreflightProblems(true).
+			KeepRetryPartition(true).
+			UseAsyncRetryProducer(true).
+			SetRetryProducerBatching(50, time.Second).
+			SetRetryProducerDeliveryAttempts(5).
 			Config()
 		if err != nil {
 			t.Fatalf("unexpected error: %s", err)
@@ -111,12 +178,14 @@ func TestBuilder_Config(t *testing.T) {
 			Key:  "product",
 		}
 		expMainProduct := &KafkaTopic{
-			Name: "product",
-			Key:  "product",
-			Next: expDeadLetterProduct,
+			Name:        "product",
+			Key:         "product",
+			Next:        expDeadLetterProduct,
+			IsMainTopic: true,
 		}
 		exp := &Config{
-			Host:             []string{"broker1", "broker2"},
+			Kafka:            KafkaCluster{Host: []string{"broker1", "broker2"}},
+			RetryKafka:       KafkaCluster{Host: []string{"broker1", "broker2"}},
 			Group:            "group",
 			ConsumableTopics: []*KafkaTopic{expMainProduct},
 			TopicMap: map[TopicKey]*KafkaTopic{
@@ -135,7 +204,11 @@ func TestBuilder_Config(t *testing.T) {
 				Pass:   "pass",
 			},
 			MaintenanceInterval: time.Hour * 1,
-			services:            map[string]interface{}{},
+			DBRetryWorkers:      1,
+			services:            map[string]interface{}{"status": status.NewTracker()},
+			DBRetryRetention: DBRetryRetention{
+				Periods: map[store.RetryState]time.Duration{store.StateSuccessful: time.Hour},
+			},
 		}
 
 		c, err := NewBuilder().
@@ -156,6 +229,350 @@ func TestBuilder_Config(t *testing.T) {
 		}
 	})
 
+	t.Run("it returns an error if blocking retries per key are used without DB retries", func(t *testing.T) {
+		_, err := NewBuilder().
+			SetKafkaHost([]string{"broker1"}).
+			SetKafkaGroup("group").
+			SetSourceTopics([]string{"product"}).
+			UseBlockingRetriesPerKey(true).
+			Config()
+
+		if err == nil {
+			t.Error("expected an error but got nil")
+		}
+	})
+
+	t.Run("it returns an error if blocking retries per key are used without retry intervals", func(t *testing.T) {
+		_, err := NewBuilder().
+			SetKafkaHost([]string{"broker1"}).
+			SetKafkaGroup("group").
+			SetSourceTopics([]string{"product"}).
+			UseDbForRetries(true).
+			UseBlockingRetriesPerKey(true).
+			Config()
+
+		if err == nil {
+			t.Error("expected an error but got nil")
+		}
+	})
+
+	t.Run("it returns an error if DB retry notifications are used without DB retries in Postgres", func(t *testing.T) {
+		_, err := NewBuilder().
+			SetKafkaHost([]string{"broker1"}).
+			SetKafkaGroup("group").
+			SetSourceTopics([]string{"product"}).
+			UseDBRetryNotifications(true).
+			Config()
+		if err == nil {
+			t.Error("expected an error without DB retries but got nil")
+		}
+
+		_, err = NewBuilder().
+			SetKafkaHost([]string{"broker1"}).
+			SetKafkaGroup("group").
+			SetSourceTopics([]string{"product"}).
+			UseDbForRetries(true).
+			SetDBDriver("sqlite").
+			UseDBRetryNotifications(true).
+			Config()
+		if err == nil {
+			t.Error("expected an error with the sqlite DB driver but got nil")
+		}
+	})
+
+	t.Run("it returns an error for an invalid DB retry batch size", func(t *testing.T) {
+		_, err := NewBuilder().
+			SetKafkaHost([]string{"broker1"}).
+			SetKafkaGroup("group").
+			SetSourceTopics([]string{"product"}).
+			SetDBRetryBatchSize("product", 0).
+			Config()
+		if err == nil {
+			t.Error("expected an error for a batch size of 0 but got nil")
+		}
+
+		_, err = NewBuilder().
+			SetKafkaHost([]string{"broker1"}).
+			SetKafkaGroup("group").
+			SetSourceTopics([]string{"product"}).
+			SetDBRetryBatchSize("missing", 10).
+			Config()
+		if err == nil {
+			t.Error("expected an error for a topic that is not a source topic but got nil")
+		}
+	})
+
+	t.Run("it returns an error if there are no DB retry workers", func(t *testing.T) {
+		_, err := NewBuilder().
+			SetKafkaHost([]string{"broker1"}).
+			SetKafkaGroup("group").
+			SetSourceTopics([]string{"product"}).
+			SetDBRetryWorkers(0).
+			Config()
+		if err == nil {
+			t.Error("expected an error but got nil")
+		}
+	})
+
+	t.Run("it sets the DB retry retention", func(t *testing.T) {
+		c, err := NewBuilder().
+			SetKafkaHost([]string{"broker1"}).
+			SetKafkaGroup("group").
+			SetSourceTopics([]string{"product"}).
+			SetRetryIntervals([]int{60}).
+			UseDbForRetries(true).
+			SetDBRetryRetention(store.StateDeadlettered, time.Hour*24*30).
+			SetDBRetryRetentionForTopic("product", store.StateSuccessful, 0).
+			ArchiveExpiredDBRetriesToDir("/var/lib/archive").
+			Config()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if p := c.DBRetryRetention.Period("product", store.StateSuccessful); p != 0 {
+			t.Errorf("expected successful retries of product to be kept forever, got %s", p)
+		}
+		if p := c.DBRetryRetention.Period("product", store.StateDeadlettered); p != time.Hour*24*30 {
+			t.Errorf("expected dead-lettered retries to be kept for 30 days, got %s", p)
+		}
+		if c.DBRetryRetention.ArchiveDir != "/var/lib/archive" {
+			t.Error("expected expired retries to be archived to the directory")
+		}
+	})
+
+	t.Run("it partitions DB retries", func(t *testing.T) {
+		c, err := NewBuilder().
+			SetKafkaHost([]string{"broker1"}).
+			SetKafkaGroup("group").
+			SetSourceTopics([]string{"product"}).
+			UseDbForRetries(true).
+			PartitionDBRetries(time.Hour*24, time.Hour*24*7).
+			Config()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if diff := deep.Equal(c.DBRetryPartitions, DBRetryPartitions{Interval: time.Hour * 24, Retention: time.Hour * 24 * 7}); diff != nil {
+			t.Error(diff)
+		}
+		if p := c.DBRetryRetention.Period("product", store.StateSuccessful); p != 0 {
+			t.Errorf("expected successful retries to be kept until their partition is dropped, got %s", p)
+		}
+	})
+
+	t.Run("it returns an error for invalid DB retry partitions", func(t *testing.T) {
+		builders := map[string]*Builder{
+			"short interval":     NewBuilder().PartitionDBRetries(time.Minute, 0),
+			"negative retention": NewBuilder().PartitionDBRetries(time.Hour, -time.Hour),
+			"sqlite driver":      NewBuilder().PartitionDBRetries(time.Hour, 0).SetDBDriver("sqlite"),
+			"unpartitioned store": NewBuilder().PartitionDBRetries(time.Hour, 0).
+				SetRetryStore(nullRetryStore{}),
+		}
+
+		for name, b := range builders {
+			_, err := b.
+				SetKafkaHost([]string{"broker1"}).
+				SetKafkaGroup("group").
+				SetSourceTopics([]string{"product"}).
+				UseDbForRetries(true).
+				Config()
+			if err == nil {
+				t.Errorf("expected an error for %s but got nil", name)
+			}
+		}
+	})
+
+	t.Run("it returns an error if DB dead letters cannot be published to Kafka", func(t *testing.T) {
+		_, err := NewBuilder().
+			SetKafkaHost([]string{"broker1"}).
+			SetKafkaGroup("group").
+			SetSourceTopics([]string{"product"}).
+			PublishDBDeadLettersToKafka(true).
+			Config()
+		if err == nil {
+			t.Error("expected an error without DB retries but got nil")
+		}
+
+		_, err = NewBuilder().
+			SetKafkaHost([]string{"broker1"}).
+			SetKafkaGroup("group").
+			SetSourceTopics([]string{"product"}).
+			SetRetryStore(nullRetryStore{}).
+			PublishDBDeadLettersToKafka(true).
+			Config()
+		if err == nil {
+			t.Error("expected an error with a store that is not an outbox but got nil")
+		}
+	})
+
+	t.Run("it returns an error for an invalid DB retry retention", func(t *testing.T) {
+		builders := map[string]*Builder{
+			"negative period": NewBuilder().
+				SetDBRetryRetention(store.StateErrored, -time.Hour),
+			"unknown state": NewBuilder().
+				SetDBRetryRetention("parked", time.Hour),
+			"unknown topic": NewBuilder().
+				SetDBRetryRetentionForTopic("missing", store.StateSuccessful, time.Hour),
+			"errored period within a retry interval": NewBuilder().
+				SetRetryIntervals([]int{7200}).
+				SetDBRetryRetention(store.StateErrored, time.Hour),
+			"store without retention": NewBuilder().
+				SetRetryStore(nullRetryStore{}).
+				SetDBRetryRetention(store.StateDeadlettered, time.Hour),
+			"store without archive table": NewBuilder().
+				SetRetryStore(nullRetryStore{}).
+				ArchiveExpiredDBRetriesToTable(true),
+		}
+
+		for name, b := range builders {
+			_, err := b.
+				SetKafkaHost([]string{"broker1"}).
+				SetKafkaGroup("group").
+				SetSourceTopics([]string{"product"}).
+				UseDbForRetries(true).
+				Config()
+			if err == nil {
+				t.Errorf("expected an error for %s but got nil", name)
+			}
+		}
+	})
+
+	t.Run("it returns an error if a retry partitioner is set when keeping the retry partition", func(t *testing.T) {
+		_, err := NewBuilder().
+			SetKafkaHost([]string{"broker1"}).
+			SetKafkaGroup("group").
+			SetSourceTopics([]string{"product"}).
+			SetRetryPartitioner(sarama.NewRandomPartitioner).
+			KeepRetryPartition(true).
+			Config()
+
+		if err == nil {
+			t.Error("expected an error but got nil")
+		}
+	})
+
+	t.Run("it returns an error if exactly-once retries are used with a separate retry cluster", func(t *testing.T) {
+		_, err := NewBuilder().
+			SetKafkaHost([]string{"broker1"}).
+			SetRetryKafkaHost([]string{"retry-broker1"}).
+			SetKafkaGroup("group").
+			SetSourceTopics([]string{"product"}).
+			UseExactlyOnceRetries(true).
+			Config()
+
+		if err == nil {
+			t.Error("expected an error but got nil")
+		}
+	})
+
+	t.Run("it returns an error if exactly-once retries are used with the async retry producer", func(t *testing.T) {
+		_, err := NewBuilder().
+			SetKafkaHost([]string{"broker1"}).
+			SetKafkaGroup("group").
+			SetSourceTopics([]string{"product"}).
+			UseAsyncRetryProducer(true).
+			UseExactlyOnceRetries(true).
+			Config()
+
+		if err == nil {
+			t.Error("expected an error but got nil")
+		}
+	})
+
+	t.Run("it returns an error if exactly-once retries are used with DB retries", func(t *testing.T) {
+		_, err := NewBuilder().
+			SetKafkaHost([]string{"broker1"}).
+			SetKafkaGroup("group").
+			SetSourceTopics([]string{"product"}).
+			SetDBHost("postgres").
+			UseDbForRetries(true).
+			UseExactlyOnceRetries(true).
+			Config()
+
+		if err == nil {
+			t.Error("expected an error but got nil")
+		}
+	})
+
+	t.Run("it creates config with exactly-once retries", func(t *testing.T) {
+		c, err := NewBuilder().
+			SetKafkaHost([]string{"broker1"}).
+			SetKafkaGroup("group").
+			SetSourceTopics([]string{"product"}).
+			UseExactlyOnceRetries(true).
+			SetTransactionalID("consumer-1").
+			Config()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if !c.ExactlyOnceRetries || c.TransactionalID != "consumer-1" {
+			t.Error("expected exactly-once retries to be configured")
+		}
+	})
+
+	t.Run("it creates config with a retry store", func(t *testing.T) {
+		c, err := NewBuilder().
+			SetKafkaHost([]string{"broker1"}).
+			SetKafkaGroup("group").
+			SetSourceTopics([]string{"product"}).
+			SetRetryStore(nullRetryStore{}).
+			Config()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if c.RetryStore == nil || !c.UseDBForRetryQueue {
+			t.Error("expected DB retries to use the retry store")
+		}
+	})
+
+	t.Run("it returns an error if blocking retries per key are used with a store that cannot park messages", func(t *testing.T) {
+		_, err := NewBuilder().
+			SetKafkaHost([]string{"broker1"}).
+			SetKafkaGroup("group").
+			SetSourceTopics([]string{"product"}).
+			SetRetryStore(nullRetryStore{}).
+			UseBlockingRetriesPerKey(true).
+			Config()
+
+		if err == nil {
+			t.Error("expected an error but got nil")
+		}
+	})
+
+	t.Run("it creates config with the sqlite DB driver", func(t *testing.T) {
+		c, err := NewBuilder().
+			SetKafkaHost([]string{"broker1"}).
+			SetKafkaGroup("group").
+			SetSourceTopics([]string{"product"}).
+			UseDbForRetries(true).
+			SetDBDriver("sqlite").
+			SetDBSchema("/var/lib/retries.db").
+			Config()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if c.DBDriver() != "sqlite" || c.DBSchema() != "/var/lib/retries.db" {
+			t.Error("expected the sqlite DB driver to be configured")
+		}
+	})
+
+	t.Run("it returns an error if DB retries use an unsupported driver", func(t *testing.T) {
+		_, err := NewBuilder().
+			SetKafkaHost([]string{"broker1"}).
+			SetKafkaGroup("group").
+			SetSourceTopics([]string{"product"}).
+			UseDbForRetries(true).
+			SetDBDriver("oracle").
+			Config()
+
+		if err == nil {
+			t.Error("expected an error but got nil")
+		}
+	})
+
 	t.Run("it returns an error if kafka host is not set", func(t *testing.T) {
 		_, err := NewBuilder().
 			SetKafkaGroup("group").
@@ -201,3 +618,33 @@ func TestBuilder_Config(t *testing.T) {
 		}
 	})
 }
+
+type nullRetryStore struct{}
+
+func (nullRetryStore) GetMessagesForRetry(ctx context.Context, topic string, sequence uint8, interval time.Duration, claim store.Claim) ([]model.Retry, error) {
+	return nil, nil
+}
+
+func (nullRetryStore) MarkRetrySuccessful(ctx context.Context, retry model.Retry) error {
+	return nil
+}
+
+func (nullRetryStore) MarkRetryErrored(ctx context.Context, retry model.Retry, err error) error {
+	return nil
+}
+
+func (nullRetryStore) RenewLease(ctx context.Context, retries []model.Retry, claim store.Claim) error {
+	return nil
+}
+
+func (nullRetryStore) ReleaseRetries(ctx context.Context, retries []model.Retry) error {
+	return nil
+}
+
+func (nullRetryStore) PublishFailure(ctx context.Context, failure failuremodel.Failure) error {
+	return nil
+}
+
+func (nullRetryStore) DeleteSuccessful(ctx context.Context, olderThan time.Time) error {
+	return nil
+}
@@ -4,12 +4,19 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"net"
 	"net/url"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/IBM/sarama"
+	"github.com/go-sql-driver/mysql"
+
 	"github.com/revdaalex/kafka-consumer-go/data"
+	"github.com/revdaalex/kafka-consumer-go/data/failure/model"
+	"github.com/revdaalex/kafka-consumer-go/data/retry/store"
+	"github.com/revdaalex/kafka-consumer-go/status"
 )
 
 var (
@@ -17,19 +24,56 @@ var (
 )
 
 type Config struct {
-	Host             []string
-	RetryHost        []string
+	// Kafka holds the connection settings for the cluster that the main topics are consumed from
+	Kafka KafkaCluster
+	// RetryKafka holds the connection settings for the cluster that retry and dead-letter topics live on,
+	// this is the same as Kafka unless a separate retry cluster has been configured
+	RetryKafka       KafkaCluster
 	Group            string
 	ConsumableTopics []*KafkaTopic
 	TopicMap         map[TopicKey]*KafkaTopic
 	// DBRetries is indexed by the topic name, and represents retry intervals for processing retries in the DB
-	DBRetries           DBRetries
-	TLSEnable           bool
-	TLSSkipVerifyPeer   bool
-	db                  Database
-	UseDBForRetryQueue  bool
-	MaintenanceInterval time.Duration
-	topicNameGenerator  topicNameGenerator
+	DBRetries DBRetries
+	// TLSEnable and TLSSkipVerifyPeer are used when connecting to the database,
+	// see KafkaCluster for the Kafka TLS settings
+	TLSEnable          bool
+	TLSSkipVerifyPeer  bool
+	db                 Database
+	UseDBForRetryQueue bool
+	// BlockingRetriesPerKey parks messages behind a pending DB retry for the same key, see UseBlockingRetriesPerKey
+	BlockingRetriesPerKey bool
+	// DBRetryNotifications wakes DB retry processors with Postgres notifications, see UseDBRetryNotifications
+	DBRetryNotifications bool
+	MaintenanceInterval  time.Duration
+	TopicCreation        TopicCreation
+	Preflight            Preflight
+	// RetryPartitioner chooses the partition of retries published to Kafka, if nil the key is hashed
+	RetryPartitioner sarama.PartitionerConstructor
+	// KeepRetryPartition publishes retries to the partition number of the original message
+	KeepRetryPartition bool
+	// AsyncRetryProducer publishes retries to Kafka in batches, see UseAsyncRetryProducer
+	AsyncRetryProducer AsyncRetryProducer
+	// RetryPublishErrorHandler is called with failures that could not be published for retry
+	RetryPublishErrorHandler RetryPublishErrorHandler
+	// ExactlyOnceRetries publishes retries in the same Kafka transaction as the offset commit, see UseExactlyOnceRetries
+	ExactlyOnceRetries bool
+	// TransactionalID of the retry producer, if empty the Kafka group and hostname are used
+	TransactionalID string
+	// DBRetryWorkers is how many DB retries of a batch each processor handles at once, see SetDBRetryWorkers
+	DBRetryWorkers int
+	// DBRetryRetention is how long maintenance keeps DB retries for, see SetDBRetryRetention
+	DBRetryRetention DBRetryRetention
+	// MaintenanceReportHandler is called with what each run of the DB retry maintenance removed
+	MaintenanceReportHandler MaintenanceReportHandler
+	// DBRetryPartitions is how the retries table is partitioned, see PartitionDBRetries
+	DBRetryPartitions DBRetryPartitions
+	// PublishDBDeadLetters publishes dead-lettered DB retries to Kafka, see PublishDBDeadLettersToKafka
+	PublishDBDeadLetters bool
+	// WorkerID is recorded against the DB retries this instance claims, if empty the hostname and process ID are used
+	WorkerID string
+	// RetryStore keeps database retries instead of the Postgres database, see SetRetryStore
+	RetryStore         store.Store
+	topicNameGenerator topicNameGenerator
 
 	// memoized services
 	services map[string]interface{}
@@ -43,6 +87,50 @@ type KafkaTopic struct {
 	IsMainTopic bool
 }
 
+// KafkaCluster represents the connection, TLS and auth settings for a single Kafka cluster.
+type KafkaCluster struct {
+	Host              []string
+	TLSEnable         bool
+	TLSSkipVerifyPeer bool
+	// SASLUser and SASLPassword are used for SASL/PLAIN authentication, which is only enabled if a user is set
+	SASLUser     string
+	SASLPassword string
+}
+
+// TopicCreation controls the creation of missing retry and deadLetter topics on startup.
+type TopicCreation struct {
+	Enable bool
+	// DryRun will only report the topics that would be created
+	DryRun bool
+	// ReplicationFactor of created topics, if zero the replication factor of the source topic is used
+	ReplicationFactor int16
+	// Retention of created topics, if zero the broker default is used
+	Retention time.Duration
+}
+
+// AsyncRetryProducer controls the batching and delivery of retries published to Kafka by the async producer.
+type AsyncRetryProducer struct {
+	Enable bool
+	// FlushMessages is the number of messages that triggers a batch to be sent, if zero it defaults to 100
+	FlushMessages int
+	// FlushFrequency is how often a batch is sent, if zero it defaults to 100ms
+	FlushFrequency time.Duration
+	// MaxDeliveryAttempts is how many times publishing a failure is attempted before giving up, if zero it defaults to 3
+	MaxDeliveryAttempts int
+}
+
+// RetryPublishErrorHandler is called with a failure, and the error from the last attempt, when the failure could
+// not be published to the next retry or deadLetter topic. The offset of the original message has already been
+// marked, so the handler is the last chance to do something with the message.
+type RetryPublishErrorHandler func(f model.Failure, err error)
+
+// Preflight controls the check of the topic chains that runs on startup.
+type Preflight struct {
+	Enable bool
+	// FailOnProblem will stop the consumer from starting if the check finds any problem
+	FailOnProblem bool
+}
+
 type Database struct {
 	Host   string
 	Port   int
@@ -108,6 +196,33 @@ func (cfg *Config) MainTopics() []string {
 	return mainTopics
 }
 
+// DerivedTopics will return the retry and dead-letter topics in the chain of the given
+// main topic, in the order that messages pass through them.
+func (cfg *Config) DerivedTopics(mainTopic string) []*KafkaTopic {
+	var derived []*KafkaTopic
+
+	topic, ok := cfg.TopicMap[TopicKey(mainTopic)]
+	if !ok {
+		return derived
+	}
+
+	for next := topic.Next; next != nil; next = next.Next {
+		derived = append(derived, next)
+	}
+
+	return derived
+}
+
+// DeadLetterTopic returns the name of the deadLetter topic of the main topic, or an empty string if the
+// main topic is not consumed.
+func (cfg *Config) DeadLetterTopic(mainTopic string) string {
+	derived := cfg.DerivedTopics(mainTopic)
+	if len(derived) == 0 {
+		return ""
+	}
+	return derived[len(derived)-1].Name
+}
+
 // DB will connect to the database and return a *sql.DB value
 // If a database connection already exists, it will return that instead of
 // creating another one.
@@ -116,11 +231,52 @@ func (cfg *Config) DB() (*sql.DB, error) {
 		return db.(*sql.DB), nil
 	}
 
-	db, err := data.NewDB(cfg.dsn())
+	var db *sql.DB
+	var err error
+	switch cfg.db.Driver {
+	case data.DriverSQLite:
+		db, err = data.NewSQLiteDB(cfg.db.Schema)
+	case data.DriverMySQL:
+		db, err = data.NewMySQLDB(cfg.mysqlDSN())
+	default:
+		db, err = data.NewDB(cfg.dsn())
+	}
 	cfg.services["db"] = db
 	return db, err
 }
 
+// Status returns the tracker that the consumer started with this config records its status in, which
+// is nil if the config was not created by a Builder.
+func (cfg *Config) Status() *status.Tracker {
+	t, _ := cfg.services["status"].(*status.Tracker)
+	return t
+}
+
+// setDBRetryBatchSizes sets the batch size of the DB retries for each topic, using the default
+// for any topic without one.
+func (cfg *Config) setDBRetryBatchSizes(sizes map[string]int) error {
+	for topic, size := range sizes {
+		if _, ok := cfg.DBRetries[topic]; !ok {
+			return fmt.Errorf("consumer/config: a DB retry batch size was set for '%s', which is not a source topic", topic)
+		}
+		if size < 1 {
+			return fmt.Errorf("consumer/config: the DB retry batch size for '%s' must be at least 1", topic)
+		}
+	}
+
+	for topic, retries := range cfg.DBRetries {
+		size, ok := sizes[topic]
+		if !ok {
+			size = DefaultDBRetryBatchSize
+		}
+		for _, r := range retries {
+			r.BatchSize = size
+		}
+	}
+
+	return nil
+}
+
 func (cfg *Config) addTopicsFromSource(topics []string, retryIntervals []int) error {
 	cfg.DBRetries = map[string][]*DBTopicRetry{}
 
@@ -175,6 +331,28 @@ func (cfg *Config) addTopicsFromSource(topics []string, retryIntervals []int) er
 	return nil
 }
 
+// validateExactlyOnceRetries checks that retries can be published in the same transaction as the offset commit,
+// which is only possible with Kafka retry topics that live on the same cluster as the main topics.
+func (cfg *Config) validateExactlyOnceRetries() error {
+	if !cfg.ExactlyOnceRetries {
+		return nil
+	}
+
+	if cfg.UseDBForRetryQueue {
+		return errors.New("consumer/config: exactly-once retries cannot be used with database retries")
+	}
+
+	if cfg.AsyncRetryProducer.Enable {
+		return errors.New("consumer/config: exactly-once retries cannot be used with the async retry producer")
+	}
+
+	if strings.Join(cfg.RetryKafka.Host, ",") != strings.Join(cfg.Kafka.Host, ",") {
+		return errors.New("consumer/config: exactly-once retries cannot be used with a separate retry cluster")
+	}
+
+	return nil
+}
+
 func (cfg *Config) dsn() string {
 	sslMode := "disable"
 	if cfg.TLSEnable {
@@ -192,6 +370,35 @@ func (cfg *Config) dsn() string {
 	)
 }
 
+// mysqlDSN returns the DSN in the format of the go-sql-driver/mysql driver, which is not a URL.
+func (cfg *Config) mysqlDSN() string {
+	mc := mysql.NewConfig()
+	mc.User = cfg.db.User
+	mc.Passwd = cfg.db.Pass
+	mc.Net = "tcp"
+	mc.Addr = net.JoinHostPort(cfg.db.Host, strconv.Itoa(cfg.db.Port))
+	mc.DBName = cfg.db.Schema
+	// the migrations run several statements at once
+	mc.MultiStatements = true
+
+	if cfg.TLSEnable {
+		mc.TLSConfig = "true"
+		if cfg.TLSSkipVerifyPeer {
+			mc.TLSConfig = "skip-verify"
+		}
+	}
+
+	return mc.FormatDSN()
+}
+
+func isSupportedDBDriver(driver string) bool {
+	switch driver {
+	case data.DriverPostgres, data.DriverSQLite, data.DriverMySQL:
+		return true
+	}
+	return false
+}
+
 func (cfg *Config) addTopics(topics []*KafkaTopic) {
 	cfg.ConsumableTopics = append(cfg.ConsumableTopics, topics[:len(topics)-1]...)
 
@@ -204,12 +411,21 @@ func (cfg *Config) addTopics(topics []*KafkaTopic) {
 }
 
 func (cfg *Config) loadFromBuilder(b *Builder) error {
-	cfg.Host = b.kafkaHost
-	cfg.RetryHost = b.retryKafkaHost
+	cfg.Kafka = KafkaCluster{
+		Host:              b.kafkaHost,
+		TLSEnable:         b.tlsEnable,
+		TLSSkipVerifyPeer: b.tlsSkipVerifyPeer,
+		SASLUser:          b.kafkaSASLUser,
+		SASLPassword:      b.kafkaSASLPassword,
+	}
+	cfg.RetryKafka = cfg.retryClusterFromBuilder(b)
 	cfg.Group = b.kafkaGroup
 	cfg.TLSEnable = b.tlsEnable
 	cfg.TLSSkipVerifyPeer = b.tlsSkipVerifyPeer
 	cfg.UseDBForRetryQueue = b.useDbForRetries
+	cfg.BlockingRetriesPerKey = b.blockingRetries
+	cfg.DBRetryNotifications = b.dbNotifications
+	cfg.DBRetryWorkers = b.dbRetryWorkers
 	cfg.db.Host = b.dBHost
 	cfg.db.User = b.dBUser
 	cfg.db.Pass = b.dBPass
@@ -217,6 +433,20 @@ func (cfg *Config) loadFromBuilder(b *Builder) error {
 	cfg.db.Port = b.dBPort
 	cfg.db.Driver = b.dBDriver
 	cfg.MaintenanceInterval = b.maintenanceInterval
+	cfg.DBRetryRetention = dbRetryRetentionFromBuilder(b)
+	cfg.MaintenanceReportHandler = b.maintenanceReportHandler
+	cfg.DBRetryPartitions = b.dbRetryPartitions
+	cfg.PublishDBDeadLetters = b.publishDBDeadLetters
+	cfg.TopicCreation = b.topicCreation
+	cfg.Preflight = b.preflight
+	cfg.RetryPartitioner = b.retryPartitioner
+	cfg.KeepRetryPartition = b.keepRetryPartition
+	cfg.AsyncRetryProducer = b.asyncRetryProducer
+	cfg.RetryPublishErrorHandler = b.retryPublishErrorHandler
+	cfg.ExactlyOnceRetries = b.exactlyOnceRetries
+	cfg.TransactionalID = b.transactionalID
+	cfg.RetryStore = b.retryStore
+	cfg.WorkerID = b.workerID
 	cfg.topicNameGenerator = b.topicNameGenerator
 
 	retryIntervals := b.retryIntervals
@@ -225,7 +455,7 @@ func (cfg *Config) loadFromBuilder(b *Builder) error {
 		return errors.New("consumer/config: you must define some source topics")
 	}
 
-	if cfg.Host == nil || len(cfg.Host) == 0 {
+	if cfg.Kafka.Host == nil || len(cfg.Kafka.Host) == 0 {
 		return errors.New("consumer/config: you must define a kafka host")
 	}
 
@@ -233,10 +463,63 @@ func (cfg *Config) loadFromBuilder(b *Builder) error {
 		return errors.New("consumer/config: you must define a kafka group")
 	}
 
+	if cfg.BlockingRetriesPerKey && !cfg.UseDBForRetryQueue {
+		return errors.New("consumer/config: blocking retries per key can only be used with database retries")
+	}
+
+	if cfg.BlockingRetriesPerKey && len(retryIntervals) == 0 {
+		return errors.New("consumer/config: blocking retries per key require some retry intervals")
+	}
+
+	if cfg.UseDBForRetryQueue && cfg.RetryStore == nil && !isSupportedDBDriver(cfg.db.Driver) {
+		return fmt.Errorf("consumer/config: unsupported database driver '%s'", cfg.db.Driver)
+	}
+
+	if cfg.DBRetryNotifications && (!cfg.UseDBForRetryQueue || cfg.RetryStore != nil || cfg.db.Driver != data.DriverPostgres) {
+		return errors.New("consumer/config: DB retry notifications can only be used with database retries kept in Postgres")
+	}
+
+	if cfg.DBRetryWorkers < 1 {
+		return errors.New("consumer/config: the number of DB retry workers must be at least 1")
+	}
+
+	if _, ok := cfg.RetryStore.(store.KeyBlockingStore); cfg.BlockingRetriesPerKey && cfg.RetryStore != nil && !ok {
+		return errors.New("consumer/config: blocking retries per key require a retry store that can park messages")
+	}
+
+	if cfg.PublishDBDeadLetters && !cfg.UseDBForRetryQueue {
+		return errors.New("consumer/config: publishing DB dead letters to Kafka can only be used with database retries")
+	}
+
+	if _, ok := cfg.RetryStore.(store.DeadLetterOutbox); cfg.PublishDBDeadLetters && cfg.RetryStore != nil && !ok {
+		return errors.New("consumer/config: publishing DB dead letters to Kafka requires a retry store that implements store.DeadLetterOutbox")
+	}
+
+	if cfg.KeepRetryPartition && cfg.RetryPartitioner != nil {
+		return errors.New("consumer/config: a retry partitioner cannot be set when keeping the retry partition")
+	}
+
+	if err := cfg.validateExactlyOnceRetries(); err != nil {
+		return err
+	}
+
 	if err := cfg.addTopicsFromSource(sourceTopics, retryIntervals); err != nil {
 		return fmt.Errorf("consumer/config: error loading config with topic names from builder: %w", err)
 	}
 
+	if err := cfg.setDBRetryBatchSizes(b.dbRetryBatchSizes); err != nil {
+		return err
+	}
+
+	if cfg.UseDBForRetryQueue {
+		if err := cfg.validateDBRetryRetention(); err != nil {
+			return err
+		}
+		if err := cfg.validateDBRetryPartitions(); err != nil {
+			return err
+		}
+	}
+
 	if cfg.MaintenanceInterval == 0 {
 		cfg.MaintenanceInterval = defaultMaintenanceInterval
 	}
@@ -244,6 +527,32 @@ func (cfg *Config) loadFromBuilder(b *Builder) error {
 	return nil
 }
 
+// retryClusterFromBuilder returns the settings for the retry cluster. These are inherited from the
+// main cluster, with anything set explicitly for the retry cluster in the builder taking precedence.
+func (cfg *Config) retryClusterFromBuilder(b *Builder) KafkaCluster {
+	c := cfg.Kafka
+
+	if len(b.retryKafkaHost) > 0 {
+		c.Host = b.retryKafkaHost
+	}
+	if b.retryTLSEnable != nil {
+		c.TLSEnable = *b.retryTLSEnable
+	}
+	if b.retryTLSSkipVerifyPeer != nil {
+		c.TLSSkipVerifyPeer = *b.retryTLSSkipVerifyPeer
+	}
+	if b.retryKafkaSASLUser != "" {
+		c.SASLUser = b.retryKafkaSASLUser
+		c.SASLPassword = b.retryKafkaSASLPassword
+	}
+
+	return c
+}
+
 func (cfg *Config) DBSchema() string {
 	return cfg.db.Schema
 }
+
+func (cfg *Config) DBDriver() string {
+	return cfg.db.Driver
+}
@@ -52,6 +52,45 @@ func TestConfig_NextTopicNameInChain(t *testing.T) {
 	})
 }
 
+func TestConfig_DerivedTopics(t *testing.T) {
+	cfg, err := NewBuilder().
+		SetKafkaHost([]string{"localhost"}).
+		SetKafkaGroup("group").
+		SetSourceTopics([]string{"product"}).
+		SetRetryIntervals([]int{60, 120}).
+		Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("it returns the retry and deadLetter topics in order", func(t *testing.T) {
+		var got []string
+		for _, topic := range cfg.DerivedTopics("product") {
+			got = append(got, topic.Name)
+		}
+
+		exp := []string{"retry1.group.product", "retry2.group.product", "deadLetter.group.product"}
+		if diff := deep.Equal(exp, got); diff != nil {
+			t.Error(diff)
+		}
+	})
+
+	t.Run("it returns no topics for an unknown topic", func(t *testing.T) {
+		if got := cfg.DerivedTopics("missing"); len(got) != 0 {
+			t.Errorf("expected no derived topics, but got %d", len(got))
+		}
+	})
+
+	t.Run("it returns the deadLetter topic", func(t *testing.T) {
+		if got := cfg.DeadLetterTopic("product"); got != "deadLetter.group.product" {
+			t.Errorf("expected the deadLetter topic of product, but got '%s'", got)
+		}
+		if got := cfg.DeadLetterTopic("missing"); got != "" {
+			t.Errorf("expected no deadLetter topic for an unknown topic, but got '%s'", got)
+		}
+	})
+}
+
 func TestConfig_AddTopics(t *testing.T) {
 	type fields struct {
 		Host             []string
@@ -225,7 +264,7 @@ func TestConfig_AddTopics(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			cfg := &Config{
-				Host:             tt.fields.Host,
+				Kafka:            KafkaCluster{Host: tt.fields.Host},
 				Group:            tt.fields.Group,
 				ConsumableTopics: tt.fields.ConsumableTopics,
 				TopicMap:         tt.fields.TopicMap,
@@ -308,7 +347,7 @@ func TestConfig_FindTopicKey(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			cfg := &Config{
-				Host:             tt.fields.Host,
+				Kafka:            KafkaCluster{Host: tt.fields.Host},
 				Group:            tt.fields.Group,
 				ConsumableTopics: tt.fields.ConsumableTopics,
 				TopicMap:         tt.fields.TopicMap,
@@ -381,10 +420,33 @@ func TestConfig_dsn(t *testing.T) {
 	}
 }
 
+func TestConfig_mysqlDSN(t *testing.T) {
+	cfg := Config{
+		db: Database{
+			Driver: "mysql",
+			Host:   "mysql-db",
+			Port:   3306,
+			Schema: "data",
+			User:   "root",
+			Pass:   "pass@123",
+		},
+	}
+
+	if got, want := cfg.mysqlDSN(), "root:pass@123@tcp(mysql-db:3306)/data?multiStatements=true"; got != want {
+		t.Errorf("mysqlDSN(): %s, want %s", got, want)
+	}
+
+	cfg.TLSEnable = true
+	cfg.TLSSkipVerifyPeer = true
+	if got, want := cfg.mysqlDSN(), "root:pass@123@tcp(mysql-db:3306)/data?multiStatements=true&tls=skip-verify"; got != want {
+		t.Errorf("mysqlDSN(): %s, want %s", got, want)
+	}
+}
+
 func TestConfig_MainTopics(t *testing.T) {
 	t.Run("main topics returned", func(t *testing.T) {
 		cfg := Config{
-			Host:  []string{"broker1", "broker2"},
+			Kafka: KafkaCluster{Host: []string{"broker1", "broker2"}},
 			Group: "kafkaGroup",
 			ConsumableTopics: []*KafkaTopic{
 				{
@@ -6,6 +6,14 @@ import (
 	"github.com/revdaalex/kafka-consumer-go/data/retry/model"
 )
 
+// DefaultDBRetryBatchSize is the most retries for a topic that are claimed at once, unless
+// a batch size is set for the topic with SetDBRetryBatchSize.
+const DefaultDBRetryBatchSize = 250
+
+// DefaultDBRetryWorkers is how many retries from a batch each DB retry processor handles at once, unless
+// it is changed with SetDBRetryWorkers.
+const DefaultDBRetryWorkers = 1
+
 type DBRetries map[string][]*DBTopicRetry
 
 // DBTopicRetry represents retry configuration for a topic when it is processed from the DB.
@@ -16,6 +24,8 @@ type DBTopicRetry struct {
 	// sequence in the retry flow.
 	Sequence uint8
 	Key      TopicKey
+	// BatchSize is the most retries that are claimed from the DB at once for the topic.
+	BatchSize int
 }
 
 // MakeRetryErrored will increment the Attempts field on the retry, and then mark it errored
@@ -41,9 +51,18 @@ func (dr DBRetries) MakeRetrySuccessful(retry model.Retry) model.Retry {
 	return retry
 }
 
+// BatchSizeForTopic returns the most retries that should be claimed at once for the topic.
+func (dr DBRetries) BatchSizeForTopic(topic string) int {
+	retries, ok := dr[topic]
+	if !ok || len(retries) == 0 || retries[0].BatchSize == 0 {
+		return DefaultDBRetryBatchSize
+	}
+	return retries[0].BatchSize
+}
+
 func (dr DBRetries) maxAttemptsForTopic(topic string) uint8 {
 	retries, ok := dr[topic]
-	if !ok {
+	if !ok || len(retries) == 0 {
 		return 0
 	}
 	last := retries[len(retries)-1]
@@ -50,6 +50,20 @@ func TestDBRetries_MakeRetryErrored(t *testing.T) {
 			t.Error(diff)
 		}
 	})
+
+	t.Run("it marks retry as dead-lettered when the topic has no retries", func(t *testing.T) {
+		retry := model.Retry{Topic: "bar"}
+		exp := model.Retry{
+			Topic:        "bar",
+			Attempts:     1,
+			Errored:      true,
+			Deadlettered: true,
+		}
+
+		if diff := deep.Equal(exp, DBRetries{"bar": []*DBTopicRetry{}}.MakeRetryErrored(retry)); diff != nil {
+			t.Error(diff)
+		}
+	})
 }
 
 func TestDBRetries_MakeRetrySuccessful(t *testing.T) {
@@ -82,3 +96,22 @@ func TestDBRetries_MakeRetrySuccessful(t *testing.T) {
 		}
 	})
 }
+
+func TestDBRetries_BatchSizeForTopic(t *testing.T) {
+	dr := DBRetries{
+		"product": {{Sequence: 1, BatchSize: 50}, {Sequence: 2, BatchSize: 50}},
+		"price":   {},
+	}
+
+	if got := dr.BatchSizeForTopic("product"); got != 50 {
+		t.Errorf("expected batch size 50 for 'product', got %d", got)
+	}
+
+	if got := dr.BatchSizeForTopic("price"); got != DefaultDBRetryBatchSize {
+		t.Errorf("expected the default batch size for 'price', got %d", got)
+	}
+
+	if got := dr.BatchSizeForTopic("missing"); got != DefaultDBRetryBatchSize {
+		t.Errorf("expected the default batch size for 'missing', got %d", got)
+	}
+}
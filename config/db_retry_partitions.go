@@ -0,0 +1,48 @@
+package config
+
+import (
+	"errors"
+	"time"
+
+	"github.com/revdaalex/kafka-consumer-go/data"
+	"github.com/revdaalex/kafka-consumer-go/data/retry/store"
+)
+
+// minPartitionInterval is the shortest interval of created_at that a partition of the retries table can hold,
+// as each partition is a table of its own.
+const minPartitionInterval = time.Hour
+
+// DBRetryPartitions is how the Postgres retries table is partitioned by when retries were created, see
+// PartitionDBRetries.
+type DBRetryPartitions struct {
+	// Interval of creation times held by each partition, which is zero if the table is not partitioned
+	Interval time.Duration
+	// Retention is how long after the end of its interval a partition is dropped, which is zero to keep them
+	Retention time.Duration
+}
+
+// validateDBRetryPartitions checks that the retries table can be partitioned.
+func (cfg *Config) validateDBRetryPartitions() error {
+	p := cfg.DBRetryPartitions
+	if p.Interval == 0 {
+		return nil
+	}
+
+	if p.Interval < minPartitionInterval {
+		return errors.New("consumer/config: the interval of DB retry partitions must be at least an hour")
+	}
+	if p.Retention < 0 {
+		return errors.New("consumer/config: the retention of DB retry partitions cannot be negative")
+	}
+
+	if cfg.RetryStore != nil {
+		if _, ok := cfg.RetryStore.(store.PartitionedStore); !ok {
+			return errors.New("consumer/config: partitioning DB retries requires a retry store that implements store.PartitionedStore")
+		}
+		return nil
+	}
+	if cfg.db.Driver != data.DriverPostgres {
+		return errors.New("consumer/config: DB retries can only be partitioned with the postgres driver")
+	}
+	return nil
+}
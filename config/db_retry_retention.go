@@ -0,0 +1,140 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/revdaalex/kafka-consumer-go/data/retry/store"
+)
+
+// DefaultSuccessfulRetention is how long successful DB retries are kept for, unless set with SetDBRetryRetention.
+const DefaultSuccessfulRetention = time.Hour
+
+// DBRetryRetention is how long DB retries are kept for by maintenance, by state, and how they are archived
+// before they are deleted. A period of zero keeps the retries in a state forever.
+type DBRetryRetention struct {
+	// Periods of each state, for every topic without its own period for the state
+	Periods map[store.RetryState]time.Duration
+	// Topics holds the periods of individual main topics
+	Topics map[string]map[store.RetryState]time.Duration
+	// ArchiveTable copies retries to the kafka_consumer_retries_archive table before they are deleted
+	ArchiveTable bool
+	// ArchiveDir writes retries to JSON lines files in the directory before they are deleted
+	ArchiveDir string
+}
+
+// Period returns how long retries of the topic are kept for in the state, which is zero if they are kept forever.
+func (r DBRetryRetention) Period(topic string, state store.RetryState) time.Duration {
+	if p, ok := r.Topics[topic][state]; ok {
+		return p
+	}
+	return r.Periods[state]
+}
+
+// needsRetentionStore returns true if the retention does more than delete successful retries, which any retry
+// store can do.
+func (r DBRetryRetention) needsRetentionStore() bool {
+	if r.ArchiveTable || r.ArchiveDir != "" || len(r.Topics) > 0 {
+		return true
+	}
+	for state, p := range r.Periods {
+		if state != store.StateSuccessful && p > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// MaintenanceReport is what a run of the DB retry maintenance removed.
+type MaintenanceReport struct {
+	StartedAt time.Time
+	Duration  time.Duration
+	// Removed holds the number of retries removed for each topic and state that had any
+	Removed []MaintenanceCount
+	// Err stopped the maintenance, which may have removed some retries before it
+	Err error
+}
+
+// MaintenanceCount is the number of retries of a topic in a state that were removed by maintenance.
+type MaintenanceCount struct {
+	Topic    string
+	State    store.RetryState
+	Deleted  int64
+	Archived int64
+}
+
+// MaintenanceReportHandler is called with the report of each run of the DB retry maintenance.
+type MaintenanceReportHandler func(r MaintenanceReport)
+
+// dbRetryRetentionFromBuilder returns the retention set in the builder, with the default period for successful
+// retries if none was set. Successful retries of a partitioned table are kept until their partition is dropped
+// instead, unless a period was set.
+func dbRetryRetentionFromBuilder(b *Builder) DBRetryRetention {
+	r := DBRetryRetention{
+		Periods:      map[store.RetryState]time.Duration{store.StateSuccessful: DefaultSuccessfulRetention},
+		Topics:       b.dbRetryTopicRetention,
+		ArchiveTable: b.archiveExpiredToTable,
+		ArchiveDir:   b.archiveExpiredToDir,
+	}
+	if b.dbRetryPartitions.Interval > 0 {
+		r.Periods = map[store.RetryState]time.Duration{}
+	}
+	for state, p := range b.dbRetryRetention {
+		r.Periods[state] = p
+	}
+	return r
+}
+
+// validateDBRetryRetention checks the retention against the topics and the retry store, once the DB retries
+// of each topic are known.
+func (cfg *Config) validateDBRetryRetention() error {
+	r := cfg.DBRetryRetention
+	if err := validateRetentionPeriods(r.Periods); err != nil {
+		return err
+	}
+
+	for topic, periods := range r.Topics {
+		if _, ok := cfg.DBRetries[topic]; !ok {
+			return fmt.Errorf("consumer/config: a DB retry retention was set for '%s', which is not a source topic", topic)
+		}
+		if err := validateRetentionPeriods(periods); err != nil {
+			return err
+		}
+	}
+
+	// errored retries are updated on each attempt, so they must be kept for longer than they wait for one
+	for topic, retries := range cfg.DBRetries {
+		p := r.Period(topic, store.StateErrored)
+		for _, rc := range retries {
+			if p > 0 && p <= rc.Interval {
+				return fmt.Errorf("consumer/config: the retention of errored DB retries of '%s' must be longer than its longest retry interval", topic)
+			}
+		}
+	}
+
+	if cfg.RetryStore == nil {
+		return nil
+	}
+	if _, ok := cfg.RetryStore.(store.RetentionStore); !ok && r.needsRetentionStore() {
+		return errors.New("consumer/config: the DB retry retention requires a retry store that implements store.RetentionStore")
+	}
+	if _, ok := cfg.RetryStore.(store.Archiver); !ok && r.ArchiveTable {
+		return errors.New("consumer/config: archiving DB retries to a table requires a retry store that implements store.Archiver")
+	}
+	return nil
+}
+
+func validateRetentionPeriods(periods map[store.RetryState]time.Duration) error {
+	for state, p := range periods {
+		switch state {
+		case store.StateSuccessful, store.StateDeadlettered, store.StateErrored:
+		default:
+			return fmt.Errorf("consumer/config: a DB retry retention was set for the unknown state '%s'", state)
+		}
+		if p < 0 {
+			return fmt.Errorf("consumer/config: the DB retry retention of %s retries cannot be negative", state)
+		}
+	}
+	return nil
+}
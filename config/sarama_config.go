@@ -29,3 +29,17 @@ func NewSaramaConfig(tlsEnable bool, tlsSkipVerify bool) *sarama.Config {
 
 	return cfg
 }
+
+// NewSaramaConfigForCluster returns a sarama config with the TLS and auth settings of the given cluster.
+func NewSaramaConfigForCluster(c KafkaCluster) *sarama.Config {
+	cfg := NewSaramaConfig(c.TLSEnable, c.TLSSkipVerifyPeer)
+
+	if c.SASLUser != "" {
+		cfg.Net.SASL.Enable = true
+		cfg.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+		cfg.Net.SASL.User = c.SASLUser
+		cfg.Net.SASL.Password = c.SASLPassword
+	}
+
+	return cfg
+}
@@ -16,3 +16,29 @@ func TestNewSaramaConfig(t *testing.T) {
 		t.Error("clientId not set on Sarama config")
 	}
 }
+
+func TestNewSaramaConfigForCluster(t *testing.T) {
+	t.Run("it uses the TLS settings of the cluster", func(t *testing.T) {
+		cfg := NewSaramaConfigForCluster(KafkaCluster{TLSEnable: true, TLSSkipVerifyPeer: true})
+
+		if !cfg.Net.TLS.Enable || !cfg.Net.TLS.Config.InsecureSkipVerify {
+			t.Error("expected TLS to be enabled without peer verification")
+		}
+
+		if cfg.Net.SASL.Enable {
+			t.Error("did not expect SASL to be enabled")
+		}
+	})
+
+	t.Run("it enables SASL when a user is set", func(t *testing.T) {
+		cfg := NewSaramaConfigForCluster(KafkaCluster{SASLUser: "user", SASLPassword: "pass"})
+
+		if !cfg.Net.SASL.Enable || cfg.Net.SASL.User != "user" || cfg.Net.SASL.Password != "pass" {
+			t.Error("expected SASL to be enabled with the cluster credentials")
+		}
+
+		if cfg.Net.TLS.Enable {
+			t.Error("did not expect TLS to be enabled")
+		}
+	})
+}
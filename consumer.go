@@ -1,6 +1,8 @@
 package consumer
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"time"
 
@@ -15,11 +17,31 @@ const (
 	nextTimeRetry = "NextTimeRetry"
 )
 
+var errBlockedByPendingRetry = errors.New("blocked by a pending retry for the same key")
+
 type consumer struct {
 	failureCh chan<- model.Failure
 	cfg       *config.Config
 	handlers  HandlerMap
 	logger    log.Logger
+	// keyBlocker is only set when blocking retries per key are enabled
+	keyBlocker keyBlocker
+	// txnPublisher is only set when exactly-once retries are enabled
+	txnPublisher txnPublisher
+}
+
+// keyBlocker is used by the consumer to hold back messages whose key already has a pending
+// retry, so that they are processed after that retry and in the order they were consumed.
+type keyBlocker interface {
+	HasPendingRetry(ctx context.Context, topic string, key []byte) (bool, error)
+	ParkFailure(ctx context.Context, f model.Failure) error
+	PublishFailure(ctx context.Context, f model.Failure) error
+}
+
+// txnPublisher is used by the consumer to publish a failure to the next topic in its chain in the same
+// Kafka transaction as the offset commit of the message that failed.
+type txnPublisher interface {
+	PublishFailureInTxn(session sarama.ConsumerGroupSession, msg *sarama.ConsumerMessage, f model.Failure) error
 }
 
 func newConsumer(fch chan<- model.Failure, cfg *config.Config, hs HandlerMap, l log.Logger) sarama.ConsumerGroupHandler {
@@ -31,6 +53,31 @@ func newConsumer(fch chan<- model.Failure, cfg *config.Config, hs HandlerMap, l
 	}
 }
 
+// newBlockingConsumer creates a consumer that parks messages behind any pending retry for the
+// same key. Failures are published synchronously with kb rather than over the failure channel,
+// so that the next message for a key always sees the retry of the one before it.
+func newBlockingConsumer(fch chan<- model.Failure, cfg *config.Config, hs HandlerMap, kb keyBlocker, l log.Logger) sarama.ConsumerGroupHandler {
+	return &consumer{
+		failureCh:  fch,
+		cfg:        cfg,
+		handlers:   hs,
+		logger:     l,
+		keyBlocker: kb,
+	}
+}
+
+// newTransactionalConsumer creates a consumer that publishes failures with tp, which commits the offset of
+// the failed message in the same transaction. Failures are published synchronously, and if that fails the
+// message is not marked, so that it is consumed again.
+func newTransactionalConsumer(cfg *config.Config, hs HandlerMap, tp txnPublisher, l log.Logger) sarama.ConsumerGroupHandler {
+	return &consumer{
+		cfg:          cfg,
+		handlers:     hs,
+		logger:       l,
+		txnPublisher: tp,
+	}
+}
+
 func (c *consumer) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
 	for {
 		select {
@@ -57,9 +104,17 @@ func (c *consumer) ConsumeClaim(session sarama.ConsumerGroupSession, claim saram
 				}
 			}
 
-			if needCheckRetryTime {
-				if retryTime.After(messageTime) {
-					time.Sleep(retryTime.Sub(messageTime))
+			// the wait is cut short when the session ends, so that a long retry delay does not hold up a
+			// rebalance of every topic sharing the consumer group. The message is not marked, so it is
+			// consumed again once the session restarts
+			if needCheckRetryTime && retryTime.After(messageTime) {
+				timer := time.NewTimer(retryTime.Sub(messageTime))
+				select {
+				case <-timer.C:
+				case <-session.Context().Done():
+					timer.Stop()
+					c.logger.Debug("consumer: session context finished while waiting to retry, returning")
+					return nil
 				}
 			}
 
@@ -71,6 +126,22 @@ func (c *consumer) ConsumeClaim(session sarama.ConsumerGroupSession, claim saram
 				return fmt.Errorf("consumer: handler not found for topic: %s", k)
 			}
 
+			if c.keyBlocker != nil {
+				if err = c.processWithKeyBlocking(session.Context(), h, message); err != nil {
+					return err
+				}
+				c.markMessageProcessed(session, message)
+				continue
+			}
+
+			if c.txnPublisher != nil {
+				if err = c.processInTxn(session, h, message); err != nil {
+					return err
+				}
+				c.markMessageProcessed(session, message)
+				continue
+			}
+
 			if err = h(session.Context(), message); err != nil {
 				c.sendToFailureChannel(message, err)
 			}
@@ -83,16 +154,76 @@ func (c *consumer) ConsumeClaim(session sarama.ConsumerGroupSession, claim saram
 	}
 }
 
+// processWithKeyBlocking will park the message if its key has a pending retry, otherwise it is passed
+// to the handler. An error is only returned if the message could not be stored for retry, in which
+// case it must not be marked as processed.
+func (c *consumer) processWithKeyBlocking(ctx context.Context, h Handler, message *sarama.ConsumerMessage) error {
+	if len(message.Key) > 0 {
+		blocked, err := c.keyBlocker.HasPendingRetry(ctx, message.Topic, message.Key)
+		if err != nil {
+			return fmt.Errorf("consumer: unable to check for pending retries for message key: %w", err)
+		}
+
+		if blocked {
+			c.logger.Debugf("parking message from topic '%s' behind a pending retry for the same key", message.Topic)
+			f := model.FailureFromSaramaMessage(errBlockedByPendingRetry, "", message)
+			if err = c.keyBlocker.ParkFailure(ctx, f); err != nil {
+				return fmt.Errorf("consumer: unable to park message behind pending retry: %w", err)
+			}
+			return nil
+		}
+	}
+
+	if err := h(ctx, message); err != nil {
+		if err = c.keyBlocker.PublishFailure(ctx, model.FailureFromSaramaMessage(err, "", message)); err != nil {
+			return fmt.Errorf("consumer: unable to publish failure for retry: %w", err)
+		}
+	}
+
+	return nil
+}
+
 func (c *consumer) markMessageProcessed(session sarama.ConsumerGroupSession, msg *sarama.ConsumerMessage) {
 	c.logger.Debugf("marking messages as processed")
 	session.MarkMessage(msg, "")
 }
 
 func (c *consumer) sendToFailureChannel(message *sarama.ConsumerMessage, err error) {
+	f, ok := c.failureForNextTopic(message, err)
+	if !ok {
+		return
+	}
+
+	c.failureCh <- f
+}
+
+// processInTxn passes the message to the handler, and publishes any failure in a transaction. An error is
+// only returned if the failure could not be published, in which case the message must not be marked.
+func (c *consumer) processInTxn(session sarama.ConsumerGroupSession, h Handler, message *sarama.ConsumerMessage) error {
+	err := h(session.Context(), message)
+	if err == nil {
+		return nil
+	}
+
+	f, ok := c.failureForNextTopic(message, err)
+	if !ok {
+		return nil
+	}
+
+	if err = c.txnPublisher.PublishFailureInTxn(session, message, f); err != nil {
+		return fmt.Errorf("consumer: unable to publish failure for retry in a transaction: %w", err)
+	}
+
+	return nil
+}
+
+// failureForNextTopic creates the failure for the next topic in the message's chain, with the time it
+// should be retried at set in the headers. It returns false if there is no next topic.
+func (c *consumer) failureForNextTopic(message *sarama.ConsumerMessage, err error) (model.Failure, bool) {
 	nextTopic, nextErr := c.cfg.NextTopicInChain(message.Topic)
 	if nextErr != nil {
 		c.logger.Errorf("no next topic to send failure to (deadletter topic being consumed?)")
-		return
+		return model.Failure{}, false
 	}
 
 	netTimeRetry := time.Now().Add(nextTopic.Delay)
@@ -108,13 +239,15 @@ func (c *consumer) sendToFailureChannel(message *sarama.ConsumerMessage, err err
 
 	message.Headers = append(message.Headers, retryHeader)
 
-	c.failureCh <- model.FailureFromSaramaMessage(err, nextTopic.Name, message)
+	return model.FailureFromSaramaMessage(err, nextTopic.Name, message), true
 }
 
-func (c *consumer) Setup(sarama.ConsumerGroupSession) error {
+func (c *consumer) Setup(session sarama.ConsumerGroupSession) error {
+	c.cfg.Status().AddClaims(session.Claims())
 	return nil
 }
 
-func (c *consumer) Cleanup(sarama.ConsumerGroupSession) error {
+func (c *consumer) Cleanup(session sarama.ConsumerGroupSession) error {
+	c.cfg.Status().RemoveClaims(session.Claims())
 	return nil
 }
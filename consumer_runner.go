@@ -2,6 +2,7 @@ package consumer
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"sync"
 
@@ -14,17 +15,32 @@ import (
 	"github.com/revdaalex/kafka-consumer-go/log"
 )
 
-func Start(cfg *config.Config, ctx context.Context, hs HandlerMap, logger log.Logger) error {
+func Start(cfg *config.Config, ctx context.Context, hs HandlerMap, logger log.Logger) (err error) {
 	if logger == nil {
 		logger = log.NullLogger{}
 	}
 
+	defer func() {
+		cfg.Status().Stopped(err)
+	}()
+
 	wg := &sync.WaitGroup{}
 	fch := make(chan model.Failure)
-	srmCfg := config.NewSaramaConfig(cfg.TLSEnable, cfg.TLSSkipVerifyPeer)
+	srmCfg := config.NewSaramaConfigForCluster(cfg.Kafka)
 
 	var cons collection
-	var err error
+
+	if cfg.TopicCreation.Enable && (!cfg.UseDBForRetryQueue || cfg.PublishDBDeadLetters) {
+		if err = createMissingTopics(cfg, defaultAdminConnector, logger); err != nil {
+			return fmt.Errorf("unable to create missing topics: %w", err)
+		}
+	}
+
+	if cfg.Preflight.Enable {
+		if err = checkTopics(cfg, defaultAdminConnector, logger); err != nil {
+			return fmt.Errorf("preflight check failed: %w", err)
+		}
+	}
 
 	if cfg.UseDBForRetryQueue {
 		cons, err = setupKafkaConsumerDbCollection(cfg, logger, fch, hs, srmCfg)
@@ -32,11 +48,12 @@ func Start(cfg *config.Config, ctx context.Context, hs HandlerMap, logger log.Lo
 			return err
 		}
 	} else {
-		kafkaProducer, err := newKafkaFailureProducerWithDefaults(cfg, fch, logger)
+		kafkaProducer, err := newKafkaFailureProducerForConfig(cfg, fch, logger)
 		if err != nil {
 			return fmt.Errorf("could not start Kafka failure producer: %w", err)
 		}
-		cons = newKafkaConsumerCollection(cfg, kafkaProducer, fch, hs, srmCfg, logger, defaultKafkaConnector)
+		retrySrmCfg := newRetryConsumerSaramaConfig(cfg)
+		cons = newKafkaConsumerCollection(cfg, kafkaProducer, fch, hs, srmCfg, retrySrmCfg, logger, defaultKafkaConnector)
 	}
 
 	if err := cons.start(ctx, wg); err != nil {
@@ -44,6 +61,7 @@ func Start(cfg *config.Config, ctx context.Context, hs HandlerMap, logger log.Lo
 	}
 	defer cons.close()
 
+	cfg.Status().Started()
 	logger.Info("kafka consumer started")
 
 	wg.Wait()
@@ -52,19 +70,89 @@ func Start(cfg *config.Config, ctx context.Context, hs HandlerMap, logger log.Lo
 }
 
 func setupKafkaConsumerDbCollection(cfg *config.Config, logger log.Logger, fch chan model.Failure, hs HandlerMap, srmCfg *sarama.Config) (collection, error) {
-	db, err := cfg.DB()
+	repo, db, err := newRetryManagerForConfig(cfg)
 	if err != nil {
-		return nil, fmt.Errorf("could not connect to DB: %w", err)
+		return nil, err
 	}
 
-	if err = data.MigrateDatabase(db, cfg.DBSchema()); err != nil {
-		return nil, fmt.Errorf("unable to migrate DB: %w", err)
-	}
-
-	repo := retry.NewManagerWithDefaults(cfg.DBRetries, db)
 	dbProducer := newDatabaseProducer(repo, fch, logger)
 	cons := newKafkaConsumerDbCollection(cfg, dbProducer, repo, fch, hs, srmCfg, logger, defaultKafkaConnector)
 	cons.setMaintenanceInterval(cfg.MaintenanceInterval)
+	if cfg.DBRetryNotifications {
+		cons.setRetryNotifier(retry.NewPostgresListener(db))
+	}
+	if cfg.PublishDBDeadLetters {
+		dlp, err := newDBDeadLetterProducerWithDefaults(cfg, repo, logger)
+		if err != nil {
+			return nil, err
+		}
+		cons.setDeadLetterProducer(dlp)
+	}
 
 	return cons, nil
 }
+
+// newRetryManagerForConfig returns a retry manager using the retry store set in the config, or the database
+// of the configured driver if none was set. The database is returned too, and is nil when a retry store is set.
+func newRetryManagerForConfig(cfg *config.Config) (*retry.Manager, *sql.DB, error) {
+	rm, db, err := newRetryManagerForStore(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if cfg.WorkerID != "" {
+		rm.SetWorkerID(cfg.WorkerID)
+	}
+	rm.SetDeadLetterPublishing(cfg.PublishDBDeadLetters)
+	rm.SetRetention(cfg.DBRetryRetention)
+	rm.SetPartitions(cfg.DBRetryPartitions)
+	rm.SetMaintenanceReportHandler(cfg.MaintenanceReportHandler)
+
+	if err := rm.CreatePartitions(context.Background()); err != nil {
+		return nil, nil, fmt.Errorf("unable to create DB retry partitions: %w", err)
+	}
+	return rm, db, nil
+}
+
+func newRetryManagerForStore(cfg *config.Config) (*retry.Manager, *sql.DB, error) {
+	if cfg.RetryStore != nil {
+		return retry.NewManager(cfg.DBRetries, cfg.RetryStore), nil, nil
+	}
+
+	db, err := cfg.DB()
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not connect to DB: %w", err)
+	}
+
+	if err = data.MigrateDatabaseForDriver(db, cfg.DBDriver(), cfg.DBSchema()); err != nil {
+		return nil, nil, fmt.Errorf("unable to migrate DB: %w", err)
+	}
+
+	if cfg.DBRetryPartitions.Interval > 0 {
+		if err = retry.PartitionRetriesTable(context.Background(), db, cfg.DBRetryPartitions.Interval); err != nil {
+			return nil, nil, fmt.Errorf("unable to partition DB retries: %w", err)
+		}
+	}
+
+	return retry.NewManagerForDriver(cfg.DBRetries, db, cfg.DBDriver()), db, nil
+}
+
+func newKafkaFailureProducerForConfig(cfg *config.Config, fch chan model.Failure, logger log.Logger) (failureProducer, error) {
+	if cfg.ExactlyOnceRetries {
+		return newKafkaTxnFailureProducerWithDefaults(cfg, logger)
+	}
+	if cfg.AsyncRetryProducer.Enable {
+		return newKafkaAsyncFailureProducerWithDefaults(cfg, fch, logger)
+	}
+	return newKafkaFailureProducerWithDefaults(cfg, fch, logger)
+}
+
+// newRetryConsumerSaramaConfig returns the sarama config for consuming retry topics. With exactly-once retries,
+// only retries from committed transactions are consumed.
+func newRetryConsumerSaramaConfig(cfg *config.Config) *sarama.Config {
+	scfg := config.NewSaramaConfigForCluster(cfg.RetryKafka)
+	if cfg.ExactlyOnceRetries {
+		scfg.Consumer.IsolationLevel = sarama.ReadCommitted
+	}
+	return scfg
+}
@@ -0,0 +1,89 @@
+package consumer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/revdaalex/kafka-consumer-go/config"
+	failuremodel "github.com/revdaalex/kafka-consumer-go/data/failure/model"
+	"github.com/revdaalex/kafka-consumer-go/data/retry/model"
+	"github.com/revdaalex/kafka-consumer-go/data/retry/store"
+)
+
+type stubRetryStore struct {
+	retries []model.Retry
+}
+
+func (s stubRetryStore) GetMessagesForRetry(ctx context.Context, topic string, sequence uint8, interval time.Duration, claim store.Claim) ([]model.Retry, error) {
+	return s.retries, nil
+}
+
+func (s stubRetryStore) MarkRetrySuccessful(ctx context.Context, retry model.Retry) error {
+	return nil
+}
+
+func (s stubRetryStore) MarkRetryErrored(ctx context.Context, retry model.Retry, err error) error {
+	return nil
+}
+
+func (s stubRetryStore) RenewLease(ctx context.Context, retries []model.Retry, claim store.Claim) error {
+	return nil
+}
+
+func (s stubRetryStore) ReleaseRetries(ctx context.Context, retries []model.Retry) error {
+	return nil
+}
+
+func (s stubRetryStore) PublishFailure(ctx context.Context, failure failuremodel.Failure) error {
+	return nil
+}
+
+func (s stubRetryStore) DeleteSuccessful(ctx context.Context, olderThan time.Time) error {
+	return nil
+}
+
+func TestNewRetryManagerForConfig(t *testing.T) {
+	t.Run("it uses the retry store from the config without connecting to the DB", func(t *testing.T) {
+		cfg := &config.Config{RetryStore: stubRetryStore{retries: []model.Retry{{ID: 10, Topic: "product"}}}}
+
+		rm, _, err := newRetryManagerForConfig(cfg)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		got, _ := rm.GetBatch(context.Background(), "product", 1, time.Second)
+		if len(got) != 1 || got[0].ID != 10 {
+			t.Errorf("expected the batch from the retry store, got %+v", got)
+		}
+	})
+	t.Run("it migrates and uses a SQLite database", func(t *testing.T) {
+		cfg, err := config.NewBuilder().
+			SetKafkaHost([]string{"broker1"}).
+			SetKafkaGroup("group").
+			SetSourceTopics([]string{"product"}).
+			SetRetryIntervals([]int{1}).
+			UseDbForRetries(true).
+			SetDBDriver("sqlite").
+			SetDBSchema(":memory:").
+			Config()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		rm, _, err := newRetryManagerForConfig(cfg)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		ctx := context.Background()
+		if err := rm.PublishFailure(ctx, failuremodel.Failure{Topic: "product", Message: []byte("{}"), MessageKey: []byte("SKU-1")}); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		got, err := rm.GetBatch(ctx, "product", 1, 0)
+		if err != nil || len(got) != 1 {
+			t.Errorf("expected the retry to be claimed from SQLite, got %+v (%v)", got, err)
+		}
+	})
+}
@@ -78,6 +78,44 @@ func TestConsumer_ConsumeClaim(t *testing.T) {
 	}
 }
 
+func TestConsumer_ConsumeClaim_SessionEndsBeforeRetryTime(t *testing.T) {
+	handler := &mockConsumerHandler{}
+	con := newConsumer(make(chan model.Failure), newTestConfig(), HandlerMap{"product": handler.handle}, log.NullLogger{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	gs := saramatest.NewMockConsumerGroupSessionWithContext(ctx)
+	gc := saramatest.NewMockConsumerGroupClaim()
+
+	msg1 := &sarama.ConsumerMessage{
+		Value: []byte(`{"type":"productCreated"}`),
+		Topic: "retry.kafkaGroup.product",
+		Headers: []*sarama.RecordHeader{{
+			Key:   []byte(nextTimeRetry),
+			Value: []byte(time.Now().Add(time.Hour).Format(time.RFC3339)),
+		}},
+	}
+	gc.PublishMessage(msg1)
+
+	done := make(chan error)
+	go func() {
+		done <- con.ConsumeClaim(gs, gc)
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("unexpected error occurred: %s", err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("expected consume claim to return when the session ended")
+	}
+
+	if gs.MessageWasMarked(msg1) || len(handler.recvdMessages) != 0 {
+		t.Error("did not expect the message to be processed before its retry time")
+	}
+}
+
 func TestConsumer_ConsumeClaim_WithFailure(t *testing.T) {
 	fch := make(chan model.Failure, 1)
 	cfg := newTestConfig()
@@ -130,12 +168,15 @@ func TestConsumer_ConsumeClaim_WithFailure(t *testing.T) {
 				Reason:         "oops",
 				Topic:          "product",
 				NextTopic:      "retry.kafkaGroup.product",
-				MessageHeaders: []byte(`{}`),
 				Message:        []byte(`{"type":"productCreated"}`),
 				MessageKey:     []byte("SKU-123"),
 				KafkaOffset:    10001,
 				KafkaPartition: 2,
 			}
+			if len(got.MessageHeaders) != 1 || string(got.MessageHeaders[0].Key) != nextTimeRetry {
+				t.Errorf("expected a single '%s' header on the failure, got %v", nextTimeRetry, got.MessageHeaders)
+			}
+			got.MessageHeaders = nil
 			if diff := deep.Equal(exp, got); diff != nil {
 				t.Error(diff)
 			}
@@ -144,6 +185,133 @@ func TestConsumer_ConsumeClaim_WithFailure(t *testing.T) {
 	}
 }
 
+func TestConsumer_ConsumeClaim_WithKeyBlocking(t *testing.T) {
+	fch := make(chan model.Failure, 1)
+	cfg := newTestConfig()
+	handler := &mockConsumerHandler{}
+	handler.willFail()
+	hs := HandlerMap{
+		"product": handler.handle,
+	}
+	rm := newMockRetryManager(false)
+
+	gs := saramatest.NewMockConsumerGroupSession()
+	gc := saramatest.NewMockConsumerGroupClaim()
+
+	msg1 := &sarama.ConsumerMessage{Value: []byte(`{"id":1}`), Topic: "product", Key: []byte("SKU-123")}
+	msg2 := &sarama.ConsumerMessage{Value: []byte(`{"id":2}`), Topic: "product", Key: []byte("SKU-123")}
+	msg3 := &sarama.ConsumerMessage{Value: []byte(`{"id":3}`), Topic: "product", Key: []byte("SKU-456")}
+	gc.PublishMessage(msg1)
+	gc.PublishMessage(msg2)
+	gc.PublishMessage(msg3)
+	gc.CloseChannel()
+
+	con := newBlockingConsumer(fch, cfg, hs, rm, log.NullLogger{})
+	if err := con.ConsumeClaim(gs, gc); err != nil {
+		t.Fatalf("unexpected error occurred: %s", err)
+	}
+
+	for _, msg := range []*sarama.ConsumerMessage{msg1, msg2, msg3} {
+		if !gs.MessageWasMarked(msg) {
+			t.Errorf("message with value %s was not marked as processed", msg.Value)
+		}
+	}
+
+	if len(handler.recvdMessages) != 2 || handler.recvdMessages[0] != msg1 || handler.recvdMessages[1] != msg3 {
+		t.Errorf("expected handler to only receive msg1 and msg3, but got %v", handler.recvdMessages)
+	}
+
+	if got := rm.getPublishedFailureCountByTopic("product"); got != 2 {
+		t.Errorf("expected 2 failures to be published synchronously, but got %d", got)
+	}
+
+	if got := len(rm.parkedFailures["product"]); got != 1 {
+		t.Fatalf("expected 1 parked message, but got %d", got)
+	}
+
+	if got := string(rm.parkedFailures["product"][0].Message); got != `{"id":2}` {
+		t.Errorf("expected msg2 to be parked, but got %s", got)
+	}
+
+	if len(fch) != 0 {
+		t.Error("expected no failures to be sent to the failure channel")
+	}
+}
+
+func TestConsumer_ConsumeClaim_WithKeyBlockingError(t *testing.T) {
+	handler := &mockConsumerHandler{}
+	handler.willFail()
+	rm := newMockRetryManager(true)
+
+	gs := saramatest.NewMockConsumerGroupSession()
+	gc := saramatest.NewMockConsumerGroupClaim()
+
+	msg1 := &sarama.ConsumerMessage{Value: []byte(`{"id":1}`), Topic: "product", Key: []byte("SKU-123")}
+	gc.PublishMessage(msg1)
+	gc.CloseChannel()
+
+	con := newBlockingConsumer(make(chan model.Failure), newTestConfig(), HandlerMap{"product": handler.handle}, rm, log.NullLogger{})
+	if err := con.ConsumeClaim(gs, gc); err == nil {
+		t.Error("expected an error but got nil")
+	}
+
+	if gs.MessageWasMarked(msg1) {
+		t.Error("msg1 should not be marked as processed when its failure could not be stored")
+	}
+}
+
+func TestConsumer_ConsumeClaim_WithTxnPublisher(t *testing.T) {
+	t.Run("failures are published in a transaction", func(t *testing.T) {
+		handler := &mockConsumerHandler{}
+		handler.willFail()
+		sp := saramatest.NewMockSyncProducer()
+		tp := newKafkaTxnFailureProducer(sp, "kafkaGroup", log.NullLogger{})
+
+		gs := saramatest.NewMockConsumerGroupSession()
+		gc := saramatest.NewMockConsumerGroupClaim()
+		msg1 := &sarama.ConsumerMessage{Value: []byte(`{"id":1}`), Topic: "product", Key: []byte("SKU-123")}
+		gc.PublishMessage(msg1)
+		gc.CloseChannel()
+
+		con := newTransactionalConsumer(newTestConfig(), HandlerMap{"product": handler.handle}, tp, log.NullLogger{})
+		if err := con.ConsumeClaim(gs, gc); err != nil {
+			t.Fatalf("unexpected error occurred: %s", err)
+		}
+
+		txns := sp.GetCommittedTxns()
+		if len(txns) != 1 || len(txns[0].Messages) != 1 || txns[0].Messages[0].Topic != "retry.kafkaGroup.product" {
+			t.Fatalf("expected the failure to be published to the retry topic in a transaction, got %+v", txns)
+		}
+
+		if !gs.MessageWasMarked(msg1) {
+			t.Error("msg1 was not marked as processed")
+		}
+	})
+
+	t.Run("messages are not marked if the transaction fails", func(t *testing.T) {
+		handler := &mockConsumerHandler{}
+		handler.willFail()
+		sp := saramatest.NewMockSyncProducer()
+		sp.ReturnErrorOnCommitTxn()
+		tp := newKafkaTxnFailureProducer(sp, "kafkaGroup", log.NullLogger{})
+
+		gs := saramatest.NewMockConsumerGroupSession()
+		gc := saramatest.NewMockConsumerGroupClaim()
+		msg1 := &sarama.ConsumerMessage{Value: []byte(`{"id":1}`), Topic: "product"}
+		gc.PublishMessage(msg1)
+		gc.CloseChannel()
+
+		con := newTransactionalConsumer(newTestConfig(), HandlerMap{"product": handler.handle}, tp, log.NullLogger{})
+		if err := con.ConsumeClaim(gs, gc); err == nil {
+			t.Error("expected an error but got nil")
+		}
+
+		if gs.MessageWasMarked(msg1) {
+			t.Error("msg1 should not be marked as processed when its failure could not be published")
+		}
+	})
+}
+
 func newTestConfig() *config.Config {
 	deadLetterProduct := &config.KafkaTopic{
 		Name: "deadLetter.kafkaGroup.product",
@@ -156,9 +324,10 @@ func newTestConfig() *config.Config {
 		Next:  deadLetterProduct,
 	}
 	product := &config.KafkaTopic{
-		Name: "product",
-		Key:  "product",
-		Next: retryProduct,
+		Name:        "product",
+		Key:         "product",
+		Next:        retryProduct,
+		IsMainTopic: true,
 	}
 
 	return &config.Config{
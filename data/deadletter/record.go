@@ -0,0 +1,104 @@
+package deadletter
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/revdaalex/kafka-consumer-go/data/retry/model"
+)
+
+const (
+	// DefaultListLimit is how many records List returns when the filter has no limit.
+	DefaultListLimit = 50
+
+	// MaxListLimit is the most records List returns at once.
+	MaxListLimit = 1000
+)
+
+// The actions recorded in the audit log.
+const (
+	ActionRequeue = "requeue"
+	ActionDelete  = "delete"
+	ActionPurge   = "purge"
+)
+
+var (
+	// ErrNotFound is returned when there is no dead-lettered record with the requested ID.
+	ErrNotFound = errors.New("data/deadletter: dead-lettered record not found")
+
+	// ErrNoActor is returned when a change is made without saying who made it, as it cannot be audited.
+	ErrNoActor = errors.New("data/deadletter: an actor is required to change dead-lettered records")
+)
+
+// Record is a dead-lettered message in the retries table.
+type Record struct {
+	model.Retry
+	// LastError is the error returned by the handler on the last attempt
+	LastError string
+	CreatedAt time.Time
+	// DeadletteredAt is when the last attempt failed and the message was dead-lettered
+	DeadletteredAt time.Time
+}
+
+// Filter selects dead-lettered records. Empty fields do not filter anything.
+type Filter struct {
+	Topic string
+	// ErrorContains matches records whose last error contains the text, ignoring case
+	ErrorContains string
+	// From and To select the records dead-lettered in the time range, From inclusive and To exclusive
+	From time.Time
+	To   time.Time
+	// AfterID returns the records after the given ID, which should be the last ID of the previous page
+	AfterID int64
+	// Limit is the most records to return, which is DefaultListLimit if not set and at most MaxListLimit
+	Limit int
+}
+
+// String describes the filter for the audit log, ignoring the pagination fields.
+func (f Filter) String() string {
+	var parts []string
+	if f.Topic != "" {
+		parts = append(parts, fmt.Sprintf("topic=%q", f.Topic))
+	}
+	if f.ErrorContains != "" {
+		parts = append(parts, fmt.Sprintf("error contains %q", f.ErrorContains))
+	}
+	if !f.From.IsZero() {
+		parts = append(parts, "from="+f.From.UTC().Format(time.RFC3339))
+	}
+	if !f.To.IsZero() {
+		parts = append(parts, "to="+f.To.UTC().Format(time.RFC3339))
+	}
+	if len(parts) == 0 {
+		return "all dead-lettered records"
+	}
+	return strings.Join(parts, " ")
+}
+
+// PageSize returns the most records that List returns for the filter.
+func (f Filter) PageSize() int {
+	switch {
+	case f.Limit <= 0:
+		return DefaultListLimit
+	case f.Limit > MaxListLimit:
+		return MaxListLimit
+	default:
+		return f.Limit
+	}
+}
+
+// AuditEntry is an operation on dead-lettered records, as recorded in the audit log.
+type AuditEntry struct {
+	ID     int64
+	Action string
+	Actor  string
+	// RetryID is the record the operation was on, which is zero for a purge
+	RetryID int64
+	Topic   string
+	// Affected is how many records were changed or removed
+	Affected  int64
+	Details   string
+	CreatedAt time.Time
+}
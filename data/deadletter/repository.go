@@ -3,15 +3,35 @@ package deadletter
 import (
 	"context"
 	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/revdaalex/kafka-consumer-go/data"
+	failuremodel "github.com/revdaalex/kafka-consumer-go/data/failure/model"
+	"github.com/revdaalex/kafka-consumer-go/data/internal/query"
+	"github.com/revdaalex/kafka-consumer-go/data/retry/model"
 )
 
+const recordColumns = "id, topic, payload, payload_headers, payload_key, kafka_offset, kafka_partition, kafka_timestamp, attempts, content_type, last_error, created_at, updated_at"
+
+// Repository manages the dead-lettered records in the retries table. Every change is recorded in the
+// kafka_consumer_dead_letter_audit table, in the same transaction as the change itself.
 type Repository struct {
-	db *sql.DB
+	db      *sql.DB
+	dialect query.Dialect
 }
 
+// NewRepository returns a Repository for a Postgres database, see NewRepositoryForDriver for other databases.
 func NewRepository(db *sql.DB) Repository {
+	return NewRepositoryForDriver(db, data.DriverPostgres)
+}
+
+// NewRepositoryForDriver returns a Repository that uses the queries for the given database driver.
+func NewRepositoryForDriver(db *sql.DB, driver string) Repository {
 	return Repository{
-		db: db,
+		db:      db,
+		dialect: query.ForDriver(driver),
 	}
 }
 
@@ -25,3 +45,317 @@ func (r Repository) Count(ctx context.Context) uint {
 
 	return c
 }
+
+// List returns a page of the dead-lettered records that match the filter, in the order they were first
+// stored. Pass the ID of the last record in AfterID to get the next page.
+func (r Repository) List(ctx context.Context, f Filter) ([]Record, error) {
+	where, args := r.where(f)
+	if f.AfterID > 0 {
+		args = append(args, f.AfterID)
+		where += " AND id > " + r.dialect.Placeholder(len(args))
+	}
+
+	args = append(args, f.PageSize())
+	q := fmt.Sprintf(`SELECT %s FROM kafka_consumer_retries WHERE %s ORDER BY id LIMIT %s;`, recordColumns, where, r.dialect.Placeholder(len(args)))
+
+	// #nosec G201
+	rows, err := r.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, fmt.Errorf("data/deadletter: error listing dead-lettered records: %w", err)
+	}
+	defer rows.Close()
+
+	return scanRecords(rows)
+}
+
+// Get returns the dead-lettered record with the given ID, or ErrNotFound if there is none.
+func (r Repository) Get(ctx context.Context, id int64) (Record, error) {
+	return r.get(ctx, r.db, id)
+}
+
+// Requeue sends a dead-lettered record through the retry chain again, with its attempts reset. It is
+// retried once the interval of the first retry has passed. If payload is not nil, the message is retried
+// with it instead of the payload that failed.
+func (r Repository) Requeue(ctx context.Context, id int64, payload []byte, actor string) error {
+	_, err := r.audited(ctx, actor, func(tx *sql.Tx) (AuditEntry, error) {
+		rec, err := r.get(ctx, tx, id)
+		if err != nil {
+			return AuditEntry{}, err
+		}
+
+		set := `attempts = 1, deadlettered = false, errored = false, parked = false, batch_id = NULL, worker_id = NULL,
+			lease_expires_at = NULL, retry_started_at = NULL, retry_finished_at = NULL, deadletter_published_at = NULL, deadletter_publish_after = NULL, last_error = '', updated_at = ` + r.dialect.Placeholder(1)
+		args := []interface{}{r.dialect.TimeArg(time.Now())}
+		details := "last error: " + rec.LastError
+
+		if payload != nil {
+			set += fmt.Sprintf(", payload = %s, payload_json = %s, content_type = %s", r.dialect.Placeholder(2), r.dialect.Placeholder(3), r.dialect.Placeholder(4))
+			args = append(args, payload, query.JSONPayload(payload), contentType(rec, payload))
+			details = "payload edited, " + details
+		}
+
+		args = append(args, id)
+		q := fmt.Sprintf(`UPDATE kafka_consumer_retries SET %s WHERE id = %s AND deadlettered = true;`, set, r.dialect.Placeholder(len(args)))
+
+		// #nosec G201
+		res, err := tx.ExecContext(ctx, q, args...)
+		if err != nil {
+			return AuditEntry{}, fmt.Errorf("data/deadletter: error requeuing dead-lettered record: %w", err)
+		}
+
+		// the record may have been requeued or deleted since it was read
+		if err := expectAffected(res); err != nil {
+			return AuditEntry{}, err
+		}
+
+		return AuditEntry{Action: ActionRequeue, RetryID: id, Topic: rec.Topic, Affected: 1, Details: details}, nil
+	})
+
+	return err
+}
+
+// Delete removes the dead-lettered record with the given ID along with its attempts, or returns ErrNotFound if
+// there is none.
+func (r Repository) Delete(ctx context.Context, id int64, actor string) error {
+	_, err := r.audited(ctx, actor, func(tx *sql.Tx) (AuditEntry, error) {
+		rec, err := r.get(ctx, tx, id)
+		if err != nil {
+			return AuditEntry{}, err
+		}
+
+		// #nosec G201
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf(`DELETE FROM kafka_consumer_retry_attempts WHERE retry_id = %s;`, r.dialect.Placeholder(1)), id); err != nil {
+			return AuditEntry{}, fmt.Errorf("data/deadletter: error deleting the attempts of a dead-lettered record: %w", err)
+		}
+
+		q := fmt.Sprintf(`DELETE FROM kafka_consumer_retries WHERE id = %s AND deadlettered = true;`, r.dialect.Placeholder(1))
+
+		// #nosec G201
+		res, err := tx.ExecContext(ctx, q, id)
+		if err != nil {
+			return AuditEntry{}, fmt.Errorf("data/deadletter: error deleting dead-lettered record: %w", err)
+		}
+
+		if err := expectAffected(res); err != nil {
+			return AuditEntry{}, err
+		}
+
+		return AuditEntry{Action: ActionDelete, RetryID: id, Topic: rec.Topic, Affected: 1, Details: "last error: " + rec.LastError}, nil
+	})
+
+	return err
+}
+
+// Purge removes every dead-lettered record that matches the filter along with their attempts, ignoring its
+// AfterID and Limit, and returns how many were removed. An empty filter removes all the dead-lettered records.
+func (r Repository) Purge(ctx context.Context, f Filter, actor string) (int64, error) {
+	entry, err := r.audited(ctx, actor, func(tx *sql.Tx) (AuditEntry, error) {
+		where, args := r.where(f)
+
+		// #nosec G201
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf(`DELETE FROM kafka_consumer_retry_attempts WHERE retry_id IN(SELECT id FROM kafka_consumer_retries WHERE %s);`, where), args...); err != nil {
+			return AuditEntry{}, fmt.Errorf("data/deadletter: error purging the attempts of dead-lettered records: %w", err)
+		}
+
+		// #nosec G201
+		res, err := tx.ExecContext(ctx, fmt.Sprintf(`DELETE FROM kafka_consumer_retries WHERE %s;`, where), args...)
+		if err != nil {
+			return AuditEntry{}, fmt.Errorf("data/deadletter: error purging dead-lettered records: %w", err)
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return AuditEntry{}, fmt.Errorf("data/deadletter: error purging dead-lettered records: %w", err)
+		}
+
+		return AuditEntry{Action: ActionPurge, Topic: f.Topic, Affected: n, Details: f.String()}, nil
+	})
+
+	return entry.Affected, err
+}
+
+// AuditLog returns the most recent entries of the audit log first, up to limit. If retryID is not zero,
+// only the entries for that record are returned.
+func (r Repository) AuditLog(ctx context.Context, retryID int64, limit int) ([]AuditEntry, error) {
+	var args []interface{}
+	where := ""
+	if retryID != 0 {
+		args = append(args, retryID)
+		where = "WHERE retry_id = " + r.dialect.Placeholder(1)
+	}
+
+	args = append(args, Filter{Limit: limit}.PageSize())
+	q := fmt.Sprintf(
+		`SELECT id, action, actor, retry_id, topic, affected, details, created_at FROM kafka_consumer_dead_letter_audit %s ORDER BY id DESC LIMIT %s;`,
+		where, r.dialect.Placeholder(len(args)),
+	)
+
+	// #nosec G201
+	rows, err := r.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, fmt.Errorf("data/deadletter: error reading the audit log: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []AuditEntry
+	for rows.Next() {
+		var e AuditEntry
+		var retryID sql.NullInt64
+		var createdAt query.NullTime
+		if err := rows.Scan(&e.ID, &e.Action, &e.Actor, &retryID, &e.Topic, &e.Affected, &e.Details, &createdAt); err != nil {
+			return nil, fmt.Errorf("data/deadletter: error scanning result into memory: %w", err)
+		}
+		e.RetryID = retryID.Int64
+		e.CreatedAt = createdAt.Time
+		entries = append(entries, e)
+	}
+
+	return entries, rows.Err()
+}
+
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func (r Repository) get(ctx context.Context, q querier, id int64) (Record, error) {
+	// #nosec G201
+	rows, err := q.QueryContext(ctx, fmt.Sprintf(`SELECT %s FROM kafka_consumer_retries WHERE id = %s AND deadlettered = true;`, recordColumns, r.dialect.Placeholder(1)), id)
+	if err != nil {
+		return Record{}, fmt.Errorf("data/deadletter: error getting dead-lettered record: %w", err)
+	}
+	defer rows.Close()
+
+	records, err := scanRecords(rows)
+	if err != nil {
+		return Record{}, err
+	}
+
+	if len(records) == 0 {
+		return Record{}, ErrNotFound
+	}
+
+	return records[0], nil
+}
+
+// contentType returns the content type of an edited payload, which is taken from the content-type header of the
+// record if it has one, as it is for a new retry.
+func contentType(rec Record, payload []byte) string {
+	f := failuremodel.Failure{Message: payload}
+	if headers, err := model.DecodeHeaders(rec.PayloadHeaders); err == nil {
+		for _, h := range headers {
+			f.MessageHeaders = append(f.MessageHeaders, *h)
+		}
+	}
+	return f.ContentType()
+}
+
+// expectAffected returns ErrNotFound if the change did not affect a record.
+func expectAffected(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("data/deadletter: error reading the records affected: %w", err)
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// audited runs op in a transaction and records the entry that it returns in the audit log, so that the
+// change is only made if it is audited.
+func (r Repository) audited(ctx context.Context, actor string, op func(tx *sql.Tx) (AuditEntry, error)) (AuditEntry, error) {
+	if actor == "" {
+		return AuditEntry{}, ErrNoActor
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return AuditEntry{}, fmt.Errorf("data/deadletter: error starting transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	entry, err := op(tx)
+	if err != nil {
+		return AuditEntry{}, err
+	}
+
+	entry.Actor = actor
+	entry.CreatedAt = time.Now()
+
+	var retryID interface{}
+	if entry.RetryID != 0 {
+		retryID = entry.RetryID
+	}
+
+	q := fmt.Sprintf(
+		`INSERT INTO kafka_consumer_dead_letter_audit(action, actor, retry_id, topic, affected, details, created_at) VALUES(%s);`,
+		r.dialect.Placeholders(1, 7),
+	)
+
+	// #nosec G201
+	if _, err = tx.ExecContext(ctx, q, entry.Action, entry.Actor, retryID, entry.Topic, entry.Affected, entry.Details, r.dialect.TimeArg(entry.CreatedAt)); err != nil {
+		return AuditEntry{}, fmt.Errorf("data/deadletter: error writing the audit log: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return AuditEntry{}, fmt.Errorf("data/deadletter: error committing transaction: %w", err)
+	}
+
+	return entry, nil
+}
+
+// where returns the conditions and arguments that select the dead-lettered records matching the filter.
+func (r Repository) where(f Filter) (string, []interface{}) {
+	conds := []string{"deadlettered = true"}
+	var args []interface{}
+
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, r.dialect.Placeholder(len(args))))
+	}
+
+	if f.Topic != "" {
+		add("topic = %s", f.Topic)
+	}
+	if f.ErrorContains != "" {
+		add("LOWER(last_error) LIKE %s ESCAPE '!'", likePattern(strings.ToLower(f.ErrorContains)))
+	}
+	if !f.From.IsZero() {
+		add("updated_at >= %s", r.dialect.TimeArg(f.From))
+	}
+	if !f.To.IsZero() {
+		add("updated_at < %s", r.dialect.TimeArg(f.To))
+	}
+
+	return strings.Join(conds, " AND "), args
+}
+
+// likePattern returns a LIKE pattern, escaped with '!', that matches text containing s.
+func likePattern(s string) string {
+	escaped := strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(s)
+	return "%" + escaped + "%"
+}
+
+func scanRecords(rows *sql.Rows) ([]Record, error) {
+	var records []Record
+	for rows.Next() {
+		var rec Record
+		var kafkaTimestamp, createdAt, updatedAt query.NullTime
+		err := rows.Scan(
+			&rec.ID, &rec.Topic, &rec.Payload, &rec.PayloadHeaders, &rec.PayloadKey, &rec.KafkaOffset, &rec.KafkaPartition,
+			&kafkaTimestamp, &rec.Attempts, &rec.ContentType, &rec.LastError, &createdAt, &updatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("data/deadletter: error scanning result into memory: %w", err)
+		}
+		rec.KafkaTimestamp = kafkaTimestamp.Time
+		rec.CreatedAt = createdAt.Time
+		rec.DeadletteredAt = updatedAt.Time
+		rec.Deadlettered = true
+		records = append(records, rec)
+	}
+
+	return records, rows.Err()
+}
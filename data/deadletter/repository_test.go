@@ -3,20 +3,22 @@ package deadletter
 import (
 	"context"
 	"errors"
-	"reflect"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/IBM/sarama"
+	"github.com/go-test/deep"
+
+	"github.com/revdaalex/kafka-consumer-go/data"
+	"github.com/revdaalex/kafka-consumer-go/data/retry/model"
 )
 
 func TestNewRepository(t *testing.T) {
 	db, _, _ := sqlmock.New()
-	exp := Repository{
-		db: db,
-	}
 
-	if got := NewRepository(db); !reflect.DeepEqual(exp, got) {
-		t.Errorf("expected %#v but got %#v", exp, got)
+	if got := NewRepository(db); got.db != db || got.dialect.Placeholder(1) != "$1" {
+		t.Errorf("expected a repository using the Postgres dialect but got %#v", got)
 	}
 }
 
@@ -44,3 +46,377 @@ func TestRepository_Count(t *testing.T) {
 		}
 	})
 }
+
+var recordColumnsForTests = []string{"id", "topic", "payload", "payload_headers", "payload_key", "kafka_offset", "kafka_partition", "kafka_timestamp", "attempts", "content_type", "last_error", "created_at", "updated_at"}
+
+func TestRepository_List(t *testing.T) {
+	ctx := context.Background()
+	createdAt := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+	deadletteredAt := time.Date(2026, 10, 18, 10, 0, 0, 0, time.UTC)
+
+	t.Run("it lists the dead-lettered records matching the filter", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+		repo := NewRepository(db)
+		f := Filter{Topic: "product", ErrorContains: "50%", From: createdAt, To: deadletteredAt, AfterID: 10, Limit: 5}
+
+		mock.ExpectQuery(`SELECT id, topic, .* FROM kafka_consumer_retries WHERE deadlettered = true AND topic = \$1 AND LOWER\(last_error\) LIKE \$2 ESCAPE '!' AND updated_at >= \$3 AND updated_at < \$4 AND id > \$5 ORDER BY id LIMIT \$6`).
+			WithArgs("product", "%50!%%", createdAt, deadletteredAt, 10, 5).
+			WillReturnRows(sqlmock.NewRows(recordColumnsForTests).
+				AddRow(11, "product", []byte(`{"foo":"bar"}`), []byte(`[]`), []byte("SKU-1"), 100, 2, nil, 4, "application/json", "oops 50% of the time", createdAt, deadletteredAt))
+
+		got, err := repo.List(ctx, f)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		exp := []Record{{
+			Retry: model.Retry{
+				ID:             11,
+				Topic:          "product",
+				Payload:        []byte(`{"foo":"bar"}`),
+				PayloadHeaders: []byte(`[]`),
+				PayloadKey:     []byte("SKU-1"),
+				KafkaOffset:    100,
+				KafkaPartition: 2,
+				Attempts:       4,
+				Deadlettered:   true,
+				ContentType:    "application/json",
+			},
+			LastError:      "oops 50% of the time",
+			CreatedAt:      createdAt,
+			DeadletteredAt: deadletteredAt,
+		}}
+
+		if diff := deep.Equal(exp, got); diff != nil {
+			t.Error(diff)
+		}
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("it limits the page size", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+		repo := NewRepository(db)
+
+		mock.ExpectQuery(`SELECT .* FROM kafka_consumer_retries WHERE deadlettered = true ORDER BY id LIMIT \$1`).
+			WithArgs(MaxListLimit).
+			WillReturnRows(sqlmock.NewRows(recordColumnsForTests))
+
+		if _, err := repo.List(ctx, Filter{Limit: MaxListLimit + 1}); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestRepository_Get(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	repo := NewRepository(db)
+
+	mock.ExpectQuery(`SELECT .* FROM kafka_consumer_retries WHERE id = \$1 AND deadlettered = true`).
+		WithArgs(12).
+		WillReturnRows(sqlmock.NewRows(recordColumnsForTests))
+
+	if _, err := repo.Get(context.Background(), 12); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound but got '%v'", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRepository_Requeue(t *testing.T) {
+	ctx := context.Background()
+	row := func() *sqlmock.Rows {
+		return sqlmock.NewRows(recordColumnsForTests).
+			AddRow(12, "product", []byte(`{}`), []byte(`[]`), []byte("SKU-1"), 1, 0, nil, 4, "application/json", "oops", time.Now(), time.Now())
+	}
+
+	t.Run("it resets the attempts and audits the requeue in a transaction", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+		repo := NewRepository(db)
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT .* FROM kafka_consumer_retries WHERE id = \$1 AND deadlettered = true`).
+			WithArgs(12).
+			WillReturnRows(row())
+		mock.ExpectExec(`UPDATE kafka_consumer_retries SET attempts = 1, deadlettered = false, .* updated_at = \$1 WHERE id = \$2 AND deadlettered = true`).
+			WithArgs(sqlmock.AnyArg(), 12).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`INSERT INTO kafka_consumer_dead_letter_audit\(action, actor, retry_id, topic, affected, details, created_at\) VALUES\(\$1, \$2, \$3, \$4, \$5, \$6, \$7\)`).
+			WithArgs(ActionRequeue, "jane", 12, "product", 1, "last error: oops", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		if err := repo.Requeue(ctx, 12, nil, "jane"); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("it requeues with an edited payload", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+		repo := NewRepository(db)
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT .* FROM kafka_consumer_retries`).
+			WillReturnRows(row())
+		mock.ExpectExec(`UPDATE kafka_consumer_retries SET .* updated_at = \$1, payload = \$2, payload_json = \$3, content_type = \$4 WHERE id = \$5`).
+			WithArgs(sqlmock.AnyArg(), []byte(`{"fixed":true}`), `{"fixed":true}`, "application/json", 12).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`INSERT INTO kafka_consumer_dead_letter_audit`).
+			WithArgs(ActionRequeue, "jane", 12, "product", 1, "payload edited, last error: oops", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		if err := repo.Requeue(ctx, 12, []byte(`{"fixed":true}`), "jane"); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("it keeps the content type of an edited payload from its content-type header", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+		repo := NewRepository(db)
+
+		headers, _ := model.EncodeHeaders([]sarama.RecordHeader{{Key: []byte("Content-Type"), Value: []byte("application/xml")}})
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT .* FROM kafka_consumer_retries`).
+			WillReturnRows(sqlmock.NewRows(recordColumnsForTests).
+				AddRow(12, "product", []byte(`<product/>`), headers, []byte("SKU-1"), 1, 0, nil, 4, "application/xml", "oops", time.Now(), time.Now()))
+		mock.ExpectExec(`UPDATE kafka_consumer_retries SET .* content_type = \$4 WHERE id = \$5`).
+			WithArgs(sqlmock.AnyArg(), []byte(`{"fixed":true}`), `{"fixed":true}`, "application/xml", 12).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`INSERT INTO kafka_consumer_dead_letter_audit`).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		if err := repo.Requeue(ctx, 12, []byte(`{"fixed":true}`), "jane"); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("it rolls back the requeue if it cannot be audited", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+		repo := NewRepository(db)
+		expErr := errors.New("oops")
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT .* FROM kafka_consumer_retries`).
+			WillReturnRows(row())
+		mock.ExpectExec(`UPDATE kafka_consumer_retries`).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`INSERT INTO kafka_consumer_dead_letter_audit`).
+			WillReturnError(expErr)
+		mock.ExpectRollback()
+
+		if err := repo.Requeue(ctx, 12, nil, "jane"); !errors.Is(err, expErr) {
+			t.Errorf("expected error from the audit log but got '%v'", err)
+		}
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("it does not audit a record that was requeued or deleted since it was read", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+		repo := NewRepository(db)
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT .* FROM kafka_consumer_retries`).
+			WillReturnRows(row())
+		mock.ExpectExec(`UPDATE kafka_consumer_retries`).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectRollback()
+
+		if err := repo.Requeue(ctx, 12, nil, "jane"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound but got '%v'", err)
+		}
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("it requires an actor", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+		repo := NewRepository(db)
+
+		if err := repo.Requeue(ctx, 12, nil, ""); !errors.Is(err, ErrNoActor) {
+			t.Errorf("expected ErrNoActor but got '%v'", err)
+		}
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestRepository_Delete(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	repo := NewRepository(db)
+
+	// the record is deleted by someone else after it was read
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT .* FROM kafka_consumer_retries WHERE id = \$1 AND deadlettered = true`).
+		WithArgs(12).
+		WillReturnRows(sqlmock.NewRows(recordColumnsForTests).
+			AddRow(12, "product", []byte(`{}`), []byte(`[]`), []byte("SKU-1"), 1, 0, nil, 4, "application/json", "oops", time.Now(), time.Now()))
+	mock.ExpectExec(`DELETE FROM kafka_consumer_retry_attempts WHERE retry_id = \$1`).
+		WithArgs(12).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`DELETE FROM kafka_consumer_retries WHERE id = \$1 AND deadlettered = true`).
+		WithArgs(12).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	if err := repo.Delete(context.Background(), 12, "jane"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound but got '%v'", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRepository_Purge(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	repo := NewRepository(db)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`DELETE FROM kafka_consumer_retry_attempts WHERE retry_id IN\(SELECT id FROM kafka_consumer_retries WHERE deadlettered = true AND topic = \$1\)`).
+		WithArgs("product").
+		WillReturnResult(sqlmock.NewResult(0, 90))
+	mock.ExpectExec(`DELETE FROM kafka_consumer_retries WHERE deadlettered = true AND topic = \$1`).
+		WithArgs("product").
+		WillReturnResult(sqlmock.NewResult(0, 42))
+	mock.ExpectExec(`INSERT INTO kafka_consumer_dead_letter_audit`).
+		WithArgs(ActionPurge, "jane", nil, "product", 42, `topic="product"`, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	got, err := repo.Purge(context.Background(), Filter{Topic: "product", Limit: 1}, "jane")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if got != 42 {
+		t.Errorf("expected 42 records to be purged, got %d", got)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRepository_SQLite(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := data.NewSQLiteDB(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	if err := data.MigrateSQLiteDatabase(db); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range []struct {
+		topic, key, lastError string
+		deadlettered          bool
+	}{
+		{"product", "SKU-1", "Timeout calling API", true},
+		{"product", "SKU-2", "invalid payload", true},
+		{"product", "SKU-3", "timeout calling API", false},
+		{"stock", "SKU-4", "timeout calling API", true},
+	} {
+		_, err := db.Exec(
+			`INSERT INTO kafka_consumer_retries(topic, payload, payload_headers, payload_key, kafka_offset, kafka_partition, attempts, deadlettered, errored, last_error, created_at, updated_at)
+			VALUES(?, '{}', '[]', ?, 1, 0, 4, ?, true, ?, '2026-10-18 09:00:00.000000', '2026-10-18 10:00:00.000000');`,
+			r.topic, r.key, r.deadlettered, r.lastError,
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	repo := NewRepositoryForDriver(db, data.DriverSQLite)
+
+	records, err := repo.List(ctx, Filter{Topic: "product", ErrorContains: "TIMEOUT", From: time.Date(2026, 10, 18, 10, 0, 0, 0, time.UTC)})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(records) != 1 || string(records[0].PayloadKey) != "SKU-1" {
+		t.Fatalf("expected only the dead-lettered timeout for the topic, got %+v", records)
+	}
+
+	if exp := time.Date(2026, 10, 18, 10, 0, 0, 0, time.UTC); !records[0].DeadletteredAt.Equal(exp) {
+		t.Errorf("expected the record to be dead-lettered at %s, got %s", exp, records[0].DeadletteredAt)
+	}
+
+	if err := repo.Requeue(ctx, records[0].ID, []byte("not json"), "jane"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	var attempts int
+	var payload string
+	var payloadJSON *string
+	var contentType string
+	row := db.QueryRow(`SELECT attempts, payload, payload_json, content_type FROM kafka_consumer_retries WHERE id = ? AND deadlettered = false;`, records[0].ID)
+	if err := row.Scan(&attempts, &payload, &payloadJSON, &contentType); err != nil {
+		t.Fatalf("expected the record to be requeued: %s", err)
+	}
+
+	if attempts != 1 || payload != "not json" || payloadJSON != nil || contentType != "application/octet-stream" {
+		t.Errorf("unexpected requeued record: attempts %d, payload %q, payload_json %v, content_type %q", attempts, payload, payloadJSON, contentType)
+	}
+
+	if err := repo.Delete(ctx, records[0].ID, "jane"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected a requeued record not to be found, got '%v'", err)
+	}
+
+	purged, err := repo.Purge(ctx, Filter{}, "john")
+	if err != nil || purged != 2 {
+		t.Errorf("expected 2 records to be purged, got %d (%v)", purged, err)
+	}
+
+	if c := repo.Count(ctx); c != 0 {
+		t.Errorf("expected no dead-lettered records to be left, got %d", c)
+	}
+
+	entries, err := repo.AuditLog(ctx, 0, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 audit entries, got %+v", entries)
+	}
+
+	if e := entries[0]; e.Action != ActionPurge || e.Actor != "john" || e.Affected != 2 || e.RetryID != 0 || e.CreatedAt.IsZero() {
+		t.Errorf("unexpected purge audit entry: %+v", e)
+	}
+
+	if e := entries[1]; e.Action != ActionRequeue || e.Actor != "jane" || e.RetryID != records[0].ID || e.Details != "payload edited, last error: Timeout calling API" {
+		t.Errorf("unexpected requeue audit entry: %+v", e)
+	}
+}
@@ -1,9 +1,18 @@
 package model
 
 import (
+	"encoding/json"
+	"strings"
+	"time"
+
 	"github.com/IBM/sarama"
 )
 
+const (
+	ContentTypeJSON   = "application/json"
+	ContentTypeBinary = "application/octet-stream"
+)
+
 type Failure struct {
 	Reason         string
 	Topic          string
@@ -13,6 +22,7 @@ type Failure struct {
 	MessageHeaders []sarama.RecordHeader
 	KafkaPartition int32
 	KafkaOffset    int64
+	KafkaTimestamp time.Time
 }
 
 // FailureFromSaramaMessage will create a Failure value from the provided values.
@@ -28,9 +38,26 @@ func FailureFromSaramaMessage(err error, nextTopic string, sm *sarama.ConsumerMe
 		MessageHeaders: convertSaramaRecordHeaders(sm.Headers),
 		KafkaPartition: sm.Partition,
 		KafkaOffset:    sm.Offset,
+		KafkaTimestamp: sm.Timestamp,
 	}
 }
 
+// ContentType returns the content type of the message, taken from its content-type header if it has one.
+// Otherwise, it is JSON if the message is valid JSON, or binary if it is not.
+func (f Failure) ContentType() string {
+	for _, h := range f.MessageHeaders {
+		if strings.EqualFold(string(h.Key), "content-type") && len(h.Value) > 0 {
+			return string(h.Value)
+		}
+	}
+
+	if json.Valid(f.Message) {
+		return ContentTypeJSON
+	}
+
+	return ContentTypeBinary
+}
+
 func convertSaramaRecordHeaders(headers []*sarama.RecordHeader) []sarama.RecordHeader {
 	nonPointHeaders := make([]sarama.RecordHeader, len(headers))
 
@@ -3,6 +3,7 @@ package model
 import (
 	"errors"
 	"testing"
+	"time"
 
 	"github.com/IBM/sarama"
 	"github.com/go-test/deep"
@@ -19,6 +20,7 @@ func TestFailureFromSaramaMessage(t *testing.T) {
 		Topic:     "product",
 		Partition: 21002,
 		Offset:    3048453957483304,
+		Timestamp: time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC),
 	}
 
 	t.Run("failure created from sarama message", func(t *testing.T) {
@@ -28,8 +30,10 @@ func TestFailureFromSaramaMessage(t *testing.T) {
 			NextTopic:      "retry1.product",
 			Message:        []byte(`{"foo":"bar"}`),
 			MessageKey:     []byte("baz"),
+			MessageHeaders: []sarama.RecordHeader{{Key: []byte("foo"), Value: []byte("buzz")}},
 			KafkaPartition: 21002,
 			KafkaOffset:    3048453957483304,
+			KafkaTimestamp: time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC),
 		}
 
 		err := errors.New("something bad happened")
@@ -39,3 +43,38 @@ func TestFailureFromSaramaMessage(t *testing.T) {
 		}
 	})
 }
+
+func TestFailure_ContentType(t *testing.T) {
+	tests := []struct {
+		name    string
+		failure Failure
+		want    string
+	}{
+		{
+			name:    "JSON message",
+			failure: Failure{Message: []byte(`{"foo":"bar"}`)},
+			want:    ContentTypeJSON,
+		},
+		{
+			name:    "binary message",
+			failure: Failure{Message: []byte{0x00, 0x01, 0xff}},
+			want:    ContentTypeBinary,
+		},
+		{
+			name: "message with a content-type header",
+			failure: Failure{
+				Message:        []byte{0x00, 0x01, 0xff},
+				MessageHeaders: []sarama.RecordHeader{{Key: []byte("Content-Type"), Value: []byte("application/x-protobuf")}},
+			},
+			want: "application/x-protobuf",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.failure.ContentType(); got != tt.want {
+				t.Errorf("ContentType(): %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
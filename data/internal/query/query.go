@@ -0,0 +1,110 @@
+// Package query holds the helpers shared by the queries of the retries table, for every database driver.
+package query
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/revdaalex/kafka-consumer-go/data"
+)
+
+// SQLiteTimeFormat is used for every timestamp written to and compared in SQLite, which stores them
+// as text. It sorts in time order and matches the format of CURRENT_TIMESTAMP.
+const SQLiteTimeFormat = "2006-01-02 15:04:05.000000"
+
+// SQLiteTime returns t in UTC in SQLiteTimeFormat.
+func SQLiteTime(t time.Time) string {
+	return t.UTC().Format(SQLiteTimeFormat)
+}
+
+// Dialect holds how a database driver differs in its placeholders and timestamps.
+type Dialect struct {
+	// Placeholder returns the placeholder for the argument at position i
+	Placeholder func(i int) string
+	// TimeArg returns a time in the format that the driver stores
+	TimeArg func(t time.Time) interface{}
+}
+
+// the dialects of the supported database drivers
+var (
+	Postgres = Dialect{
+		Placeholder: func(i int) string { return "$" + strconv.Itoa(i) },
+		TimeArg:     func(t time.Time) interface{} { return t },
+	}
+	SQLite = Dialect{
+		Placeholder: questionMark,
+		TimeArg:     func(t time.Time) interface{} { return SQLiteTime(t) },
+	}
+	MySQL = Dialect{
+		Placeholder: questionMark,
+		TimeArg:     func(t time.Time) interface{} { return t.UTC() },
+	}
+)
+
+// ForDriver returns the dialect of the database driver, which defaults to Postgres.
+func ForDriver(driver string) Dialect {
+	switch driver {
+	case data.DriverSQLite:
+		return SQLite
+	case data.DriverMySQL:
+		return MySQL
+	default:
+		return Postgres
+	}
+}
+
+// Placeholders returns n placeholders, starting from the position from.
+func (d Dialect) Placeholders(from, n int) string {
+	ps := make([]string, n)
+	for i := range ps {
+		ps[i] = d.Placeholder(from + i)
+	}
+	return strings.Join(ps, ", ")
+}
+
+func questionMark(int) string {
+	return "?"
+}
+
+// JSONPayload returns the message to be stored in the payload_json column, which is only set for JSON
+// messages so that they can still be queried as JSON.
+func JSONPayload(msg []byte) interface{} {
+	if !json.Valid(msg) {
+		return nil
+	}
+	return string(msg)
+}
+
+// NullTime scans a nullable timestamp. The SQLite and MySQL drivers return timestamps as text, which
+// is always written in UTC.
+type NullTime struct {
+	time.Time
+}
+
+func (t *NullTime) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		t.Time = time.Time{}
+	case time.Time:
+		t.Time = v
+	case string:
+		return t.parse(v)
+	case []byte:
+		return t.parse(string(v))
+	default:
+		return fmt.Errorf("cannot scan %T into a timestamp", src)
+	}
+	return nil
+}
+
+func (t *NullTime) parse(s string) error {
+	parsed, err := time.ParseInLocation("2006-01-02 15:04:05.999999999", s, time.UTC)
+	if err != nil {
+		return err
+	}
+	t.Time = parsed
+	return nil
+}
@@ -0,0 +1,69 @@
+package query
+
+import (
+	"testing"
+	"time"
+
+	"github.com/revdaalex/kafka-consumer-go/data"
+)
+
+func TestDialect_Placeholders(t *testing.T) {
+	if got := ForDriver(data.DriverPostgres).Placeholders(2, 3); got != "$2, $3, $4" {
+		t.Errorf("unexpected Postgres placeholders: %s", got)
+	}
+
+	for _, driver := range []string{data.DriverSQLite, data.DriverMySQL} {
+		if got := ForDriver(driver).Placeholders(2, 3); got != "?, ?, ?" {
+			t.Errorf("unexpected %s placeholders: %s", driver, got)
+		}
+	}
+}
+
+func TestDialect_TimeArg(t *testing.T) {
+	ts := time.Date(2026, 10, 18, 14, 30, 0, 0, time.FixedZone("CEST", 2*60*60))
+
+	if got := Postgres.TimeArg(ts); got != ts {
+		t.Errorf("expected Postgres to be passed the time as is, got %v", got)
+	}
+
+	if got := SQLite.TimeArg(ts); got != "2026-10-18 12:30:00.000000" {
+		t.Errorf("expected SQLite to be passed the time as text in UTC, got %v", got)
+	}
+
+	if got := MySQL.TimeArg(ts); got != ts.UTC() {
+		t.Errorf("expected MySQL to be passed the time in UTC, got %v", got)
+	}
+}
+
+func TestJSONPayload(t *testing.T) {
+	if got := JSONPayload([]byte(`{"foo":"bar"}`)); got != `{"foo":"bar"}` {
+		t.Errorf("expected a JSON message to be returned as a string, got %v", got)
+	}
+
+	if got := JSONPayload([]byte("foo")); got != nil {
+		t.Errorf("expected nil for a message that is not JSON, got %v", got)
+	}
+}
+
+func TestNullTime_Scan(t *testing.T) {
+	exp := time.Date(2026, 10, 18, 12, 30, 0, 123456000, time.UTC)
+
+	for _, src := range []interface{}{exp, SQLiteTime(exp), []byte(SQLiteTime(exp))} {
+		var nt NullTime
+		if err := nt.Scan(src); err != nil {
+			t.Fatalf("unexpected error scanning %T: %s", src, err)
+		}
+		if !nt.Equal(exp) {
+			t.Errorf("expected %s when scanning %T, got %s", exp, src, nt.Time)
+		}
+	}
+
+	nt := NullTime{Time: exp}
+	if err := nt.Scan(nil); err != nil || !nt.IsZero() {
+		t.Errorf("expected a zero time when scanning NULL, got %s, %v", nt.Time, err)
+	}
+
+	if err := nt.Scan(42); err == nil {
+		t.Error("expected an error scanning an int")
+	}
+}
@@ -3,30 +3,141 @@ package data
 import (
 	"database/sql"
 	"embed"
+	"errors"
 	"fmt"
+	"os"
 
 	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database"
+	"github.com/golang-migrate/migrate/v4/database/mysql"
 	"github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/database/sqlite"
+	"github.com/golang-migrate/migrate/v4/source"
 	"github.com/golang-migrate/migrate/v4/source/iofs"
 )
 
+const migrationsTable = "kafka_consumer_migrations"
+
 //go:embed migrations/*.sql
 var migrationFiles embed.FS
 
+//go:embed migrations/sqlite/*.sql
+var sqliteMigrationFiles embed.FS
+
+//go:embed migrations/mysql/*.sql
+var mysqlMigrationFiles embed.FS
+
+// MigrateDatabase migrates a Postgres database, see MigrateDatabaseForDriver for other databases.
 func MigrateDatabase(db *sql.DB, schema string) error {
-	databaseDriver, err := postgres.WithInstance(db, &postgres.Config{MigrationsTable: "kafka_consumer_migrations"})
+	databaseDriver, err := postgres.WithInstance(db, &postgres.Config{MigrationsTable: migrationsTable})
 	if err != nil {
 		return fmt.Errorf("unable to create migration instance from database: %w", err)
 	}
 
-	d, err := iofs.New(migrationFiles, "migrations")
+	return migrateUp(databaseDriver, migrationFiles, "migrations", schema)
+}
+
+// MigrateDatabaseForDriver migrates the database with the migrations for the given driver.
+func MigrateDatabaseForDriver(db *sql.DB, driver, schema string) error {
+	switch driver {
+	case DriverPostgres:
+		return MigrateDatabase(db, schema)
+	case DriverSQLite:
+		return MigrateSQLiteDatabase(db)
+	case DriverMySQL:
+		return MigrateMySQLDatabase(db, schema)
+	default:
+		return fmt.Errorf("unable to migrate database: unsupported driver '%s'", driver)
+	}
+}
+
+// MigrateSQLiteDatabase migrates a SQLite database.
+func MigrateSQLiteDatabase(db *sql.DB) error {
+	databaseDriver, err := sqlite.WithInstance(db, &sqlite.Config{MigrationsTable: migrationsTable})
 	if err != nil {
-		return fmt.Errorf("unable to load migration files from embedded filesystem: %w", err)
+		return fmt.Errorf("unable to create migration instance from database: %w", err)
 	}
 
-	m, err := migrate.NewWithInstance("go-bindata", d, schema, databaseDriver)
+	return migrateUp(databaseDriver, sqliteMigrationFiles, "migrations/sqlite", "main")
+}
+
+// MigrateMySQLDatabase migrates a MySQL database.
+func MigrateMySQLDatabase(db *sql.DB, schema string) error {
+	databaseDriver, err := mysql.WithInstance(db, &mysql.Config{MigrationsTable: migrationsTable})
+	if err != nil {
+		return fmt.Errorf("unable to create migration instance from database: %w", err)
+	}
+
+	return migrateUp(databaseDriver, mysqlMigrationFiles, "migrations/mysql", schema)
+}
+
+// MigrationStatus is how far the migrations of a database have been applied.
+type MigrationStatus struct {
+	// Version is the last migration applied, which is zero if none have been
+	Version uint
+	// Dirty is true if the last migration failed part way through and the database must be fixed by hand
+	Dirty bool
+	// Latest is the last migration available
+	Latest uint
+	// Pending are the migrations that have not been applied yet, in order
+	Pending []uint
+}
+
+// MigrationStatusForDriver reports which of the migrations for the given driver have been applied to the database.
+func MigrationStatusForDriver(db *sql.DB, driver, schema string) (MigrationStatus, error) {
+	var (
+		databaseDriver database.Driver
+		err            error
+		files          = migrationFiles
+		path           = "migrations"
+	)
+
+	switch driver {
+	case DriverPostgres:
+		databaseDriver, err = postgres.WithInstance(db, &postgres.Config{MigrationsTable: migrationsTable})
+	case DriverSQLite:
+		databaseDriver, err = sqlite.WithInstance(db, &sqlite.Config{MigrationsTable: migrationsTable})
+		files, path, schema = sqliteMigrationFiles, "migrations/sqlite", "main"
+	case DriverMySQL:
+		databaseDriver, err = mysql.WithInstance(db, &mysql.Config{MigrationsTable: migrationsTable})
+		files, path = mysqlMigrationFiles, "migrations/mysql"
+	default:
+		return MigrationStatus{}, fmt.Errorf("unable to read migration status: unsupported driver '%s'", driver)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to load migration files from source driver: %w", err)
+		return MigrationStatus{}, fmt.Errorf("unable to create migration instance from database: %w", err)
+	}
+
+	m, d, err := newMigrate(databaseDriver, files, path, schema)
+	if err != nil {
+		return MigrationStatus{}, err
+	}
+
+	var status MigrationStatus
+	status.Version, status.Dirty, err = m.Version()
+	if err != nil && err != migrate.ErrNilVersion {
+		return MigrationStatus{}, fmt.Errorf("unable to read migration version: %w", err)
+	}
+
+	v, err := d.First()
+	for err == nil {
+		status.Latest = v
+		if v > status.Version {
+			status.Pending = append(status.Pending, v)
+		}
+		v, err = d.Next(v)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return MigrationStatus{}, fmt.Errorf("unable to read migration files: %w", err)
+	}
+
+	return status, nil
+}
+
+func migrateUp(databaseDriver database.Driver, files embed.FS, path, schema string) error {
+	m, _, err := newMigrate(databaseDriver, files, path, schema)
+	if err != nil {
+		return err
 	}
 
 	if err := m.Up(); err != nil && err != migrate.ErrNoChange {
@@ -35,3 +146,17 @@ func MigrateDatabase(db *sql.DB, schema string) error {
 
 	return nil
 }
+
+func newMigrate(databaseDriver database.Driver, files embed.FS, path, schema string) (*migrate.Migrate, source.Driver, error) {
+	d, err := iofs.New(files, path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to load migration files from embedded filesystem: %w", err)
+	}
+
+	m, err := migrate.NewWithInstance("go-bindata", d, schema, databaseDriver)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load migration files from source driver: %w", err)
+	}
+
+	return m, d, nil
+}
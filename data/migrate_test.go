@@ -0,0 +1,37 @@
+package data
+
+import (
+	"testing"
+)
+
+func TestMigrationStatusForDriver(t *testing.T) {
+	db, err := NewSQLiteDB(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	before, err := MigrationStatusForDriver(db, DriverSQLite, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if before.Version != 0 || before.Latest == 0 || len(before.Pending) == 0 || before.Pending[len(before.Pending)-1] != before.Latest {
+		t.Errorf("expected every migration to be pending, got %+v", before)
+	}
+
+	if err := MigrateSQLiteDatabase(db); err != nil {
+		t.Fatal(err)
+	}
+
+	after, err := MigrationStatusForDriver(db, DriverSQLite, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if after.Version != before.Latest || after.Dirty || len(after.Pending) != 0 {
+		t.Errorf("expected every migration to be applied, got %+v", after)
+	}
+
+	if _, err := MigrationStatusForDriver(db, "oracle", ""); err == nil {
+		t.Error("expected an error for an unsupported driver")
+	}
+}
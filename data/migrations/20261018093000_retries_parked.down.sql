@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS retries_topic_key_idx;
+ALTER TABLE kafka_consumer_retries DROP COLUMN IF EXISTS parked;
\ No newline at end of file
@@ -0,0 +1,2 @@
+ALTER TABLE kafka_consumer_retries ADD COLUMN IF NOT EXISTS parked BOOLEAN NOT NULL DEFAULT false;
+CREATE INDEX IF NOT EXISTS retries_topic_key_idx ON kafka_consumer_retries (topic, payload_key);
\ No newline at end of file
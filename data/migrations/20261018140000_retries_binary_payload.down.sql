@@ -0,0 +1,4 @@
+DELETE FROM kafka_consumer_retries WHERE payload_json IS NULL;
+ALTER TABLE kafka_consumer_retries ALTER COLUMN payload_json SET NOT NULL;
+ALTER TABLE kafka_consumer_retries DROP COLUMN IF EXISTS content_type;
+ALTER TABLE kafka_consumer_retries DROP COLUMN IF EXISTS payload;
@@ -0,0 +1,5 @@
+ALTER TABLE kafka_consumer_retries ADD COLUMN IF NOT EXISTS payload BYTEA NULL;
+ALTER TABLE kafka_consumer_retries ADD COLUMN IF NOT EXISTS content_type VARCHAR(255) NOT NULL DEFAULT 'application/json';
+UPDATE kafka_consumer_retries SET payload = convert_to(payload_json::text, 'UTF8') WHERE payload IS NULL;
+ALTER TABLE kafka_consumer_retries ALTER COLUMN payload SET NOT NULL;
+ALTER TABLE kafka_consumer_retries ALTER COLUMN payload_json DROP NOT NULL;
@@ -0,0 +1,3 @@
+UPDATE kafka_consumer_retries SET payload_headers = replace(replace(payload_headers::text, '"key":', '"Key":'), '"value":', '"Value":')::json
+    WHERE json_typeof(payload_headers) = 'array';
+ALTER TABLE kafka_consumer_retries DROP COLUMN IF EXISTS kafka_timestamp;
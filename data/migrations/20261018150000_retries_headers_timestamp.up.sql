@@ -0,0 +1,11 @@
+ALTER TABLE kafka_consumer_retries ADD COLUMN IF NOT EXISTS kafka_timestamp timestamp NULL;
+UPDATE kafka_consumer_retries SET payload_headers = '[]' WHERE json_typeof(payload_headers) = 'null';
+UPDATE kafka_consumer_retries SET payload_headers = (
+    SELECT COALESCE(json_agg(json_build_object(
+        'key', translate(encode(convert_to(h.key, 'UTF8'), 'base64'), E'\n', ''),
+        'value', translate(encode(convert_to(h.value, 'UTF8'), 'base64'), E'\n', '')
+    )), '[]')
+    FROM json_each_text(kafka_consumer_retries.payload_headers) AS h
+) WHERE json_typeof(payload_headers) = 'object';
+UPDATE kafka_consumer_retries SET payload_headers = replace(replace(payload_headers::text, '"Key":', '"key":'), '"Value":', '"value":')::json
+    WHERE json_typeof(payload_headers) = 'array';
@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS retries_notify_update ON kafka_consumer_retries;
+DROP TRIGGER IF EXISTS retries_notify_insert ON kafka_consumer_retries;
+DROP FUNCTION IF EXISTS kafka_consumer_retries_notify();
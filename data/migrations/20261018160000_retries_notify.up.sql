@@ -0,0 +1,18 @@
+CREATE OR REPLACE FUNCTION kafka_consumer_retries_notify() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify('kafka_consumer_retries', json_build_object(
+        'topic', NEW.topic,
+        'attempts', NEW.attempts,
+        'pending', NOT (NEW.successful OR NEW.deadlettered)
+    )::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS retries_notify_insert ON kafka_consumer_retries;
+CREATE TRIGGER retries_notify_insert AFTER INSERT ON kafka_consumer_retries
+    FOR EACH ROW EXECUTE PROCEDURE kafka_consumer_retries_notify();
+
+DROP TRIGGER IF EXISTS retries_notify_update ON kafka_consumer_retries;
+CREATE TRIGGER retries_notify_update AFTER UPDATE OF errored, successful, deadlettered ON kafka_consumer_retries
+    FOR EACH ROW EXECUTE PROCEDURE kafka_consumer_retries_notify();
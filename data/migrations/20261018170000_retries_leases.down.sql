@@ -0,0 +1,2 @@
+ALTER TABLE kafka_consumer_retries DROP COLUMN IF EXISTS lease_expires_at;
+ALTER TABLE kafka_consumer_retries DROP COLUMN IF EXISTS worker_id;
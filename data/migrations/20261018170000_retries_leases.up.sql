@@ -0,0 +1,2 @@
+ALTER TABLE kafka_consumer_retries ADD COLUMN IF NOT EXISTS worker_id varchar(255) NULL;
+ALTER TABLE kafka_consumer_retries ADD COLUMN IF NOT EXISTS lease_expires_at timestamp NULL;
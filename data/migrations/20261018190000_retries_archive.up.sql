@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS kafka_consumer_retries_archive(
+    id BIGINT PRIMARY KEY,
+    topic VARCHAR (255) NOT NULL,
+    payload BYTEA NOT NULL,
+    payload_headers JSON NOT NULL,
+    payload_key VARCHAR(255) NOT NULL,
+    content_type VARCHAR(255) NOT NULL,
+    kafka_offset BIGINT NOT NULL,
+    kafka_partition INT NOT NULL,
+    kafka_timestamp timestamp NULL,
+    attempts SMALLINT NOT NULL,
+    state VARCHAR (32) NOT NULL,
+    last_error TEXT NOT NULL DEFAULT '',
+    created_at timestamp NULL,
+    updated_at timestamp NULL,
+    archived_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS retries_archive_topic_state_idx ON kafka_consumer_retries_archive (topic, state);
+CREATE INDEX IF NOT EXISTS retries_archive_archived_at_idx ON kafka_consumer_retries_archive (archived_at);
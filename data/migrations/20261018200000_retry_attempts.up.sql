@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS kafka_consumer_retry_attempts(
+    id SERIAL PRIMARY KEY,
+    retry_id BIGINT NOT NULL,
+    attempt SMALLINT NOT NULL,
+    started_at timestamp NULL,
+    finished_at timestamp NOT NULL,
+    successful BOOLEAN NOT NULL DEFAULT false,
+    error TEXT NOT NULL DEFAULT '',
+    worker_id VARCHAR (255) NULL
+);
+CREATE INDEX IF NOT EXISTS retry_attempts_retry_id_idx ON kafka_consumer_retry_attempts (retry_id);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS retries_deadletter_outbox_idx;
+ALTER TABLE kafka_consumer_retries DROP COLUMN IF EXISTS deadletter_published_at;
@@ -0,0 +1 @@
+ALTER TABLE kafka_consumer_retries ADD COLUMN IF NOT EXISTS deadletter_publish_after timestamp NULL;
@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS kafka_consumer_retries(
+    id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    topic VARCHAR (255) NOT NULL,
+    batch_id CHAR(36) NULL,
+    retry_started_at DATETIME(6) NULL,
+    retry_finished_at DATETIME(6) NULL,
+    payload_json LONGBLOB NOT NULL,
+    payload_headers LONGBLOB NOT NULL,
+    payload_key VARBINARY(255) NOT NULL,
+    kafka_offset BIGINT NOT NULL,
+    kafka_partition INT NOT NULL,
+    attempts SMALLINT NOT NULL DEFAULT 1,
+    deadlettered BOOLEAN NOT NULL DEFAULT false,
+    successful BOOLEAN NOT NULL DEFAULT false,
+    errored BOOLEAN NOT NULL DEFAULT false,
+    parked BOOLEAN NOT NULL DEFAULT false,
+    last_error TEXT NOT NULL,
+    created_at DATETIME(6) NOT NULL,
+    updated_at DATETIME(6) NOT NULL,
+    INDEX topic_attempts_idx (topic, attempts),
+    INDEX batch_id_idx (batch_id),
+    INDEX retries_updated_at_idx (updated_at),
+    INDEX retries_topic_key_idx (topic, payload_key)
+);
@@ -0,0 +1,2 @@
+DELETE FROM kafka_consumer_retries WHERE payload_json IS NULL;
+ALTER TABLE kafka_consumer_retries DROP COLUMN payload_json, DROP COLUMN content_type, CHANGE COLUMN payload payload_json LONGBLOB NOT NULL;
@@ -0,0 +1,8 @@
+ALTER TABLE kafka_consumer_retries
+    ADD COLUMN payload LONGBLOB NULL AFTER topic,
+    ADD COLUMN content_type VARCHAR(255) NOT NULL DEFAULT 'application/json' AFTER payload,
+    CHANGE COLUMN payload_json payload_raw LONGBLOB NOT NULL;
+ALTER TABLE kafka_consumer_retries ADD COLUMN payload_json JSON NULL AFTER payload;
+UPDATE kafka_consumer_retries SET payload = payload_raw;
+UPDATE kafka_consumer_retries SET payload_json = CONVERT(payload_raw USING utf8mb4) WHERE JSON_VALID(CONVERT(payload_raw USING utf8mb4));
+ALTER TABLE kafka_consumer_retries MODIFY COLUMN payload LONGBLOB NOT NULL, DROP COLUMN payload_raw;
@@ -0,0 +1,3 @@
+ALTER TABLE kafka_consumer_retries ADD COLUMN kafka_timestamp DATETIME(6) NULL AFTER kafka_partition;
+UPDATE kafka_consumer_retries SET payload_headers = '[]' WHERE payload_headers = 'null';
+UPDATE kafka_consumer_retries SET payload_headers = REPLACE(REPLACE(payload_headers, '"Key":', '"key":'), '"Value":', '"value":');
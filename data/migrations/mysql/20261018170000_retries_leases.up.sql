@@ -0,0 +1,2 @@
+ALTER TABLE kafka_consumer_retries ADD COLUMN worker_id VARCHAR(255) NULL AFTER batch_id;
+ALTER TABLE kafka_consumer_retries ADD COLUMN lease_expires_at DATETIME(6) NULL AFTER retry_finished_at;
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS kafka_consumer_dead_letter_audit;
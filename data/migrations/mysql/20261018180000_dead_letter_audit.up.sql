@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS kafka_consumer_dead_letter_audit(
+    id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    action VARCHAR (32) NOT NULL,
+    actor VARCHAR (255) NOT NULL,
+    retry_id BIGINT NULL,
+    topic VARCHAR (255) NOT NULL DEFAULT '',
+    affected BIGINT NOT NULL DEFAULT 0,
+    details TEXT NOT NULL,
+    created_at DATETIME(6) NOT NULL,
+    INDEX dead_letter_audit_retry_id_idx (retry_id),
+    INDEX dead_letter_audit_created_at_idx (created_at)
+);
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS kafka_consumer_retries_archive(
+    id BIGINT NOT NULL PRIMARY KEY,
+    topic VARCHAR (255) NOT NULL,
+    payload LONGBLOB NOT NULL,
+    payload_headers LONGBLOB NOT NULL,
+    payload_key VARBINARY(255) NOT NULL,
+    content_type VARCHAR(255) NOT NULL,
+    kafka_offset BIGINT NOT NULL,
+    kafka_partition INT NOT NULL,
+    kafka_timestamp DATETIME(6) NULL,
+    attempts SMALLINT NOT NULL,
+    state VARCHAR (32) NOT NULL,
+    last_error TEXT NOT NULL,
+    created_at DATETIME(6) NULL,
+    updated_at DATETIME(6) NULL,
+    archived_at DATETIME(6) NOT NULL,
+    INDEX retries_archive_topic_state_idx (topic, state),
+    INDEX retries_archive_archived_at_idx (archived_at)
+);
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS kafka_consumer_retry_attempts(
+    id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    retry_id BIGINT NOT NULL,
+    attempt SMALLINT NOT NULL,
+    started_at DATETIME(6) NULL,
+    finished_at DATETIME(6) NOT NULL,
+    successful BOOLEAN NOT NULL DEFAULT false,
+    error TEXT NOT NULL,
+    worker_id VARCHAR(255) NULL,
+    INDEX retry_attempts_retry_id_idx (retry_id)
+);
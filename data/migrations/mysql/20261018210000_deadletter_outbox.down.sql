@@ -0,0 +1,2 @@
+DROP INDEX retries_deadletter_outbox_idx ON kafka_consumer_retries;
+ALTER TABLE kafka_consumer_retries DROP COLUMN deadletter_published_at;
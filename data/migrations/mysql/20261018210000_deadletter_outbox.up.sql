@@ -0,0 +1,3 @@
+ALTER TABLE kafka_consumer_retries ADD COLUMN deadletter_published_at DATETIME(6) NULL AFTER lease_expires_at;
+UPDATE kafka_consumer_retries SET deadletter_published_at = updated_at WHERE deadlettered = true;
+CREATE INDEX retries_deadletter_outbox_idx ON kafka_consumer_retries (deadlettered, deadletter_published_at);
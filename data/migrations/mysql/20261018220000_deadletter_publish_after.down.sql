@@ -0,0 +1 @@
+ALTER TABLE kafka_consumer_retries DROP COLUMN deadletter_publish_after;
@@ -0,0 +1 @@
+ALTER TABLE kafka_consumer_retries ADD COLUMN deadletter_publish_after DATETIME(6) NULL AFTER deadletter_published_at;
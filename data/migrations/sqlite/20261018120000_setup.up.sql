@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS kafka_consumer_retries(
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    topic VARCHAR (255) NOT NULL,
+    batch_id CHAR(36) NULL,
+    retry_started_at TIMESTAMP NULL,
+    retry_finished_at TIMESTAMP NULL,
+    payload_json TEXT NOT NULL,
+    payload_headers TEXT NOT NULL,
+    payload_key VARCHAR(255) NOT NULL,
+    kafka_offset BIGINT NOT NULL,
+    kafka_partition INT NOT NULL,
+    attempts SMALLINT NOT NULL DEFAULT 1,
+    deadlettered BOOLEAN NOT NULL DEFAULT false,
+    successful BOOLEAN NOT NULL DEFAULT false,
+    errored BOOLEAN NOT NULL DEFAULT false,
+    parked BOOLEAN NOT NULL DEFAULT false,
+    last_error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS topic_attempts_idx ON kafka_consumer_retries (topic, attempts);
+CREATE INDEX IF NOT EXISTS batch_id_idx ON kafka_consumer_retries (batch_id);
+CREATE INDEX IF NOT EXISTS retries_updated_at_idx ON kafka_consumer_retries (updated_at);
+CREATE INDEX IF NOT EXISTS retries_topic_key_idx ON kafka_consumer_retries (topic, payload_key);
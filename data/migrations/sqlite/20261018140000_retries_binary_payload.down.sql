@@ -0,0 +1,3 @@
+DELETE FROM kafka_consumer_retries WHERE payload_json IS NULL;
+ALTER TABLE kafka_consumer_retries DROP COLUMN content_type;
+ALTER TABLE kafka_consumer_retries DROP COLUMN payload;
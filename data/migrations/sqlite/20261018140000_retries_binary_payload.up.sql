@@ -0,0 +1,31 @@
+CREATE TABLE kafka_consumer_retries_binary(
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    topic VARCHAR (255) NOT NULL,
+    batch_id CHAR(36) NULL,
+    retry_started_at TIMESTAMP NULL,
+    retry_finished_at TIMESTAMP NULL,
+    payload BLOB NOT NULL,
+    payload_json TEXT NULL,
+    content_type VARCHAR(255) NOT NULL DEFAULT 'application/json',
+    payload_headers TEXT NOT NULL,
+    payload_key VARCHAR(255) NOT NULL,
+    kafka_offset BIGINT NOT NULL,
+    kafka_partition INT NOT NULL,
+    attempts SMALLINT NOT NULL DEFAULT 1,
+    deadlettered BOOLEAN NOT NULL DEFAULT false,
+    successful BOOLEAN NOT NULL DEFAULT false,
+    errored BOOLEAN NOT NULL DEFAULT false,
+    parked BOOLEAN NOT NULL DEFAULT false,
+    last_error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+INSERT INTO kafka_consumer_retries_binary(id, topic, batch_id, retry_started_at, retry_finished_at, payload, payload_json, payload_headers, payload_key, kafka_offset, kafka_partition, attempts, deadlettered, successful, errored, parked, last_error, created_at, updated_at)
+    SELECT id, topic, batch_id, retry_started_at, retry_finished_at, CAST(payload_json AS BLOB), CASE WHEN json_valid(payload_json) THEN payload_json END, payload_headers, payload_key, kafka_offset, kafka_partition, attempts, deadlettered, successful, errored, parked, last_error, created_at, updated_at
+    FROM kafka_consumer_retries;
+DROP TABLE kafka_consumer_retries;
+ALTER TABLE kafka_consumer_retries_binary RENAME TO kafka_consumer_retries;
+CREATE INDEX IF NOT EXISTS topic_attempts_idx ON kafka_consumer_retries (topic, attempts);
+CREATE INDEX IF NOT EXISTS batch_id_idx ON kafka_consumer_retries (batch_id);
+CREATE INDEX IF NOT EXISTS retries_updated_at_idx ON kafka_consumer_retries (updated_at);
+CREATE INDEX IF NOT EXISTS retries_topic_key_idx ON kafka_consumer_retries (topic, payload_key);
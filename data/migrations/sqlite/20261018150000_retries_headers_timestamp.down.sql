@@ -0,0 +1,2 @@
+UPDATE kafka_consumer_retries SET payload_headers = replace(replace(payload_headers, '"key":', '"Key":'), '"value":', '"Value":');
+ALTER TABLE kafka_consumer_retries DROP COLUMN kafka_timestamp;
@@ -0,0 +1,3 @@
+ALTER TABLE kafka_consumer_retries ADD COLUMN kafka_timestamp TEXT NULL;
+UPDATE kafka_consumer_retries SET payload_headers = '[]' WHERE payload_headers = 'null';
+UPDATE kafka_consumer_retries SET payload_headers = replace(replace(payload_headers, '"Key":', '"key":'), '"Value":', '"value":');
@@ -0,0 +1,2 @@
+ALTER TABLE kafka_consumer_retries DROP COLUMN lease_expires_at;
+ALTER TABLE kafka_consumer_retries DROP COLUMN worker_id;
@@ -0,0 +1,2 @@
+ALTER TABLE kafka_consumer_retries ADD COLUMN worker_id TEXT NULL;
+ALTER TABLE kafka_consumer_retries ADD COLUMN lease_expires_at TIMESTAMP NULL;
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS kafka_consumer_dead_letter_audit(
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    action VARCHAR (32) NOT NULL,
+    actor VARCHAR (255) NOT NULL,
+    retry_id BIGINT NULL,
+    topic VARCHAR (255) NOT NULL DEFAULT '',
+    affected BIGINT NOT NULL DEFAULT 0,
+    details TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS dead_letter_audit_retry_id_idx ON kafka_consumer_dead_letter_audit (retry_id);
+CREATE INDEX IF NOT EXISTS dead_letter_audit_created_at_idx ON kafka_consumer_dead_letter_audit (created_at);
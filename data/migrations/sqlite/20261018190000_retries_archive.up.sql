@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS kafka_consumer_retries_archive(
+    id INTEGER PRIMARY KEY,
+    topic VARCHAR (255) NOT NULL,
+    payload BLOB NOT NULL,
+    payload_headers TEXT NOT NULL,
+    payload_key VARCHAR(255) NOT NULL,
+    content_type VARCHAR(255) NOT NULL,
+    kafka_offset BIGINT NOT NULL,
+    kafka_partition INT NOT NULL,
+    kafka_timestamp TEXT NULL,
+    attempts SMALLINT NOT NULL,
+    state VARCHAR (32) NOT NULL,
+    last_error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NULL,
+    updated_at TIMESTAMP NULL,
+    archived_at TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS retries_archive_topic_state_idx ON kafka_consumer_retries_archive (topic, state);
+CREATE INDEX IF NOT EXISTS retries_archive_archived_at_idx ON kafka_consumer_retries_archive (archived_at);
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS kafka_consumer_retry_attempts(
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    retry_id INTEGER NOT NULL,
+    attempt SMALLINT NOT NULL,
+    started_at TIMESTAMP NULL,
+    finished_at TIMESTAMP NOT NULL,
+    successful BOOLEAN NOT NULL DEFAULT false,
+    error TEXT NOT NULL DEFAULT '',
+    worker_id TEXT NULL
+);
+CREATE INDEX IF NOT EXISTS retry_attempts_retry_id_idx ON kafka_consumer_retry_attempts (retry_id);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS retries_deadletter_outbox_idx;
+ALTER TABLE kafka_consumer_retries DROP COLUMN deadletter_published_at;
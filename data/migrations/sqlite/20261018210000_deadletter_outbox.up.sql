@@ -0,0 +1,3 @@
+ALTER TABLE kafka_consumer_retries ADD COLUMN deadletter_published_at TIMESTAMP NULL;
+UPDATE kafka_consumer_retries SET deadletter_published_at = updated_at WHERE deadlettered = true;
+CREATE INDEX IF NOT EXISTS retries_deadletter_outbox_idx ON kafka_consumer_retries (id) WHERE deadlettered = true AND deadletter_published_at IS NULL;
@@ -0,0 +1 @@
+ALTER TABLE kafka_consumer_retries ADD COLUMN deadletter_publish_after TIMESTAMP NULL;
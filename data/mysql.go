@@ -0,0 +1,15 @@
+package data
+
+import (
+	"database/sql"
+
+	_ "github.com/go-sql-driver/mysql"
+)
+
+// DriverMySQL is the database driver for keeping retries in a MySQL database.
+const DriverMySQL = "mysql"
+
+// NewMySQLDB connects to the MySQL database, the dsn must be in the format of the go-sql-driver/mysql driver.
+func NewMySQLDB(dsn string) (*sql.DB, error) {
+	return openDB(DriverMySQL, dsn)
+}
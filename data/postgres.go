@@ -10,8 +10,15 @@ import (
 
 const retryAttempts = 10
 
+// DriverPostgres is the database driver for keeping retries in a Postgres database.
+const DriverPostgres = "postgres"
+
 func NewDB(dsn string) (*sql.DB, error) {
-	db, err := sql.Open("pgx", dsn)
+	return openDB("pgx", dsn)
+}
+
+func openDB(driverName, dsn string) (*sql.DB, error) {
+	db, err := sql.Open(driverName, dsn)
 
 	if err != nil {
 		return nil, fmt.Errorf("unable to connect to the database: %w", err)
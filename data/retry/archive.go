@@ -0,0 +1,154 @@
+package retry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/revdaalex/kafka-consumer-go/data/retry/store"
+)
+
+// FileArchiver archives expired retries to a JSON lines file in a directory for each day, named
+// kafka_consumer_retries-YYYY-MM-DD.jsonl after the day in UTC that they were archived. A retry
+// that is already in the file of the day is not written again, e.g. when maintenance archived it
+// but stopped before deleting it.
+type FileArchiver struct {
+	dir string
+	now func() time.Time
+}
+
+// NewFileArchiver returns a FileArchiver that writes to dir, which is created if it does not exist.
+func NewFileArchiver(dir string) *FileArchiver {
+	return &FileArchiver{dir: dir, now: time.Now}
+}
+
+// archivedRetry is a line of an archive file. The payload and key are base64 encoded, as they may not be text.
+type archivedRetry struct {
+	ID             int64           `json:"id"`
+	Topic          string          `json:"topic"`
+	Payload        []byte          `json:"payload"`
+	PayloadHeaders json.RawMessage `json:"payload_headers,omitempty"`
+	PayloadKey     []byte          `json:"payload_key,omitempty"`
+	ContentType    string          `json:"content_type,omitempty"`
+	KafkaOffset    int64           `json:"kafka_offset"`
+	KafkaPartition int32           `json:"kafka_partition"`
+	KafkaTimestamp *time.Time      `json:"kafka_timestamp,omitempty"`
+	Attempts       uint8           `json:"attempts"`
+	State          string          `json:"state"`
+	LastError      string          `json:"last_error,omitempty"`
+	CreatedAt      *time.Time      `json:"created_at,omitempty"`
+	UpdatedAt      *time.Time      `json:"updated_at,omitempty"`
+	ArchivedAt     time.Time       `json:"archived_at"`
+}
+
+// ArchiveRetries appends the retries that are not archived yet to the archive file of the day, and
+// syncs it before returning.
+func (a *FileArchiver) ArchiveRetries(ctx context.Context, retries []store.ExpiredRetry) error {
+	if len(retries) == 0 {
+		return nil
+	}
+
+	now := a.now().UTC()
+	path := filepath.Join(a.dir, fmt.Sprintf("kafka_consumer_retries-%s.jsonl", now.Format("2006-01-02")))
+	archived, err := archivedIDs(path)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, r := range retries {
+		if archived[r.ID] {
+			continue
+		}
+		archived[r.ID] = true
+
+		line := archivedRetry{
+			ID:             r.ID,
+			Topic:          r.Topic,
+			Payload:        r.Payload,
+			PayloadKey:     r.PayloadKey,
+			ContentType:    r.ContentType,
+			KafkaOffset:    r.KafkaOffset,
+			KafkaPartition: r.KafkaPartition,
+			KafkaTimestamp: timeOrNil(r.KafkaTimestamp),
+			Attempts:       r.Attempts,
+			State:          string(r.State),
+			LastError:      r.LastError,
+			CreatedAt:      timeOrNil(r.CreatedAt),
+			UpdatedAt:      timeOrNil(r.UpdatedAt),
+			ArchivedAt:     now,
+		}
+		if len(r.PayloadHeaders) > 0 && json.Valid(r.PayloadHeaders) {
+			line.PayloadHeaders = r.PayloadHeaders
+		}
+		if err := enc.Encode(line); err != nil {
+			return fmt.Errorf("data/retries: error encoding retry %d for the archive: %w", r.ID, err)
+		}
+	}
+	if buf.Len() == 0 {
+		return nil
+	}
+
+	if err := os.MkdirAll(a.dir, 0750); err != nil {
+		return fmt.Errorf("data/retries: error creating the archive directory: %w", err)
+	}
+
+	// #nosec G304
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("data/retries: error opening the archive file: %w", err)
+	}
+
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("data/retries: error writing to the archive file: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("data/retries: error syncing the archive file: %w", err)
+	}
+	return f.Close()
+}
+
+// archivedIDs returns the IDs of the retries in an archive file, which may not exist yet.
+func archivedIDs(path string) (map[int64]bool, error) {
+	ids := map[int64]bool{}
+	// #nosec G304
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return ids, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("data/retries: error opening the archive file: %w", err)
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(f)
+	for {
+		var line struct {
+			ID int64 `json:"id"`
+		}
+		err := dec.Decode(&line)
+		if err == io.EOF {
+			return ids, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("data/retries: error reading the archive file: %w", err)
+		}
+		ids[line.ID] = true
+	}
+}
+
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
@@ -0,0 +1,122 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/revdaalex/kafka-consumer-go/data/internal/query"
+	"github.com/revdaalex/kafka-consumer-go/data/retry/store"
+)
+
+// recordAttempt adds the latest attempt at a retry to the kafka_consumer_retry_attempts table, taken from the
+// state that the retry was just published or updated with. A retry that was just published has yet to be
+// claimed or finished, so its first attempt finished when it was created.
+func recordAttempt(ctx context.Context, tx *sql.Tx, id int64, d query.Dialect) error {
+	q := fmt.Sprintf(`INSERT INTO kafka_consumer_retry_attempts(retry_id, attempt, started_at, finished_at, successful, error, worker_id)
+		SELECT id, attempts - 1, retry_started_at, COALESCE(retry_finished_at, created_at), successful, last_error, worker_id
+		FROM kafka_consumer_retries WHERE id = %s;`, d.Placeholder(1))
+
+	// #nosec G201
+	if _, err := tx.ExecContext(ctx, q, id); err != nil {
+		return fmt.Errorf("data/retries: error recording a retry attempt: %w", err)
+	}
+	return nil
+}
+
+// recordPublishedAttempt records the first attempt at a retry that was just inserted, for the database drivers
+// that return the ID of an inserted row from its result.
+func recordPublishedAttempt(ctx context.Context, tx *sql.Tx, res sql.Result, d query.Dialect) error {
+	id, err := res.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("data/retries: error getting the ID of a published failure: %w", err)
+	}
+	return recordAttempt(ctx, tx, id, d)
+}
+
+// getAttempts returns the attempts at the retry in db, using the dialect of its driver.
+func getAttempts(ctx context.Context, db *sql.DB, retryID int64, d query.Dialect) ([]store.Attempt, error) {
+	q := fmt.Sprintf(`SELECT retry_id, attempt, started_at, finished_at, successful, error, worker_id
+		FROM kafka_consumer_retry_attempts WHERE retry_id = %s ORDER BY id;`, d.Placeholder(1))
+
+	// #nosec G201
+	rows, err := db.QueryContext(ctx, q, retryID)
+	if err != nil {
+		return nil, fmt.Errorf("data/retries: error getting retry attempts: %w", err)
+	}
+	defer rows.Close()
+
+	var attempts []store.Attempt
+	for rows.Next() {
+		var (
+			a                     store.Attempt
+			startedAt, finishedAt query.NullTime
+			workerID              sql.NullString
+		)
+		if err := rows.Scan(&a.RetryID, &a.Attempt, &startedAt, &finishedAt, &a.Successful, &a.Error, &workerID); err != nil {
+			return nil, fmt.Errorf("data/retries: error scanning result into memory: %w", err)
+		}
+		a.StartedAt, a.FinishedAt, a.WorkerID = startedAt.Time, finishedAt.Time, workerID.String
+		attempts = append(attempts, a)
+	}
+
+	return attempts, rows.Err()
+}
+
+// deleteOrphanedAttempts deletes the attempts of the retries with the IDs that no longer exist.
+func deleteOrphanedAttempts(ctx context.Context, tx *sql.Tx, ids []interface{}, d query.Dialect) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	q := fmt.Sprintf(
+		`DELETE FROM kafka_consumer_retry_attempts WHERE retry_id IN(%s)
+		AND NOT EXISTS(SELECT 1 FROM kafka_consumer_retries WHERE kafka_consumer_retries.id = kafka_consumer_retry_attempts.retry_id);`,
+		d.Placeholders(1, len(ids)),
+	)
+
+	// #nosec G201
+	if _, err := tx.ExecContext(ctx, q, ids...); err != nil {
+		return fmt.Errorf("data/retries: error deleting retry attempts: %w", err)
+	}
+	return nil
+}
+
+// deleteSuccessful deletes the successful retries in db that were last updated before olderThan, along with
+// their attempts, using the dialect of its driver.
+func deleteSuccessful(ctx context.Context, db *sql.DB, olderThan time.Time, d query.Dialect) error {
+	cond := "successful = true AND updated_at <= " + d.Placeholder(1)
+	arg := d.TimeArg(olderThan)
+	return inTransaction(ctx, db, func(tx *sql.Tx) error {
+		// #nosec G201
+		q := fmt.Sprintf(`DELETE FROM kafka_consumer_retry_attempts WHERE retry_id IN(SELECT id FROM kafka_consumer_retries WHERE %s);`, cond)
+		if _, err := tx.ExecContext(ctx, q, arg); err != nil {
+			return fmt.Errorf("data/retries: error deleting retry attempts: %w", err)
+		}
+
+		// #nosec G201
+		_, err := tx.ExecContext(ctx, fmt.Sprintf(`DELETE FROM kafka_consumer_retries WHERE %s;`, cond), arg)
+		return err
+	})
+}
+
+// inTransaction runs fn in a transaction, which is committed if fn succeeds and rolled back if it does not.
+func inTransaction(ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("data/retries: error starting transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("data/retries: error committing transaction: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/revdaalex/kafka-consumer-go/data/internal/query"
+	"github.com/revdaalex/kafka-consumer-go/data/retry/store"
+)
+
+// deadLetterCondition selects the dead letters that are waiting to be published. Dead-lettered retries are
+// written with no deadletter_published_at by MarkRetryErroredToPublish, which makes the retries table their outbox.
+const deadLetterCondition = "deadlettered = true AND successful = false AND deadletter_published_at IS NULL"
+
+// deadLetterPublishedAt returns what deadletter_published_at is set to when a retry is marked as errored. It is
+// only left empty for a retry to publish, so that the dead letters written while publishing is disabled are not
+// published once it is enabled.
+func deadLetterPublishedAt(d query.Dialect, toPublish bool) interface{} {
+	if toPublish {
+		return nil
+	}
+	return d.TimeArg(time.Now())
+}
+
+// publishDeadLetters claims up to claim.Limit unpublished dead letters of the topics in a short transaction, publishes
+// them in order outside of it, and then marks those that were published. A dead letter that could not be published
+// is backed off along with the later dead letters with its key, and the rest are published.
+func (d dialect) publishDeadLetters(ctx context.Context, db *sql.DB, topics []string, claim store.DeadLetterClaim, publish func(store.DeadLetter) error) (int, error) {
+	claimedAt := time.Now()
+	deadLetters, err := d.claimDeadLetters(ctx, db, topics, claim, claimedAt)
+	if err != nil {
+		return 0, err
+	}
+
+	var published, failed, released []interface{}
+	var publishErr error
+	blocked := map[string]bool{}
+	for _, dl := range deadLetters {
+		key := deadLetterKey(dl)
+		switch {
+		case time.Since(claimedAt) > claim.Lease/2:
+			// the rest are released well before their lease expires, so that no other instance publishes them too
+			released = append(released, dl.ID)
+		case blocked[key]:
+			failed = append(failed, dl.ID)
+		default:
+			if err := publish(dl); err != nil {
+				if publishErr == nil {
+					publishErr = err
+				}
+				failed = append(failed, dl.ID)
+				if len(dl.PayloadKey) > 0 {
+					blocked[key] = true
+				}
+				continue
+			}
+			published = append(published, dl.ID)
+		}
+	}
+
+	now := time.Now()
+	set := "deadletter_published_at = " + d.Placeholder(1) + ", deadletter_publish_after = NULL"
+	if err := d.updateDeadLetters(ctx, db, set, published, d.TimeArg(now)); err != nil {
+		return 0, fmt.Errorf("data/retries: error marking dead letters as published: %w", err)
+	}
+	if err := d.updateDeadLetters(ctx, db, "deadletter_publish_after = "+d.Placeholder(1), failed, d.TimeArg(now.Add(claim.Backoff))); err != nil {
+		return len(published), fmt.Errorf("data/retries: error backing off dead letters: %w", err)
+	}
+	if err := d.updateDeadLetters(ctx, db, "deadletter_publish_after = NULL", released); err != nil {
+		return len(published), fmt.Errorf("data/retries: error releasing dead letters: %w", err)
+	}
+
+	return len(published), publishErr
+}
+
+// claimDeadLetters returns the dead letters to publish, which are held until the end of the lease.
+func (d dialect) claimDeadLetters(ctx context.Context, db *sql.DB, topics []string, claim store.DeadLetterClaim, now time.Time) ([]store.DeadLetter, error) {
+	var deadLetters []store.DeadLetter
+	err := inTransaction(ctx, db, func(tx *sql.Tx) error {
+		if d.lockDatabase != "" {
+			if _, err := tx.ExecContext(ctx, d.lockDatabase); err != nil {
+				return fmt.Errorf("data/retries: error locking dead letters: %w", err)
+			}
+		}
+
+		var err error
+		deadLetters, err = d.getDeadLetters(ctx, tx, topics, claim.Limit, now)
+		if err != nil {
+			return err
+		}
+
+		ids := make([]interface{}, 0, len(deadLetters))
+		for _, dl := range deadLetters {
+			ids = append(ids, dl.ID)
+		}
+		if err := d.updateDeadLetters(ctx, tx, "deadletter_publish_after = "+d.Placeholder(1), ids, d.TimeArg(now.Add(claim.Lease))); err != nil {
+			return fmt.Errorf("data/retries: error claiming dead letters: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return deadLetters, nil
+}
+
+// updateDeadLetters sets the columns of the dead letters with the IDs, where set uses placeholders from position 1
+// for args.
+func (d dialect) updateDeadLetters(ctx context.Context, q querier, set string, ids []interface{}, args ...interface{}) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	update := fmt.Sprintf(`UPDATE kafka_consumer_retries SET %s WHERE id IN(%s);`, set, d.Placeholders(len(args)+1, len(ids)))
+	// #nosec G201
+	_, err := q.ExecContext(ctx, update, append(args, ids...)...)
+	return err
+}
+
+// deadLetterKey identifies the dead letters that must be published in order.
+func deadLetterKey(dl store.DeadLetter) string {
+	return dl.Topic + "/" + string(dl.PayloadKey)
+}
+
+// getDeadLetters returns the unpublished dead letters of the topics that are neither claimed nor backed off at now,
+// nor behind an earlier dead letter with the same key that is.
+func (d dialect) getDeadLetters(ctx context.Context, tx *sql.Tx, topics []string, limit int, now time.Time) ([]store.DeadLetter, error) {
+	if len(topics) == 0 {
+		return nil, nil
+	}
+
+	q := fmt.Sprintf(
+		`SELECT %s, last_error, created_at, updated_at FROM kafka_consumer_retries dl
+		WHERE %s AND (deadletter_publish_after IS NULL OR deadletter_publish_after <= %s) AND topic IN(%s)
+		AND NOT EXISTS(
+			SELECT 1 FROM kafka_consumer_retries e
+			WHERE e.topic = dl.topic AND e.payload_key = dl.payload_key AND LENGTH(e.payload_key) > 0 AND e.id < dl.id
+			AND e.deadlettered = true AND e.successful = false AND e.deadletter_published_at IS NULL AND e.deadletter_publish_after > %s
+		)
+		ORDER BY id LIMIT %s%s;`,
+		Repository{}.columnsAsString(), deadLetterCondition, d.Placeholder(1), d.Placeholders(2, len(topics)), d.Placeholder(len(topics)+2), d.Placeholder(len(topics)+3), d.lockRows,
+	)
+	args := make([]interface{}, 0, len(topics)+3)
+	args = append(args, d.TimeArg(now))
+	for _, t := range topics {
+		args = append(args, t)
+	}
+
+	// #nosec G201
+	rows, err := tx.QueryContext(ctx, q, append(args, d.TimeArg(now), limit)...)
+	if err != nil {
+		return nil, fmt.Errorf("data/retries: error getting dead letters: %w", err)
+	}
+	defer rows.Close()
+
+	var deadLetters []store.DeadLetter
+	for rows.Next() {
+		var dl store.DeadLetter
+		var ts, createdAt, updatedAt query.NullTime
+		err := rows.Scan(&dl.ID, &dl.Topic, &dl.Payload, &dl.PayloadHeaders, &dl.PayloadKey, &dl.KafkaOffset, &dl.KafkaPartition, &ts, &dl.Attempts, &dl.ContentType, &dl.LastError, &createdAt, &updatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("data/retries: error scanning result into memory: %w", err)
+		}
+		dl.KafkaTimestamp, dl.CreatedAt, dl.DeadletteredAt = ts.Time, createdAt.Time, updatedAt.Time
+		dl.Deadlettered, dl.Errored = true, true
+		deadLetters = append(deadLetters, dl)
+	}
+
+	return deadLetters, rows.Err()
+}
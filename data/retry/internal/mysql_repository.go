@@ -0,0 +1,248 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	failuremodel "github.com/revdaalex/kafka-consumer-go/data/failure/model"
+	"github.com/revdaalex/kafka-consumer-go/data/internal/query"
+	"github.com/revdaalex/kafka-consumer-go/data/retry/model"
+	"github.com/revdaalex/kafka-consumer-go/data/retry/store"
+)
+
+// MySQLRepository keeps retries in a MySQL database. MySQL cannot update a table from a subquery on the
+// same table, so batches are claimed in a transaction that locks the selected rows before updating them.
+type MySQLRepository struct {
+	db *sql.DB
+}
+
+func NewMySQLRepository(db *sql.DB) MySQLRepository {
+	return MySQLRepository{
+		db: db,
+	}
+}
+
+func (r MySQLRepository) PublishFailure(ctx context.Context, f failuremodel.Failure) error {
+	headers, err := model.EncodeHeaders(f.MessageHeaders)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	q := `INSERT INTO kafka_consumer_retries(topic, payload, payload_json, content_type, payload_headers, kafka_offset, kafka_partition, kafka_timestamp, payload_key, last_error, created_at, updated_at) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`
+	return inTransaction(ctx, r.db, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, q, f.Topic, f.Message, query.JSONPayload(f.Message), f.ContentType(), string(headers), f.KafkaOffset, f.KafkaPartition, kafkaTimestamp(f), string(f.MessageKey), f.Reason, now, now)
+		if err != nil {
+			return fmt.Errorf("data/retries: error publishing failure to the database: %w", err)
+		}
+		return recordPublishedAttempt(ctx, tx, res, query.MySQL)
+	})
+}
+
+func (r MySQLRepository) PublishParkedFailure(ctx context.Context, f failuremodel.Failure) error {
+	headers, err := model.EncodeHeaders(f.MessageHeaders)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	q := `INSERT INTO kafka_consumer_retries(topic, payload, payload_json, content_type, payload_headers, kafka_offset, kafka_partition, kafka_timestamp, payload_key, last_error, attempts, parked, created_at, updated_at) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, '', 0, true, ?, ?);`
+	_, err = r.db.ExecContext(ctx, q, f.Topic, f.Message, query.JSONPayload(f.Message), f.ContentType(), string(headers), f.KafkaOffset, f.KafkaPartition, kafkaTimestamp(f), string(f.MessageKey), now, now)
+	if err != nil {
+		return fmt.Errorf("data/retries: error publishing parked failure to the database: %w", err)
+	}
+	return nil
+}
+
+func (r MySQLRepository) HasPendingRetryForKey(ctx context.Context, topic string, key []byte) (bool, error) {
+	q := `SELECT EXISTS(
+			SELECT 1 FROM kafka_consumer_retries
+			WHERE topic = ? AND payload_key = ? AND successful = false AND deadlettered = false
+		);`
+
+	var exists bool
+	if err := r.db.QueryRowContext(ctx, q, topic, string(key)).Scan(&exists); err != nil {
+		return false, fmt.Errorf("data/retries: error checking for pending retries for key: %w", err)
+	}
+
+	return exists, nil
+}
+
+func (r MySQLRepository) GetMessagesForRetry(ctx context.Context, topic string, sequence uint8, interval time.Duration, claim store.Claim) ([]model.Retry, error) {
+	now := time.Now().UTC()
+
+	selSql := `SELECT id FROM kafka_consumer_retries
+		WHERE topic = ?
+		AND (
+			batch_id IS NULL OR lease_expires_at < ? OR
+			(lease_expires_at IS NULL AND retry_finished_at IS NULL AND retry_started_at < ?)
+		)
+		AND attempts = ? AND parked = false AND deadlettered = false AND successful = false AND updated_at <= ?
+		ORDER BY id
+		LIMIT ?
+		FOR UPDATE SKIP LOCKED;`
+
+	return r.claimBatch(ctx, now, claim, selSql, topic, now, now.Add(consideredStaleAfter*-1), sequence, now.Add(interval*-1), claim.BatchSize)
+}
+
+func (r MySQLRepository) GetReleasedParkedMessages(ctx context.Context, topic string, claim store.Claim) ([]model.Retry, error) {
+	now := time.Now().UTC()
+
+	selSql := `SELECT p.id FROM kafka_consumer_retries p
+		WHERE p.topic = ? AND p.parked = true
+		AND (
+			p.batch_id IS NULL OR p.lease_expires_at < ? OR
+			(p.lease_expires_at IS NULL AND p.retry_finished_at IS NULL AND p.retry_started_at < ?)
+		)
+		AND p.id = (
+			SELECT MIN(o.id) FROM kafka_consumer_retries o
+			WHERE o.topic = p.topic AND o.payload_key = p.payload_key AND o.parked = true
+		)
+		AND NOT EXISTS(
+			SELECT 1 FROM kafka_consumer_retries a
+			WHERE a.topic = p.topic AND a.payload_key = p.payload_key
+			AND a.parked = false AND a.successful = false AND a.deadlettered = false
+		)
+		ORDER BY p.id
+		LIMIT ?
+		FOR UPDATE SKIP LOCKED;`
+
+	return r.claimBatch(ctx, now, claim, selSql, topic, now, now.Add(consideredStaleAfter*-1), claim.BatchSize)
+}
+
+func (r MySQLRepository) DeleteSuccessful(ctx context.Context, olderThan time.Time) error {
+	return deleteSuccessful(ctx, r.db, olderThan, query.MySQL)
+}
+
+func (r MySQLRepository) MarkRetrySuccessful(ctx context.Context, retry model.Retry) error {
+	now := time.Now().UTC()
+	q := `UPDATE kafka_consumer_retries
+		SET lease_expires_at = NULL, attempts = ?, last_error = '', retry_finished_at = ?, errored = false, successful = true, parked = false, updated_at = ?
+		WHERE id = ?;`
+
+	return inTransaction(ctx, r.db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, q, retry.Attempts, now, now, retry.ID); err != nil {
+			return fmt.Errorf("data/retries: error marking a retry as successful: %w", err)
+		}
+		return recordAttempt(ctx, tx, retry.ID, query.MySQL)
+	})
+}
+
+func (r MySQLRepository) MarkRetryErrored(ctx context.Context, retry model.Retry, retryErr error) error {
+	return r.markRetryErrored(ctx, retry, retryErr, false)
+}
+
+// MarkRetryErroredToPublish marks the retry as errored, leaving it waiting to be published if it has been
+// dead-lettered.
+func (r MySQLRepository) MarkRetryErroredToPublish(ctx context.Context, retry model.Retry, retryErr error) error {
+	return r.markRetryErrored(ctx, retry, retryErr, true)
+}
+
+func (r MySQLRepository) markRetryErrored(ctx context.Context, retry model.Retry, retryErr error, toPublish bool) error {
+	now := time.Now().UTC()
+	q := `UPDATE kafka_consumer_retries
+		SET batch_id = NULL, lease_expires_at = NULL, attempts = ?, last_error = ?, retry_finished_at = ?, errored = ?, deadlettered = ?, deadletter_published_at = ?, parked = false, updated_at = ?
+		WHERE id = ?;`
+
+	return inTransaction(ctx, r.db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, q, retry.Attempts, retryErr.Error(), now, retry.Errored, retry.Deadlettered, deadLetterPublishedAt(query.MySQL, toPublish), now, retry.ID); err != nil {
+			return fmt.Errorf("data/retries: error marking a retry as errored: %w", err)
+		}
+		return recordAttempt(ctx, tx, retry.ID, query.MySQL)
+	})
+}
+
+// PublishDeadLetters publishes the dead letters of the topics that have not been published yet, claiming them in
+// a transaction of their own and marking them as published once they have been.
+func (r MySQLRepository) PublishDeadLetters(ctx context.Context, topics []string, claim store.DeadLetterClaim, publish func(store.DeadLetter) error) (int, error) {
+	return mysqlDialect.publishDeadLetters(ctx, r.db, topics, claim, publish)
+}
+
+// GetAttempts returns the attempts at processing the retry from the kafka_consumer_retry_attempts table.
+func (r MySQLRepository) GetAttempts(ctx context.Context, retryID int64) ([]store.Attempt, error) {
+	return getAttempts(ctx, r.db, retryID, query.MySQL)
+}
+
+func (r MySQLRepository) RenewLease(ctx context.Context, retries []model.Retry, claim store.Claim) error {
+	return renewLease(ctx, r.db, retries, claim, query.MySQL)
+}
+
+func (r MySQLRepository) ReleaseRetries(ctx context.Context, retries []model.Retry) error {
+	return releaseRetries(ctx, r.db, retries, query.MySQL)
+}
+
+func (r MySQLRepository) GetExpiredRetries(ctx context.Context, expiry store.Expiry, limit int) ([]store.ExpiredRetry, error) {
+	return mysqlDialect.getExpiredRetries(ctx, r.db, expiry, limit)
+}
+
+func (r MySQLRepository) DeleteExpiredRetries(ctx context.Context, expiry store.Expiry, retries []store.ExpiredRetry) (int64, error) {
+	return mysqlDialect.deleteExpiredRetries(ctx, r.db, expiry, retries)
+}
+
+// ArchiveRetries copies expired retries to the kafka_consumer_retries_archive table, ignoring any that are
+// already there.
+func (r MySQLRepository) ArchiveRetries(ctx context.Context, retries []store.ExpiredRetry) error {
+	return mysqlDialect.archiveRetries(ctx, r.db, retries)
+}
+
+// claimBatch locks the rows returned by selSql and assigns them to a new batch in one transaction, so that
+// concurrent consumers cannot claim the same retries.
+func (r MySQLRepository) claimBatch(ctx context.Context, now time.Time, claim store.Claim, selSql string, args ...interface{}) ([]model.Retry, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("data/retries: error starting transaction when creating a batch: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	ids, err := selectIDs(ctx, tx, selSql, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ids) == 0 {
+		return nil, tx.Commit()
+	}
+
+	batchId := uuid.New()
+	upSql := fmt.Sprintf(
+		`UPDATE kafka_consumer_retries SET batch_id = ?, retry_started_at = ?, worker_id = ?, lease_expires_at = ? WHERE id IN(%s);`,
+		query.MySQL.Placeholders(1, len(ids)),
+	)
+
+	upArgs := append([]interface{}{batchId.String(), now, claim.WorkerID, now.Add(claim.Lease)}, ids...)
+	// #nosec G201
+	if _, err = tx.ExecContext(ctx, upSql, upArgs...); err != nil {
+		return nil, fmt.Errorf("data/retries: error updating retries records when creating a batch: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("data/retries: error committing batch: %w", err)
+	}
+
+	return selectBatch(ctx, r.db, batchId)
+}
+
+func selectIDs(ctx context.Context, tx *sql.Tx, q string, args ...interface{}) ([]interface{}, error) {
+	rows, err := tx.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, fmt.Errorf("data/retries: error selecting retries records when creating a batch: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []interface{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("data/retries: error scanning result into memory: %w", err)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
@@ -0,0 +1,193 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/IBM/sarama"
+	"github.com/go-test/deep"
+
+	failuremodel "github.com/revdaalex/kafka-consumer-go/data/failure/model"
+	"github.com/revdaalex/kafka-consumer-go/data/retry/model"
+)
+
+func TestMySQLRepository_PublishFailure(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	repo := NewMySQLRepository(db)
+	f := failuremodel.Failure{
+		Reason:         "something bad happened",
+		Topic:          "product",
+		Message:        []byte(`{"foo":"bar"}`),
+		MessageKey:     []byte(`SKU-123`),
+		MessageHeaders: []sarama.RecordHeader{{Key: []byte("buzz"), Value: []byte("bazz")}},
+		KafkaPartition: 100,
+		KafkaOffset:    200,
+		KafkaTimestamp: kafkaTimestampForTests,
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO kafka_consumer_retries.*VALUES\(\?, \?, \?, \?, \?, \?, \?, \?, \?, \?, \?, \?\)`).
+		WithArgs("product", []byte(`{"foo":"bar"}`), `{"foo":"bar"}`, "application/json", `[{"key":"YnV6eg==","value":"YmF6eg=="}]`, 200, 100, kafkaTimestampForTests, "SKU-123", "something bad happened", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(7, 1))
+	mock.ExpectExec(`INSERT INTO kafka_consumer_retry_attempts.* FROM kafka_consumer_retries WHERE id = \?`).
+		WithArgs(7).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	if err := repo.PublishFailure(context.Background(), f); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMySQLRepository_GetMessagesForRetry(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("it locks and claims a batch in a transaction", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+		repo := NewMySQLRepository(db)
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT id FROM kafka_consumer_retries .* FOR UPDATE SKIP LOCKED`).
+			WithArgs("product", sqlmock.AnyArg(), sqlmock.AnyArg(), 1, sqlmock.AnyArg(), claimForTests.BatchSize).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+		mock.ExpectExec(`UPDATE kafka_consumer_retries SET batch_id = \?, retry_started_at = \?, worker_id = \?, lease_expires_at = \? WHERE id IN\(\?, \?\)`).
+			WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), "worker-1", sqlmock.AnyArg(), 1, 2).
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectCommit()
+		mock.ExpectQuery(`SELECT .* FROM kafka_consumer_retries WHERE batch_id = \?`).
+			WithArgs(sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows(columns).
+				AddRow(1, "product", `{"foo":"bar"}`, `[{"key":"YnV6eg==","value":"YmFy"}]`, "foo", 100, 200, kafkaTimestampForTests, 1, "application/json").
+				AddRow(2, "product", `{"foo":"bazz"}`, "[]", "", 200, 300, nil, 10, "application/json"))
+
+		got, err := repo.GetMessagesForRetry(ctx, "product", 1, time.Second*10, claimForTests)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if diff := deep.Equal(expectedRetriesForTests(), got); diff != nil {
+			t.Error(diff)
+		}
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("it does not update anything when there are no retries", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+		repo := NewMySQLRepository(db)
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT id FROM kafka_consumer_retries .* FOR UPDATE SKIP LOCKED`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectCommit()
+
+		got, err := repo.GetMessagesForRetry(ctx, "product", 1, time.Second*10, claimForTests)
+		if err != nil || len(got) != 0 {
+			t.Errorf("expected no retries and no error, got %+v (%v)", got, err)
+		}
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("it rolls back the transaction if the batch cannot be updated", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+		repo := NewMySQLRepository(db)
+		expErr := errors.New("oops")
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT id FROM kafka_consumer_retries .* FOR UPDATE SKIP LOCKED`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectExec(`UPDATE kafka_consumer_retries .*`).
+			WillReturnError(expErr)
+		mock.ExpectRollback()
+
+		if _, err := repo.GetMessagesForRetry(ctx, "product", 1, time.Second*10, claimForTests); !errors.Is(err, expErr) {
+			t.Errorf("expected error from update but got '%v'", err)
+		}
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestMySQLRepository_GetReleasedParkedMessages(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	repo := NewMySQLRepository(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT p.id FROM kafka_consumer_retries p .* p.parked = true .* FOR UPDATE SKIP LOCKED`).
+		WithArgs("product", sqlmock.AnyArg(), sqlmock.AnyArg(), claimForTests.BatchSize).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+	mock.ExpectExec(`UPDATE kafka_consumer_retries SET batch_id = \?, retry_started_at = \?, worker_id = \?, lease_expires_at = \? WHERE id IN\(\?\)`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), "worker-1", sqlmock.AnyArg(), 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery(`SELECT .* FROM kafka_consumer_retries WHERE batch_id = \?`).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(3, "product", `{}`, `[]`, "foo", 1, 2, nil, 0, "application/json"))
+
+	got, err := repo.GetReleasedParkedMessages(context.Background(), "product", claimForTests)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(got) != 1 || got[0].ID != 3 {
+		t.Errorf("expected the released parked message, got %+v", got)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMySQLRepository_MarkRetryErrored(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	repo := NewMySQLRepository(db)
+	retry := expectedRetriesForTests()[0]
+	retry.Errored = true
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE kafka_consumer_retries\s+SET batch_id = NULL.*WHERE id = \?`).
+		WithArgs(retry.Attempts, "oops", sqlmock.AnyArg(), true, false, sqlmock.AnyArg(), sqlmock.AnyArg(), retry.ID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO kafka_consumer_retry_attempts`).
+		WithArgs(retry.ID).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	if err := repo.MarkRetryErrored(context.Background(), retry, errors.New("oops")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMySQLRepository_ReleaseRetries(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	repo := NewMySQLRepository(db)
+
+	mock.ExpectExec(`UPDATE kafka_consumer_retries SET batch_id = NULL, worker_id = NULL, lease_expires_at = NULL WHERE id IN\(\?, \?\) AND successful = false`).
+		WithArgs(10, 11).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	if err := repo.ReleaseRetries(context.Background(), []model.Retry{{ID: 10}, {ID: 11}}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,451 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/revdaalex/kafka-consumer-go/data/internal/query"
+	"github.com/revdaalex/kafka-consumer-go/data/retry/store"
+)
+
+const (
+	// historyPartition holds the retries that were in the table when it was partitioned
+	historyPartition = "kafka_consumer_retries_history"
+	// defaultPartition holds retries created outside of every partition, until a partition is created for them
+	defaultPartition = "kafka_consumer_retries_default"
+	// partitionTimeFormat is how partition bounds are written, in the time zone of the database as created_at
+	// has no time zone
+	partitionTimeFormat = "2006-01-02 15:04:05"
+)
+
+// partitionBound matches the bound of a range partition, as returned by pg_get_expr().
+var partitionBound = regexp.MustCompile(`FROM \((?:MINVALUE|'([^']+)')\) TO \('([^']+)'\)`)
+
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// PartitionRetriesTable converts the kafka_consumer_retries table into one partitioned by created_at. The table
+// becomes the first partition, holding every retry created until the end of the current interval, so no retries
+// are copied. The table is locked while it is converted, which scans it once and indexes it by ID. Nothing is
+// done, and the table is not locked, if it is already partitioned.
+func PartitionRetriesTable(ctx context.Context, db *sql.DB, interval time.Duration) error {
+	partitioned, err := isPartitioned(ctx, db)
+	if err != nil || partitioned {
+		return err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("data/retries: error starting transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if _, err := tx.ExecContext(ctx, `LOCK TABLE kafka_consumer_retries IN ACCESS EXCLUSIVE MODE;`); err != nil {
+		return fmt.Errorf("data/retries: error locking the retries table: %w", err)
+	}
+
+	// another consumer may have converted the table while waiting for the lock
+	if partitioned, err := isPartitioned(ctx, tx); err != nil || partitioned {
+		return err
+	}
+
+	now, err := localTimestamp(ctx, tx)
+	if err != nil {
+		return err
+	}
+	bound := now.Truncate(interval).Add(interval)
+
+	indexes, err := queryPairs(ctx, tx, `SELECT indexname, indexdef FROM pg_indexes
+		WHERE schemaname = current_schema() AND tablename = 'kafka_consumer_retries'
+		AND indexname NOT IN(SELECT conname FROM pg_constraint WHERE conrelid = 'kafka_consumer_retries'::regclass AND contype = 'p');`)
+	if err != nil {
+		return err
+	}
+	triggers, err := queryPairs(ctx, tx, `SELECT tgname, pg_get_triggerdef(oid) FROM pg_trigger WHERE tgrelid = 'kafka_consumer_retries'::regclass AND NOT tgisinternal;`)
+	if err != nil {
+		return err
+	}
+	var sequence sql.NullString
+	if err := tx.QueryRowContext(ctx, `SELECT pg_get_serial_sequence('kafka_consumer_retries', 'id');`).Scan(&sequence); err != nil {
+		return fmt.Errorf("data/retries: error reading the ID sequence of the retries table: %w", err)
+	}
+
+	var stmts []string
+	stmts = append(stmts,
+		`UPDATE kafka_consumer_retries SET created_at = COALESCE(updated_at, LOCALTIMESTAMP) WHERE created_at IS NULL;`,
+		`ALTER TABLE kafka_consumer_retries ALTER COLUMN created_at SET NOT NULL;`,
+		`ALTER TABLE kafka_consumer_retries RENAME TO `+historyPartition+`;`,
+	)
+	// the triggers and indexes are created on the partitioned table instead, which gives the partitions their own
+	for _, t := range triggers {
+		stmts = append(stmts, fmt.Sprintf(`DROP TRIGGER %s ON %s;`, quoteIdentifier(t[0]), historyPartition))
+	}
+	for _, i := range indexes {
+		stmts = append(stmts, fmt.Sprintf(`ALTER INDEX %s RENAME TO %s;`, quoteIdentifier(i[0]), quoteIdentifier(i[0]+"_history")))
+	}
+	if sequence.Valid {
+		// the sequence would otherwise be dropped along with the history partition
+		stmts = append(stmts, fmt.Sprintf(`ALTER SEQUENCE %s OWNED BY NONE;`, sequence.String))
+	}
+	stmts = append(stmts,
+		`CREATE TABLE kafka_consumer_retries (LIKE `+historyPartition+` INCLUDING DEFAULTS) PARTITION BY RANGE (created_at);`,
+		fmt.Sprintf(`ALTER TABLE kafka_consumer_retries ATTACH PARTITION %s FOR VALUES FROM (MINVALUE) TO ('%s');`, historyPartition, bound.Format(partitionTimeFormat)),
+		`CREATE TABLE `+defaultPartition+` PARTITION OF kafka_consumer_retries DEFAULT;`,
+		`CREATE INDEX IF NOT EXISTS retries_id_idx ON kafka_consumer_retries (id);`,
+	)
+	if sequence.Valid {
+		stmts = append(stmts, fmt.Sprintf(`ALTER SEQUENCE %s OWNED BY kafka_consumer_retries.id;`, sequence.String))
+	}
+	for _, i := range indexes {
+		stmts = append(stmts, i[1]+";")
+	}
+	for _, t := range triggers {
+		stmts = append(stmts, t[1]+";")
+	}
+
+	for _, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("data/retries: error partitioning the retries table: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("data/retries: error committing the partitioning of the retries table: %w", err)
+	}
+	return nil
+}
+
+// CreatePartitions creates partitions of the interval from the current one until ahead of now, where there are
+// none yet. Any retries in the default partition that belong in a new partition are moved to it.
+func (r Repository) CreatePartitions(ctx context.Context, interval, ahead time.Duration) error {
+	now, err := localTimestamp(ctx, r.db)
+	if err != nil {
+		return err
+	}
+	partitions, err := listPartitions(ctx, r.db)
+	if err != nil {
+		return err
+	}
+
+	until := now.Add(ahead)
+	for from := now.Truncate(interval); from.Before(until); from = from.Add(interval) {
+		p, ok := missingPartition(partitions, from, from.Add(interval))
+		if !ok {
+			continue
+		}
+		if err := r.createPartition(ctx, p); err != nil {
+			return err
+		}
+		partitions = append(partitions, p)
+		sortPartitions(partitions)
+	}
+
+	return nil
+}
+
+func (r Repository) createPartition(ctx context.Context, p store.Partition) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("data/retries: error starting transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	from, to := p.From.Format(partitionTimeFormat), p.To.Format(partitionTimeFormat)
+	create := fmt.Sprintf(`CREATE TABLE %s PARTITION OF kafka_consumer_retries FOR VALUES FROM ('%s') TO ('%s');`, quoteIdentifier(p.Name), from, to)
+
+	var misplaced bool
+	q := `SELECT EXISTS(SELECT 1 FROM ` + defaultPartition + ` WHERE created_at >= $1 AND created_at < $2);`
+	if err := tx.QueryRowContext(ctx, q, from, to).Scan(&misplaced); err != nil {
+		return fmt.Errorf("data/retries: error checking the default partition: %w", err)
+	}
+
+	stmts := []string{create}
+	if misplaced {
+		// a partition cannot be created while the default partition holds retries that belong in it
+		stmts = []string{
+			`ALTER TABLE kafka_consumer_retries DETACH PARTITION ` + defaultPartition + `;`,
+			create,
+			fmt.Sprintf(`INSERT INTO %s SELECT * FROM %s WHERE created_at >= '%s' AND created_at < '%s';`, quoteIdentifier(p.Name), defaultPartition, from, to),
+			fmt.Sprintf(`DELETE FROM %s WHERE created_at >= '%s' AND created_at < '%s';`, defaultPartition, from, to),
+			`ALTER TABLE kafka_consumer_retries ATTACH PARTITION ` + defaultPartition + ` DEFAULT;`,
+		}
+	}
+
+	for _, stmt := range stmts {
+		// #nosec G201
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("data/retries: error creating partition %s: %w", p.Name, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("data/retries: error committing partition %s: %w", p.Name, err)
+	}
+	return nil
+}
+
+// GetExpiredPartitions returns the partitions that ended longer than retention ago, and hold no retries that are
+// waiting to be retried.
+func (r Repository) GetExpiredPartitions(ctx context.Context, retention time.Duration) ([]store.Partition, error) {
+	now, err := localTimestamp(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	partitions, err := listPartitions(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+
+	var expired []store.Partition
+	for _, p := range partitions {
+		if p.To.After(now.Add(-retention)) {
+			continue
+		}
+		pending, err := hasPendingRetries(ctx, r.db, p)
+		if err != nil {
+			return nil, err
+		}
+		if !pending {
+			expired = append(expired, p)
+		}
+	}
+
+	return expired, nil
+}
+
+// GetPartitionRetries returns a page of the retries in the partition, so that they can be archived.
+func (r Repository) GetPartitionRetries(ctx context.Context, p store.Partition, afterID int64, limit int) ([]store.ExpiredRetry, error) {
+	q := fmt.Sprintf(
+		`SELECT %s, last_error, created_at, updated_at, successful, deadlettered FROM %s WHERE id > $1 ORDER BY id LIMIT $2;`,
+		r.columnsAsString(), quoteIdentifier(p.Name),
+	)
+
+	// #nosec G201
+	rows, err := r.db.QueryContext(ctx, q, afterID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("data/retries: error getting the retries of partition %s: %w", p.Name, err)
+	}
+	defer rows.Close()
+
+	var retries []store.ExpiredRetry
+	for rows.Next() {
+		var (
+			retry                    store.ExpiredRetry
+			ts, created, updated     query.NullTime
+			successful, deadlettered bool
+		)
+		err := rows.Scan(&retry.ID, &retry.Topic, &retry.Payload, &retry.PayloadHeaders, &retry.PayloadKey, &retry.KafkaOffset, &retry.KafkaPartition, &ts, &retry.Attempts, &retry.ContentType, &retry.LastError, &created, &updated, &successful, &deadlettered)
+		if err != nil {
+			return nil, fmt.Errorf("data/retries: error scanning result into memory: %w", err)
+		}
+		retry.KafkaTimestamp, retry.CreatedAt, retry.UpdatedAt = ts.Time, created.Time, updated.Time
+		retry.State = retryState(successful, deadlettered)
+		retry.Deadlettered, retry.Errored = deadlettered, !successful
+		retries = append(retries, retry)
+	}
+
+	return retries, rows.Err()
+}
+
+// DropPartition detaches and drops an expired partition, and returns how many retries it held. The partition is
+// checked again once it is detached, so that retries requeued since it expired are not dropped.
+func (r Repository) DropPartition(ctx context.Context, p store.Partition) ([]store.RetryCount, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("data/retries: error starting transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	// #nosec G201
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(`ALTER TABLE kafka_consumer_retries DETACH PARTITION %s;`, quoteIdentifier(p.Name))); err != nil {
+		return nil, fmt.Errorf("data/retries: error detaching partition %s: %w", p.Name, err)
+	}
+
+	pending, err := hasPendingRetries(ctx, tx, p)
+	if err != nil {
+		return nil, err
+	}
+	if pending {
+		return nil, store.ErrPartitionPending
+	}
+
+	// #nosec G201
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf(`SELECT topic, successful, COUNT(*) FROM %s GROUP BY topic, successful ORDER BY topic, successful DESC;`, quoteIdentifier(p.Name)))
+	if err != nil {
+		return nil, fmt.Errorf("data/retries: error counting the retries of partition %s: %w", p.Name, err)
+	}
+	var counts []store.RetryCount
+	for rows.Next() {
+		var (
+			c          store.RetryCount
+			successful bool
+		)
+		if err := rows.Scan(&c.Topic, &successful, &c.Retries); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("data/retries: error scanning result into memory: %w", err)
+		}
+		c.State = retryState(successful, !successful)
+		counts = append(counts, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("data/retries: error counting the retries of partition %s: %w", p.Name, err)
+	}
+
+	// #nosec G201
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(`DELETE FROM kafka_consumer_retry_attempts WHERE retry_id IN(SELECT id FROM %s);`, quoteIdentifier(p.Name))); err != nil {
+		return nil, fmt.Errorf("data/retries: error deleting the retry attempts of partition %s: %w", p.Name, err)
+	}
+
+	// #nosec G201
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(`DROP TABLE %s;`, quoteIdentifier(p.Name))); err != nil {
+		return nil, fmt.Errorf("data/retries: error dropping partition %s: %w", p.Name, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("data/retries: error committing the drop of partition %s: %w", p.Name, err)
+	}
+	return counts, nil
+}
+
+// isPartitioned returns true if the retries table is already partitioned.
+func isPartitioned(ctx context.Context, q querier) (bool, error) {
+	var partitioned bool
+	if err := q.QueryRowContext(ctx, `SELECT relkind = 'p' FROM pg_class WHERE oid = 'kafka_consumer_retries'::regclass;`).Scan(&partitioned); err != nil {
+		return false, fmt.Errorf("data/retries: error reading the retries table: %w", err)
+	}
+	return partitioned, nil
+}
+
+// listPartitions returns the range partitions of the retries table, in order of their bounds.
+func listPartitions(ctx context.Context, q querier) ([]store.Partition, error) {
+	bounds, err := queryPairs(ctx, q, `SELECT c.relname, pg_get_expr(c.relpartbound, c.oid) FROM pg_inherits i
+		JOIN pg_class c ON c.oid = i.inhrelid
+		WHERE i.inhparent = 'kafka_consumer_retries'::regclass;`)
+	if err != nil {
+		return nil, err
+	}
+
+	var partitions []store.Partition
+	for _, b := range bounds {
+		m := partitionBound.FindStringSubmatch(b[1])
+		if m == nil {
+			// the default partition has no bounds
+			continue
+		}
+
+		p := store.Partition{Name: b[0]}
+		if m[1] != "" {
+			if p.From, err = time.ParseInLocation("2006-01-02 15:04:05.999999999", m[1], time.UTC); err != nil {
+				return nil, fmt.Errorf("data/retries: error reading the bounds of partition %s: %w", p.Name, err)
+			}
+		}
+		if p.To, err = time.ParseInLocation("2006-01-02 15:04:05.999999999", m[2], time.UTC); err != nil {
+			return nil, fmt.Errorf("data/retries: error reading the bounds of partition %s: %w", p.Name, err)
+		}
+		partitions = append(partitions, p)
+	}
+
+	sortPartitions(partitions)
+	return partitions, nil
+}
+
+func sortPartitions(partitions []store.Partition) {
+	sort.Slice(partitions, func(i, j int) bool {
+		return partitions[i].From.Before(partitions[j].From)
+	})
+}
+
+// missingPartition returns the partition for the part of the range from until to that no partition holds yet,
+// which is cut short by any partition in the way, e.g. after the interval has been changed.
+func missingPartition(partitions []store.Partition, from, to time.Time) (store.Partition, bool) {
+	for _, p := range partitions {
+		if !p.From.After(from) && p.To.After(from) {
+			from = p.To
+		}
+	}
+	for _, p := range partitions {
+		if p.From.After(from) && p.From.Before(to) {
+			to = p.From
+		}
+	}
+	if !from.Before(to) {
+		return store.Partition{}, false
+	}
+
+	return store.Partition{Name: "kafka_consumer_retries_p" + from.Format("20060102_1504"), From: from, To: to}, true
+}
+
+// hasPendingRetries returns true if the partition holds retries that have neither succeeded nor been dead-lettered,
+// or dead letters that are waiting to be published.
+func hasPendingRetries(ctx context.Context, q querier, p store.Partition) (bool, error) {
+	var pending bool
+	exists := fmt.Sprintf(
+		`SELECT EXISTS(SELECT 1 FROM %s WHERE successful = false AND (deadlettered = false OR deadletter_published_at IS NULL));`,
+		quoteIdentifier(p.Name),
+	)
+	// #nosec G201
+	err := q.QueryRowContext(ctx, exists).Scan(&pending)
+	if err != nil {
+		return false, fmt.Errorf("data/retries: error checking partition %s for pending retries: %w", p.Name, err)
+	}
+	return pending, nil
+}
+
+// localTimestamp returns the time in the time zone of the database, which created_at is set in.
+func localTimestamp(ctx context.Context, q querier) (time.Time, error) {
+	var now time.Time
+	if err := q.QueryRowContext(ctx, `SELECT LOCALTIMESTAMP;`).Scan(&now); err != nil {
+		return time.Time{}, fmt.Errorf("data/retries: error reading the time of the database: %w", err)
+	}
+	// the wall clock is kept as UTC, as partition bounds are written without a time zone
+	return time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), now.Minute(), now.Second(), now.Nanosecond(), time.UTC), nil
+}
+
+// queryPairs returns the rows of a query that selects two text columns.
+func queryPairs(ctx context.Context, q querier, query string) ([][2]string, error) {
+	rows, err := q.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("data/retries: error reading the retries table: %w", err)
+	}
+	defer rows.Close()
+
+	var pairs [][2]string
+	for rows.Next() {
+		var p [2]string
+		if err := rows.Scan(&p[0], &p[1]); err != nil {
+			return nil, fmt.Errorf("data/retries: error scanning result into memory: %w", err)
+		}
+		pairs = append(pairs, p)
+	}
+	return pairs, rows.Err()
+}
+
+func retryState(successful, deadlettered bool) store.RetryState {
+	switch {
+	case successful:
+		return store.StateSuccessful
+	case deadlettered:
+		return store.StateDeadlettered
+	default:
+		return store.StateErrored
+	}
+}
+
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
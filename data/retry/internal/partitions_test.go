@@ -0,0 +1,233 @@
+package internal
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-test/deep"
+
+	"github.com/revdaalex/kafka-consumer-go/data/retry/store"
+)
+
+var _ store.PartitionedStore = Repository{}
+
+var nowForPartitionTests = time.Date(2026, 10, 18, 13, 30, 0, 0, time.UTC)
+
+func TestPartitionRetriesTable(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("it does nothing if the table is already partitioned", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+		// the table is not locked to find out
+		mock.ExpectQuery(`SELECT relkind = 'p'`).WillReturnRows(sqlmock.NewRows([]string{"partitioned"}).AddRow(true))
+
+		if err := PartitionRetriesTable(ctx, db, 24*time.Hour); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("it does nothing if the table was partitioned while waiting for the lock", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+		mock.ExpectQuery(`SELECT relkind = 'p'`).WillReturnRows(sqlmock.NewRows([]string{"partitioned"}).AddRow(false))
+		mock.ExpectBegin()
+		mock.ExpectExec(`LOCK TABLE kafka_consumer_retries`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(`SELECT relkind = 'p'`).WillReturnRows(sqlmock.NewRows([]string{"partitioned"}).AddRow(true))
+		mock.ExpectRollback()
+
+		if err := PartitionRetriesTable(ctx, db, 24*time.Hour); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("it keeps the table as the history partition", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+		mock.ExpectQuery(`SELECT relkind = 'p'`).WillReturnRows(sqlmock.NewRows([]string{"partitioned"}).AddRow(false))
+		mock.ExpectBegin()
+		mock.ExpectExec(`LOCK TABLE kafka_consumer_retries`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(`SELECT relkind = 'p'`).WillReturnRows(sqlmock.NewRows([]string{"partitioned"}).AddRow(false))
+		mock.ExpectQuery(`SELECT LOCALTIMESTAMP`).WillReturnRows(sqlmock.NewRows([]string{"now"}).AddRow(nowForPartitionTests))
+		mock.ExpectQuery(`FROM pg_indexes`).WillReturnRows(sqlmock.NewRows([]string{"indexname", "indexdef"}).
+			AddRow("topic_attempts_idx", "CREATE INDEX topic_attempts_idx ON public.kafka_consumer_retries USING btree (topic, attempts)"))
+		mock.ExpectQuery(`FROM pg_trigger`).WillReturnRows(sqlmock.NewRows([]string{"tgname", "def"}).
+			AddRow("retries_notify_insert", "CREATE TRIGGER retries_notify_insert AFTER INSERT ON public.kafka_consumer_retries FOR EACH ROW EXECUTE PROCEDURE kafka_consumer_retries_notify()"))
+		mock.ExpectQuery(`pg_get_serial_sequence`).WillReturnRows(sqlmock.NewRows([]string{"seq"}).AddRow("public.kafka_consumer_retries_id_seq"))
+
+		for _, stmt := range []string{
+			`UPDATE kafka_consumer_retries SET created_at = COALESCE\(updated_at, LOCALTIMESTAMP\) WHERE created_at IS NULL`,
+			`ALTER TABLE kafka_consumer_retries ALTER COLUMN created_at SET NOT NULL`,
+			`ALTER TABLE kafka_consumer_retries RENAME TO kafka_consumer_retries_history`,
+			`DROP TRIGGER "retries_notify_insert" ON kafka_consumer_retries_history`,
+			`ALTER INDEX "topic_attempts_idx" RENAME TO "topic_attempts_idx_history"`,
+			`ALTER SEQUENCE public.kafka_consumer_retries_id_seq OWNED BY NONE`,
+			`CREATE TABLE kafka_consumer_retries \(LIKE kafka_consumer_retries_history INCLUDING DEFAULTS\) PARTITION BY RANGE \(created_at\)`,
+			`ATTACH PARTITION kafka_consumer_retries_history FOR VALUES FROM \(MINVALUE\) TO \('2026-10-19 00:00:00'\)`,
+			`CREATE TABLE kafka_consumer_retries_default PARTITION OF kafka_consumer_retries DEFAULT`,
+			`CREATE INDEX IF NOT EXISTS retries_id_idx ON kafka_consumer_retries \(id\)`,
+			`ALTER SEQUENCE public.kafka_consumer_retries_id_seq OWNED BY kafka_consumer_retries.id`,
+			`CREATE INDEX topic_attempts_idx ON public.kafka_consumer_retries`,
+			`CREATE TRIGGER retries_notify_insert AFTER INSERT ON public.kafka_consumer_retries`,
+		} {
+			mock.ExpectExec(stmt).WillReturnResult(sqlmock.NewResult(0, 0))
+		}
+		mock.ExpectCommit()
+
+		if err := PartitionRetriesTable(ctx, db, 24*time.Hour); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestRepository_CreatePartitions(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	repo := NewRepository(db)
+
+	mock.ExpectQuery(`SELECT LOCALTIMESTAMP`).WillReturnRows(sqlmock.NewRows([]string{"now"}).AddRow(nowForPartitionTests))
+	mock.ExpectQuery(`FROM pg_inherits`).WillReturnRows(sqlmock.NewRows([]string{"relname", "bound"}).
+		AddRow("kafka_consumer_retries_history", "FOR VALUES FROM (MINVALUE) TO ('2026-10-19 00:00:00')").
+		AddRow("kafka_consumer_retries_default", "DEFAULT"))
+
+	// the first partition is created straight away
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT EXISTS\(SELECT 1 FROM kafka_consumer_retries_default`).
+		WithArgs("2026-10-19 00:00:00", "2026-10-20 00:00:00").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectExec(`CREATE TABLE "kafka_consumer_retries_p20261019_0000" PARTITION OF kafka_consumer_retries FOR VALUES FROM \('2026-10-19 00:00:00'\) TO \('2026-10-20 00:00:00'\)`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	// the retries of the second partition that are in the default partition are moved to it
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT EXISTS\(SELECT 1 FROM kafka_consumer_retries_default`).
+		WithArgs("2026-10-20 00:00:00", "2026-10-21 00:00:00").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectExec(`DETACH PARTITION kafka_consumer_retries_default`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`CREATE TABLE "kafka_consumer_retries_p20261020_0000" PARTITION OF`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO "kafka_consumer_retries_p20261020_0000" SELECT \* FROM kafka_consumer_retries_default`).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(`DELETE FROM kafka_consumer_retries_default`).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(`ATTACH PARTITION kafka_consumer_retries_default DEFAULT`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	if err := repo.CreatePartitions(context.Background(), 24*time.Hour, 48*time.Hour); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRepository_GetExpiredPartitions(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	repo := NewRepository(db)
+
+	mock.ExpectQuery(`SELECT LOCALTIMESTAMP`).WillReturnRows(sqlmock.NewRows([]string{"now"}).AddRow(nowForPartitionTests))
+	mock.ExpectQuery(`FROM pg_inherits`).WillReturnRows(sqlmock.NewRows([]string{"relname", "bound"}).
+		AddRow("kafka_consumer_retries_p20261017_0000", "FOR VALUES FROM ('2026-10-17 00:00:00') TO ('2026-10-18 00:00:00')").
+		AddRow("kafka_consumer_retries_history", "FOR VALUES FROM (MINVALUE) TO ('2026-10-17 00:00:00')").
+		AddRow("kafka_consumer_retries_p20261018_0000", "FOR VALUES FROM ('2026-10-18 00:00:00') TO ('2026-10-19 00:00:00')"))
+	mock.ExpectQuery(`SELECT EXISTS\(SELECT 1 FROM "kafka_consumer_retries_history" WHERE successful = false AND \(deadlettered = false OR deadletter_published_at IS NULL\)\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery(`SELECT EXISTS\(SELECT 1 FROM "kafka_consumer_retries_p20261017_0000"`).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+
+	expired, err := repo.GetExpiredPartitions(context.Background(), time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	exp := []store.Partition{{
+		Name: "kafka_consumer_retries_p20261017_0000",
+		From: time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC),
+		To:   time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC),
+	}}
+	if diff := deep.Equal(expired, exp); diff != nil {
+		t.Error(diff)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRepository_DropPartition(t *testing.T) {
+	ctx := context.Background()
+	p := store.Partition{Name: "kafka_consumer_retries_p20261017_0000"}
+
+	t.Run("it drops the partition and counts its retries", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+		repo := NewRepository(db)
+
+		mock.ExpectBegin()
+		mock.ExpectExec(`ALTER TABLE kafka_consumer_retries DETACH PARTITION "kafka_consumer_retries_p20261017_0000"`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(`SELECT EXISTS`).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+		mock.ExpectQuery(`SELECT topic, successful, COUNT\(\*\) FROM "kafka_consumer_retries_p20261017_0000"`).
+			WillReturnRows(sqlmock.NewRows([]string{"topic", "successful", "count"}).AddRow("product", true, 10).AddRow("product", false, 2))
+		mock.ExpectExec(`DELETE FROM kafka_consumer_retry_attempts WHERE retry_id IN\(SELECT id FROM "kafka_consumer_retries_p20261017_0000"\)`).WillReturnResult(sqlmock.NewResult(0, 4))
+		mock.ExpectExec(`DROP TABLE "kafka_consumer_retries_p20261017_0000"`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectCommit()
+
+		counts, err := repo.DropPartition(ctx, p)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		exp := []store.RetryCount{
+			{Topic: "product", State: store.StateSuccessful, Retries: 10},
+			{Topic: "product", State: store.StateDeadlettered, Retries: 2},
+		}
+		if diff := deep.Equal(counts, exp); diff != nil {
+			t.Error(diff)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("it keeps a partition with pending retries", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+		repo := NewRepository(db)
+
+		mock.ExpectBegin()
+		mock.ExpectExec(`DETACH PARTITION`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(`SELECT EXISTS`).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectRollback()
+
+		if _, err := repo.DropPartition(ctx, p); err != store.ErrPartitionPending {
+			t.Errorf("expected store.ErrPartitionPending, got %v", err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestMissingPartition(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2026, 10, d, 0, 0, 0, 0, time.UTC) }
+	partitions := []store.Partition{
+		{Name: "history", To: day(18).Add(6 * time.Hour)},
+		{Name: "next", From: day(19).Add(12 * time.Hour), To: day(20)},
+	}
+
+	p, ok := missingPartition(partitions, day(18), day(19))
+	if !ok || !p.From.Equal(day(18).Add(6*time.Hour)) || !p.To.Equal(day(19)) || p.Name != "kafka_consumer_retries_p20261018_0600" {
+		t.Errorf("expected a partition from the end of the history partition, got %+v", p)
+	}
+
+	p, ok = missingPartition(partitions, day(19), day(20))
+	if !ok || !p.From.Equal(day(19)) || !p.To.Equal(day(19).Add(12*time.Hour)) {
+		t.Errorf("expected a partition until the start of the next partition, got %+v", p)
+	}
+
+	if _, ok := missingPartition(partitions, day(17), day(18)); ok {
+		t.Error("expected no partition where the history partition already holds the retries")
+	}
+}
@@ -0,0 +1,102 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	failuremodel "github.com/revdaalex/kafka-consumer-go/data/failure/model"
+	"github.com/revdaalex/kafka-consumer-go/data/internal/query"
+	"github.com/revdaalex/kafka-consumer-go/data/retry/model"
+	"github.com/revdaalex/kafka-consumer-go/data/retry/store"
+)
+
+// kafkaTimestamp returns the timestamp of the failed message in UTC, or nil if it is not known.
+func kafkaTimestamp(f failuremodel.Failure) interface{} {
+	if f.KafkaTimestamp.IsZero() {
+		return nil
+	}
+	return f.KafkaTimestamp.UTC()
+}
+
+// retryIDs returns the IDs of the retries as query arguments.
+func retryIDs(retries []model.Retry) []interface{} {
+	ids := make([]interface{}, len(retries))
+	for i, r := range retries {
+		ids[i] = r.ID
+	}
+	return ids
+}
+
+// releaseRetries releases the claim on the retries in db, using the dialect of its driver.
+func releaseRetries(ctx context.Context, db *sql.DB, retries []model.Retry, d query.Dialect) error {
+	if len(retries) == 0 {
+		return nil
+	}
+
+	q := fmt.Sprintf(
+		`UPDATE kafka_consumer_retries SET batch_id = NULL, worker_id = NULL, lease_expires_at = NULL WHERE id IN(%s) AND successful = false;`,
+		d.Placeholders(1, len(retries)),
+	)
+
+	// #nosec G201
+	_, err := db.ExecContext(ctx, q, retryIDs(retries)...)
+	if err != nil {
+		return fmt.Errorf("data/retries: error releasing retries: %w", err)
+	}
+	return nil
+}
+
+// renewLease extends the lease on the retries in db that are still claimed by the worker, using the dialect
+// of its driver.
+func renewLease(ctx context.Context, db *sql.DB, retries []model.Retry, claim store.Claim, d query.Dialect) error {
+	if len(retries) == 0 {
+		return nil
+	}
+
+	q := fmt.Sprintf(
+		`UPDATE kafka_consumer_retries SET lease_expires_at = %s WHERE worker_id = %s AND batch_id IS NOT NULL AND successful = false AND id IN(%s);`,
+		d.Placeholder(1), d.Placeholder(2), d.Placeholders(3, len(retries)),
+	)
+
+	args := append([]interface{}{d.TimeArg(time.Now().Add(claim.Lease)), claim.WorkerID}, retryIDs(retries)...)
+	// #nosec G201
+	if _, err := db.ExecContext(ctx, q, args...); err != nil {
+		return fmt.Errorf("data/retries: error renewing the lease on retries: %w", err)
+	}
+	return nil
+}
+
+// scanRetries reads the retries from rows selected with the columns of Repository.columnsAsString.
+func scanRetries(rows *sql.Rows) ([]model.Retry, error) {
+	var retries []model.Retry
+	for rows.Next() {
+		retry := model.Retry{}
+		var ts query.NullTime
+		err := rows.Scan(&retry.ID, &retry.Topic, &retry.Payload, &retry.PayloadHeaders, &retry.PayloadKey, &retry.KafkaOffset, &retry.KafkaPartition, &ts, &retry.Attempts, &retry.ContentType)
+		if err != nil {
+			return nil, fmt.Errorf("data/retries: error scanning result into memory: %w", err)
+		}
+		retry.KafkaTimestamp = ts.Time
+		retries = append(retries, retry)
+	}
+
+	return retries, nil
+}
+
+// selectBatch returns the retries claimed for batchId, for the database drivers that use ? placeholders.
+func selectBatch(ctx context.Context, db *sql.DB, batchId uuid.UUID) ([]model.Retry, error) {
+	q := fmt.Sprintf(`SELECT %s FROM kafka_consumer_retries WHERE batch_id = ? ORDER BY id`, Repository{}.columnsAsString())
+
+	// #nosec G201
+	rows, err := db.QueryContext(ctx, q, batchId.String())
+	if err != nil {
+		return nil, fmt.Errorf("data/retries: error getting messages for retry: %w", err)
+	}
+	defer rows.Close()
+
+	return scanRetries(rows)
+}
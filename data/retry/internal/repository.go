@@ -10,15 +10,19 @@ import (
 	"github.com/google/uuid"
 
 	failuremodel "github.com/revdaalex/kafka-consumer-go/data/failure/model"
+	"github.com/revdaalex/kafka-consumer-go/data/internal/query"
 	"github.com/revdaalex/kafka-consumer-go/data/retry/model"
+	"github.com/revdaalex/kafka-consumer-go/data/retry/store"
 )
 
 const (
+	// consideredStaleAfter is how long a claim without a lease is held for. Only claims made before leases
+	// were recorded have no lease.
 	consideredStaleAfter = time.Minute * 10
 )
 
 var (
-	columns = []string{"id", "topic", "payload_json", "payload_headers", "payload_key", "kafka_offset", "kafka_partition", "attempts"}
+	columns = []string{"id", "topic", "payload", "payload_headers", "payload_key", "kafka_offset", "kafka_partition", "kafka_timestamp", "attempts", "content_type"}
 )
 
 type Repository struct {
@@ -32,16 +36,57 @@ func NewRepository(db *sql.DB) Repository {
 }
 
 func (r Repository) PublishFailure(ctx context.Context, f failuremodel.Failure) error {
-	q := `INSERT INTO kafka_consumer_retries(topic, payload_json, payload_headers, kafka_offset, kafka_partition, payload_key) VALUES($1, $2, $3, $4, $5, $6);`
-	_, err := r.db.ExecContext(ctx, q, f.Topic, f.Message, f.MessageHeaders, f.KafkaOffset, f.KafkaPartition, string(f.MessageKey))
+	headers, err := model.EncodeHeaders(f.MessageHeaders)
 	if err != nil {
-		return fmt.Errorf("data/retries: error publishing failure to the database: %w", err)
+		return err
+	}
+
+	q := `INSERT INTO kafka_consumer_retries(topic, payload, payload_json, content_type, payload_headers, kafka_offset, kafka_partition, kafka_timestamp, payload_key, last_error) VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id;`
+	return inTransaction(ctx, r.db, func(tx *sql.Tx) error {
+		var id int64
+		err := tx.QueryRowContext(ctx, q, f.Topic, f.Message, query.JSONPayload(f.Message), f.ContentType(), string(headers), f.KafkaOffset, f.KafkaPartition, kafkaTimestamp(f), string(f.MessageKey), f.Reason).Scan(&id)
+		if err != nil {
+			return fmt.Errorf("data/retries: error publishing failure to the database: %w", err)
+		}
+		return recordAttempt(ctx, tx, id, query.Postgres)
+	})
+}
+
+// PublishParkedFailure stores a message that has not been processed yet because an earlier message
+// with the same key is still being retried. Parked messages have no attempts and are only released
+// once there is no other pending retry for their key (see GetReleasedParkedMessages).
+func (r Repository) PublishParkedFailure(ctx context.Context, f failuremodel.Failure) error {
+	headers, err := model.EncodeHeaders(f.MessageHeaders)
+	if err != nil {
+		return err
+	}
+
+	q := `INSERT INTO kafka_consumer_retries(topic, payload, payload_json, content_type, payload_headers, kafka_offset, kafka_partition, kafka_timestamp, payload_key, attempts, parked) VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, 0, true);`
+	_, err = r.db.ExecContext(ctx, q, f.Topic, f.Message, query.JSONPayload(f.Message), f.ContentType(), string(headers), f.KafkaOffset, f.KafkaPartition, kafkaTimestamp(f), string(f.MessageKey))
+	if err != nil {
+		return fmt.Errorf("data/retries: error publishing parked failure to the database: %w", err)
 	}
 	return nil
 }
 
-func (r Repository) GetMessagesForRetry(ctx context.Context, topic string, sequence uint8, interval time.Duration) ([]model.Retry, error) {
-	batchId, err := r.createEventBatch(ctx, topic, sequence, interval)
+// HasPendingRetryForKey returns true if there is a retry for the given topic and key that has
+// neither succeeded nor been dead-lettered yet, including any parked messages.
+func (r Repository) HasPendingRetryForKey(ctx context.Context, topic string, key []byte) (bool, error) {
+	q := `SELECT EXISTS(
+			SELECT 1 FROM kafka_consumer_retries
+			WHERE topic = $1 AND payload_key = $2 AND successful = false AND deadlettered = false
+		);`
+
+	var exists bool
+	if err := r.db.QueryRowContext(ctx, q, topic, string(key)).Scan(&exists); err != nil {
+		return false, fmt.Errorf("data/retries: error checking for pending retries for key: %w", err)
+	}
+
+	return exists, nil
+}
+
+func (r Repository) GetMessagesForRetry(ctx context.Context, topic string, sequence uint8, interval time.Duration, claim store.Claim) ([]model.Retry, error) {
+	batchId, err := r.createEventBatch(ctx, topic, sequence, interval, claim)
 	if err != nil {
 		return nil, err
 	}
@@ -49,56 +94,117 @@ func (r Repository) GetMessagesForRetry(ctx context.Context, topic string, seque
 	return r.getCreatedEventBatch(ctx, batchId)
 }
 
-func (r Repository) DeleteSuccessful(ctx context.Context, olderThan time.Time) error {
-	_, err := r.db.ExecContext(ctx, `DELETE FROM kafka_consumer_retries WHERE successful = true AND updated_at <= $1;`, olderThan)
+// GetReleasedParkedMessages returns the oldest parked message for each key in the given topic,
+// as long as that key no longer has a pending retry ahead of it.
+func (r Repository) GetReleasedParkedMessages(ctx context.Context, topic string, claim store.Claim) ([]model.Retry, error) {
+	batchId, err := r.createReleasedParkedBatch(ctx, topic, claim)
+	if err != nil {
+		return nil, err
+	}
 
-	return err
+	return r.getCreatedEventBatch(ctx, batchId)
+}
+
+func (r Repository) DeleteSuccessful(ctx context.Context, olderThan time.Time) error {
+	return deleteSuccessful(ctx, r.db, olderThan, query.Postgres)
 }
 
 func (r Repository) MarkRetrySuccessful(ctx context.Context, retry model.Retry) error {
 	q := `UPDATE kafka_consumer_retries
-		SET attempts = $1, last_error = '', retry_finished_at = NOW(), errored = false, successful = true, updated_at = NOW()
+		SET lease_expires_at = NULL, attempts = $1, last_error = '', retry_finished_at = NOW(), errored = false, successful = true, parked = false, updated_at = NOW()
 		WHERE id = $2;`
 
-	_, err := r.db.ExecContext(ctx, q, retry.Attempts, retry.ID)
-	if err != nil {
-		return fmt.Errorf("data/retries: error marking a retry as successful: %w", err)
-	}
-
-	return nil
+	return inTransaction(ctx, r.db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, q, retry.Attempts, retry.ID); err != nil {
+			return fmt.Errorf("data/retries: error marking a retry as successful: %w", err)
+		}
+		return recordAttempt(ctx, tx, retry.ID, query.Postgres)
+	})
 }
 
 func (r Repository) MarkRetryErrored(ctx context.Context, retry model.Retry, retryErr error) error {
+	return r.markRetryErrored(ctx, retry, retryErr, false)
+}
+
+// MarkRetryErroredToPublish marks the retry as errored, leaving it waiting to be published if it has been
+// dead-lettered.
+func (r Repository) MarkRetryErroredToPublish(ctx context.Context, retry model.Retry, retryErr error) error {
+	return r.markRetryErrored(ctx, retry, retryErr, true)
+}
+
+func (r Repository) markRetryErrored(ctx context.Context, retry model.Retry, retryErr error, toPublish bool) error {
 	q := `UPDATE kafka_consumer_retries
-		SET batch_id = NULL, attempts = $1, last_error = $2, retry_finished_at = NOW(), errored = $3, deadlettered = $4, updated_at = NOW()
-		WHERE id = $5;`
+		SET batch_id = NULL, lease_expires_at = NULL, attempts = $1, last_error = $2, retry_finished_at = NOW(), errored = $3, deadlettered = $4, deadletter_published_at = $5, parked = false, updated_at = NOW()
+		WHERE id = $6;`
 
-	_, err := r.db.ExecContext(ctx, q, retry.Attempts, retryErr.Error(), retry.Errored, retry.Deadlettered, retry.ID)
-	if err != nil {
-		return fmt.Errorf("data/retries: error marking a retry as errored: %w", err)
-	}
+	return inTransaction(ctx, r.db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, q, retry.Attempts, retryErr.Error(), retry.Errored, retry.Deadlettered, deadLetterPublishedAt(query.Postgres, toPublish), retry.ID); err != nil {
+			return fmt.Errorf("data/retries: error marking a retry as errored: %w", err)
+		}
+		return recordAttempt(ctx, tx, retry.ID, query.Postgres)
+	})
+}
 
-	return nil
+// ReleaseRetries releases the claim on retries that were not processed, so that they can be claimed again.
+func (r Repository) ReleaseRetries(ctx context.Context, retries []model.Retry) error {
+	return releaseRetries(ctx, r.db, retries, query.Postgres)
+}
+
+func (r Repository) GetExpiredRetries(ctx context.Context, expiry store.Expiry, limit int) ([]store.ExpiredRetry, error) {
+	return postgresDialect.getExpiredRetries(ctx, r.db, expiry, limit)
+}
+
+func (r Repository) DeleteExpiredRetries(ctx context.Context, expiry store.Expiry, retries []store.ExpiredRetry) (int64, error) {
+	return postgresDialect.deleteExpiredRetries(ctx, r.db, expiry, retries)
+}
+
+// ArchiveRetries copies expired retries to the kafka_consumer_retries_archive table, ignoring any that are
+// already there.
+func (r Repository) ArchiveRetries(ctx context.Context, retries []store.ExpiredRetry) error {
+	return postgresDialect.archiveRetries(ctx, r.db, retries)
 }
 
-func (r Repository) createEventBatch(ctx context.Context, topic string, sequence uint8, interval time.Duration) (uuid.UUID, error) {
+// PublishDeadLetters publishes the dead letters of the topics that have not been published yet, claiming them in
+// a transaction of their own and marking them as published once they have been.
+func (r Repository) PublishDeadLetters(ctx context.Context, topics []string, claim store.DeadLetterClaim, publish func(store.DeadLetter) error) (int, error) {
+	return postgresDialect.publishDeadLetters(ctx, r.db, topics, claim, publish)
+}
+
+// GetAttempts returns the attempts at processing the retry from the kafka_consumer_retry_attempts table.
+func (r Repository) GetAttempts(ctx context.Context, retryID int64) ([]store.Attempt, error) {
+	return getAttempts(ctx, r.db, retryID, query.Postgres)
+}
+
+// RenewLease extends the lease on retries that the worker of the claim is still processing.
+func (r Repository) RenewLease(ctx context.Context, retries []model.Retry, claim store.Claim) error {
+	return renewLease(ctx, r.db, retries, claim, query.Postgres)
+}
+
+// createEventBatch claims up to batchSize retries that are due. Rows that another consumer is claiming at the
+// same time are locked, and are skipped rather than waited for, so several consumers can claim batches in parallel.
+func (r Repository) createEventBatch(ctx context.Context, topic string, sequence uint8, interval time.Duration, claim store.Claim) (uuid.UUID, error) {
 	batchId := uuid.New()
-	stale := time.Now().Add(consideredStaleAfter * -1)
-	before := time.Now().Add(interval * -1)
+	now := time.Now()
+	before := now.Add(interval * -1)
 
-	upSql := `UPDATE kafka_consumer_retries SET batch_id = $1, retry_started_at = NOW()
+	upSql := `UPDATE kafka_consumer_retries SET batch_id = $1, retry_started_at = NOW(), worker_id = $2, lease_expires_at = $3
 		WHERE id IN(
 			SELECT id FROM kafka_consumer_retries
-			WHERE topic = $2
+			WHERE topic = $4
 			AND (
-				batch_id IS NULL OR
-				(batch_id IS NOT NULL AND retry_finished_at IS NULL AND retry_started_at < $3)
+				batch_id IS NULL OR lease_expires_at < $5 OR
+				(lease_expires_at IS NULL AND retry_finished_at IS NULL AND retry_started_at < $6)
 			)
-			AND attempts = $4 AND deadlettered = false AND successful = false AND updated_at <= $5
-			LIMIT 250
+			AND attempts = $7 AND parked = false AND deadlettered = false AND successful = false AND updated_at <= $8
+			ORDER BY id
+			LIMIT $9
+			FOR UPDATE SKIP LOCKED
 		);`
 
-	_, err := r.db.ExecContext(ctx, upSql, batchId, topic, stale, sequence, before)
+	_, err := r.db.ExecContext(
+		ctx, upSql, batchId, claim.WorkerID, now.Add(claim.Lease), topic,
+		now, now.Add(consideredStaleAfter*-1), sequence, before, claim.BatchSize,
+	)
 	if err != nil {
 		return batchId, fmt.Errorf("data/retries: error updating retries records when creating a batch: %w", err)
 	}
@@ -106,6 +212,43 @@ func (r Repository) createEventBatch(ctx context.Context, topic string, sequence
 	return batchId, nil
 }
 
+func (r Repository) createReleasedParkedBatch(ctx context.Context, topic string, claim store.Claim) (uuid.UUID, error) {
+	batchId := uuid.New()
+	now := time.Now()
+
+	upSql := `UPDATE kafka_consumer_retries SET batch_id = $1, retry_started_at = NOW(), worker_id = $2, lease_expires_at = $3
+		WHERE id IN(
+			SELECT p.id FROM kafka_consumer_retries p
+			WHERE p.topic = $4 AND p.parked = true
+			AND (
+				p.batch_id IS NULL OR p.lease_expires_at < $5 OR
+				(p.lease_expires_at IS NULL AND p.retry_finished_at IS NULL AND p.retry_started_at < $6)
+			)
+			AND p.id = (
+				SELECT MIN(o.id) FROM kafka_consumer_retries o
+				WHERE o.topic = p.topic AND o.payload_key = p.payload_key AND o.parked = true
+			)
+			AND NOT EXISTS(
+				SELECT 1 FROM kafka_consumer_retries a
+				WHERE a.topic = p.topic AND a.payload_key = p.payload_key
+				AND a.parked = false AND a.successful = false AND a.deadlettered = false
+			)
+			ORDER BY p.id
+			LIMIT $7
+			FOR UPDATE OF p SKIP LOCKED
+		);`
+
+	_, err := r.db.ExecContext(
+		ctx, upSql, batchId, claim.WorkerID, now.Add(claim.Lease), topic,
+		now, now.Add(consideredStaleAfter*-1), claim.BatchSize,
+	)
+	if err != nil {
+		return batchId, fmt.Errorf("data/retries: error updating parked records when creating a batch: %w", err)
+	}
+
+	return batchId, nil
+}
+
 func (r Repository) getCreatedEventBatch(ctx context.Context, batchId uuid.UUID) ([]model.Retry, error) {
 	q := fmt.Sprintf(`SELECT %s FROM kafka_consumer_retries WHERE batch_id = $1`, r.columnsAsString())
 
@@ -116,17 +259,7 @@ func (r Repository) getCreatedEventBatch(ctx context.Context, batchId uuid.UUID)
 	}
 	defer rows.Close()
 
-	var retries []model.Retry
-	for rows.Next() {
-		retry := model.Retry{}
-		err := rows.Scan(&retry.ID, &retry.Topic, &retry.PayloadJSON, &retry.PayloadHeaders, &retry.PayloadKey, &retry.KafkaOffset, &retry.KafkaPartition, &retry.Attempts)
-		if err != nil {
-			return nil, fmt.Errorf("data/retries: error scanning result into memory: %w", err)
-		}
-		retries = append(retries, retry)
-	}
-
-	return retries, nil
+	return scanRetries(rows)
 }
 
 func (r Repository) columnsAsString() string {
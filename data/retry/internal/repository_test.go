@@ -7,10 +7,12 @@ import (
 	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/IBM/sarama"
 	"github.com/go-test/deep"
 
 	failuremodel "github.com/revdaalex/kafka-consumer-go/data/failure/model"
 	"github.com/revdaalex/kafka-consumer-go/data/retry/model"
+	"github.com/revdaalex/kafka-consumer-go/data/retry/store"
 )
 
 func TestNewRepository(t *testing.T) {
@@ -37,15 +39,21 @@ func TestRepository_PublishFailure(t *testing.T) {
 		NextTopic:      "retry1.payment.product",
 		Message:        []byte(`{"foo":"bar"}`),
 		MessageKey:     []byte(`SKU-123`),
-		MessageHeaders: []byte(`{"buzz":"bazz"}`),
+		MessageHeaders: []sarama.RecordHeader{{Key: []byte("buzz"), Value: []byte("bazz")}},
 		KafkaPartition: 100,
 		KafkaOffset:    200,
+		KafkaTimestamp: kafkaTimestampForTests,
 	}
 
 	t.Run("failure successfully published to DB", func(t *testing.T) {
-		mock.ExpectExec(`INSERT INTO kafka_consumer_retries.*`).
-			WithArgs("product", []byte(`{"foo":"bar"}`), []byte(`{"buzz":"bazz"}`), 200, 100, "SKU-123").
+		mock.ExpectBegin()
+		mock.ExpectQuery(`INSERT INTO kafka_consumer_retries.* RETURNING id`).
+			WithArgs("product", []byte(`{"foo":"bar"}`), `{"foo":"bar"}`, "application/json", `[{"key":"YnV6eg==","value":"YmF6eg=="}]`, 200, 100, kafkaTimestampForTests, "SKU-123", "something bad happened").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectExec(`INSERT INTO kafka_consumer_retry_attempts.* SELECT id, attempts - 1, .* FROM kafka_consumer_retries WHERE id = \$1`).
+			WithArgs(1).
 			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
 
 		if err := repo.PublishFailure(ctx, f); err != nil {
 			t.Fatalf("unexpected error: %s", err)
@@ -56,9 +64,33 @@ func TestRepository_PublishFailure(t *testing.T) {
 		}
 	})
 
+	t.Run("binary failure published without a JSON payload", func(t *testing.T) {
+		binary := f
+		binary.Message = []byte{0x00, 0x01, 0xff}
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(`INSERT INTO kafka_consumer_retries.*`).
+			WithArgs("product", []byte{0x00, 0x01, 0xff}, nil, "application/octet-stream", `[{"key":"YnV6eg==","value":"YmF6eg=="}]`, 200, 100, kafkaTimestampForTests, "SKU-123", "something bad happened").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+		mock.ExpectExec(`INSERT INTO kafka_consumer_retry_attempts`).
+			WithArgs(2).
+			WillReturnResult(sqlmock.NewResult(2, 1))
+		mock.ExpectCommit()
+
+		if err := repo.PublishFailure(ctx, binary); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
 	t.Run("error during insert", func(t *testing.T) {
-		mock.ExpectExec(`INSERT INTO kafka_consumer_retries.*`).
+		mock.ExpectBegin()
+		mock.ExpectQuery(`INSERT INTO kafka_consumer_retries.*`).
 			WillReturnError(errors.New("oops"))
+		mock.ExpectRollback()
 
 		if err := repo.PublishFailure(ctx, f); err == nil {
 			t.Error("expected an error but got nil")
@@ -70,6 +102,121 @@ func TestRepository_PublishFailure(t *testing.T) {
 	})
 }
 
+func TestRepository_PublishParkedFailure(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	repo := NewRepository(db)
+	ctx := context.Background()
+	f := failuremodel.Failure{
+		Topic:          "product",
+		Message:        []byte(`{"foo":"bar"}`),
+		MessageKey:     []byte(`SKU-123`),
+		KafkaPartition: 100,
+		KafkaOffset:    200,
+	}
+
+	t.Run("parked failure successfully published to DB", func(t *testing.T) {
+		mock.ExpectExec(`INSERT INTO kafka_consumer_retries.*parked.*VALUES\(.*0, true\)`).
+			WithArgs("product", []byte(`{"foo":"bar"}`), `{"foo":"bar"}`, "application/json", `[]`, 200, 100, nil, "SKU-123").
+			WillReturnResult(sqlmock.NewResult(1, 1))
+
+		if err := repo.PublishParkedFailure(ctx, f); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("error during insert", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`INSERT INTO kafka_consumer_retries.*`).
+			WillReturnError(errors.New("oops"))
+		mock.ExpectRollback()
+
+		if err := repo.PublishParkedFailure(ctx, f); err == nil {
+			t.Error("expected an error but got nil")
+		}
+	})
+}
+
+func TestRepository_HasPendingRetryForKey(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	repo := NewRepository(db)
+	ctx := context.Background()
+
+	t.Run("returns whether a pending retry exists", func(t *testing.T) {
+		mock.ExpectQuery(`SELECT EXISTS\(.*`).
+			WithArgs("product", "SKU-123").
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+
+		got, err := repo.HasPendingRetryForKey(ctx, "product", []byte("SKU-123"))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if !got {
+			t.Error("expected a pending retry to be found")
+		}
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("error from query is returned", func(t *testing.T) {
+		mock.ExpectQuery(`SELECT EXISTS\(.*`).
+			WillReturnError(errors.New("oops"))
+
+		if _, err := repo.HasPendingRetryForKey(ctx, "product", []byte("SKU-123")); err == nil {
+			t.Error("expected an error but got nil")
+		}
+	})
+}
+
+func TestRepository_GetReleasedParkedMessages(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	repo := NewRepository(db)
+	ctx := context.Background()
+
+	t.Run("successfully fetches released parked messages", func(t *testing.T) {
+		rows := sqlmock.NewRows(columns).
+			AddRow(1, "product", `{"foo":"bar"}`, `[{"key":"YnV6eg==","value":"YmFy"}]`, "foo", 100, 200, kafkaTimestampForTests, 1, "application/json").
+			AddRow(2, "product", `{"foo":"bazz"}`, "[]", "", 200, 300, nil, 10, "application/json")
+
+		mock.ExpectExec("UPDATE kafka_consumer_retries SET batch_id .* p.parked = true .* FOR UPDATE OF p SKIP LOCKED").
+			WithArgs(sqlmock.AnyArg(), "worker-1", sqlmock.AnyArg(), "product", sqlmock.AnyArg(), sqlmock.AnyArg(), claimForTests.BatchSize).
+			WillReturnResult(sqlmock.NewResult(0, 2))
+
+		mock.ExpectQuery("SELECT .* FROM kafka_consumer_retries WHERE .*").
+			WithArgs(sqlmock.AnyArg()).
+			WillReturnRows(rows)
+
+		got, err := repo.GetReleasedParkedMessages(ctx, "product", claimForTests)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if diff := deep.Equal(expectedRetriesForTests(), got); diff != nil {
+			t.Error(diff)
+		}
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("error when creating batch is returned", func(t *testing.T) {
+		expErr := errors.New("oops")
+		mock.ExpectExec("UPDATE kafka_consumer_retries .*").
+			WillReturnError(expErr)
+
+		if _, err := repo.GetReleasedParkedMessages(ctx, "product", claimForTests); !errors.Is(err, expErr) {
+			t.Errorf("expected error from update but got '%v'", err)
+		}
+	})
+}
+
 func TestRepository_GetMessagesForRetry(t *testing.T) {
 	db, mock, _ := sqlmock.New()
 	repo := NewRepository(db)
@@ -77,18 +224,18 @@ func TestRepository_GetMessagesForRetry(t *testing.T) {
 
 	t.Run("successfully fetches messages for retry", func(t *testing.T) {
 		rows := sqlmock.NewRows(columns).
-			AddRow(1, "product", `{"foo":"bar"}`, `{"buzz":"bar"}`, "foo", 100, 200, 1).
-			AddRow(2, "product", `{"foo":"bazz"}`, "{}", "", 200, 300, 10)
+			AddRow(1, "product", `{"foo":"bar"}`, `[{"key":"YnV6eg==","value":"YmFy"}]`, "foo", 100, 200, kafkaTimestampForTests, 1, "application/json").
+			AddRow(2, "product", `{"foo":"bazz"}`, "[]", "", 200, 300, nil, 10, "application/json")
 
-		mock.ExpectExec("UPDATE kafka_consumer_retries.*").
-			WithArgs(sqlmock.AnyArg(), "product", sqlmock.AnyArg(), 1, sqlmock.AnyArg()).
+		mock.ExpectExec("UPDATE kafka_consumer_retries SET batch_id = \\$1, retry_started_at = NOW\\(\\), worker_id = \\$2, lease_expires_at = \\$3 .* LIMIT \\$9 FOR UPDATE SKIP LOCKED").
+			WithArgs(sqlmock.AnyArg(), "worker-1", sqlmock.AnyArg(), "product", sqlmock.AnyArg(), sqlmock.AnyArg(), 1, sqlmock.AnyArg(), claimForTests.BatchSize).
 			WillReturnResult(sqlmock.NewResult(0, 250))
 
 		mock.ExpectQuery("SELECT .* FROM kafka_consumer_retries WHERE .*").
 			WithArgs(sqlmock.AnyArg()).
 			WillReturnRows(rows)
 
-		got, err := repo.GetMessagesForRetry(ctx, "product", 1, time.Second*10)
+		got, err := repo.GetMessagesForRetry(ctx, "product", 1, time.Second*10, claimForTests)
 		if err != nil {
 			t.Fatalf("unexpected error: %s", err)
 		}
@@ -107,7 +254,7 @@ func TestRepository_GetMessagesForRetry(t *testing.T) {
 		mock.ExpectExec("UPDATE kafka_consumer_retries .*").
 			WillReturnError(expErr)
 
-		_, err := repo.GetMessagesForRetry(ctx, "product", 1, time.Second*10)
+		_, err := repo.GetMessagesForRetry(ctx, "product", 1, time.Second*10, claimForTests)
 		if !errors.Is(err, expErr) {
 			t.Errorf("expected error from update but got '%v'", err)
 		}
@@ -122,7 +269,7 @@ func TestRepository_GetMessagesForRetry(t *testing.T) {
 		mock.ExpectQuery("SELECT .* FROM kafka_consumer_retries WHERE .*").
 			WillReturnError(expErr)
 
-		_, err := repo.GetMessagesForRetry(ctx, "product", 1, time.Second*10)
+		_, err := repo.GetMessagesForRetry(ctx, "product", 1, time.Second*10, claimForTests)
 		if !errors.Is(err, expErr) {
 			t.Errorf("expected error from select but got '%v'", err)
 		}
@@ -136,9 +283,14 @@ func TestRepository_DeleteSuccessful(t *testing.T) {
 	now := time.Now()
 
 	t.Run("deletes successfully processed retries", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectExec(`DELETE FROM kafka_consumer_retry_attempts WHERE retry_id IN\(SELECT id FROM kafka_consumer_retries WHERE successful = true AND updated_at <= \$1\)`).
+			WithArgs(now).
+			WillReturnResult(sqlmock.NewResult(0, 30))
 		mock.ExpectExec(`DELETE FROM kafka_consumer_retries WHERE.*`).
 			WithArgs(now).
 			WillReturnResult(sqlmock.NewResult(0, 10))
+		mock.ExpectCommit()
 
 		if err := repo.DeleteSuccessful(ctx, now); err != nil {
 			t.Fatalf("unexpected error: %s", err)
@@ -150,8 +302,12 @@ func TestRepository_DeleteSuccessful(t *testing.T) {
 	})
 
 	t.Run("returns error from query", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectExec(`DELETE FROM kafka_consumer_retry_attempts WHERE.*`).
+			WillReturnResult(sqlmock.NewResult(0, 0))
 		mock.ExpectExec(`DELETE FROM kafka_consumer_retries WHERE.*`).
 			WillReturnError(errors.New("oops"))
+		mock.ExpectRollback()
 
 		if err := repo.DeleteSuccessful(ctx, now); err == nil {
 			t.Error("expected an error but got nil")
@@ -169,9 +325,14 @@ func TestRepository_MarkRetryErrored(t *testing.T) {
 	ctx := context.Background()
 
 	t.Run("retry marked as errored successfully", func(t *testing.T) {
+		mock.ExpectBegin()
 		mock.ExpectExec("UPDATE kafka_consumer_retries SET .* WHERE .*").
-			WithArgs(2, "something bad", true, false, 10).
+			WithArgs(2, "something bad", true, false, sqlmock.AnyArg(), 10).
 			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("INSERT INTO kafka_consumer_retry_attempts").
+			WithArgs(10).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
 
 		retry := model.Retry{
 			ID:       10,
@@ -189,9 +350,14 @@ func TestRepository_MarkRetryErrored(t *testing.T) {
 	})
 
 	t.Run("retry marked as deadlettered successfully", func(t *testing.T) {
+		mock.ExpectBegin()
 		mock.ExpectExec("UPDATE kafka_consumer_retries SET .* WHERE .*").
-			WithArgs(2, "something bad", true, true, 10).
+			WithArgs(2, "something bad", true, true, sqlmock.AnyArg(), 10).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("INSERT INTO kafka_consumer_retry_attempts").
+			WithArgs(10).
 			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
 
 		retry := model.Retry{
 			ID:           10,
@@ -209,9 +375,37 @@ func TestRepository_MarkRetryErrored(t *testing.T) {
 		}
 	})
 
+	t.Run("retry marked as deadlettered to publish successfully", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectExec("UPDATE kafka_consumer_retries SET .* WHERE .*").
+			WithArgs(2, "something bad", true, true, nil, 10).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("INSERT INTO kafka_consumer_retry_attempts").
+			WithArgs(10).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		retry := model.Retry{
+			ID:           10,
+			Attempts:     2,
+			Errored:      true,
+			Deadlettered: true,
+		}
+
+		if err := repo.MarkRetryErroredToPublish(ctx, retry, errors.New("something bad")); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	})
+
 	t.Run("error from database update is returned", func(t *testing.T) {
+		mock.ExpectBegin()
 		mock.ExpectExec("UPDATE kafka_consumer_retries SET .* WHERE .*").
 			WillReturnError(errors.New("oops"))
+		mock.ExpectRollback()
 
 		retry := model.Retry{
 			ID:       11,
@@ -228,15 +422,159 @@ func TestRepository_MarkRetryErrored(t *testing.T) {
 	})
 }
 
+func TestRepository_PublishDeadLetters(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	repo := NewRepository(db)
+	ctx := context.Background()
+	claim := store.DeadLetterClaim{Limit: 100, Lease: time.Minute, Backoff: time.Minute}
+
+	t.Run("dead letters are claimed in a transaction of their own and marked once published", func(t *testing.T) {
+		rows := sqlmock.NewRows(append(columns, "last_error", "created_at", "updated_at")).
+			AddRow(1, "product", `{"foo":"bar"}`, "[]", "foo", 100, 200, nil, 3, "application/json", "oops", time.Now(), time.Now()).
+			AddRow(2, "product", `{"foo":"bazz"}`, "[]", "foo", 200, 300, nil, 3, "application/json", "oops", time.Now(), time.Now()).
+			AddRow(3, "product", `{"foo":"qux"}`, "[]", "bar", 300, 400, nil, 3, "application/json", "oops", time.Now(), time.Now())
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT .* FROM kafka_consumer_retries dl WHERE deadlettered = true .* deadletter_published_at IS NULL AND \\(deadletter_publish_after IS NULL OR deadletter_publish_after <= \\$1\\) AND topic IN\\(\\$2\\) AND NOT EXISTS\\(.* e.deadletter_publish_after > \\$3 \\) ORDER BY id LIMIT \\$4 FOR UPDATE SKIP LOCKED").
+			WithArgs(sqlmock.AnyArg(), "product", sqlmock.AnyArg(), 100).
+			WillReturnRows(rows)
+		mock.ExpectExec("UPDATE kafka_consumer_retries SET deadletter_publish_after = \\$1 WHERE id IN\\(\\$2, \\$3, \\$4\\)").
+			WithArgs(sqlmock.AnyArg(), int64(1), int64(2), int64(3)).
+			WillReturnResult(sqlmock.NewResult(0, 3))
+		mock.ExpectCommit()
+		mock.ExpectExec("UPDATE kafka_consumer_retries SET deadletter_published_at = \\$1, deadletter_publish_after = NULL WHERE id IN\\(\\$2\\)").
+			WithArgs(sqlmock.AnyArg(), int64(3)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("UPDATE kafka_consumer_retries SET deadletter_publish_after = \\$1 WHERE id IN\\(\\$2, \\$3\\)").
+			WithArgs(sqlmock.AnyArg(), int64(1), int64(2)).
+			WillReturnResult(sqlmock.NewResult(0, 2))
+
+		expErr := errors.New("kafka unavailable")
+		var attempted []int64
+		n, err := repo.PublishDeadLetters(ctx, []string{"product"}, claim, func(dl store.DeadLetter) error {
+			attempted = append(attempted, dl.ID)
+			if dl.ID == 1 {
+				return expErr
+			}
+			return nil
+		})
+		if !errors.Is(err, expErr) || n != 1 {
+			t.Errorf("expected 1 dead letter to be published along with the error, got %d (%v)", n, err)
+		}
+		// the dead letter with the same key as the one that failed is backed off with it, to keep them in order
+		if diff := deep.Equal(attempted, []int64{1, 3}); diff != nil {
+			t.Error(diff)
+		}
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("nothing is updated without dead letters", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT .* FROM kafka_consumer_retries").WillReturnRows(sqlmock.NewRows(append(columns, "last_error", "created_at", "updated_at")))
+		mock.ExpectCommit()
+
+		if n, err := repo.PublishDeadLetters(ctx, []string{"product"}, claim, func(store.DeadLetter) error { return nil }); err != nil || n != 0 {
+			t.Errorf("expected nothing to be published, got %d (%v)", n, err)
+		}
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("error marking the dead letters as published is returned", func(t *testing.T) {
+		rows := sqlmock.NewRows(append(columns, "last_error", "created_at", "updated_at")).
+			AddRow(1, "product", `{"foo":"bar"}`, "[]", "foo", 100, 200, nil, 3, "application/json", "oops", time.Now(), time.Now())
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT .* FROM kafka_consumer_retries").WillReturnRows(rows)
+		mock.ExpectExec("UPDATE kafka_consumer_retries SET deadletter_publish_after").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		mock.ExpectExec("UPDATE kafka_consumer_retries SET deadletter_published_at").WillReturnError(errors.New("oops"))
+
+		if n, err := repo.PublishDeadLetters(ctx, []string{"product"}, claim, func(store.DeadLetter) error { return nil }); err == nil || n != 0 {
+			t.Errorf("expected an error and no dead letters counted as published, got %d (%v)", n, err)
+		}
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestRepository_ReleaseRetries(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	repo := NewRepository(db)
+	ctx := context.Background()
+
+	t.Run("it releases the claim on the retries", func(t *testing.T) {
+		mock.ExpectExec(`UPDATE kafka_consumer_retries SET batch_id = NULL, worker_id = NULL, lease_expires_at = NULL WHERE id IN\(\$1, \$2\) AND successful = false`).
+			WithArgs(10, 11).
+			WillReturnResult(sqlmock.NewResult(0, 2))
+
+		if err := repo.ReleaseRetries(ctx, []model.Retry{{ID: 10}, {ID: 11}}); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	})
+
+	t.Run("it does nothing without retries", func(t *testing.T) {
+		if err := repo.ReleaseRetries(ctx, nil); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	})
+
+	t.Run("it returns an error from the database", func(t *testing.T) {
+		mock.ExpectExec("UPDATE kafka_consumer_retries SET batch_id = NULL").
+			WillReturnError(errors.New("oops"))
+
+		if err := repo.ReleaseRetries(ctx, []model.Retry{{ID: 10}}); err == nil {
+			t.Error("expected an error but got nil")
+		}
+	})
+}
+
+func TestRepository_RenewLease(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	repo := NewRepository(db)
+
+	mock.ExpectExec(`UPDATE kafka_consumer_retries SET lease_expires_at = \$1 WHERE worker_id = \$2 AND batch_id IS NOT NULL AND successful = false AND id IN\(\$3, \$4\)`).
+		WithArgs(sqlmock.AnyArg(), "worker-1", 10, 11).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	if err := repo.RenewLease(context.Background(), []model.Retry{{ID: 10}, {ID: 11}}, claimForTests); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
 func TestRepository_MarkRetrySuccessful(t *testing.T) {
 	db, mock, _ := sqlmock.New()
 	repo := NewRepository(db)
 	ctx := context.Background()
 
 	t.Run("retry marked as successful successfully", func(t *testing.T) {
+		mock.ExpectBegin()
 		mock.ExpectExec("UPDATE kafka_consumer_retries SET .* WHERE .*").
 			WithArgs(3, 11).
 			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("INSERT INTO kafka_consumer_retry_attempts").
+			WithArgs(11).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
 
 		retry := model.Retry{
 			ID:       11,
@@ -253,8 +591,10 @@ func TestRepository_MarkRetrySuccessful(t *testing.T) {
 	})
 
 	t.Run("error from database is returned", func(t *testing.T) {
+		mock.ExpectBegin()
 		mock.ExpectExec("UPDATE kafka_consumer_retries SET .* WHERE .*").
 			WillReturnError(errors.New("oops"))
+		mock.ExpectRollback()
 
 		retry := model.Retry{
 			ID:       12,
@@ -271,26 +611,42 @@ func TestRepository_MarkRetrySuccessful(t *testing.T) {
 	})
 }
 
+var (
+	_ store.AttemptHistoryStore = Repository{}
+	_ store.AttemptHistoryStore = SQLiteRepository{}
+	_ store.AttemptHistoryStore = MySQLRepository{}
+	_ store.DeadLetterOutbox    = Repository{}
+	_ store.DeadLetterOutbox    = SQLiteRepository{}
+	_ store.DeadLetterOutbox    = MySQLRepository{}
+)
+
+var claimForTests = store.Claim{BatchSize: 250, WorkerID: "worker-1", Lease: time.Second * 30}
+
+var kafkaTimestampForTests = time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+
 func expectedRetriesForTests() []model.Retry {
 	retry1 := model.Retry{
 		ID:             1,
 		Topic:          "product",
-		PayloadJSON:    []byte(`{"foo":"bar"}`),
-		PayloadHeaders: []byte(`{"buzz":"bar"}`),
+		Payload:        []byte(`{"foo":"bar"}`),
+		PayloadHeaders: []byte(`[{"key":"YnV6eg==","value":"YmFy"}]`),
 		PayloadKey:     []byte("foo"),
 		KafkaOffset:    100,
 		KafkaPartition: 200,
+		KafkaTimestamp: kafkaTimestampForTests,
 		Attempts:       1,
+		ContentType:    "application/json",
 	}
 	retry2 := model.Retry{
 		ID:             2,
 		Topic:          "product",
-		PayloadJSON:    []byte(`{"foo":"bazz"}`),
-		PayloadHeaders: []byte(`{}`),
+		Payload:        []byte(`{"foo":"bazz"}`),
+		PayloadHeaders: []byte(`[]`),
 		PayloadKey:     []byte(""),
 		KafkaOffset:    200,
 		KafkaPartition: 300,
 		Attempts:       10,
+		ContentType:    "application/json",
 	}
 
 	return []model.Retry{retry1, retry2}
@@ -0,0 +1,186 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/revdaalex/kafka-consumer-go/data/internal/query"
+	"github.com/revdaalex/kafka-consumer-go/data/retry/store"
+)
+
+// archiveColumns are the columns of the kafka_consumer_retries_archive table, in the order ArchiveRetries writes them.
+var archiveColumns = []string{"id", "topic", "payload", "payload_headers", "payload_key", "content_type", "kafka_offset", "kafka_partition", "kafka_timestamp", "attempts", "state", "last_error", "created_at", "updated_at", "archived_at"}
+
+// dialect adds how a database driver differs in the queries of the retention of retries, and of the
+// publishing of dead letters, to its placeholders and timestamps.
+type dialect struct {
+	query.Dialect
+	// insertArchive and onArchiveConflict surround the columns and values of the insert into the archive
+	// table, so that retries that are already archived are ignored
+	insertArchive     string
+	onArchiveConflict string
+	// lockRows is appended to the select of the dead letters to publish, to lock them until they are published,
+	// and lockDatabase is run before it instead by drivers that lock the whole database for writing
+	lockRows     string
+	lockDatabase string
+}
+
+// expiryCondition returns the condition that selects the retries of the expiry, and its arguments, using
+// placeholders from position 1.
+func (d dialect) expiryCondition(e store.Expiry) (string, []interface{}, error) {
+	var cond string
+	switch e.State {
+	case store.StateSuccessful:
+		cond = "successful = true"
+	case store.StateDeadlettered:
+		// dead letters that are waiting to be published to Kafka are kept until they have been
+		cond = "deadlettered = true AND successful = false AND deadletter_published_at IS NOT NULL"
+	case store.StateErrored:
+		cond = "errored = true AND deadlettered = false AND successful = false AND parked = false AND batch_id IS NULL"
+	default:
+		return "", nil, fmt.Errorf("data/retries: unknown retry state '%s'", e.State)
+	}
+
+	cond += " AND updated_at <= " + d.Placeholder(1)
+	args := []interface{}{d.TimeArg(e.OlderThan)}
+
+	if e.Topic != "" {
+		cond += " AND topic = " + d.Placeholder(2)
+		args = append(args, e.Topic)
+	} else if len(e.ExceptTopics) > 0 {
+		cond += fmt.Sprintf(" AND topic NOT IN(%s)", d.Placeholders(2, len(e.ExceptTopics)))
+		for _, t := range e.ExceptTopics {
+			args = append(args, t)
+		}
+	}
+
+	return cond, args, nil
+}
+
+func (d dialect) getExpiredRetries(ctx context.Context, db *sql.DB, e store.Expiry, limit int) ([]store.ExpiredRetry, error) {
+	cond, args, err := d.expiryCondition(e)
+	if err != nil {
+		return nil, err
+	}
+
+	q := fmt.Sprintf(
+		`SELECT %s, last_error, created_at, updated_at FROM kafka_consumer_retries WHERE %s ORDER BY id LIMIT %s;`,
+		Repository{}.columnsAsString(), cond, d.Placeholder(len(args)+1),
+	)
+
+	// #nosec G201
+	rows, err := db.QueryContext(ctx, q, append(args, limit)...)
+	if err != nil {
+		return nil, fmt.Errorf("data/retries: error getting expired retries: %w", err)
+	}
+	defer rows.Close()
+
+	var retries []store.ExpiredRetry
+	for rows.Next() {
+		r := store.ExpiredRetry{State: e.State}
+		var ts, createdAt, updatedAt query.NullTime
+		err := rows.Scan(&r.ID, &r.Topic, &r.Payload, &r.PayloadHeaders, &r.PayloadKey, &r.KafkaOffset, &r.KafkaPartition, &ts, &r.Attempts, &r.ContentType, &r.LastError, &createdAt, &updatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("data/retries: error scanning result into memory: %w", err)
+		}
+		r.KafkaTimestamp, r.CreatedAt, r.UpdatedAt = ts.Time, createdAt.Time, updatedAt.Time
+		r.Deadlettered = e.State == store.StateDeadlettered
+		r.Errored = e.State != store.StateSuccessful
+		retries = append(retries, r)
+	}
+
+	return retries, rows.Err()
+}
+
+func (d dialect) deleteExpiredRetries(ctx context.Context, db *sql.DB, e store.Expiry, retries []store.ExpiredRetry) (int64, error) {
+	if len(retries) == 0 {
+		return 0, nil
+	}
+
+	cond, args, err := d.expiryCondition(e)
+	if err != nil {
+		return 0, err
+	}
+
+	q := fmt.Sprintf(
+		`DELETE FROM kafka_consumer_retries WHERE %s AND id IN(%s);`,
+		cond, d.Placeholders(len(args)+1, len(retries)),
+	)
+	ids := make([]interface{}, len(retries))
+	for i, r := range retries {
+		ids[i] = r.ID
+	}
+
+	var deleted int64
+	err = inTransaction(ctx, db, func(tx *sql.Tx) error {
+		// #nosec G201
+		res, err := tx.ExecContext(ctx, q, append(args, ids...)...)
+		if err != nil {
+			return fmt.Errorf("data/retries: error deleting expired retries: %w", err)
+		}
+		if deleted, err = res.RowsAffected(); err != nil {
+			return err
+		}
+		return deleteOrphanedAttempts(ctx, tx, ids, d.Dialect)
+	})
+	return deleted, err
+}
+
+func (d dialect) archiveRetries(ctx context.Context, db *sql.DB, retries []store.ExpiredRetry) error {
+	if len(retries) == 0 {
+		return nil
+	}
+
+	archivedAt := d.TimeArg(time.Now())
+	values := make([]string, len(retries))
+	var args []interface{}
+	for i, r := range retries {
+		values[i] = "(" + d.Placeholders(len(args)+1, len(archiveColumns)) + ")"
+		args = append(args,
+			r.ID, r.Topic, r.Payload, string(r.PayloadHeaders), string(r.PayloadKey), r.ContentType, r.KafkaOffset, r.KafkaPartition,
+			d.nullableTime(r.KafkaTimestamp), r.Attempts, string(r.State), r.LastError, d.nullableTime(r.CreatedAt), d.nullableTime(r.UpdatedAt), archivedAt,
+		)
+	}
+
+	q := fmt.Sprintf(
+		`%s kafka_consumer_retries_archive(%s) VALUES %s%s;`,
+		d.insertArchive, strings.Join(archiveColumns, ", "), strings.Join(values, ", "), d.onArchiveConflict,
+	)
+
+	// #nosec G201
+	if _, err := db.ExecContext(ctx, q, args...); err != nil {
+		return fmt.Errorf("data/retries: error archiving expired retries: %w", err)
+	}
+	return nil
+}
+
+func (d dialect) nullableTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return d.TimeArg(t)
+}
+
+var postgresDialect = dialect{
+	Dialect:           query.Postgres,
+	insertArchive:     "INSERT INTO",
+	onArchiveConflict: " ON CONFLICT (id) DO NOTHING",
+	lockRows:          " FOR UPDATE SKIP LOCKED",
+}
+
+var sqliteDialect = dialect{
+	Dialect:       query.SQLite,
+	insertArchive: "INSERT OR IGNORE INTO",
+	// a write that changes nothing takes the write lock, which is otherwise only taken by the update that
+	// claims the dead letters
+	lockDatabase: "UPDATE kafka_consumer_retries SET deadletter_published_at = NULL WHERE 1 = 0;",
+}
+
+var mysqlDialect = dialect{
+	Dialect:       query.MySQL,
+	insertArchive: "INSERT IGNORE INTO",
+	lockRows:      " FOR UPDATE SKIP LOCKED",
+}
@@ -0,0 +1,221 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	failuremodel "github.com/revdaalex/kafka-consumer-go/data/failure/model"
+	"github.com/revdaalex/kafka-consumer-go/data/internal/query"
+	"github.com/revdaalex/kafka-consumer-go/data/retry/model"
+	"github.com/revdaalex/kafka-consumer-go/data/retry/store"
+)
+
+// SQLiteRepository keeps retries in a SQLite database, claiming batches in the same way as Repository.
+// The database should be opened with data.NewSQLiteDB, so that batch claims cannot interleave.
+type SQLiteRepository struct {
+	db *sql.DB
+}
+
+func NewSQLiteRepository(db *sql.DB) SQLiteRepository {
+	return SQLiteRepository{
+		db: db,
+	}
+}
+
+func (r SQLiteRepository) PublishFailure(ctx context.Context, f failuremodel.Failure) error {
+	headers, err := model.EncodeHeaders(f.MessageHeaders)
+	if err != nil {
+		return err
+	}
+
+	now := query.SQLiteTime(time.Now())
+	q := `INSERT INTO kafka_consumer_retries(topic, payload, payload_json, content_type, payload_headers, kafka_offset, kafka_partition, kafka_timestamp, payload_key, last_error, created_at, updated_at) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`
+	return inTransaction(ctx, r.db, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, q, f.Topic, f.Message, query.JSONPayload(f.Message), f.ContentType(), string(headers), f.KafkaOffset, f.KafkaPartition, sqliteKafkaTimestamp(f), string(f.MessageKey), f.Reason, now, now)
+		if err != nil {
+			return fmt.Errorf("data/retries: error publishing failure to the database: %w", err)
+		}
+		return recordPublishedAttempt(ctx, tx, res, query.SQLite)
+	})
+}
+
+func (r SQLiteRepository) PublishParkedFailure(ctx context.Context, f failuremodel.Failure) error {
+	headers, err := model.EncodeHeaders(f.MessageHeaders)
+	if err != nil {
+		return err
+	}
+
+	now := query.SQLiteTime(time.Now())
+	q := `INSERT INTO kafka_consumer_retries(topic, payload, payload_json, content_type, payload_headers, kafka_offset, kafka_partition, kafka_timestamp, payload_key, attempts, parked, created_at, updated_at) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, 0, true, ?, ?);`
+	_, err = r.db.ExecContext(ctx, q, f.Topic, f.Message, query.JSONPayload(f.Message), f.ContentType(), string(headers), f.KafkaOffset, f.KafkaPartition, sqliteKafkaTimestamp(f), string(f.MessageKey), now, now)
+	if err != nil {
+		return fmt.Errorf("data/retries: error publishing parked failure to the database: %w", err)
+	}
+	return nil
+}
+
+func (r SQLiteRepository) HasPendingRetryForKey(ctx context.Context, topic string, key []byte) (bool, error) {
+	q := `SELECT EXISTS(
+			SELECT 1 FROM kafka_consumer_retries
+			WHERE topic = ? AND payload_key = ? AND successful = false AND deadlettered = false
+		);`
+
+	var exists bool
+	if err := r.db.QueryRowContext(ctx, q, topic, string(key)).Scan(&exists); err != nil {
+		return false, fmt.Errorf("data/retries: error checking for pending retries for key: %w", err)
+	}
+
+	return exists, nil
+}
+
+func (r SQLiteRepository) GetMessagesForRetry(ctx context.Context, topic string, sequence uint8, interval time.Duration, claim store.Claim) ([]model.Retry, error) {
+	batchId := uuid.New()
+	now := time.Now()
+
+	upSql := `UPDATE kafka_consumer_retries SET batch_id = ?, retry_started_at = ?, worker_id = ?, lease_expires_at = ?
+		WHERE id IN(
+			SELECT id FROM kafka_consumer_retries
+			WHERE topic = ?
+			AND (
+				batch_id IS NULL OR lease_expires_at < ? OR
+				(lease_expires_at IS NULL AND retry_finished_at IS NULL AND retry_started_at < ?)
+			)
+			AND attempts = ? AND parked = false AND deadlettered = false AND successful = false AND updated_at <= ?
+			ORDER BY id
+			LIMIT ?
+		);`
+
+	_, err := r.db.ExecContext(
+		ctx, upSql, batchId.String(), query.SQLiteTime(now), claim.WorkerID, query.SQLiteTime(now.Add(claim.Lease)), topic,
+		query.SQLiteTime(now), query.SQLiteTime(now.Add(consideredStaleAfter*-1)), sequence, query.SQLiteTime(now.Add(interval*-1)), claim.BatchSize,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("data/retries: error updating retries records when creating a batch: %w", err)
+	}
+
+	return selectBatch(ctx, r.db, batchId)
+}
+
+func (r SQLiteRepository) GetReleasedParkedMessages(ctx context.Context, topic string, claim store.Claim) ([]model.Retry, error) {
+	batchId := uuid.New()
+	now := time.Now()
+
+	upSql := `UPDATE kafka_consumer_retries SET batch_id = ?, retry_started_at = ?, worker_id = ?, lease_expires_at = ?
+		WHERE id IN(
+			SELECT p.id FROM kafka_consumer_retries p
+			WHERE p.topic = ? AND p.parked = true
+			AND (
+				p.batch_id IS NULL OR p.lease_expires_at < ? OR
+				(p.lease_expires_at IS NULL AND p.retry_finished_at IS NULL AND p.retry_started_at < ?)
+			)
+			AND p.id = (
+				SELECT MIN(o.id) FROM kafka_consumer_retries o
+				WHERE o.topic = p.topic AND o.payload_key = p.payload_key AND o.parked = true
+			)
+			AND NOT EXISTS(
+				SELECT 1 FROM kafka_consumer_retries a
+				WHERE a.topic = p.topic AND a.payload_key = p.payload_key
+				AND a.parked = false AND a.successful = false AND a.deadlettered = false
+			)
+			ORDER BY p.id
+			LIMIT ?
+		);`
+
+	_, err := r.db.ExecContext(
+		ctx, upSql, batchId.String(), query.SQLiteTime(now), claim.WorkerID, query.SQLiteTime(now.Add(claim.Lease)), topic,
+		query.SQLiteTime(now), query.SQLiteTime(now.Add(consideredStaleAfter*-1)), claim.BatchSize,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("data/retries: error updating parked records when creating a batch: %w", err)
+	}
+
+	return selectBatch(ctx, r.db, batchId)
+}
+
+// PublishDeadLetters publishes the dead letters of the topics that have not been published yet, claiming them in
+// a transaction of their own and marking them as published once they have been.
+func (r SQLiteRepository) PublishDeadLetters(ctx context.Context, topics []string, claim store.DeadLetterClaim, publish func(store.DeadLetter) error) (int, error) {
+	return sqliteDialect.publishDeadLetters(ctx, r.db, topics, claim, publish)
+}
+
+// GetAttempts returns the attempts at processing the retry from the kafka_consumer_retry_attempts table.
+func (r SQLiteRepository) GetAttempts(ctx context.Context, retryID int64) ([]store.Attempt, error) {
+	return getAttempts(ctx, r.db, retryID, query.SQLite)
+}
+
+func (r SQLiteRepository) RenewLease(ctx context.Context, retries []model.Retry, claim store.Claim) error {
+	return renewLease(ctx, r.db, retries, claim, query.SQLite)
+}
+
+func (r SQLiteRepository) ReleaseRetries(ctx context.Context, retries []model.Retry) error {
+	return releaseRetries(ctx, r.db, retries, query.SQLite)
+}
+
+func (r SQLiteRepository) GetExpiredRetries(ctx context.Context, expiry store.Expiry, limit int) ([]store.ExpiredRetry, error) {
+	return sqliteDialect.getExpiredRetries(ctx, r.db, expiry, limit)
+}
+
+func (r SQLiteRepository) DeleteExpiredRetries(ctx context.Context, expiry store.Expiry, retries []store.ExpiredRetry) (int64, error) {
+	return sqliteDialect.deleteExpiredRetries(ctx, r.db, expiry, retries)
+}
+
+// ArchiveRetries copies expired retries to the kafka_consumer_retries_archive table, ignoring any that are
+// already there.
+func (r SQLiteRepository) ArchiveRetries(ctx context.Context, retries []store.ExpiredRetry) error {
+	return sqliteDialect.archiveRetries(ctx, r.db, retries)
+}
+
+func (r SQLiteRepository) DeleteSuccessful(ctx context.Context, olderThan time.Time) error {
+	return deleteSuccessful(ctx, r.db, olderThan, query.SQLite)
+}
+
+func (r SQLiteRepository) MarkRetrySuccessful(ctx context.Context, retry model.Retry) error {
+	now := query.SQLiteTime(time.Now())
+	q := `UPDATE kafka_consumer_retries
+		SET lease_expires_at = NULL, attempts = ?, last_error = '', retry_finished_at = ?, errored = false, successful = true, parked = false, updated_at = ?
+		WHERE id = ?;`
+
+	return inTransaction(ctx, r.db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, q, retry.Attempts, now, now, retry.ID); err != nil {
+			return fmt.Errorf("data/retries: error marking a retry as successful: %w", err)
+		}
+		return recordAttempt(ctx, tx, retry.ID, query.SQLite)
+	})
+}
+
+func (r SQLiteRepository) MarkRetryErrored(ctx context.Context, retry model.Retry, retryErr error) error {
+	return r.markRetryErrored(ctx, retry, retryErr, false)
+}
+
+// MarkRetryErroredToPublish marks the retry as errored, leaving it waiting to be published if it has been
+// dead-lettered.
+func (r SQLiteRepository) MarkRetryErroredToPublish(ctx context.Context, retry model.Retry, retryErr error) error {
+	return r.markRetryErrored(ctx, retry, retryErr, true)
+}
+
+func (r SQLiteRepository) markRetryErrored(ctx context.Context, retry model.Retry, retryErr error, toPublish bool) error {
+	now := query.SQLiteTime(time.Now())
+	q := `UPDATE kafka_consumer_retries
+		SET batch_id = NULL, lease_expires_at = NULL, attempts = ?, last_error = ?, retry_finished_at = ?, errored = ?, deadlettered = ?, deadletter_published_at = ?, parked = false, updated_at = ?
+		WHERE id = ?;`
+
+	return inTransaction(ctx, r.db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, q, retry.Attempts, retryErr.Error(), now, retry.Errored, retry.Deadlettered, deadLetterPublishedAt(query.SQLite, toPublish), now, retry.ID); err != nil {
+			return fmt.Errorf("data/retries: error marking a retry as errored: %w", err)
+		}
+		return recordAttempt(ctx, tx, retry.ID, query.SQLite)
+	})
+}
+
+// sqliteKafkaTimestamp returns the timestamp of the failed message in query.SQLiteTimeFormat, or nil if it is
+// not known.
+func sqliteKafkaTimestamp(f failuremodel.Failure) interface{} {
+	if f.KafkaTimestamp.IsZero() {
+		return nil
+	}
+	return query.SQLiteTime(f.KafkaTimestamp)
+}
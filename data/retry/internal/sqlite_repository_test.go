@@ -0,0 +1,528 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/go-test/deep"
+
+	"github.com/revdaalex/kafka-consumer-go/data"
+	failuremodel "github.com/revdaalex/kafka-consumer-go/data/failure/model"
+	"github.com/revdaalex/kafka-consumer-go/data/internal/query"
+	"github.com/revdaalex/kafka-consumer-go/data/retry/store"
+)
+
+func TestSQLiteRepository_GetMessagesForRetry(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("it claims a batch of retries once", func(t *testing.T) {
+		repo := newSQLiteRepositoryForTests(t)
+		publishForSQLiteTests(t, repo, "product", "SKU-1")
+		publishForSQLiteTests(t, repo, "product", "SKU-2")
+		publishForSQLiteTests(t, repo, "other", "SKU-3")
+
+		batch, err := repo.GetMessagesForRetry(ctx, "product", 1, 0, claimForTests)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if len(batch) != 2 || string(batch[0].PayloadKey) != "SKU-1" || string(batch[1].PayloadKey) != "SKU-2" {
+			t.Fatalf("expected the 2 retries for the topic, got %+v", batch)
+		}
+
+		if string(batch[0].Payload) != `{"sku":"SKU-1"}` || batch[0].Attempts != 1 || batch[0].KafkaOffset != 10 {
+			t.Errorf("unexpected retry returned: %+v", batch[0])
+		}
+
+		if again, _ := repo.GetMessagesForRetry(ctx, "product", 1, 0, claimForTests); len(again) != 0 {
+			t.Errorf("expected claimed retries not to be returned again, got %d", len(again))
+		}
+	})
+
+	t.Run("it does not claim retries before their interval has passed", func(t *testing.T) {
+		repo := newSQLiteRepositoryForTests(t)
+		publishForSQLiteTests(t, repo, "product", "SKU-1")
+
+		if batch, _ := repo.GetMessagesForRetry(ctx, "product", 1, time.Minute, claimForTests); len(batch) != 0 {
+			t.Errorf("expected no retries to be claimed, got %d", len(batch))
+		}
+	})
+
+	t.Run("it reclaims batches once their lease expires", func(t *testing.T) {
+		repo := newSQLiteRepositoryForTests(t)
+		publishForSQLiteTests(t, repo, "product", "SKU-1")
+
+		expired := store.Claim{BatchSize: 1, WorkerID: "worker-2", Lease: -time.Second}
+		if batch, _ := repo.GetMessagesForRetry(ctx, "product", 1, 0, expired); len(batch) != 1 {
+			t.Fatalf("expected 1 retry to be claimed, got %d", len(batch))
+		}
+
+		if batch, _ := repo.GetMessagesForRetry(ctx, "product", 1, 0, claimForTests); len(batch) != 1 {
+			t.Fatalf("expected the retry to be claimed again once its lease expired, got %d", len(batch))
+		}
+
+		var workerID string
+		if err := repo.db.QueryRow(`SELECT worker_id FROM kafka_consumer_retries`).Scan(&workerID); err != nil || workerID != "worker-1" {
+			t.Errorf("expected the retry to be claimed by worker-1, got '%s' (%v)", workerID, err)
+		}
+	})
+
+	t.Run("it reclaims stale batches that were claimed without a lease", func(t *testing.T) {
+		repo := newSQLiteRepositoryForTests(t)
+		publishForSQLiteTests(t, repo, "product", "SKU-1")
+
+		stale := query.SQLiteTime(time.Now().Add(-consideredStaleAfter - time.Minute))
+		if _, err := repo.db.Exec(`UPDATE kafka_consumer_retries SET batch_id = 'legacy', retry_started_at = ?`, stale); err != nil {
+			t.Fatal(err)
+		}
+
+		if batch, _ := repo.GetMessagesForRetry(ctx, "product", 1, 0, claimForTests); len(batch) != 1 {
+			t.Errorf("expected the stale retry to be claimed again, got %d", len(batch))
+		}
+	})
+}
+
+func TestSQLiteRepository_RenewLease(t *testing.T) {
+	ctx := context.Background()
+	repo := newSQLiteRepositoryForTests(t)
+	publishForSQLiteTests(t, repo, "product", "SKU-1")
+
+	expiring := store.Claim{BatchSize: 1, WorkerID: "worker-1", Lease: -time.Second}
+	batch, _ := repo.GetMessagesForRetry(ctx, "product", 1, 0, expiring)
+
+	other := store.Claim{WorkerID: "worker-2", Lease: time.Minute}
+	if err := repo.RenewLease(ctx, batch, other); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := repo.RenewLease(ctx, batch, claimForTests); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if again, _ := repo.GetMessagesForRetry(ctx, "product", 1, 0, other); len(again) != 0 {
+		t.Errorf("expected the retry not to be claimed while its lease is renewed, got %d", len(again))
+	}
+}
+
+func TestSQLiteRepository_MarkRetryErrored(t *testing.T) {
+	ctx := context.Background()
+	repo := newSQLiteRepositoryForTests(t)
+	publishForSQLiteTests(t, repo, "product", "SKU-1")
+
+	batch, _ := repo.GetMessagesForRetry(ctx, "product", 1, 0, claimForTests)
+	retry := batch[0]
+	retry.Attempts = 2
+	retry.Errored = true
+
+	if err := repo.MarkRetryErrored(ctx, retry, errors.New("oops")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if batch, _ := repo.GetMessagesForRetry(ctx, "product", 2, 0, claimForTests); len(batch) != 1 || batch[0].Attempts != 2 {
+		t.Errorf("expected the retry to be claimed on its next attempt, got %+v", batch)
+	}
+
+	retry.Deadlettered = true
+	if err := repo.MarkRetryErrored(ctx, retry, errors.New("oops")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if batch, _ := repo.GetMessagesForRetry(ctx, "product", 2, 0, claimForTests); len(batch) != 0 {
+		t.Errorf("expected a dead-lettered retry not to be claimed, got %d", len(batch))
+	}
+}
+
+func TestSQLiteRepository_ReleaseRetries(t *testing.T) {
+	ctx := context.Background()
+	repo := newSQLiteRepositoryForTests(t)
+	publishForSQLiteTests(t, repo, "product", "SKU-1")
+	publishForSQLiteTests(t, repo, "product", "SKU-2")
+
+	batch, _ := repo.GetMessagesForRetry(ctx, "product", 1, 0, claimForTests)
+	if err := repo.MarkRetrySuccessful(ctx, batch[0]); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if err := repo.ReleaseRetries(ctx, batch); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	again, _ := repo.GetMessagesForRetry(ctx, "product", 1, 0, claimForTests)
+	if len(again) != 1 || string(again[0].PayloadKey) != "SKU-2" || again[0].Attempts != 1 {
+		t.Errorf("expected only the unprocessed retry to be claimed again, got %+v", again)
+	}
+}
+
+func TestSQLiteRepository_DeleteSuccessful(t *testing.T) {
+	ctx := context.Background()
+	repo := newSQLiteRepositoryForTests(t)
+	publishForSQLiteTests(t, repo, "product", "SKU-1")
+
+	batch, _ := repo.GetMessagesForRetry(ctx, "product", 1, 0, claimForTests)
+	if err := repo.MarkRetrySuccessful(ctx, batch[0]); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if err := repo.DeleteSuccessful(ctx, time.Now().Add(-time.Hour)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if countRetriesForSQLiteTests(t, repo) != 1 {
+		t.Error("expected a recent successful retry to be kept")
+	}
+
+	if err := repo.DeleteSuccessful(ctx, time.Now().Add(time.Second)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if countRetriesForSQLiteTests(t, repo) != 0 {
+		t.Error("expected the successful retry to be deleted")
+	}
+}
+
+func TestSQLiteRepository_ExpiredRetries(t *testing.T) {
+	ctx := context.Background()
+	repo := newSQLiteRepositoryForTests(t)
+	publishForSQLiteTests(t, repo, "product", "SKU-1")
+	publishForSQLiteTests(t, repo, "product", "SKU-2")
+	publishForSQLiteTests(t, repo, "order", "ORD-1")
+	publishForSQLiteTests(t, repo, "product", "SKU-3")
+
+	batch, _ := repo.GetMessagesForRetry(ctx, "product", 1, 0, claimForTests)
+	if err := repo.MarkRetrySuccessful(ctx, batch[0]); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	retry := batch[1]
+	retry.Errored, retry.Deadlettered = true, true
+	if err := repo.MarkRetryErrored(ctx, retry, errors.New("oops")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// a dead letter that has not been published to Kafka yet does not expire
+	unpublished := batch[2]
+	unpublished.Errored, unpublished.Deadlettered = true, true
+	if err := repo.MarkRetryErroredToPublish(ctx, unpublished, errors.New("oops")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := repo.ReleaseRetries(ctx, batch); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	later := time.Now().Add(time.Second)
+	expiry := store.Expiry{State: store.StateDeadlettered, OlderThan: later, ExceptTopics: []string{"order"}}
+	if got, _ := repo.GetExpiredRetries(ctx, store.Expiry{State: store.StateDeadlettered, OlderThan: time.Now().Add(-time.Hour)}, 10); len(got) != 0 {
+		t.Errorf("expected a recent retry not to have expired, got %d", len(got))
+	}
+
+	expired, err := repo.GetExpiredRetries(ctx, expiry, 10)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(expired) != 1 || string(expired[0].PayloadKey) != "SKU-2" || expired[0].LastError != "oops" || expired[0].CreatedAt.IsZero() {
+		t.Fatalf("expected the dead-lettered retry to have expired, got %+v", expired)
+	}
+
+	if err := repo.ArchiveRetries(ctx, expired); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// archiving the same retries again is ignored
+	if err := repo.ArchiveRetries(ctx, expired); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var state, lastError string
+	if err := repo.db.QueryRow(`SELECT state, last_error FROM kafka_consumer_retries_archive WHERE id = ?;`, expired[0].ID).Scan(&state, &lastError); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if state != "deadlettered" || lastError != "oops" {
+		t.Errorf("expected the retry to be archived as dead-lettered, got state %s and error %s", state, lastError)
+	}
+
+	deleted, err := repo.DeleteExpiredRetries(ctx, expiry, expired)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if deleted != 1 || countRetriesForSQLiteTests(t, repo) != 3 {
+		t.Errorf("expected only the dead-lettered retry to be deleted, deleted %d", deleted)
+	}
+}
+
+func TestSQLiteRepository_ParkedFailures(t *testing.T) {
+	ctx := context.Background()
+	repo := newSQLiteRepositoryForTests(t)
+	publishForSQLiteTests(t, repo, "product", "SKU-1")
+
+	f := failuremodel.Failure{Topic: "product", Message: []byte(`{}`), MessageKey: []byte("SKU-1")}
+	if err := repo.PublishParkedFailure(ctx, f); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if pending, _ := repo.HasPendingRetryForKey(ctx, "product", []byte("SKU-1")); !pending {
+		t.Error("expected key 'SKU-1' to have a pending retry")
+	}
+
+	if pending, _ := repo.HasPendingRetryForKey(ctx, "product", []byte("SKU-2")); pending {
+		t.Error("expected key 'SKU-2' to have no pending retry")
+	}
+
+	if released, _ := repo.GetReleasedParkedMessages(ctx, "product", claimForTests); len(released) != 0 {
+		t.Fatalf("expected the parked message to be held back, got %d", len(released))
+	}
+
+	batch, _ := repo.GetMessagesForRetry(ctx, "product", 1, 0, claimForTests)
+	if err := repo.MarkRetrySuccessful(ctx, batch[0]); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	released, err := repo.GetReleasedParkedMessages(ctx, "product", claimForTests)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(released) != 1 || released[0].Attempts != 0 {
+		t.Errorf("expected the parked message to be released, got %+v", released)
+	}
+}
+
+func TestSQLiteRepository_Attempts(t *testing.T) {
+	ctx := context.Background()
+	repo := newSQLiteRepositoryForTests(t)
+	err := repo.PublishFailure(ctx, failuremodel.Failure{Topic: "product", Message: []byte(`{}`), MessageKey: []byte("SKU-1"), Reason: "consume failed"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	batch, _ := repo.GetMessagesForRetry(ctx, "product", 1, 0, claimForTests)
+	retry := batch[0]
+	retry.Attempts, retry.Errored = 2, true
+	if err := repo.MarkRetryErrored(ctx, retry, errors.New("retry 1 failed")); err != nil {
+		t.Fatal(err)
+	}
+
+	batch, _ = repo.GetMessagesForRetry(ctx, "product", 2, 0, claimForTests)
+	retry = batch[0]
+	retry.Attempts, retry.Errored = 3, false
+	if err := repo.MarkRetrySuccessful(ctx, retry); err != nil {
+		t.Fatal(err)
+	}
+
+	attempts, err := repo.GetAttempts(ctx, retry.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(attempts) != 3 {
+		t.Fatalf("expected the failure and both retries to be recorded, got %+v", attempts)
+	}
+	if a := attempts[0]; a.Attempt != 0 || a.Error != "consume failed" || !a.StartedAt.IsZero() || a.FinishedAt.IsZero() || a.WorkerID != "" {
+		t.Errorf("expected the first attempt to hold the reason the message failed, got %+v", a)
+	}
+	if a := attempts[1]; a.Attempt != 1 || a.Error != "retry 1 failed" || a.Successful || a.StartedAt.IsZero() || a.WorkerID != claimForTests.WorkerID {
+		t.Errorf("expected the failed retry to be recorded, got %+v", a)
+	}
+	if a := attempts[2]; a.Attempt != 2 || a.Error != "" || !a.Successful || a.FinishedAt.Before(a.StartedAt) {
+		t.Errorf("expected the successful retry to be recorded, got %+v", a)
+	}
+
+	expiry := store.Expiry{State: store.StateSuccessful, OlderThan: time.Now().Add(time.Second)}
+	if _, err := repo.DeleteExpiredRetries(ctx, expiry, []store.ExpiredRetry{{Retry: retry}}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if attempts, _ := repo.GetAttempts(ctx, retry.ID); len(attempts) != 0 {
+		t.Errorf("expected the attempts to be deleted along with the retry, got %+v", attempts)
+	}
+}
+
+func TestSQLiteRepository_PublishDeadLetters(t *testing.T) {
+	ctx := context.Background()
+	repo := newSQLiteRepositoryForTests(t)
+	publishForSQLiteTests(t, repo, "product", "SKU-1")
+	publishForSQLiteTests(t, repo, "product", "SKU-2")
+	publishForSQLiteTests(t, repo, "product", "SKU-3")
+	publishForSQLiteTests(t, repo, "other", "SKU-4")
+	publishForSQLiteTests(t, repo, "product", "SKU-5")
+
+	batch, _ := repo.GetMessagesForRetry(ctx, "product", 1, 0, claimForTests)
+	other, _ := repo.GetMessagesForRetry(ctx, "other", 1, 0, claimForTests)
+	for _, retry := range append(batch, other...) {
+		retry.Attempts, retry.Errored, retry.Deadlettered = 2, true, string(retry.PayloadKey) != "SKU-3"
+		markErrored := repo.MarkRetryErroredToPublish
+		if string(retry.PayloadKey) == "SKU-5" {
+			// dead-lettered while publishing was disabled
+			markErrored = repo.MarkRetryErrored
+		}
+		if err := markErrored(ctx, retry, errors.New("retry failed")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	publishForSQLiteTests(t, repo, "product", "SKU-2")
+	later, _ := repo.GetMessagesForRetry(ctx, "product", 1, 0, claimForTests)
+	for _, retry := range later {
+		retry.Attempts, retry.Errored, retry.Deadlettered = 2, true, true
+		if err := repo.MarkRetryErroredToPublish(ctx, retry, errors.New("retry failed")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	claim := store.DeadLetterClaim{Limit: 10, Lease: time.Minute, Backoff: time.Hour}
+	var published []store.DeadLetter
+	var attempted []string
+	sendErr := errors.New("kafka unavailable")
+	n, err := repo.PublishDeadLetters(ctx, []string{"product"}, claim, func(dl store.DeadLetter) error {
+		attempted = append(attempted, string(dl.PayloadKey))
+		if string(dl.PayloadKey) == "SKU-2" {
+			return sendErr
+		}
+		// no transaction is held while publishing, and the claimed dead letters are not claimed again
+		if n, err := repo.PublishDeadLetters(ctx, []string{"product"}, claim, func(store.DeadLetter) error { return nil }); err != nil || n != 0 {
+			t.Errorf("expected the claimed dead letters not to be claimed again, got %d (%v)", n, err)
+		}
+		published = append(published, dl)
+		return nil
+	})
+	if !errors.Is(err, sendErr) || n != 1 {
+		t.Fatalf("expected 1 dead letter to be published along with the error, got %d (%v)", n, err)
+	}
+	dl := published[0]
+	if string(dl.PayloadKey) != "SKU-1" || dl.Attempts != 2 || !dl.Deadlettered || dl.LastError != "retry failed" || dl.DeadletteredAt.IsZero() {
+		t.Errorf("unexpected dead letter published: %+v", dl)
+	}
+	// the later dead letter for SKU-2 waits behind the one that failed
+	if diff := deep.Equal(attempted, []string{"SKU-1", "SKU-2"}); diff != nil {
+		t.Error(diff)
+	}
+
+	// a dead letter with the key that was dead-lettered since is held back too
+	publishForSQLiteTests(t, repo, "product", "SKU-2")
+	since, _ := repo.GetMessagesForRetry(ctx, "product", 1, 0, claimForTests)
+	since[0].Attempts, since[0].Errored, since[0].Deadlettered = 2, true, true
+	if err := repo.MarkRetryErroredToPublish(ctx, since[0], errors.New("retry failed")); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, _ := repo.PublishDeadLetters(ctx, []string{"product"}, claim, func(store.DeadLetter) error { return nil }); n != 0 {
+		t.Errorf("expected the dead letters behind the one that failed to be backed off, got %d published", n)
+	}
+
+	if _, err := repo.db.Exec(`UPDATE kafka_consumer_retries SET deadletter_publish_after = NULL;`); err != nil {
+		t.Fatal(err)
+	}
+	attempted = nil
+	if n, err := repo.PublishDeadLetters(ctx, []string{"product"}, claim, func(dl store.DeadLetter) error {
+		attempted = append(attempted, string(dl.PayloadKey))
+		return nil
+	}); err != nil || n != 3 {
+		t.Fatalf("expected the dead letters that failed to be published once backed off, got %d (%v)", n, err)
+	}
+	if diff := deep.Equal(attempted, []string{"SKU-2", "SKU-2", "SKU-2"}); diff != nil {
+		t.Error(diff)
+	}
+
+	if n, _ := repo.PublishDeadLetters(ctx, []string{"product"}, claim, func(store.DeadLetter) error { return nil }); n != 0 {
+		t.Errorf("expected every dead letter to have been published once, and none marked as published, got %d more", n)
+	}
+}
+
+func newSQLiteRepositoryForTests(t *testing.T) SQLiteRepository {
+	t.Helper()
+
+	db, err := data.NewSQLiteDB(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	if err := data.MigrateSQLiteDatabase(db); err != nil {
+		t.Fatal(err)
+	}
+
+	return NewSQLiteRepository(db)
+}
+
+func publishForSQLiteTests(t *testing.T, repo SQLiteRepository, topic, key string) {
+	t.Helper()
+
+	err := repo.PublishFailure(context.Background(), failuremodel.Failure{
+		Topic:          topic,
+		Message:        []byte(`{"sku":"` + key + `"}`),
+		MessageKey:     []byte(key),
+		KafkaOffset:    10,
+		KafkaPartition: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func countRetriesForSQLiteTests(t *testing.T, repo SQLiteRepository) int {
+	t.Helper()
+
+	var c int
+	if err := repo.db.QueryRow(`SELECT COUNT(*) FROM kafka_consumer_retries`).Scan(&c); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestSQLiteRepository_BinaryPayload(t *testing.T) {
+	ctx := context.Background()
+	repo := newSQLiteRepositoryForTests(t)
+
+	err := repo.PublishFailure(ctx, failuremodel.Failure{Topic: "product", Message: []byte{0x00, 0x01, 0xff}, MessageKey: []byte("SKU-1")})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	batch, _ := repo.GetMessagesForRetry(ctx, "product", 1, 0, claimForTests)
+	if len(batch) != 1 || string(batch[0].Payload) != "\x00\x01\xff" || batch[0].ContentType != failuremodel.ContentTypeBinary {
+		t.Fatalf("expected the binary payload to be returned, got %+v", batch)
+	}
+
+	var jsonPayloads int
+	if err := repo.db.QueryRow(`SELECT COUNT(payload_json) FROM kafka_consumer_retries`).Scan(&jsonPayloads); err != nil {
+		t.Fatal(err)
+	}
+	if jsonPayloads != 0 {
+		t.Error("did not expect a JSON payload to be stored for a binary message")
+	}
+}
+
+func TestSQLiteRepository_HeadersAndTimestamp(t *testing.T) {
+	ctx := context.Background()
+	repo := newSQLiteRepositoryForTests(t)
+
+	headers := []*sarama.RecordHeader{
+		{Key: []byte("trace"), Value: []byte("a")},
+		{Key: []byte("trace"), Value: []byte("b")},
+		{Key: []byte("sig"), Value: []byte{0x00, 0xff}},
+	}
+	msg := &sarama.ConsumerMessage{
+		Topic:     "product",
+		Key:       []byte("SKU-1"),
+		Value:     []byte(`{}`),
+		Headers:   headers,
+		Timestamp: time.Date(2026, 10, 18, 12, 30, 0, 123456000, time.UTC),
+	}
+
+	if err := repo.PublishFailure(ctx, failuremodel.FailureFromSaramaMessage(errors.New("oops"), "", msg)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := repo.PublishFailure(ctx, failuremodel.Failure{Topic: "product", Message: []byte(`{}`), MessageKey: []byte("SKU-2")}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	batch, _ := repo.GetMessagesForRetry(ctx, "product", 1, 0, claimForTests)
+	if len(batch) != 2 {
+		t.Fatalf("expected 2 retries, got %d", len(batch))
+	}
+
+	got := batch[0].ToSaramaConsumerMessage()
+	if diff := deep.Equal(headers, got.Headers); diff != nil {
+		t.Error(diff)
+	}
+	if !got.Timestamp.Equal(msg.Timestamp) {
+		t.Errorf("expected timestamp %s, got %s", msg.Timestamp, got.Timestamp)
+	}
+
+	if got := batch[1].ToSaramaConsumerMessage(); len(got.Headers) != 0 || !got.Timestamp.IsZero() {
+		t.Errorf("expected no headers or timestamp, got %+v", got)
+	}
+}
@@ -0,0 +1,70 @@
+package retry
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v4/stdlib"
+)
+
+// notificationChannel is the channel that the kafka_consumer_retries triggers notify on,
+// see the retries_notify migration.
+const notificationChannel = "kafka_consumer_retries"
+
+// Notification is sent by Postgres when a retry is published or marked as successful, errored
+// or dead-lettered.
+type Notification struct {
+	Topic string `json:"topic"`
+	// Attempts made so far, which is the sequence of the retry that will process it next
+	Attempts uint8 `json:"attempts"`
+	// Pending is true until the retry has succeeded or been dead-lettered
+	Pending bool `json:"pending"`
+}
+
+// PostgresListener listens for notifications about retries kept in a Postgres database.
+type PostgresListener struct {
+	db *sql.DB
+}
+
+func NewPostgresListener(db *sql.DB) PostgresListener {
+	return PostgresListener{
+		db: db,
+	}
+}
+
+// Listen calls notify with each notification received until ctx is done or the connection fails, which
+// is returned as an error. The connection is taken from the pool of the database for as long as it listens.
+func (l PostgresListener) Listen(ctx context.Context, notify func(Notification)) error {
+	conn, err := l.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("data/retries: error connecting to listen for notifications: %w", err)
+	}
+	defer conn.Close()
+
+	return conn.Raw(func(driverConn interface{}) error {
+		sc, ok := driverConn.(*stdlib.Conn)
+		if !ok {
+			return errors.New("data/retries: notifications can only be received from a Postgres database")
+		}
+
+		pc := sc.Conn()
+		if _, err := pc.Exec(ctx, "LISTEN "+notificationChannel); err != nil {
+			return fmt.Errorf("data/retries: error listening for notifications: %w", err)
+		}
+
+		for {
+			n, err := pc.WaitForNotification(ctx)
+			if err != nil {
+				return fmt.Errorf("data/retries: error waiting for notifications: %w", err)
+			}
+
+			var notification Notification
+			if err := json.Unmarshal([]byte(n.Payload), &notification); err == nil {
+				notify(notification)
+			}
+		}
+	})
+}
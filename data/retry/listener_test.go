@@ -0,0 +1,19 @@
+package retry
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestPostgresListener_Listen(t *testing.T) {
+	t.Run("returns error for a database that is not Postgres", func(t *testing.T) {
+		db, _, _ := sqlmock.New()
+		l := NewPostgresListener(db)
+
+		if err := l.Listen(context.Background(), func(Notification) {}); err == nil {
+			t.Error("expected an error but got nil")
+		}
+	})
+}
@@ -0,0 +1,264 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/revdaalex/kafka-consumer-go/config"
+	"github.com/revdaalex/kafka-consumer-go/data/retry/store"
+)
+
+// maintenanceBatchSize is the most expired retries that maintenance archives and deletes at once.
+var maintenanceBatchSize = 500
+
+// partitionsAhead is how many intervals ahead of now maintenance creates partitions for.
+const partitionsAhead = 3
+
+// expiringStates are the states that retries are removed from by maintenance, in the order they are removed.
+var expiringStates = []store.RetryState{store.StateSuccessful, store.StateDeadlettered, store.StateErrored}
+
+// SetRetention sets how long retries are kept for by maintenance, which by default only deletes successful
+// retries after an hour. Retries are archived to the store before they are deleted if the retention archives to
+// a table and the store is a store.Archiver, and to JSON lines files if it archives to a directory.
+func (m *Manager) SetRetention(r config.DBRetryRetention) {
+	m.retention = r
+	m.archivers = nil
+	if a, ok := m.repo.(store.Archiver); ok && r.ArchiveTable {
+		m.archivers = append(m.archivers, a)
+	}
+	if r.ArchiveDir != "" {
+		m.archivers = append(m.archivers, NewFileArchiver(r.ArchiveDir))
+	}
+}
+
+// SetPartitions sets how the retries are partitioned, for a store.PartitionedStore. Maintenance then creates
+// partitions ahead of time and drops those that have expired.
+func (m *Manager) SetPartitions(p config.DBRetryPartitions) {
+	m.partitions = p
+}
+
+// CreatePartitions creates the partitions that retries will be stored in, up to a few intervals ahead of now.
+// It does nothing unless the retries are partitioned.
+func (m Manager) CreatePartitions(ctx context.Context) error {
+	ps, ok := m.repo.(store.PartitionedStore)
+	if !ok || m.partitions.Interval == 0 {
+		return nil
+	}
+	return ps.CreatePartitions(ctx, m.partitions.Interval, m.partitions.Interval*partitionsAhead)
+}
+
+// SetMaintenanceReportHandler sets a handler that is called with the report of each run of the maintenance.
+func (m *Manager) SetMaintenanceReportHandler(h config.MaintenanceReportHandler) {
+	m.reportHandler = h
+}
+
+// RunMaintenance archives and deletes the retries that have been kept for their retention period. Stores that
+// are not a store.RetentionStore only have their successful retries deleted, and nothing is counted in the report.
+// If the retries are partitioned, the upcoming partitions are created and expired partitions are dropped first.
+func (m Manager) RunMaintenance(ctx context.Context) error {
+	report := config.MaintenanceReport{StartedAt: time.Now()}
+	report.Removed, report.Err = m.removeExpiredRetries(ctx, report.StartedAt)
+	report.Duration = time.Since(report.StartedAt)
+
+	if m.reportHandler != nil {
+		m.reportHandler(report)
+	}
+	return report.Err
+}
+
+func (m Manager) removeExpiredRetries(ctx context.Context, now time.Time) ([]config.MaintenanceCount, error) {
+	counts := &maintenanceCounts{}
+	if ps, ok := m.repo.(store.PartitionedStore); ok && m.partitions.Interval > 0 {
+		if err := m.maintainPartitions(ctx, ps, counts); err != nil {
+			return counts.list, err
+		}
+	}
+
+	rs, ok := m.repo.(store.RetentionStore)
+	if !ok {
+		p := m.retention.Periods[store.StateSuccessful]
+		if p <= 0 {
+			return counts.list, nil
+		}
+		return counts.list, m.repo.DeleteSuccessful(ctx, now.In(time.UTC).Add(-p))
+	}
+
+	for _, e := range m.expiries(now) {
+		if err := m.removeExpiry(ctx, rs, e, counts); err != nil {
+			return counts.list, err
+		}
+	}
+	return counts.list, nil
+}
+
+// expiries returns the expiries of the retention, with one for each topic that has its own period for a state
+// and one for the rest of the topics.
+func (m Manager) expiries(now time.Time) []store.Expiry {
+	topics := make([]string, 0, len(m.retention.Topics))
+	for t := range m.retention.Topics {
+		topics = append(topics, t)
+	}
+	sort.Strings(topics)
+
+	var expiries []store.Expiry
+	for _, state := range expiringStates {
+		var except []string
+		for _, t := range topics {
+			p, ok := m.retention.Topics[t][state]
+			if !ok {
+				continue
+			}
+			except = append(except, t)
+			if p > 0 {
+				expiries = append(expiries, store.Expiry{State: state, OlderThan: now.Add(-p), Topic: t})
+			}
+		}
+
+		if p := m.retention.Periods[state]; p > 0 {
+			expiries = append(expiries, store.Expiry{State: state, OlderThan: now.Add(-p), ExceptTopics: except})
+		}
+	}
+	return expiries
+}
+
+// removeExpiry archives and deletes the retries of the expiry in batches, until none are left.
+func (m Manager) removeExpiry(ctx context.Context, rs store.RetentionStore, e store.Expiry, counts *maintenanceCounts) error {
+	for {
+		retries, err := rs.GetExpiredRetries(ctx, e, maintenanceBatchSize)
+		if err != nil || len(retries) == 0 {
+			return err
+		}
+
+		for _, a := range m.archivers {
+			if err := a.ArchiveRetries(ctx, retries); err != nil {
+				return err
+			}
+		}
+
+		// retries are deleted by topic so that the report can count them by topic
+		var deleted int64
+		for _, group := range groupByTopic(retries) {
+			n, err := rs.DeleteExpiredRetries(ctx, e, group)
+			if err != nil {
+				return err
+			}
+			archived := 0
+			if len(m.archivers) > 0 {
+				archived = len(group)
+			}
+			counts.add(group[0].Topic, e.State, n, int64(archived))
+			deleted += n
+		}
+
+		// retries that were not deleted have changed since they were selected, and would be selected again
+		if len(retries) < maintenanceBatchSize || deleted == 0 {
+			return nil
+		}
+	}
+}
+
+// maintainPartitions creates the upcoming partitions, and archives and drops those that have expired. Partitions
+// with retries that were requeued while they were archived are kept.
+func (m Manager) maintainPartitions(ctx context.Context, ps store.PartitionedStore, counts *maintenanceCounts) error {
+	if err := m.CreatePartitions(ctx); err != nil {
+		return err
+	}
+	if m.partitions.Retention == 0 {
+		return nil
+	}
+
+	expired, err := ps.GetExpiredPartitions(ctx, m.partitions.Retention)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range expired {
+		archived, err := m.archivePartition(ctx, ps, p)
+		if err != nil {
+			return err
+		}
+
+		dropped, err := ps.DropPartition(ctx, p)
+		if errors.Is(err, store.ErrPartitionPending) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for _, c := range dropped {
+			counts.add(c.Topic, c.State, c.Retries, archived[retryCountKey{c.Topic, c.State}])
+		}
+	}
+	return nil
+}
+
+type retryCountKey struct {
+	topic string
+	state store.RetryState
+}
+
+// archivePartition archives the retries of a partition in batches, and returns how many were archived.
+func (m Manager) archivePartition(ctx context.Context, ps store.PartitionedStore, p store.Partition) (map[retryCountKey]int64, error) {
+	archived := map[retryCountKey]int64{}
+	if len(m.archivers) == 0 {
+		return archived, nil
+	}
+
+	var afterID int64
+	for {
+		retries, err := ps.GetPartitionRetries(ctx, p, afterID, maintenanceBatchSize)
+		if err != nil || len(retries) == 0 {
+			return archived, err
+		}
+
+		for _, a := range m.archivers {
+			if err := a.ArchiveRetries(ctx, retries); err != nil {
+				return archived, err
+			}
+		}
+		for _, r := range retries {
+			archived[retryCountKey{r.Topic, r.State}]++
+		}
+
+		if len(retries) < maintenanceBatchSize {
+			return archived, nil
+		}
+		afterID = retries[len(retries)-1].ID
+	}
+}
+
+func groupByTopic(retries []store.ExpiredRetry) [][]store.ExpiredRetry {
+	var groups [][]store.ExpiredRetry
+	index := map[string]int{}
+	for _, r := range retries {
+		i, ok := index[r.Topic]
+		if !ok {
+			i = len(groups)
+			index[r.Topic] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], r)
+	}
+	return groups
+}
+
+// maintenanceCounts tallies the retries removed for each topic and state, in the order they were first removed.
+type maintenanceCounts struct {
+	list []config.MaintenanceCount
+}
+
+func (c *maintenanceCounts) add(topic string, state store.RetryState, deleted, archived int64) {
+	if deleted == 0 && archived == 0 {
+		return
+	}
+	for i := range c.list {
+		if c.list[i].Topic == topic && c.list[i].State == state {
+			c.list[i].Deleted += deleted
+			c.list[i].Archived += archived
+			return
+		}
+	}
+	c.list = append(c.list, config.MaintenanceCount{Topic: topic, State: state, Deleted: deleted, Archived: archived})
+}
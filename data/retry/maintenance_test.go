@@ -0,0 +1,248 @@
+package retry
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+
+	"github.com/revdaalex/kafka-consumer-go/config"
+	failuremodel "github.com/revdaalex/kafka-consumer-go/data/failure/model"
+	"github.com/revdaalex/kafka-consumer-go/data/retry/model"
+	"github.com/revdaalex/kafka-consumer-go/data/retry/store"
+	"github.com/revdaalex/kafka-consumer-go/data/retry/store/memory"
+)
+
+func TestManager_RunMaintenanceWithRetention(t *testing.T) {
+	ctx := context.Background()
+	defer func(size int) { maintenanceBatchSize = size }(maintenanceBatchSize)
+	maintenanceBatchSize = 1
+
+	s := memory.NewStore()
+	for _, f := range []failuremodel.Failure{
+		{Topic: "product", Message: []byte(`{}`), MessageKey: []byte("SKU-1")},
+		{Topic: "product", Message: []byte(`{}`), MessageKey: []byte("SKU-2")},
+		{Topic: "order", Message: []byte(`{}`), MessageKey: []byte("ORD-1")},
+	} {
+		if err := s.PublishFailure(ctx, f); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m := NewManager(config.DBRetries{}, s)
+	for _, topic := range []string{"product", "order"} {
+		batch, _ := m.GetBatch(ctx, topic, 1, 0)
+		for _, r := range batch {
+			_ = m.repo.MarkRetrySuccessful(ctx, r)
+		}
+	}
+
+	dir := t.TempDir()
+	var report config.MaintenanceReport
+	m.SetRetention(config.DBRetryRetention{
+		Periods: map[store.RetryState]time.Duration{store.StateSuccessful: time.Nanosecond},
+		Topics:  map[string]map[store.RetryState]time.Duration{"order": {store.StateSuccessful: time.Hour}},
+		// the memory store does not archive to a table, so only the directory is used
+		ArchiveTable: true,
+		ArchiveDir:   dir,
+	})
+	m.SetMaintenanceReportHandler(func(r config.MaintenanceReport) { report = r })
+
+	time.Sleep(time.Millisecond)
+	if err := m.RunMaintenance(ctx); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := []config.MaintenanceCount{{Topic: "product", State: store.StateSuccessful, Deleted: 2, Archived: 2}}
+	if diff := deep.Equal(report.Removed, expected); diff != nil {
+		t.Error(diff)
+	}
+	if report.StartedAt.IsZero() || report.Err != nil {
+		t.Errorf("unexpected report %+v", report)
+	}
+
+	if expired, _ := s.GetExpiredRetries(ctx, store.Expiry{State: store.StateSuccessful, OlderThan: time.Now()}, 10); len(expired) != 1 || expired[0].Topic != "order" {
+		t.Errorf("expected the successful retry of the topic with its own retention to be kept, got %+v", expired)
+	}
+
+	lines := readArchiveForTests(t, dir)
+	if len(lines) != 2 || lines[0].Topic != "product" || lines[0].State != "successful" || string(lines[1].PayloadKey) != "SKU-2" {
+		t.Errorf("expected the deleted retries to be archived, got %+v", lines)
+	}
+}
+
+func TestManager_RunMaintenanceReportsErrors(t *testing.T) {
+	ctx := context.Background()
+	s := memory.NewStore()
+	if err := s.PublishFailure(ctx, failuremodel.Failure{Topic: "product", Message: []byte(`{}`)}); err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewManager(config.DBRetries{}, s)
+	batch, _ := m.GetBatch(ctx, "product", 1, 0)
+	_ = m.repo.MarkRetrySuccessful(ctx, batch[0])
+	m.SetRetention(config.DBRetryRetention{Periods: map[store.RetryState]time.Duration{store.StateSuccessful: time.Nanosecond}})
+	m.archivers = []store.Archiver{erroringArchiver{}}
+
+	var report config.MaintenanceReport
+	m.SetMaintenanceReportHandler(func(r config.MaintenanceReport) { report = r })
+
+	time.Sleep(time.Millisecond)
+	if err := m.RunMaintenance(ctx); err == nil {
+		t.Fatal("expected an error but got nil")
+	}
+	if report.Err == nil || len(report.Removed) != 0 {
+		t.Errorf("expected the report to hold the error and nothing removed, got %+v", report)
+	}
+	if expired, _ := s.GetExpiredRetries(ctx, store.Expiry{State: store.StateSuccessful, OlderThan: time.Now()}, 10); len(expired) != 1 {
+		t.Error("expected a retry that could not be archived to be kept")
+	}
+}
+
+func TestManager_RunMaintenanceWithPartitions(t *testing.T) {
+	ctx := context.Background()
+	expired := store.Partition{Name: "kafka_consumer_retries_p20261017_0000"}
+	s := &partitionedStoreForTests{
+		Store:   memory.NewStore(),
+		expired: []store.Partition{expired, {Name: "requeued"}},
+		retries: []store.ExpiredRetry{
+			{Retry: model.Retry{ID: 1, Topic: "product"}, State: store.StateSuccessful},
+			{Retry: model.Retry{ID: 2, Topic: "product"}, State: store.StateDeadlettered},
+		},
+		dropped: []store.RetryCount{
+			{Topic: "product", State: store.StateSuccessful, Retries: 1},
+			{Topic: "product", State: store.StateDeadlettered, Retries: 1},
+		},
+	}
+	archiver := &recordingArchiver{}
+
+	m := NewManager(config.DBRetries{}, s)
+	m.SetRetention(config.DBRetryRetention{})
+	m.SetPartitions(config.DBRetryPartitions{Interval: 24 * time.Hour, Retention: 48 * time.Hour})
+	m.archivers = []store.Archiver{archiver}
+
+	var report config.MaintenanceReport
+	m.SetMaintenanceReportHandler(func(r config.MaintenanceReport) { report = r })
+
+	if err := m.RunMaintenance(ctx); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if s.createdAhead != 72*time.Hour || s.retention != 48*time.Hour {
+		t.Errorf("expected partitions to be created 3 days ahead and expire after 2 days, got %s and %s", s.createdAhead, s.retention)
+	}
+	if len(archiver.archived) != 4 {
+		t.Errorf("expected the retries of both partitions to be archived, got %d", len(archiver.archived))
+	}
+	if diff := deep.Equal(s.droppedNames, []string{expired.Name}); diff != nil {
+		t.Error(diff)
+	}
+
+	expected := []config.MaintenanceCount{
+		{Topic: "product", State: store.StateSuccessful, Deleted: 1, Archived: 1},
+		{Topic: "product", State: store.StateDeadlettered, Deleted: 1, Archived: 1},
+	}
+	if diff := deep.Equal(report.Removed, expected); diff != nil {
+		t.Error(diff)
+	}
+}
+
+// partitionedStoreForTests drops every expired partition except "requeued", which has pending retries by the
+// time it is dropped.
+type partitionedStoreForTests struct {
+	*memory.Store
+	expired      []store.Partition
+	retries      []store.ExpiredRetry
+	dropped      []store.RetryCount
+	createdAhead time.Duration
+	retention    time.Duration
+	droppedNames []string
+}
+
+func (s *partitionedStoreForTests) CreatePartitions(ctx context.Context, interval, ahead time.Duration) error {
+	s.createdAhead = ahead
+	return nil
+}
+
+func (s *partitionedStoreForTests) GetExpiredPartitions(ctx context.Context, retention time.Duration) ([]store.Partition, error) {
+	s.retention = retention
+	return s.expired, nil
+}
+
+func (s *partitionedStoreForTests) GetPartitionRetries(ctx context.Context, p store.Partition, afterID int64, limit int) ([]store.ExpiredRetry, error) {
+	if afterID > 0 {
+		return nil, nil
+	}
+	return s.retries, nil
+}
+
+func (s *partitionedStoreForTests) DropPartition(ctx context.Context, p store.Partition) ([]store.RetryCount, error) {
+	if p.Name == "requeued" {
+		return nil, store.ErrPartitionPending
+	}
+	s.droppedNames = append(s.droppedNames, p.Name)
+	return s.dropped, nil
+}
+
+type recordingArchiver struct {
+	archived []store.ExpiredRetry
+}
+
+func (a *recordingArchiver) ArchiveRetries(ctx context.Context, retries []store.ExpiredRetry) error {
+	a.archived = append(a.archived, retries...)
+	return nil
+}
+
+type erroringArchiver struct{}
+
+func (erroringArchiver) ArchiveRetries(ctx context.Context, retries []store.ExpiredRetry) error {
+	return errors.New("disk full")
+}
+
+func TestFileArchiver_ArchiveRetriesSkipsArchivedRetries(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	a := NewFileArchiver(dir)
+
+	first := []store.ExpiredRetry{{Retry: model.Retry{ID: 1, Topic: "product"}, State: store.StateSuccessful}}
+	if err := a.ArchiveRetries(ctx, first); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// maintenance stopped before deleting the first retry, so it is archived again with the next one
+	second := append(first, store.ExpiredRetry{Retry: model.Retry{ID: 2, Topic: "product"}, State: store.StateSuccessful})
+	if err := a.ArchiveRetries(ctx, second); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	lines := readArchiveForTests(t, dir)
+	if len(lines) != 2 || lines[0].ID != 1 || lines[1].ID != 2 {
+		t.Errorf("expected each retry to be archived once, got %+v", lines)
+	}
+}
+
+func readArchiveForTests(t *testing.T, dir string) []archivedRetry {
+	t.Helper()
+
+	f, err := os.Open(filepath.Join(dir, "kafka_consumer_retries-"+time.Now().UTC().Format("2006-01-02")+".jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var lines []archivedRetry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var line archivedRetry
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
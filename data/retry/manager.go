@@ -3,40 +3,119 @@ package retry
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+	"os"
 	"time"
 
 	"github.com/revdaalex/kafka-consumer-go/config"
+	"github.com/revdaalex/kafka-consumer-go/data"
 	failuremodel "github.com/revdaalex/kafka-consumer-go/data/failure/model"
 	"github.com/revdaalex/kafka-consumer-go/data/retry/internal"
 	"github.com/revdaalex/kafka-consumer-go/data/retry/model"
+	"github.com/revdaalex/kafka-consumer-go/data/retry/store"
 )
 
-var (
-	deleteSuccessfulRetriesAfter = time.Hour * 1
-)
+// leaseDuration is how long claimed retries are held for without the lease being renewed, after which
+// the worker holding them is assumed to have stopped and they can be claimed by another
+var leaseDuration = time.Second * 30
+
+// ErrKeyBlockingNotSupported is returned when parking a message in a store that is not a store.KeyBlockingStore.
+var ErrKeyBlockingNotSupported = errors.New("retry store does not support blocking retries per key")
+
+// ErrAttemptHistoryNotSupported is returned when getting the attempts of a retry from a store that is not a
+// store.AttemptHistoryStore.
+var ErrAttemptHistoryNotSupported = errors.New("retry store does not keep a history of retry attempts")
+
+// ErrDeadLetterOutboxNotSupported is returned when publishing dead letters from a store that is not a
+// store.DeadLetterOutbox.
+var ErrDeadLetterOutboxNotSupported = errors.New("retry store does not support publishing dead letters")
+
+// deadLetterBatchSize is the most dead letters that are claimed and published at once.
+var deadLetterBatchSize = 100
+
+// deadLetterLease is how long claimed dead letters are held for while they are published, after which the
+// instance holding them is assumed to have stopped and they can be claimed by another
+var deadLetterLease = time.Minute * 5
+
+// deadLetterBackoff is how long a dead letter that could not be published waits before it is published again
+var deadLetterBackoff = time.Minute
 
 type Manager struct {
-	dbRetries config.DBRetries
-	repo      repository
+	dbRetries     config.DBRetries
+	repo          store.Store
+	workerID      string
+	retention     config.DBRetryRetention
+	partitions    config.DBRetryPartitions
+	archivers     []store.Archiver
+	reportHandler config.MaintenanceReportHandler
+	// publishDeadLetters leaves dead letters waiting to be published by PublishDeadLetters
+	publishDeadLetters bool
 }
 
-type repository interface {
-	GetMessagesForRetry(ctx context.Context, topic string, sequence uint8, interval time.Duration) ([]model.Retry, error)
-	MarkRetrySuccessful(ctx context.Context, retry model.Retry) error
-	MarkRetryErrored(ctx context.Context, retry model.Retry, err error) error
-	PublishFailure(ctx context.Context, failure failuremodel.Failure) error
-	DeleteSuccessful(ctx context.Context, olderThan time.Time) error
+func NewManagerWithDefaults(dbRetries config.DBRetries, db *sql.DB) *Manager {
+	return NewManager(dbRetries, internal.NewRepository(db))
 }
 
-func NewManagerWithDefaults(dbRetries config.DBRetries, db *sql.DB) *Manager {
+// NewManagerForDriver returns a Manager that keeps retries in db, using the repository for the given
+// database driver.
+func NewManagerForDriver(dbRetries config.DBRetries, db *sql.DB, driver string) *Manager {
+	switch driver {
+	case data.DriverSQLite:
+		return NewManager(dbRetries, internal.NewSQLiteRepository(db))
+	case data.DriverMySQL:
+		return NewManager(dbRetries, internal.NewMySQLRepository(db))
+	default:
+		return NewManagerWithDefaults(dbRetries, db)
+	}
+}
+
+// NewManager returns a Manager that keeps retries in the given store.
+func NewManager(dbRetries config.DBRetries, s store.Store) *Manager {
 	return &Manager{
 		dbRetries: dbRetries,
-		repo:      internal.NewRepository(db),
+		repo:      s,
+		workerID:  defaultWorkerID(),
+		retention: config.DBRetryRetention{
+			Periods: map[store.RetryState]time.Duration{store.StateSuccessful: config.DefaultSuccessfulRetention},
+		},
 	}
 }
 
+// SetWorkerID sets the ID recorded against the retries that the manager claims, which defaults to the
+// hostname and process ID.
+func (m *Manager) SetWorkerID(id string) {
+	m.workerID = id
+}
+
+// SetDeadLetterPublishing sets whether the retries that are dead-lettered are left waiting to be published with
+// PublishDeadLetters, which requires a store.DeadLetterOutbox. Otherwise they are marked as published, so that
+// they are not published if publishing is enabled later on.
+func (m *Manager) SetDeadLetterPublishing(enabled bool) {
+	m.publishDeadLetters = enabled
+}
+
 func (m Manager) GetBatch(ctx context.Context, topic string, sequence uint8, interval time.Duration) ([]model.Retry, error) {
-	return m.repo.GetMessagesForRetry(ctx, topic, sequence, interval)
+	return m.repo.GetMessagesForRetry(ctx, topic, sequence, interval, m.claim(topic))
+}
+
+// RenewLease extends the lease on claimed retries that are still being processed. It should be called
+// well within the lease of 30 seconds, for as long as any retry from a batch is being processed.
+func (m Manager) RenewLease(ctx context.Context, retries []model.Retry) error {
+	if len(retries) == 0 {
+		return nil
+	}
+	return m.repo.RenewLease(ctx, retries, m.claim(retries[0].Topic))
+}
+
+// GetReleasedBatch returns parked messages for the topic that are next in line for their key,
+// now that any earlier retry for that key has succeeded or been dead-lettered.
+func (m Manager) GetReleasedBatch(ctx context.Context, topic string) ([]model.Retry, error) {
+	ks, ok := m.repo.(store.KeyBlockingStore)
+	if !ok {
+		return nil, ErrKeyBlockingNotSupported
+	}
+	return ks.GetReleasedParkedMessages(ctx, topic, m.claim(topic))
 }
 
 func (m Manager) MarkSuccessful(ctx context.Context, retry model.Retry) error {
@@ -44,15 +123,87 @@ func (m Manager) MarkSuccessful(ctx context.Context, retry model.Retry) error {
 }
 
 func (m Manager) MarkErrored(ctx context.Context, retry model.Retry, err error) error {
-	return m.repo.MarkRetryErrored(ctx, m.dbRetries.MakeRetryErrored(retry), err)
+	if !m.publishDeadLetters {
+		return m.repo.MarkRetryErrored(ctx, m.dbRetries.MakeRetryErrored(retry), err)
+	}
+
+	outbox, ok := m.repo.(store.DeadLetterOutbox)
+	if !ok {
+		return ErrDeadLetterOutboxNotSupported
+	}
+	return outbox.MarkRetryErroredToPublish(ctx, m.dbRetries.MakeRetryErrored(retry), err)
+}
+
+// Release releases the claim on retries from a batch that were not processed, so that they can be
+// claimed again without waiting for the claim to go stale.
+func (m Manager) Release(ctx context.Context, retries []model.Retry) error {
+	return m.repo.ReleaseRetries(ctx, retries)
 }
 
 func (m Manager) PublishFailure(ctx context.Context, failure failuremodel.Failure) error {
 	return m.repo.PublishFailure(ctx, failure)
 }
 
-func (m Manager) RunMaintenance(ctx context.Context) error {
-	olderThan := time.Now().In(time.UTC).Add(-1 * deleteSuccessfulRetriesAfter)
+// ParkFailure stores a message behind an earlier pending retry for the same key, without it
+// having been processed.
+func (m Manager) ParkFailure(ctx context.Context, failure failuremodel.Failure) error {
+	ks, ok := m.repo.(store.KeyBlockingStore)
+	if !ok {
+		return ErrKeyBlockingNotSupported
+	}
+	return ks.PublishParkedFailure(ctx, failure)
+}
+
+// HasPendingRetry returns true if a message with the given key from the given topic is still
+// waiting to be retried, or is parked behind another retry.
+func (m Manager) HasPendingRetry(ctx context.Context, topic string, key []byte) (bool, error) {
+	ks, ok := m.repo.(store.KeyBlockingStore)
+	if !ok {
+		return false, ErrKeyBlockingNotSupported
+	}
+	return ks.HasPendingRetryForKey(ctx, topic, key)
+}
+
+// GetAttempts returns every attempt at processing the retry with the given ID, starting with the failure
+// that it was published for.
+func (m Manager) GetAttempts(ctx context.Context, retryID int64) ([]store.Attempt, error) {
+	hs, ok := m.repo.(store.AttemptHistoryStore)
+	if !ok {
+		return nil, ErrAttemptHistoryNotSupported
+	}
+	return hs.GetAttempts(ctx, retryID)
+}
+
+// PublishDeadLetters calls publish with every dead letter of the topics that has not been published yet, in
+// batches, and returns how many were published. It stops after a batch with an error, and the dead letters that
+// could not be published are published again by a later call, once their backoff has passed.
+func (m Manager) PublishDeadLetters(ctx context.Context, topics []string, publish func(store.DeadLetter) error) (int, error) {
+	outbox, ok := m.repo.(store.DeadLetterOutbox)
+	if !ok {
+		return 0, ErrDeadLetterOutboxNotSupported
+	}
+
+	claim := store.DeadLetterClaim{Limit: deadLetterBatchSize, Lease: deadLetterLease, Backoff: deadLetterBackoff}
+	var total int
+	for {
+		n, err := outbox.PublishDeadLetters(ctx, topics, claim, publish)
+		total += n
+		if err != nil || n < deadLetterBatchSize {
+			return total, err
+		}
+	}
+}
+
+func (m Manager) claim(topic string) store.Claim {
+	return store.Claim{
+		BatchSize: m.dbRetries.BatchSizeForTopic(topic),
+		WorkerID:  m.workerID,
+		Lease:     leaseDuration,
+	}
+}
 
-	return m.repo.DeleteSuccessful(ctx, olderThan)
+// defaultWorkerID identifies this process by its hostname and process ID.
+func defaultWorkerID() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
 }
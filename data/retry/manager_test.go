@@ -13,6 +13,8 @@ import (
 	failuremodel "github.com/revdaalex/kafka-consumer-go/data/failure/model"
 	"github.com/revdaalex/kafka-consumer-go/data/retry/internal"
 	"github.com/revdaalex/kafka-consumer-go/data/retry/model"
+	"github.com/revdaalex/kafka-consumer-go/data/retry/store"
+	"github.com/revdaalex/kafka-consumer-go/data/retry/store/memory"
 )
 
 func TestNewManagerWithDefaults(t *testing.T) {
@@ -22,6 +24,7 @@ func TestNewManagerWithDefaults(t *testing.T) {
 	exp := &Manager{
 		dbRetries: dbRetries,
 		repo:      internal.NewRepository(db),
+		workerID:  defaultWorkerID(),
 	}
 
 	got := NewManagerWithDefaults(dbRetries, db)
@@ -30,6 +33,57 @@ func TestNewManagerWithDefaults(t *testing.T) {
 	}
 }
 
+func TestNewManager(t *testing.T) {
+	repo := newMockRepository(false)
+	dbRetries := config.DBRetries{}
+
+	exp := &Manager{
+		dbRetries: dbRetries,
+		repo:      repo,
+		workerID:  defaultWorkerID(),
+	}
+
+	got := NewManager(dbRetries, repo)
+	if diff := deep.Equal(exp, got); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestNewManagerForDriver(t *testing.T) {
+	db, _, _ := sqlmock.New()
+	dbRetries := config.DBRetries{}
+
+	if _, ok := NewManagerForDriver(dbRetries, db, "sqlite").repo.(internal.SQLiteRepository); !ok {
+		t.Error("expected the SQLite repository to be used for the sqlite driver")
+	}
+
+	if _, ok := NewManagerForDriver(dbRetries, db, "mysql").repo.(internal.MySQLRepository); !ok {
+		t.Error("expected the MySQL repository to be used for the mysql driver")
+	}
+
+	if _, ok := NewManagerForDriver(dbRetries, db, "postgres").repo.(internal.Repository); !ok {
+		t.Error("expected the Postgres repository to be used for the postgres driver")
+	}
+}
+
+func TestManager_WithoutKeyBlockingStore(t *testing.T) {
+	ctx := context.Background()
+	// wrapping the mock hides its parking methods, leaving only those of store.Store
+	manager := NewManager(dummyDbRetriesForManagerTests(), struct{ store.Store }{newMockRepository(false)})
+
+	if _, err := manager.GetReleasedBatch(ctx, "foo"); !errors.Is(err, ErrKeyBlockingNotSupported) {
+		t.Errorf("expected ErrKeyBlockingNotSupported from GetReleasedBatch, got: %v", err)
+	}
+
+	if err := manager.ParkFailure(ctx, failuremodel.Failure{}); !errors.Is(err, ErrKeyBlockingNotSupported) {
+		t.Errorf("expected ErrKeyBlockingNotSupported from ParkFailure, got: %v", err)
+	}
+
+	if _, err := manager.HasPendingRetry(ctx, "foo", []byte("bar")); !errors.Is(err, ErrKeyBlockingNotSupported) {
+		t.Errorf("expected ErrKeyBlockingNotSupported from HasPendingRetry, got: %v", err)
+	}
+}
+
 func TestManager_GetBatch(t *testing.T) {
 	t.Run("returns batch from repository", func(t *testing.T) {
 		manager, repo := newManagerForTests(false)
@@ -51,6 +105,36 @@ func TestManager_GetBatch(t *testing.T) {
 		}
 	})
 
+	t.Run("claims the batch size of the topic", func(t *testing.T) {
+		manager, repo := newManagerForTests(false)
+
+		if _, err := manager.GetBatch(context.Background(), "foo", 1, time.Second*1); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+		if repo.receivedClaim.BatchSize != 50 {
+			t.Errorf("expected a batch size of 50, got %d", repo.receivedClaim.BatchSize)
+		}
+
+		if _, err := manager.GetBatch(context.Background(), "bar", 1, time.Second*1); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+		if repo.receivedClaim.BatchSize != config.DefaultDBRetryBatchSize {
+			t.Errorf("expected the default batch size for a topic without retries, got %d", repo.receivedClaim.BatchSize)
+		}
+	})
+
+	t.Run("claims the batch for the worker with a lease", func(t *testing.T) {
+		manager, repo := newManagerForTests(false)
+		manager.SetWorkerID("worker-1")
+
+		if _, err := manager.GetBatch(context.Background(), "foo", 1, time.Second*1); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+		if repo.receivedClaim.WorkerID != "worker-1" || repo.receivedClaim.Lease != leaseDuration {
+			t.Errorf("expected the batch to be claimed by worker-1 with a lease, got %+v", repo.receivedClaim)
+		}
+	})
+
 	t.Run("returns error from repository", func(t *testing.T) {
 		manager, _ := newManagerForTests(true)
 
@@ -61,6 +145,29 @@ func TestManager_GetBatch(t *testing.T) {
 	})
 }
 
+func TestManager_RenewLease(t *testing.T) {
+	t.Run("renews the lease of the worker on the retries", func(t *testing.T) {
+		manager, repo := newManagerForTests(false)
+		manager.SetWorkerID("worker-1")
+		retries := []model.Retry{{ID: 1, Topic: "foo"}}
+
+		if err := manager.RenewLease(context.Background(), retries); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+		if len(repo.LeaseRenewed) != 1 || repo.receivedClaim.WorkerID != "worker-1" || repo.receivedClaim.Lease != leaseDuration {
+			t.Errorf("expected the lease to be renewed for worker-1, got %+v", repo.receivedClaim)
+		}
+	})
+
+	t.Run("does nothing without retries", func(t *testing.T) {
+		manager, _ := newManagerForTests(true)
+
+		if err := manager.RenewLease(context.Background(), nil); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	})
+}
+
 func TestManager_MarkSuccessful(t *testing.T) {
 	ctx := context.Background()
 
@@ -198,6 +305,197 @@ func TestManager_PublishFailure(t *testing.T) {
 	})
 }
 
+func TestManager_GetReleasedBatch(t *testing.T) {
+	t.Run("returns released batch from repository", func(t *testing.T) {
+		manager, repo := newManagerForTests(false)
+		expRetries := []model.Retry{{ID: 10, Topic: "foo"}}
+		repo.retriesToReturn = expRetries
+
+		got, err := manager.GetReleasedBatch(context.Background(), "foo")
+		if err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+
+		if diff := deep.Equal(expRetries, got); diff != nil {
+			t.Error(diff)
+		}
+	})
+
+	t.Run("returns error from repository", func(t *testing.T) {
+		manager, _ := newManagerForTests(true)
+
+		if _, err := manager.GetReleasedBatch(context.Background(), "foo"); err == nil {
+			t.Error("expected an error but got nil")
+		}
+	})
+}
+
+func TestManager_ParkFailure(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("parks failure", func(t *testing.T) {
+		manager, repo := newManagerForTests(false)
+		f := failuremodel.Failure{Topic: "foo", MessageKey: []byte("bar")}
+
+		if err := manager.ParkFailure(ctx, f); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+
+		if diff := deep.Equal(&f, repo.ParkedFailure); diff != nil {
+			t.Error(diff)
+		}
+	})
+
+	t.Run("returns error from repository", func(t *testing.T) {
+		manager, _ := newManagerForTests(true)
+
+		if err := manager.ParkFailure(ctx, failuremodel.Failure{}); err == nil {
+			t.Error("expected an error but got nil")
+		}
+	})
+}
+
+func TestManager_HasPendingRetry(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("returns pending state from repository", func(t *testing.T) {
+		manager, repo := newManagerForTests(false)
+		repo.pendingKeys = map[string]bool{"foo/bar": true}
+
+		if got, _ := manager.HasPendingRetry(ctx, "foo", []byte("bar")); !got {
+			t.Error("expected key 'bar' to have a pending retry")
+		}
+
+		if got, _ := manager.HasPendingRetry(ctx, "foo", []byte("baz")); got {
+			t.Error("expected key 'baz' to have no pending retry")
+		}
+	})
+
+	t.Run("returns error from repository", func(t *testing.T) {
+		manager, _ := newManagerForTests(true)
+
+		if _, err := manager.HasPendingRetry(ctx, "foo", []byte("bar")); err == nil {
+			t.Error("expected an error but got nil")
+		}
+	})
+}
+
+func TestManager_GetAttempts(t *testing.T) {
+	ctx := context.Background()
+	s := memory.NewStore()
+	manager := NewManager(dummyDbRetriesForManagerTests(), s)
+	manager.SetWorkerID("worker-1")
+
+	if err := manager.PublishFailure(ctx, failuremodel.Failure{Topic: "foo", Message: []byte(`{}`), Reason: "consume failed"}); err != nil {
+		t.Fatal(err)
+	}
+	for i, reason := range []string{"retry 1 failed", "retry 2 failed"} {
+		batch, _ := manager.GetBatch(ctx, "foo", uint8(i+1), 0)
+		if len(batch) != 1 {
+			t.Fatalf("expected the retry to be claimed on attempt %d, got %d", i+1, len(batch))
+		}
+		if err := manager.MarkErrored(ctx, batch[0], errors.New(reason)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	attempts, err := manager.GetAttempts(ctx, 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(attempts) != 3 {
+		t.Fatalf("expected the failure and both retries to be recorded, got %+v", attempts)
+	}
+	for i, reason := range []string{"consume failed", "retry 1 failed", "retry 2 failed"} {
+		a := attempts[i]
+		if a.RetryID != 1 || a.Attempt != uint8(i) || a.Error != reason || a.Successful || a.FinishedAt.IsZero() {
+			t.Errorf("unexpected attempt %d: %+v", i, a)
+		}
+		if first := i == 0; first != a.StartedAt.IsZero() || first != (a.WorkerID == "") {
+			t.Errorf("expected only the retries to have been claimed by the worker, got attempt %d: %+v", i, a)
+		}
+	}
+
+	t.Run("it returns an error for a store without attempt history", func(t *testing.T) {
+		manager := NewManager(dummyDbRetriesForManagerTests(), struct{ store.Store }{s})
+		if _, err := manager.GetAttempts(ctx, 1); !errors.Is(err, ErrAttemptHistoryNotSupported) {
+			t.Errorf("expected ErrAttemptHistoryNotSupported, got: %v", err)
+		}
+	})
+}
+
+func TestManager_PublishDeadLetters(t *testing.T) {
+	ctx := context.Background()
+	defer func(size int) { deadLetterBatchSize = size }(deadLetterBatchSize)
+	deadLetterBatchSize = 2
+
+	s := memory.NewStore()
+	manager := NewManager(dummyDbRetriesForManagerTests(), s)
+	manager.SetDeadLetterPublishing(true)
+	for _, key := range []string{"SKU-1", "SKU-2", "SKU-3"} {
+		if err := manager.PublishFailure(ctx, failuremodel.Failure{Topic: "foo", Message: []byte(`{}`), MessageKey: []byte(key)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for sequence := uint8(1); sequence <= 2; sequence++ {
+		batch, _ := manager.GetBatch(ctx, "foo", sequence, 0)
+		for _, r := range batch {
+			if err := manager.MarkErrored(ctx, r, errors.New("retry failed")); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	var keys []string
+	n, err := manager.PublishDeadLetters(ctx, []string{"foo"}, func(dl store.DeadLetter) error {
+		keys = append(keys, string(dl.PayloadKey))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if n != 3 {
+		t.Errorf("expected the dead letters of every batch to be published, got %d", n)
+	}
+	if diff := deep.Equal(keys, []string{"SKU-1", "SKU-2", "SKU-3"}); diff != nil {
+		t.Error(diff)
+	}
+
+	if n, _ := manager.PublishDeadLetters(ctx, []string{"foo"}, func(store.DeadLetter) error { return nil }); n != 0 {
+		t.Errorf("expected the dead letters to only be published once, got %d more", n)
+	}
+
+	t.Run("it does not publish dead letters while publishing is disabled", func(t *testing.T) {
+		manager := NewManager(dummyDbRetriesForManagerTests(), memory.NewStore())
+		if err := manager.PublishFailure(ctx, failuremodel.Failure{Topic: "foo", Message: []byte(`{}`)}); err != nil {
+			t.Fatal(err)
+		}
+		for sequence := uint8(1); sequence <= 2; sequence++ {
+			batch, _ := manager.GetBatch(ctx, "foo", sequence, 0)
+			for _, r := range batch {
+				_ = manager.MarkErrored(ctx, r, errors.New("retry failed"))
+			}
+		}
+
+		manager.SetDeadLetterPublishing(true)
+		if n, _ := manager.PublishDeadLetters(ctx, []string{"foo"}, func(store.DeadLetter) error { return nil }); n != 0 {
+			t.Errorf("expected the dead letters from before publishing was enabled not to be published, got %d", n)
+		}
+	})
+
+	t.Run("it returns an error for a store that is not an outbox", func(t *testing.T) {
+		manager := NewManager(dummyDbRetriesForManagerTests(), struct{ store.Store }{s})
+		if _, err := manager.PublishDeadLetters(ctx, []string{"foo"}, func(store.DeadLetter) error { return nil }); !errors.Is(err, ErrDeadLetterOutboxNotSupported) {
+			t.Errorf("expected ErrDeadLetterOutboxNotSupported, got: %v", err)
+		}
+
+		manager.SetDeadLetterPublishing(true)
+		if err := manager.MarkErrored(ctx, model.Retry{ID: 1, Topic: "foo"}, errors.New("retry failed")); !errors.Is(err, ErrDeadLetterOutboxNotSupported) {
+			t.Errorf("expected ErrDeadLetterOutboxNotSupported marking a retry as errored, got: %v", err)
+		}
+	})
+}
+
 func TestManager_RunMaintenance(t *testing.T) {
 	ctx := context.Background()
 	now := time.Now()
@@ -228,6 +526,10 @@ func newManagerForTests(repoWillError bool) (Manager, *mockRepository) {
 	manager := Manager{
 		dbRetries: dummyDbRetriesForManagerTests(),
 		repo:      repo,
+		workerID:  "worker-1",
+		retention: config.DBRetryRetention{
+			Periods: map[store.RetryState]time.Duration{store.StateSuccessful: time.Hour},
+		},
 	}
 	return manager, repo
 }
@@ -236,14 +538,16 @@ func dummyDbRetriesForManagerTests() config.DBRetries {
 	return config.DBRetries{
 		"foo": {
 			{
-				Interval: time.Second * 1,
-				Sequence: 1,
-				Key:      "foo",
+				Interval:  time.Second * 1,
+				Sequence:  1,
+				Key:       "foo",
+				BatchSize: 50,
 			},
 			{
-				Interval: time.Second * 2,
-				Sequence: 2,
-				Key:      "foo",
+				Interval:  time.Second * 2,
+				Sequence:  2,
+				Key:       "foo",
+				BatchSize: 50,
 			},
 		},
 	}
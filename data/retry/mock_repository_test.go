@@ -7,22 +7,29 @@ import (
 
 	failuremodel "github.com/revdaalex/kafka-consumer-go/data/failure/model"
 	"github.com/revdaalex/kafka-consumer-go/data/retry/model"
+	"github.com/revdaalex/kafka-consumer-go/data/retry/store"
 )
 
 type mockRepository struct {
 	RetryMarkedSuccessful *model.Retry
 	RetryMarkedErrored    *model.Retry
+	RetriesReleased       []model.Retry
+	LeaseRenewed          []model.Retry
 	PublishedFailure      *failuremodel.Failure
+	ParkedFailure         *failuremodel.Failure
+	pendingKeys           map[string]bool
 	retriesToReturn       []model.Retry
 	willError             bool
 	receivedOlderThan     time.Time
+	receivedClaim         store.Claim
 }
 
 func newMockRepository(willError bool) *mockRepository {
 	return &mockRepository{willError: willError}
 }
 
-func (m *mockRepository) GetMessagesForRetry(ctx context.Context, topic string, sequence uint8, interval time.Duration) ([]model.Retry, error) {
+func (m *mockRepository) GetMessagesForRetry(ctx context.Context, topic string, sequence uint8, interval time.Duration, claim store.Claim) ([]model.Retry, error) {
+	m.receivedClaim = claim
 	if m.willError {
 		return nil, errors.New("oops")
 	}
@@ -45,6 +52,23 @@ func (m *mockRepository) MarkRetryErrored(ctx context.Context, retry model.Retry
 	return nil
 }
 
+func (m *mockRepository) RenewLease(ctx context.Context, retries []model.Retry, claim store.Claim) error {
+	if m.willError {
+		return errors.New("oops")
+	}
+	m.receivedClaim = claim
+	m.LeaseRenewed = retries
+	return nil
+}
+
+func (m *mockRepository) ReleaseRetries(ctx context.Context, retries []model.Retry) error {
+	if m.willError {
+		return errors.New("oops")
+	}
+	m.RetriesReleased = retries
+	return nil
+}
+
 func (m *mockRepository) PublishFailure(ctx context.Context, failure failuremodel.Failure) error {
 	if m.willError {
 		return errors.New("oops")
@@ -60,3 +84,26 @@ func (m *mockRepository) DeleteSuccessful(ctx context.Context, olderThan time.Ti
 	m.receivedOlderThan = olderThan
 	return nil
 }
+
+func (m *mockRepository) PublishParkedFailure(ctx context.Context, failure failuremodel.Failure) error {
+	if m.willError {
+		return errors.New("oops")
+	}
+	m.ParkedFailure = &failure
+	return nil
+}
+
+func (m *mockRepository) HasPendingRetryForKey(ctx context.Context, topic string, key []byte) (bool, error) {
+	if m.willError {
+		return false, errors.New("oops")
+	}
+	return m.pendingKeys[topic+"/"+string(key)], nil
+}
+
+func (m *mockRepository) GetReleasedParkedMessages(ctx context.Context, topic string, claim store.Claim) ([]model.Retry, error) {
+	m.receivedClaim = claim
+	if m.willError {
+		return nil, errors.New("oops")
+	}
+	return m.retriesToReturn, nil
+}
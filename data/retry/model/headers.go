@@ -0,0 +1,48 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/IBM/sarama"
+)
+
+// Header is a message header as it is stored in the payload_headers column. Headers are stored as a
+// JSON list, so that their order and any duplicate keys are kept, and their keys and values are base64
+// encoded so that binary values are kept too, e.g. [{"key":"Zm9v","value":"YmFy"}].
+type Header struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// EncodeHeaders encodes message headers for the payload_headers column.
+func EncodeHeaders(headers []sarama.RecordHeader) ([]byte, error) {
+	encoded := make([]Header, len(headers))
+	for i, h := range headers {
+		encoded[i] = Header{Key: h.Key, Value: h.Value}
+	}
+
+	b, err := json.Marshal(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("data/retries: error encoding failure headers: %w", err)
+	}
+	return b, nil
+}
+
+// DecodeHeaders decodes message headers stored by EncodeHeaders. Empty input decodes to no headers.
+func DecodeHeaders(b []byte) ([]*sarama.RecordHeader, error) {
+	if len(b) == 0 {
+		return nil, nil
+	}
+
+	var decoded []Header
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		return nil, fmt.Errorf("data/retries: error decoding retry headers: %w", err)
+	}
+
+	var headers []*sarama.RecordHeader
+	for _, h := range decoded {
+		headers = append(headers, &sarama.RecordHeader{Key: h.Key, Value: h.Value})
+	}
+	return headers, nil
+}
@@ -0,0 +1,64 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/go-test/deep"
+)
+
+func TestEncodeHeaders(t *testing.T) {
+	headers := []sarama.RecordHeader{
+		{Key: []byte("trace"), Value: []byte("a")},
+		{Key: []byte("trace"), Value: []byte("b")},
+		{Key: []byte("sig"), Value: []byte{0x00, 0xff}},
+	}
+
+	b, err := EncodeHeaders(headers)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	exp := `[{"key":"dHJhY2U=","value":"YQ=="},{"key":"dHJhY2U=","value":"Yg=="},{"key":"c2ln","value":"AP8="}]`
+	if string(b) != exp {
+		t.Errorf("expected %s, got %s", exp, b)
+	}
+
+	t.Run("headers decode in order with duplicates and binary values", func(t *testing.T) {
+		got, err := DecodeHeaders(b)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		exp := []*sarama.RecordHeader{&headers[0], &headers[1], &headers[2]}
+		if diff := deep.Equal(exp, got); diff != nil {
+			t.Error(diff)
+		}
+	})
+
+	t.Run("no headers encode as an empty list", func(t *testing.T) {
+		if b, _ := EncodeHeaders(nil); string(b) != "[]" {
+			t.Errorf("expected [], got %s", b)
+		}
+	})
+}
+
+func TestDecodeHeaders(t *testing.T) {
+	t.Run("headers written by earlier versions are decoded", func(t *testing.T) {
+		got, err := DecodeHeaders([]byte(`[{"Key":"Zm9v","Value":"YmFy"}]`))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		exp := []*sarama.RecordHeader{{Key: []byte("foo"), Value: []byte("bar")}}
+		if diff := deep.Equal(exp, got); diff != nil {
+			t.Error(diff)
+		}
+	})
+
+	t.Run("invalid headers return an error", func(t *testing.T) {
+		if _, err := DecodeHeaders([]byte(`{"foo":"bar"}`)); err == nil {
+			t.Error("expected an error but got nil")
+		}
+	})
+}
@@ -1,7 +1,7 @@
 package model
 
 import (
-	"encoding/json"
+	"time"
 
 	"github.com/IBM/sarama"
 )
@@ -9,35 +9,32 @@ import (
 type Retry struct {
 	ID             int64
 	Topic          string
-	PayloadJSON    []byte
+	Payload        []byte
 	PayloadHeaders []byte
 	PayloadKey     []byte
 	KafkaOffset    int64
 	KafkaPartition int32
+	// KafkaTimestamp of the original message, which is zero if it was not known
+	KafkaTimestamp time.Time
 	Attempts       uint8
 	Deadlettered   bool
 	Errored        bool
+	// ContentType of the payload, taken from its content-type header or detected from the payload
+	ContentType string
 }
 
-type recordHeaders map[string]string
-
 func (r Retry) ToSaramaConsumerMessage() *sarama.ConsumerMessage {
 	msg := &sarama.ConsumerMessage{
 		Key:       r.PayloadKey,
-		Value:     r.PayloadJSON,
+		Value:     r.Payload,
 		Topic:     r.Topic,
 		Partition: r.KafkaPartition,
 		Offset:    r.KafkaOffset,
+		Timestamp: r.KafkaTimestamp,
 	}
 
-	var rh recordHeaders
-	if err := json.Unmarshal(r.PayloadHeaders, &rh); err == nil {
-		for k, v := range rh {
-			msg.Headers = append(msg.Headers, &sarama.RecordHeader{
-				Key:   []byte(k),
-				Value: []byte(v),
-			})
-		}
+	if headers, err := DecodeHeaders(r.PayloadHeaders); err == nil {
+		msg.Headers = headers
 	}
 
 	return msg
@@ -2,6 +2,7 @@ package model
 
 import (
 	"testing"
+	"time"
 
 	"github.com/IBM/sarama"
 	"github.com/go-test/deep"
@@ -11,23 +12,28 @@ func TestRetry_ToSaramaConsumerMessage(t *testing.T) {
 	retry := Retry{
 		ID:             10,
 		Topic:          "product",
-		PayloadJSON:    []byte(`{"foo":"bar"}`),
-		PayloadHeaders: []byte(`{"baz":"buzz"}`),
+		Payload:        []byte(`{"foo":"bar"}`),
+		PayloadHeaders: []byte(`[{"key":"YmF6","value":"YnV6eg=="},{"key":"YmF6","value":"AP8="}]`),
 		PayloadKey:     []byte("foo"),
 		KafkaOffset:    100,
 		KafkaPartition: 101,
+		KafkaTimestamp: time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC),
 		Attempts:       1,
 	}
 
 	t.Run("retry converts to consumer message", func(t *testing.T) {
 		t.Parallel()
 		exp := &sarama.ConsumerMessage{
-			Headers:   []*sarama.RecordHeader{{Key: []byte("baz"), Value: []byte("buzz")}},
+			Headers: []*sarama.RecordHeader{
+				{Key: []byte("baz"), Value: []byte("buzz")},
+				{Key: []byte("baz"), Value: []byte{0x00, 0xff}},
+			},
 			Key:       []byte("foo"),
 			Value:     []byte(`{"foo":"bar"}`),
 			Topic:     "product",
 			Partition: 101,
 			Offset:    100,
+			Timestamp: time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC),
 		}
 
 		got := retry.ToSaramaConsumerMessage()
@@ -46,6 +52,7 @@ func TestRetry_ToSaramaConsumerMessage(t *testing.T) {
 			Topic:     "product",
 			Partition: 101,
 			Offset:    100,
+			Timestamp: time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC),
 		}
 
 		got := retry2.ToSaramaConsumerMessage()
@@ -57,13 +64,14 @@ func TestRetry_ToSaramaConsumerMessage(t *testing.T) {
 	t.Run("retry with empty headers converts to consumer message", func(t *testing.T) {
 		t.Parallel()
 		retry2 := retry
-		retry2.PayloadHeaders = []byte(`{}`)
+		retry2.PayloadHeaders = []byte(`[]`)
 		exp := &sarama.ConsumerMessage{
 			Key:       []byte("foo"),
 			Value:     []byte(`{"foo":"bar"}`),
 			Topic:     "product",
 			Partition: 101,
 			Offset:    100,
+			Timestamp: time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC),
 		}
 
 		got := retry2.ToSaramaConsumerMessage()
@@ -0,0 +1,16 @@
+package retry
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/revdaalex/kafka-consumer-go/data/retry/internal"
+)
+
+// PartitionRetriesTable converts the Postgres retries table into one partitioned by when retries were created,
+// with a partition for each interval. The retries already in the table are kept in a partition of their own,
+// which is dropped once every retry in it has expired. It does nothing if the table is already partitioned.
+func PartitionRetriesTable(ctx context.Context, db *sql.DB, interval time.Duration) error {
+	return internal.PartitionRetriesTable(ctx, db, interval)
+}
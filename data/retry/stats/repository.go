@@ -0,0 +1,100 @@
+package stats
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// State of a retry in the retries table.
+type State string
+
+const (
+	// StatePending retries are waiting for their next attempt
+	StatePending State = "pending"
+	// StateProcessing retries are claimed by a consumer
+	StateProcessing   State = "processing"
+	StateParked       State = "parked"
+	StateSuccessful   State = "successful"
+	StateDeadlettered State = "deadlettered"
+)
+
+// Count is the number of retries of a topic in a state, that have had the given number of attempts. The
+// attempts of a pending retry are the sequence of the retry interval that it is waiting for.
+type Count struct {
+	Topic    string `json:"topic"`
+	State    State  `json:"state"`
+	Attempts uint8  `json:"attempts"`
+	Count    int64  `json:"count"`
+}
+
+// Repository reports on the retries table. Its queries have no arguments, so it works with every database driver.
+type Repository struct {
+	db *sql.DB
+}
+
+func NewRepository(db *sql.DB) Repository {
+	return Repository{
+		db: db,
+	}
+}
+
+// Counts returns the number of retries of each topic, state and number of attempts, ordered by topic,
+// state and attempts.
+func (r Repository) Counts(ctx context.Context) ([]Count, error) {
+	q := `SELECT topic, CASE
+				WHEN successful = true THEN 'successful'
+				WHEN deadlettered = true THEN 'deadlettered'
+				WHEN parked = true THEN 'parked'
+				WHEN batch_id IS NOT NULL THEN 'processing'
+				ELSE 'pending'
+			END AS state, attempts, COUNT(*)
+		FROM kafka_consumer_retries
+		GROUP BY topic, state, attempts
+		ORDER BY topic, state, attempts;`
+
+	rows, err := r.db.QueryContext(ctx, q)
+	if err != nil {
+		return nil, fmt.Errorf("data/retry/stats: error counting retries: %w", err)
+	}
+	defer rows.Close()
+
+	var counts []Count
+	for rows.Next() {
+		var c Count
+		if err := rows.Scan(&c.Topic, &c.State, &c.Attempts, &c.Count); err != nil {
+			return nil, fmt.Errorf("data/retry/stats: error scanning result into memory: %w", err)
+		}
+		counts = append(counts, c)
+	}
+
+	return counts, rows.Err()
+}
+
+// Backlog returns the number of pending and processing retries of each topic, indexed by the sequence
+// of the retry interval that they are waiting for.
+func Backlog(counts []Count) map[string]map[uint8]int64 {
+	backlog := map[string]map[uint8]int64{}
+	for _, c := range counts {
+		if c.State != StatePending && c.State != StateProcessing {
+			continue
+		}
+		if backlog[c.Topic] == nil {
+			backlog[c.Topic] = map[uint8]int64{}
+		}
+		backlog[c.Topic][c.Attempts] += c.Count
+	}
+	return backlog
+}
+
+// ByState returns the number of retries of each topic in each state.
+func ByState(counts []Count) map[string]map[State]int64 {
+	byState := map[string]map[State]int64{}
+	for _, c := range counts {
+		if byState[c.Topic] == nil {
+			byState[c.Topic] = map[State]int64{}
+		}
+		byState[c.Topic][c.State] += c.Count
+	}
+	return byState
+}
@@ -0,0 +1,79 @@
+package stats
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-test/deep"
+
+	"github.com/revdaalex/kafka-consumer-go/data"
+)
+
+func TestRepository_Counts(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("it returns an error if the query fails", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+		expErr := errors.New("oops")
+		mock.ExpectQuery(`SELECT topic, CASE .* FROM kafka_consumer_retries GROUP BY topic, state, attempts`).
+			WillReturnError(expErr)
+
+		if _, err := NewRepository(db).Counts(ctx); !errors.Is(err, expErr) {
+			t.Errorf("expected error from query but got '%v'", err)
+		}
+	})
+
+	t.Run("it counts the retries of each topic by state and attempts", func(t *testing.T) {
+		db, err := data.NewSQLiteDB(":memory:")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = db.Close() })
+
+		if err := data.MigrateSQLiteDatabase(db); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = db.Exec(`INSERT INTO kafka_consumer_retries(topic, payload, payload_headers, payload_key, kafka_offset, kafka_partition, attempts, batch_id, parked, successful, deadlettered)
+			VALUES
+				('product', '{}', '[]', '', 1, 0, 1, NULL, false, false, false),
+				('product', '{}', '[]', '', 2, 0, 1, NULL, false, false, false),
+				('product', '{}', '[]', '', 3, 0, 2, 'batch', false, false, false),
+				('product', '{}', '[]', '', 4, 0, 0, NULL, true, false, false),
+				('product', '{}', '[]', '', 5, 0, 3, NULL, false, false, true),
+				('stock', '{}', '[]', '', 6, 0, 1, 'batch', false, true, false);`)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		counts, err := NewRepository(db).Counts(ctx)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		exp := []Count{
+			{Topic: "product", State: StateDeadlettered, Attempts: 3, Count: 1},
+			{Topic: "product", State: StateParked, Attempts: 0, Count: 1},
+			{Topic: "product", State: StatePending, Attempts: 1, Count: 2},
+			{Topic: "product", State: StateProcessing, Attempts: 2, Count: 1},
+			{Topic: "stock", State: StateSuccessful, Attempts: 1, Count: 1},
+		}
+		if diff := deep.Equal(exp, counts); diff != nil {
+			t.Error(diff)
+		}
+
+		if diff := deep.Equal(map[string]map[uint8]int64{"product": {1: 2, 2: 1}}, Backlog(counts)); diff != nil {
+			t.Errorf("unexpected backlog: %v", diff)
+		}
+
+		expByState := map[string]map[State]int64{
+			"product": {StateDeadlettered: 1, StateParked: 1, StatePending: 2, StateProcessing: 1},
+			"stock":   {StateSuccessful: 1},
+		}
+		if diff := deep.Equal(expByState, ByState(counts)); diff != nil {
+			t.Errorf("unexpected counts by state: %v", diff)
+		}
+	})
+}
@@ -0,0 +1,474 @@
+// Package memory provides a retry store that keeps retries in memory, for running database retries in
+// development and tests without a database. Retries are lost when the process exits.
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	failuremodel "github.com/revdaalex/kafka-consumer-go/data/failure/model"
+	"github.com/revdaalex/kafka-consumer-go/data/retry/model"
+	"github.com/revdaalex/kafka-consumer-go/data/retry/store"
+)
+
+// Store keeps retries in memory, claiming them in batches in the same way as the Postgres repository.
+// It implements store.KeyBlockingStore, store.RetentionStore, store.AttemptHistoryStore and store.DeadLetterOutbox.
+type Store struct {
+	retries     []*record
+	nextID      int64
+	nextBatchID int64
+	now         func() time.Time
+	sync.Mutex
+}
+
+// record holds a retry as it is returned from a batch, together with the rest of its state.
+type record struct {
+	retry           model.Retry
+	key             string
+	batchID         int64
+	workerID        string
+	leaseExpiresAt  time.Time
+	retryStartedAt  time.Time
+	retryFinishedAt time.Time
+	errored         bool
+	deadlettered    bool
+	successful      bool
+	parked          bool
+	lastError       string
+	createdAt       time.Time
+	updatedAt       time.Time
+	attempts        []store.Attempt
+	// deadLetterPublishAfter is when the dead letter can be claimed again, after its claim or backoff
+	deadLetterPublishAfter time.Time
+	deadLetterPublishedAt  time.Time
+}
+
+func NewStore() *Store {
+	return &Store{now: time.Now}
+}
+
+func (s *Store) PublishFailure(ctx context.Context, f failuremodel.Failure) error {
+	return s.add(f, 1, false)
+}
+
+func (s *Store) PublishParkedFailure(ctx context.Context, f failuremodel.Failure) error {
+	return s.add(f, 0, true)
+}
+
+func (s *Store) HasPendingRetryForKey(ctx context.Context, topic string, key []byte) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.hasPending(topic, string(key), false), nil
+}
+
+func (s *Store) GetMessagesForRetry(ctx context.Context, topic string, sequence uint8, interval time.Duration, claim store.Claim) ([]model.Retry, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	now := s.now()
+	before := now.Add(interval * -1)
+
+	return s.claim(now, claim, func(r *record) bool {
+		return r.retry.Topic == topic && r.retry.Attempts == sequence && !r.parked &&
+			!r.deadlettered && !r.successful && !r.updatedAt.After(before)
+	}), nil
+}
+
+func (s *Store) GetReleasedParkedMessages(ctx context.Context, topic string, claim store.Claim) ([]model.Retry, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.claim(s.now(), claim, func(r *record) bool {
+		return r.retry.Topic == topic && r.parked && s.firstParked(topic, r.key) == r && !s.hasPending(topic, r.key, true)
+	}), nil
+}
+
+func (s *Store) DeleteSuccessful(ctx context.Context, olderThan time.Time) error {
+	s.Lock()
+	defer s.Unlock()
+
+	kept := s.retries[:0]
+	for _, r := range s.retries {
+		if !r.successful || r.updatedAt.After(olderThan) {
+			kept = append(kept, r)
+		}
+	}
+	s.retries = kept
+
+	return nil
+}
+
+func (s *Store) GetExpiredRetries(ctx context.Context, expiry store.Expiry, limit int) ([]store.ExpiredRetry, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	var expired []store.ExpiredRetry
+	for _, r := range s.retries {
+		if len(expired) == limit {
+			break
+		}
+		if r.expired(expiry) {
+			retry := r.retry
+			retry.Errored, retry.Deadlettered = r.errored, r.deadlettered
+			expired = append(expired, store.ExpiredRetry{
+				Retry:     retry,
+				State:     expiry.State,
+				LastError: r.lastError,
+				CreatedAt: r.createdAt,
+				UpdatedAt: r.updatedAt,
+			})
+		}
+	}
+
+	return expired, nil
+}
+
+func (s *Store) DeleteExpiredRetries(ctx context.Context, expiry store.Expiry, retries []store.ExpiredRetry) (int64, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	ids := map[int64]bool{}
+	for _, r := range retries {
+		ids[r.ID] = true
+	}
+
+	var deleted int64
+	kept := s.retries[:0]
+	for _, r := range s.retries {
+		if ids[r.retry.ID] && r.expired(expiry) {
+			deleted++
+			continue
+		}
+		kept = append(kept, r)
+	}
+	s.retries = kept
+
+	return deleted, nil
+}
+
+func (s *Store) MarkRetrySuccessful(ctx context.Context, retry model.Retry) error {
+	s.Lock()
+	defer s.Unlock()
+
+	r := s.find(retry.ID)
+	if r == nil {
+		return nil
+	}
+
+	now := s.now()
+	r.leaseExpiresAt = time.Time{}
+	r.retry.Attempts = retry.Attempts
+	r.lastError = ""
+	r.retryFinishedAt = now
+	r.errored = false
+	r.successful = true
+	r.parked = false
+	r.updatedAt = now
+	r.recordAttempt()
+
+	return nil
+}
+
+func (s *Store) MarkRetryErrored(ctx context.Context, retry model.Retry, retryErr error) error {
+	return s.markRetryErrored(retry, retryErr, false)
+}
+
+// MarkRetryErroredToPublish marks the retry as errored, leaving it waiting to be published if it has been
+// dead-lettered.
+func (s *Store) MarkRetryErroredToPublish(ctx context.Context, retry model.Retry, retryErr error) error {
+	return s.markRetryErrored(retry, retryErr, true)
+}
+
+func (s *Store) markRetryErrored(retry model.Retry, retryErr error, toPublish bool) error {
+	s.Lock()
+	defer s.Unlock()
+
+	r := s.find(retry.ID)
+	if r == nil {
+		return nil
+	}
+
+	now := s.now()
+	r.batchID = 0
+	r.leaseExpiresAt = time.Time{}
+	r.retry.Attempts = retry.Attempts
+	r.lastError = retryErr.Error()
+	r.retryFinishedAt = now
+	r.errored = retry.Errored
+	r.deadlettered = retry.Deadlettered
+	r.deadLetterPublishedAt = now
+	if toPublish {
+		r.deadLetterPublishedAt = time.Time{}
+	}
+	r.parked = false
+	r.updatedAt = now
+	r.recordAttempt()
+
+	return nil
+}
+
+// GetAttempts returns the attempts at processing the retry, or none if it has been deleted.
+func (s *Store) GetAttempts(ctx context.Context, retryID int64) ([]store.Attempt, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	r := s.find(retryID)
+	if r == nil {
+		return nil, nil
+	}
+	return append([]store.Attempt(nil), r.attempts...), nil
+}
+
+// PublishDeadLetters publishes the dead letters of the topics that have not been published yet. The store is not locked while
+// they are published, so the dead letters are claimed first.
+func (s *Store) PublishDeadLetters(ctx context.Context, topics []string, claim store.DeadLetterClaim, publish func(store.DeadLetter) error) (int, error) {
+	consumed := map[string]bool{}
+	for _, t := range topics {
+		consumed[t] = true
+	}
+
+	s.Lock()
+	claimedAt := s.now()
+	var claimed []*record
+	var deadLetters []store.DeadLetter
+	held := map[string]bool{}
+	for _, r := range s.retries {
+		if len(claimed) == claim.Limit {
+			break
+		}
+		if !consumed[r.retry.Topic] || !r.deadlettered || r.successful || !r.deadLetterPublishedAt.IsZero() {
+			continue
+		}
+		// a dead letter that is claimed or backed off holds back the later dead letters with its key
+		key := r.retry.Topic + "/" + string(r.retry.PayloadKey)
+		if held[key] || r.deadLetterPublishAfter.After(claimedAt) {
+			held[key] = len(r.retry.PayloadKey) > 0
+			continue
+		}
+		r.deadLetterPublishAfter = claimedAt.Add(claim.Lease)
+		claimed = append(claimed, r)
+
+		retry := r.retry
+		retry.Errored, retry.Deadlettered = r.errored, r.deadlettered
+		deadLetters = append(deadLetters, store.DeadLetter{Retry: retry, LastError: r.lastError, CreatedAt: r.createdAt, DeadletteredAt: r.updatedAt})
+	}
+	s.Unlock()
+
+	// the outcome of each claimed dead letter, which is released if it is neither published nor failed
+	published := make([]bool, len(claimed))
+	failed := make([]bool, len(claimed))
+	var err error
+	blocked := map[string]bool{}
+	for i, dl := range deadLetters {
+		key := dl.Topic + "/" + string(dl.PayloadKey)
+		switch {
+		case s.now().Sub(claimedAt) > claim.Lease/2:
+		case blocked[key]:
+			failed[i] = true
+		default:
+			if publishErr := publish(dl); publishErr != nil {
+				if err == nil {
+					err = publishErr
+				}
+				failed[i] = true
+				blocked[key] = len(dl.PayloadKey) > 0
+				continue
+			}
+			published[i] = true
+		}
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	now := s.now()
+	var n int
+	for i, r := range claimed {
+		switch {
+		case published[i]:
+			r.deadLetterPublishedAt = now
+			r.deadLetterPublishAfter = time.Time{}
+			n++
+		case failed[i]:
+			r.deadLetterPublishAfter = now.Add(claim.Backoff)
+		default:
+			r.deadLetterPublishAfter = time.Time{}
+		}
+	}
+
+	return n, err
+}
+
+func (s *Store) RenewLease(ctx context.Context, retries []model.Retry, claim store.Claim) error {
+	s.Lock()
+	defer s.Unlock()
+
+	expiresAt := s.now().Add(claim.Lease)
+	for _, retry := range retries {
+		if r := s.find(retry.ID); r != nil && r.batchID != 0 && !r.successful && r.workerID == claim.WorkerID {
+			r.leaseExpiresAt = expiresAt
+		}
+	}
+
+	return nil
+}
+
+func (s *Store) ReleaseRetries(ctx context.Context, retries []model.Retry) error {
+	s.Lock()
+	defer s.Unlock()
+
+	for _, retry := range retries {
+		if r := s.find(retry.ID); r != nil && !r.successful {
+			r.batchID = 0
+			r.workerID = ""
+			r.leaseExpiresAt = time.Time{}
+		}
+	}
+
+	return nil
+}
+
+func (s *Store) add(f failuremodel.Failure, attempts uint8, parked bool) error {
+	headers, err := model.EncodeHeaders(f.MessageHeaders)
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.nextID++
+	r := &record{
+		retry: model.Retry{
+			ID:             s.nextID,
+			Topic:          f.Topic,
+			Payload:        f.Message,
+			PayloadHeaders: headers,
+			PayloadKey:     f.MessageKey,
+			KafkaOffset:    f.KafkaOffset,
+			KafkaPartition: f.KafkaPartition,
+			KafkaTimestamp: f.KafkaTimestamp,
+			Attempts:       attempts,
+			ContentType:    f.ContentType(),
+		},
+		key:       string(f.MessageKey),
+		parked:    parked,
+		createdAt: s.now(),
+		updatedAt: s.now(),
+	}
+	// parked messages have not been processed yet, so they have no attempts or error
+	if !parked {
+		r.lastError = f.Reason
+		r.recordAttempt()
+	}
+	s.retries = append(s.retries, r)
+
+	return nil
+}
+
+// claim assigns up to the batch size of retries that match to a new batch, skipping any that are already
+// in a batch unless its lease has expired.
+func (s *Store) claim(now time.Time, claim store.Claim, match func(r *record) bool) []model.Retry {
+	s.nextBatchID++
+
+	var retries []model.Retry
+	for _, r := range s.retries {
+		if len(retries) == claim.BatchSize {
+			break
+		}
+
+		claimable := r.batchID == 0 || r.leaseExpiresAt.Before(now)
+		if !claimable || !match(r) {
+			continue
+		}
+
+		r.batchID = s.nextBatchID
+		r.workerID = claim.WorkerID
+		r.leaseExpiresAt = now.Add(claim.Lease)
+		r.retryStartedAt = now
+		retries = append(retries, r.retry)
+	}
+
+	return retries
+}
+
+// hasPending returns true if a retry for the key has neither succeeded nor been dead-lettered, only
+// considering retries that are not parked if excludeParked is set.
+func (s *Store) hasPending(topic, key string, excludeParked bool) bool {
+	for _, r := range s.retries {
+		if r.retry.Topic != topic || r.key != key || (excludeParked && r.parked) {
+			continue
+		}
+		if !r.successful && !r.deadlettered {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Store) firstParked(topic, key string) *record {
+	for _, r := range s.retries {
+		if r.retry.Topic == topic && r.key == key && r.parked {
+			return r
+		}
+	}
+	return nil
+}
+
+// find returns the record of the retry, or nil if it has been deleted.
+func (s *Store) find(id int64) *record {
+	for _, r := range s.retries {
+		if r.retry.ID == id {
+			return r
+		}
+	}
+	return nil
+}
+
+// recordAttempt adds the latest attempt at the retry to its history, from the state it was just updated to.
+func (r *record) recordAttempt() {
+	finishedAt := r.retryFinishedAt
+	if finishedAt.IsZero() {
+		finishedAt = r.createdAt
+	}
+	r.attempts = append(r.attempts, store.Attempt{
+		RetryID:    r.retry.ID,
+		Attempt:    r.retry.Attempts - 1,
+		StartedAt:  r.retryStartedAt,
+		FinishedAt: finishedAt,
+		Successful: r.successful,
+		Error:      r.lastError,
+		WorkerID:   r.workerID,
+	})
+}
+
+// expired returns true if the retry is selected by the expiry.
+func (r *record) expired(e store.Expiry) bool {
+	if r.updatedAt.After(e.OlderThan) {
+		return false
+	}
+
+	if e.Topic != "" && r.retry.Topic != e.Topic {
+		return false
+	}
+	if e.Topic == "" {
+		for _, t := range e.ExceptTopics {
+			if r.retry.Topic == t {
+				return false
+			}
+		}
+	}
+
+	switch e.State {
+	case store.StateSuccessful:
+		return r.successful
+	case store.StateDeadlettered:
+		return r.deadlettered && !r.successful && !r.deadLetterPublishedAt.IsZero()
+	case store.StateErrored:
+		return r.errored && !r.deadlettered && !r.successful && !r.parked && r.batchID == 0
+	default:
+		return false
+	}
+}
@@ -0,0 +1,319 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+
+	"github.com/revdaalex/kafka-consumer-go/config"
+	failuremodel "github.com/revdaalex/kafka-consumer-go/data/failure/model"
+	"github.com/revdaalex/kafka-consumer-go/data/retry"
+	"github.com/revdaalex/kafka-consumer-go/data/retry/store"
+)
+
+var (
+	_ store.KeyBlockingStore    = (*Store)(nil)
+	_ store.RetentionStore      = (*Store)(nil)
+	_ store.AttemptHistoryStore = (*Store)(nil)
+	_ store.DeadLetterOutbox    = (*Store)(nil)
+)
+
+var claimForTests = store.Claim{BatchSize: 250, WorkerID: "worker-1", Lease: time.Second * 30}
+
+func TestStore_GetMessagesForRetry(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("it claims a batch of retries once", func(t *testing.T) {
+		s := NewStore()
+		publishForTests(t, s, "product", "SKU-1")
+		publishForTests(t, s, "product", "SKU-2")
+		publishForTests(t, s, "other", "SKU-3")
+
+		batch, _ := s.GetMessagesForRetry(ctx, "product", 1, 0, claimForTests)
+		if len(batch) != 2 || string(batch[0].PayloadKey) != "SKU-1" || string(batch[1].PayloadKey) != "SKU-2" {
+			t.Fatalf("expected the 2 retries for the topic, got %+v", batch)
+		}
+
+		if batch[0].Attempts != 1 || string(batch[0].PayloadHeaders) != `[{"key":"Zm9v","value":"YmFy"}]` {
+			t.Errorf("unexpected retry returned: %+v", batch[0])
+		}
+
+		if again, _ := s.GetMessagesForRetry(ctx, "product", 1, 0, claimForTests); len(again) != 0 {
+			t.Errorf("expected claimed retries not to be returned again, got %d", len(again))
+		}
+	})
+
+	t.Run("it only claims retries once their interval has passed", func(t *testing.T) {
+		s := NewStore()
+		publishForTests(t, s, "product", "SKU-1")
+
+		if batch, _ := s.GetMessagesForRetry(ctx, "product", 1, time.Minute, claimForTests); len(batch) != 0 {
+			t.Fatalf("expected no retries to be claimed, got %d", len(batch))
+		}
+
+		s.now = func() time.Time { return time.Now().Add(time.Minute) }
+		if batch, _ := s.GetMessagesForRetry(ctx, "product", 1, time.Minute, claimForTests); len(batch) != 1 {
+			t.Errorf("expected the retry to be claimed, got %d", len(batch))
+		}
+	})
+
+	t.Run("it reclaims batches once their lease expires", func(t *testing.T) {
+		s := NewStore()
+		publishForTests(t, s, "product", "SKU-1")
+		s.GetMessagesForRetry(ctx, "product", 1, 0, claimForTests)
+
+		s.now = func() time.Time { return time.Now().Add(claimForTests.Lease + time.Second) }
+		if batch, _ := s.GetMessagesForRetry(ctx, "product", 1, 0, claimForTests); len(batch) != 1 {
+			t.Errorf("expected the retry to be claimed again once its lease expired, got %d", len(batch))
+		}
+	})
+
+	t.Run("it does not reclaim batches while their lease is renewed", func(t *testing.T) {
+		s := NewStore()
+		publishForTests(t, s, "product", "SKU-1")
+		batch, _ := s.GetMessagesForRetry(ctx, "product", 1, 0, claimForTests)
+
+		s.now = func() time.Time { return time.Now().Add(claimForTests.Lease / 2) }
+		if err := s.RenewLease(ctx, batch, claimForTests); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		s.now = func() time.Time { return time.Now().Add(claimForTests.Lease + time.Second) }
+		if again, _ := s.GetMessagesForRetry(ctx, "product", 1, 0, claimForTests); len(again) != 0 {
+			t.Errorf("expected the retry not to be claimed while its lease is renewed, got %d", len(again))
+		}
+	})
+
+	t.Run("it claims released retries again", func(t *testing.T) {
+		s := NewStore()
+		publishForTests(t, s, "product", "SKU-1")
+		batch, _ := s.GetMessagesForRetry(ctx, "product", 1, 0, claimForTests)
+
+		if err := s.ReleaseRetries(ctx, batch); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if again, _ := s.GetMessagesForRetry(ctx, "product", 1, 0, claimForTests); len(again) != 1 {
+			t.Errorf("expected the released retry to be claimed again, got %d", len(again))
+		}
+	})
+
+	t.Run("it claims at most one batch size", func(t *testing.T) {
+		s := NewStore()
+		for i := 0; i < 3; i++ {
+			publishForTests(t, s, "product", "SKU-1")
+		}
+
+		if batch, _ := s.GetMessagesForRetry(ctx, "product", 1, 0, store.Claim{BatchSize: 2, Lease: time.Second}); len(batch) != 2 {
+			t.Errorf("expected 2 retries to be claimed, got %d", len(batch))
+		}
+	})
+}
+
+func TestStore_WithManager(t *testing.T) {
+	ctx := context.Background()
+	cfg, err := config.NewBuilder().
+		SetKafkaHost([]string{"broker1"}).
+		SetKafkaGroup("group").
+		SetSourceTopics([]string{"product"}).
+		SetRetryIntervals([]int{0, 0}).
+		SetRetryStore(NewStore()).
+		Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := cfg.RetryStore.(*Store)
+	m := retry.NewManager(cfg.DBRetries, s)
+	publishForTests(t, s, "product", "SKU-1")
+
+	t.Run("it moves errored retries through the attempts until they are dead-lettered", func(t *testing.T) {
+		for attempt := uint8(1); attempt <= 2; attempt++ {
+			batch, _ := m.GetBatch(ctx, "product", attempt, 0)
+			if len(batch) != 1 {
+				t.Fatalf("expected the retry to be claimed on attempt %d, got %d", attempt, len(batch))
+			}
+
+			if err := m.MarkErrored(ctx, batch[0], errors.New("oops")); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+		}
+
+		if batch, _ := m.GetBatch(ctx, "product", 3, 0); len(batch) != 0 {
+			t.Errorf("expected the dead-lettered retry not to be claimed, got %d", len(batch))
+		}
+
+		if pending, _ := m.HasPendingRetry(ctx, "product", []byte("SKU-1")); pending {
+			t.Error("expected the dead-lettered retry not to be pending")
+		}
+	})
+}
+
+func TestStore_MarkRetrySuccessful(t *testing.T) {
+	ctx := context.Background()
+	s := NewStore()
+	publishForTests(t, s, "product", "SKU-1")
+
+	batch, _ := s.GetMessagesForRetry(ctx, "product", 1, 0, claimForTests)
+	batch[0].Attempts = 2
+	if err := s.MarkRetrySuccessful(ctx, batch[0]); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if pending, _ := s.HasPendingRetryForKey(ctx, "product", []byte("SKU-1")); pending {
+		t.Error("expected the successful retry not to be pending")
+	}
+
+	if batch, _ := s.GetMessagesForRetry(ctx, "product", 2, 0, claimForTests); len(batch) != 0 {
+		t.Errorf("expected the successful retry not to be claimed, got %d", len(batch))
+	}
+}
+
+func TestStore_DeleteSuccessful(t *testing.T) {
+	ctx := context.Background()
+	s := NewStore()
+	publishForTests(t, s, "product", "SKU-1")
+	publishForTests(t, s, "product", "SKU-2")
+
+	batch, _ := s.GetMessagesForRetry(ctx, "product", 1, 0, claimForTests)
+	_ = s.MarkRetrySuccessful(ctx, batch[0])
+
+	_ = s.DeleteSuccessful(ctx, time.Now().Add(-time.Hour))
+	if len(s.retries) != 2 {
+		t.Error("expected a recent successful retry to be kept")
+	}
+
+	_ = s.DeleteSuccessful(ctx, time.Now().Add(time.Second))
+	if len(s.retries) != 1 || s.retries[0].key != "SKU-2" {
+		t.Error("expected only the successful retry to be deleted")
+	}
+}
+
+func TestStore_ExpiredRetries(t *testing.T) {
+	ctx := context.Background()
+	s := NewStore()
+	publishForTests(t, s, "product", "SKU-1")
+	publishForTests(t, s, "product", "SKU-2")
+	publishForTests(t, s, "order", "ORD-1")
+
+	batch, _ := s.GetMessagesForRetry(ctx, "product", 1, 0, claimForTests)
+	_ = s.MarkRetrySuccessful(ctx, batch[0])
+	retry := batch[1]
+	retry.Errored = true
+	_ = s.MarkRetryErrored(ctx, retry, errors.New("oops"))
+	_ = s.ReleaseRetries(ctx, batch)
+
+	expiry := store.Expiry{State: store.StateErrored, OlderThan: time.Now().Add(time.Second), Topic: "product"}
+	expired, _ := s.GetExpiredRetries(ctx, expiry, 10)
+	if len(expired) != 1 || string(expired[0].PayloadKey) != "SKU-2" || expired[0].LastError != "oops" {
+		t.Fatalf("expected only the errored retry to have expired, got %+v", expired)
+	}
+
+	if got, _ := s.GetExpiredRetries(ctx, store.Expiry{State: store.StateSuccessful, OlderThan: time.Now().Add(time.Second), ExceptTopics: []string{"product"}}, 10); len(got) != 0 {
+		t.Errorf("expected no retry of the excepted topic to have expired, got %d", len(got))
+	}
+
+	if deleted, _ := s.DeleteExpiredRetries(ctx, expiry, expired); deleted != 1 || len(s.retries) != 2 {
+		t.Errorf("expected the errored retry to be deleted, deleted %d", deleted)
+	}
+
+	order, _ := s.GetMessagesForRetry(ctx, "order", 1, 0, claimForTests)
+	retry = order[0]
+	retry.Errored, retry.Deadlettered = true, true
+	_ = s.MarkRetryErroredToPublish(ctx, retry, errors.New("oops"))
+	deadlettered := store.Expiry{State: store.StateDeadlettered, OlderThan: time.Now().Add(time.Second)}
+	if got, _ := s.GetExpiredRetries(ctx, deadlettered, 10); len(got) != 0 {
+		t.Errorf("expected a dead letter waiting to be published not to expire, got %d", len(got))
+	}
+}
+
+func TestStore_PublishDeadLetters(t *testing.T) {
+	ctx := context.Background()
+	s := NewStore()
+	publishForTests(t, s, "product", "SKU-1")
+	publishForTests(t, s, "product", "SKU-1")
+	publishForTests(t, s, "product", "SKU-2")
+
+	batch, _ := s.GetMessagesForRetry(ctx, "product", 1, 0, claimForTests)
+	for _, retry := range batch {
+		retry.Errored, retry.Deadlettered = true, true
+		_ = s.MarkRetryErroredToPublish(ctx, retry, errors.New("oops"))
+	}
+
+	claim := store.DeadLetterClaim{Limit: 10, Lease: time.Minute, Backoff: time.Hour}
+	var attempted []int64
+	n, err := s.PublishDeadLetters(ctx, []string{"product"}, claim, func(dl store.DeadLetter) error {
+		attempted = append(attempted, dl.ID)
+		if dl.ID == batch[0].ID {
+			return errors.New("kafka unavailable")
+		}
+		return nil
+	})
+	if err == nil || n != 1 {
+		t.Fatalf("expected 1 dead letter to be published along with the error, got %d (%v)", n, err)
+	}
+	if len(attempted) != 2 || attempted[1] != batch[2].ID {
+		t.Errorf("expected the later dead letter with the key that failed to be skipped, got %v", attempted)
+	}
+
+	// a dead letter with the key that was dead-lettered since is held back too
+	publishForTests(t, s, "product", "SKU-1")
+	later, _ := s.GetMessagesForRetry(ctx, "product", 1, 0, claimForTests)
+	later[0].Errored, later[0].Deadlettered = true, true
+	_ = s.MarkRetryErroredToPublish(ctx, later[0], errors.New("oops"))
+
+	if n, _ := s.PublishDeadLetters(ctx, []string{"product"}, claim, func(store.DeadLetter) error { return nil }); n != 0 {
+		t.Errorf("expected the dead letters behind the one that failed to be backed off, got %d published", n)
+	}
+
+	s.now = func() time.Time { return time.Now().Add(claim.Backoff + time.Second) }
+	if n, _ := s.PublishDeadLetters(ctx, []string{"product"}, claim, func(store.DeadLetter) error { return nil }); n != 3 {
+		t.Errorf("expected the dead letters to be published once backed off, got %d", n)
+	}
+}
+
+func TestStore_ParkedFailures(t *testing.T) {
+	ctx := context.Background()
+	s := NewStore()
+	publishForTests(t, s, "product", "SKU-1")
+
+	for i := 0; i < 2; i++ {
+		if err := s.PublishParkedFailure(ctx, failuremodel.Failure{Topic: "product", MessageKey: []byte("SKU-1")}); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	if released, _ := s.GetReleasedParkedMessages(ctx, "product", claimForTests); len(released) != 0 {
+		t.Fatalf("expected the parked messages to be held back, got %d", len(released))
+	}
+
+	batch, _ := s.GetMessagesForRetry(ctx, "product", 1, 0, claimForTests)
+	_ = s.MarkRetrySuccessful(ctx, batch[0])
+
+	released, _ := s.GetReleasedParkedMessages(ctx, "product", claimForTests)
+	if len(released) != 1 || released[0].ID != 2 || released[0].Attempts != 0 {
+		t.Fatalf("expected only the first parked message to be released, got %+v", released)
+	}
+
+	_ = s.MarkRetrySuccessful(ctx, released[0])
+
+	if released, _ := s.GetReleasedParkedMessages(ctx, "product", claimForTests); len(released) != 1 || released[0].ID != 3 {
+		t.Errorf("expected the next parked message to be released, got %+v", released)
+	}
+}
+
+func publishForTests(t *testing.T, s *Store, topic, key string) {
+	t.Helper()
+
+	err := s.PublishFailure(context.Background(), failuremodel.Failure{
+		Topic:          topic,
+		Message:        []byte(`{}`),
+		MessageKey:     []byte(key),
+		MessageHeaders: []sarama.RecordHeader{{Key: []byte("foo"), Value: []byte("bar")}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,203 @@
+// Package store defines the interface a datastore must implement to hold database retries, so that
+// retries can be kept somewhere other than the built-in Postgres table.
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	failuremodel "github.com/revdaalex/kafka-consumer-go/data/failure/model"
+	"github.com/revdaalex/kafka-consumer-go/data/retry/model"
+)
+
+// Claim describes how a batch of retries is claimed.
+type Claim struct {
+	// BatchSize is the most retries to claim at once.
+	BatchSize int
+	// WorkerID identifies the instance of the consumer claiming the retries, and is recorded against them.
+	WorkerID string
+	// Lease is how long the retries are held for unless the lease is renewed. Once it has expired, the
+	// retries may be claimed again, as the worker holding them is assumed to have stopped.
+	Lease time.Duration
+}
+
+// Store holds retries for messages that failed to be processed. Implementations must be safe for
+// concurrent use, as every retry topic is polled from its own goroutine.
+type Store interface {
+	// GetMessagesForRetry claims and returns a batch of retries for the topic that are on the given attempt
+	// and were last attempted more than interval ago. A claimed retry must not be returned again until it has
+	// been marked successful or errored, or its lease has expired, even to another instance of the consumer.
+	GetMessagesForRetry(ctx context.Context, topic string, sequence uint8, interval time.Duration, claim Claim) ([]model.Retry, error)
+	// RenewLease extends the lease of the claim on retries that are still being processed by its worker.
+	RenewLease(ctx context.Context, retries []model.Retry, claim Claim) error
+	// MarkRetrySuccessful records that the retry was processed successfully.
+	MarkRetrySuccessful(ctx context.Context, retry model.Retry) error
+	// MarkRetryErrored records that the retry failed again, saving its new attempts and whether it
+	// has been dead-lettered, and releases its claim.
+	MarkRetryErrored(ctx context.Context, retry model.Retry, err error) error
+	// ReleaseRetries releases the claim on retries that were returned in a batch but not processed, so that
+	// they can be claimed again straight away, without changing their attempts.
+	ReleaseRetries(ctx context.Context, retries []model.Retry) error
+	// PublishFailure stores a new retry for a message that failed to be processed, with the reason it failed
+	// as its last error.
+	PublishFailure(ctx context.Context, failure failuremodel.Failure) error
+	// DeleteSuccessful removes successful retries last updated before olderThan.
+	DeleteSuccessful(ctx context.Context, olderThan time.Time) error
+}
+
+// KeyBlockingStore is a Store that can also park messages behind an earlier retry for the same key.
+// It is required when blocking retries per key are enabled.
+type KeyBlockingStore interface {
+	Store
+	// PublishParkedFailure stores a message behind an earlier pending retry for the same key.
+	PublishParkedFailure(ctx context.Context, failure failuremodel.Failure) error
+	// HasPendingRetryForKey returns true if a retry or parked message with the key is pending.
+	HasPendingRetryForKey(ctx context.Context, topic string, key []byte) (bool, error)
+	// GetReleasedParkedMessages claims and returns a batch of parked messages that are next in line for their key.
+	GetReleasedParkedMessages(ctx context.Context, topic string, claim Claim) ([]model.Retry, error)
+}
+
+// Attempt is an attempt at processing a retry.
+type Attempt struct {
+	RetryID int64
+	// Attempt is 0 for the first time the message was processed, and the sequence of the retry after that.
+	Attempt uint8
+	// StartedAt is when the retry was claimed for the attempt, which is zero for the first attempt.
+	StartedAt  time.Time
+	FinishedAt time.Time
+	Successful bool
+	Error      string
+	// WorkerID identifies the worker that claimed the retry, which is empty for the first attempt.
+	WorkerID string
+}
+
+// AttemptHistoryStore is a Store that keeps a history of every attempt at processing a retry, starting with
+// the failure that it was published for. Attempts are recorded when a retry is published or marked successful
+// or errored, and are removed along with their retry.
+type AttemptHistoryStore interface {
+	Store
+	// GetAttempts returns the attempts at processing the retry, in the order they were made.
+	GetAttempts(ctx context.Context, retryID int64) ([]Attempt, error)
+}
+
+// DeadLetter is a retry that has been dead-lettered, along with the rest of its state.
+type DeadLetter struct {
+	model.Retry
+	LastError string
+	CreatedAt time.Time
+	// DeadletteredAt is when the retry failed its last attempt
+	DeadletteredAt time.Time
+}
+
+// DeadLetterOutbox is a Store that can mark retries as waiting to be published in the same write that dead-letters
+// them, so that dead letters can be published from the store once they have been committed. It is required to
+// publish dead letters to Kafka.
+type DeadLetterOutbox interface {
+	Store
+	// MarkRetryErroredToPublish records that the retry failed again like MarkRetryErrored, but leaves it waiting to
+	// be published if it has been dead-lettered. It is used instead of MarkRetryErrored while dead letters are
+	// published, as the dead letters marked by MarkRetryErrored are never published.
+	MarkRetryErroredToPublish(ctx context.Context, retry model.Retry, err error) error
+	// PublishDeadLetters claims up to claim.Limit dead letters of the topics that have not been published, oldest
+	// first, and calls publish with each in turn, without holding a transaction or lock while it does. A dead letter
+	// that could not be published is not claimed again until its backoff has passed, and neither are the later
+	// dead letters with the same key, so that they stay in order. The dead letters that were published are marked
+	// as such, the claim on the rest is released once half of the lease has passed, and how many were published is
+	// returned along with the first error. A claimed dead letter must not be claimed again until it has been
+	// published, its claim released or its lease has expired, even by another instance.
+	PublishDeadLetters(ctx context.Context, topics []string, claim DeadLetterClaim, publish func(DeadLetter) error) (int, error)
+}
+
+// DeadLetterClaim is how dead letters are claimed to be published.
+type DeadLetterClaim struct {
+	// Limit is the most dead letters that are claimed at once.
+	Limit int
+	// Lease is how long the dead letters are held for while they are published. Once it has expired, they may be
+	// claimed again, as the instance holding them is assumed to have stopped.
+	Lease time.Duration
+	// Backoff is how long a dead letter that could not be published waits before it is claimed again.
+	Backoff time.Duration
+}
+
+// RetryState is a state that retries are removed in by maintenance, once they have been kept for the
+// retention period of the state.
+type RetryState string
+
+const (
+	// StateSuccessful retries were processed successfully.
+	StateSuccessful RetryState = "successful"
+	// StateDeadlettered retries failed on every attempt.
+	StateDeadlettered RetryState = "deadlettered"
+	// StateErrored retries have failed and are waiting for their next attempt. They only expire if they are
+	// not attempted again, e.g. because their topic is no longer consumed.
+	StateErrored RetryState = "errored"
+)
+
+// Expiry selects the retries in a state that were last updated before a time.
+type Expiry struct {
+	State     RetryState
+	OlderThan time.Time
+	// Topic limits the expiry to the retries of a topic. If it is empty, the retries of every topic
+	// except ExceptTopics are selected.
+	Topic        string
+	ExceptTopics []string
+}
+
+// ExpiredRetry is a retry selected by an Expiry, along with the rest of its state.
+type ExpiredRetry struct {
+	model.Retry
+	State     RetryState
+	LastError string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// RetentionStore is a Store that can remove retries in any state once they have expired. It is required to
+// keep retries for other than the default retention periods, or to archive them.
+type RetentionStore interface {
+	Store
+	// GetExpiredRetries returns up to limit retries that match the expiry, oldest first.
+	GetExpiredRetries(ctx context.Context, expiry Expiry, limit int) ([]ExpiredRetry, error)
+	// DeleteExpiredRetries deletes the retries that still match the expiry, and returns how many were deleted.
+	DeleteExpiredRetries(ctx context.Context, expiry Expiry, retries []ExpiredRetry) (int64, error)
+}
+
+// Archiver keeps a copy of expired retries before maintenance deletes them. A retry may be archived more than
+// once if deleting it fails, so archivers should ignore retries that they already hold where they can.
+type Archiver interface {
+	ArchiveRetries(ctx context.Context, retries []ExpiredRetry) error
+}
+
+// ErrPartitionPending is returned when dropping a partition that holds retries that are still pending.
+var ErrPartitionPending = errors.New("retry store: the partition holds pending retries")
+
+// Partition holds the retries created from From until To.
+type Partition struct {
+	Name string
+	// From is zero for a partition that holds every retry created before To
+	From time.Time
+	To   time.Time
+}
+
+// RetryCount is the number of retries of a topic in a state.
+type RetryCount struct {
+	Topic   string
+	State   RetryState
+	Retries int64
+}
+
+// PartitionedStore is a Store that keeps retries in partitions by when they were created. Maintenance creates
+// partitions ahead of time, and drops whole partitions once they have expired rather than deleting retries.
+type PartitionedStore interface {
+	Store
+	// CreatePartitions creates the missing partitions of the interval from the current one until ahead of now.
+	CreatePartitions(ctx context.Context, interval, ahead time.Duration) error
+	// GetExpiredPartitions returns the partitions that ended longer than retention ago and hold no pending retries.
+	GetExpiredPartitions(ctx context.Context, retention time.Duration) ([]Partition, error)
+	// GetPartitionRetries returns up to limit retries of the partition with an ID after afterID, in order of ID.
+	GetPartitionRetries(ctx context.Context, p Partition, afterID int64, limit int) ([]ExpiredRetry, error)
+	// DropPartition drops the partition and returns how many retries it held. It returns ErrPartitionPending
+	// if a retry in the partition became pending again, e.g. because it was requeued.
+	DropPartition(ctx context.Context, p Partition) ([]RetryCount, error)
+}
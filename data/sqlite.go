@@ -0,0 +1,28 @@
+package data
+
+import (
+	"database/sql"
+	"fmt"
+
+	_ "modernc.org/sqlite"
+)
+
+// DriverSQLite is the database driver for keeping retries in a SQLite database file.
+const DriverSQLite = "sqlite"
+
+// NewSQLiteDB opens the SQLite database file at path, creating it if it does not exist. Only one
+// connection is opened, as SQLite allows a single writer at a time and this serialises batch claims.
+func NewSQLiteDB(path string) (*sql.DB, error) {
+	db, err := sql.Open(DriverSQLite, path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open the SQLite database: %w", err)
+	}
+
+	db.SetMaxOpenConns(1)
+
+	if err = db.Ping(); err != nil {
+		return nil, fmt.Errorf("unable to open the SQLite database: %w", err)
+	}
+
+	return db, nil
+}
@@ -0,0 +1,187 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+
+	"github.com/revdaalex/kafka-consumer-go/config"
+	"github.com/revdaalex/kafka-consumer-go/data/retry/model"
+	"github.com/revdaalex/kafka-consumer-go/data/retry/store"
+	"github.com/revdaalex/kafka-consumer-go/log"
+)
+
+// the headers that dead letters are published to Kafka with, along with the headers of the original message
+const (
+	deadLetterRetryIDHeader        = "RetryID"
+	deadLetterAttemptsHeader       = "RetryAttempts"
+	deadLetterLastErrorHeader      = "RetryLastError"
+	deadLetterTopicHeader          = "OriginalTopic"
+	deadLetterPartitionHeader      = "OriginalPartition"
+	deadLetterOffsetHeader         = "OriginalOffset"
+	deadLetterDeadletteredAtHeader = "DeadletteredAt"
+)
+
+// deadLetterOutbox holds the DB retries that have been dead-lettered but not published to Kafka yet.
+type deadLetterOutbox interface {
+	PublishDeadLetters(ctx context.Context, topics []string, publish func(store.DeadLetter) error) (int, error)
+}
+
+// dbDeadLetterProducer publishes dead-lettered DB retries to the deadLetter topic of their source topic. The
+// retries table is its outbox: retries are only published once the transaction that dead-lettered them has been
+// committed, and are claimed before they are published and marked as published after, so each is only published
+// once unless the consumer stops between publishing and marking it.
+type dbDeadLetterProducer struct {
+	producer sarama.SyncProducer
+	outbox   deadLetterOutbox
+	cfg      *config.Config
+	logger   log.Logger
+}
+
+func newDBDeadLetterProducerWithDefaults(cfg *config.Config, outbox deadLetterOutbox, logger log.Logger) (*dbDeadLetterProducer, error) {
+	if logger == nil {
+		logger = log.NullLogger{}
+	}
+
+	var sp sarama.SyncProducer
+	var err error
+
+	scfg := newDeadLetterProducerSaramaConfig(cfg)
+	for i := 0; i < maxConnectionAttempts; i++ {
+		sp, err = sarama.NewSyncProducer(cfg.RetryKafka.Host, scfg)
+		if err == nil {
+			break
+		}
+
+		// the cluster may be temporarily unreachable so if we see ErrOutOfBrokers we continue to the
+		// next iteration to make another attempt to connect
+		if !errors.Is(err, sarama.ErrOutOfBrokers) {
+			return nil, fmt.Errorf("error occurred creating Kafka producer for DB dead letters: %w", err)
+		}
+
+		logger.Info("Kafka cluster is not reachable, retrying...")
+		time.Sleep(connectionInterval)
+	}
+
+	return newDBDeadLetterProducer(sp, outbox, cfg, logger), nil
+}
+
+// newDeadLetterProducerSaramaConfig returns the sarama config of the dead letter producer, with idempotence
+// enabled so that sarama's own retries cannot duplicate dead letters.
+func newDeadLetterProducerSaramaConfig(cfg *config.Config) *sarama.Config {
+	scfg := newRetryProducerSaramaConfig(cfg)
+
+	scfg.Producer.Idempotent = true
+	scfg.Producer.RequiredAcks = sarama.WaitForAll
+	scfg.Net.MaxOpenRequests = 1
+	scfg.Producer.Return.Successes = true
+
+	return scfg
+}
+
+func newDBDeadLetterProducer(sp sarama.SyncProducer, outbox deadLetterOutbox, cfg *config.Config, logger log.Logger) *dbDeadLetterProducer {
+	if logger == nil {
+		logger = log.NullLogger{}
+	}
+
+	return &dbDeadLetterProducer{
+		producer: sp,
+		outbox:   outbox,
+		cfg:      cfg,
+		logger:   logger,
+	}
+}
+
+// listenForDeadLetters publishes the dead letters of the main topics in the outbox every poll interval, until ctx
+// is done.
+func (p *dbDeadLetterProducer) listenForDeadLetters(ctx context.Context, wg *sync.WaitGroup) {
+	p.logger.Info("starting Kafka producer for DB dead letters")
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer func() {
+			if err := p.producer.Close(); err != nil {
+				p.logger.Error("error occurred closing Kafka producer for DB dead letters")
+			}
+		}()
+
+		timer := time.NewTimer(0)
+		for {
+			select {
+			case <-timer.C:
+				p.publishDeadLetters(ctx)
+				timer.Reset(dbRetryPollInterval)
+			case <-ctx.Done():
+				if !timer.Stop() {
+					<-timer.C
+				}
+				return
+			}
+		}
+	}()
+}
+
+func (p *dbDeadLetterProducer) publishDeadLetters(ctx context.Context) {
+	n, err := p.outbox.PublishDeadLetters(ctx, p.cfg.MainTopics(), p.publishDeadLetter)
+	if n > 0 {
+		p.logger.Debugf("published %d DB dead letters to Kafka", n)
+	}
+	if err != nil {
+		p.logger.Errorf("error occurred publishing DB dead letters to Kafka: %s", err)
+	}
+}
+
+func (p *dbDeadLetterProducer) publishDeadLetter(dl store.DeadLetter) error {
+	topic := p.cfg.DeadLetterTopic(dl.Topic)
+	if _, _, err := p.producer.SendMessage(newDeadLetterProducerMessage(topic, dl)); err != nil {
+		return fmt.Errorf("error publishing dead letter %d to Kafka topic '%s': %w", dl.ID, topic, err)
+	}
+	return nil
+}
+
+// newDeadLetterProducerMessage creates the message that publishes the dead letter to the deadLetter topic, with
+// the headers of the original message followed by the metadata of the retry.
+func newDeadLetterProducerMessage(topic string, dl store.DeadLetter) *sarama.ProducerMessage {
+	// headers that cannot be decoded are left out, as they are when the retry is handled
+	original, _ := model.DecodeHeaders(dl.PayloadHeaders)
+
+	var headers []sarama.RecordHeader
+	for _, h := range original {
+		// the time a message is next retried at does not apply to a dead letter
+		if string(h.Key) != nextTimeRetry {
+			headers = append(headers, *h)
+		}
+	}
+	for _, h := range [][2]string{
+		{deadLetterRetryIDHeader, strconv.FormatInt(dl.ID, 10)},
+		{deadLetterAttemptsHeader, strconv.Itoa(int(dl.Attempts))},
+		{deadLetterLastErrorHeader, dl.LastError},
+		{deadLetterTopicHeader, dl.Topic},
+		{deadLetterPartitionHeader, strconv.Itoa(int(dl.KafkaPartition))},
+		{deadLetterOffsetHeader, strconv.FormatInt(dl.KafkaOffset, 10)},
+		{deadLetterDeadletteredAtHeader, dl.DeadletteredAt.UTC().Format(time.RFC3339)},
+	} {
+		headers = append(headers, sarama.RecordHeader{Key: []byte(h[0]), Value: []byte(h[1])})
+	}
+
+	msg := &sarama.ProducerMessage{
+		Topic:   topic,
+		Value:   sarama.ByteEncoder(dl.Payload),
+		Headers: headers,
+		// the partition is only used by the manual partitioner, when keeping the retry partition
+		Partition: dl.KafkaPartition,
+	}
+
+	// keyless messages are stored with an empty key, which is left unset so that they are still spread over the partitions
+	if len(dl.PayloadKey) > 0 {
+		msg.Key = sarama.ByteEncoder(dl.PayloadKey)
+	}
+
+	return msg
+}
@@ -0,0 +1,133 @@
+package consumer
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/go-test/deep"
+
+	"github.com/revdaalex/kafka-consumer-go/data/retry/model"
+	"github.com/revdaalex/kafka-consumer-go/data/retry/store"
+	"github.com/revdaalex/kafka-consumer-go/log"
+	"github.com/revdaalex/kafka-consumer-go/test/saramatest"
+)
+
+func TestNewDeadLetterProducerMessage(t *testing.T) {
+	deadletteredAt := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	dl := store.DeadLetter{
+		Retry: model.Retry{
+			ID:             42,
+			Topic:          "product",
+			Payload:        []byte(`{"sku":"SKU-1"}`),
+			PayloadHeaders: []byte(`[{"key":"dHJhY2U=","value":"YQ=="},{"key":"TmV4dFRpbWVSZXRyeQ==","value":"MQ=="}]`),
+			PayloadKey:     []byte("SKU-1"),
+			KafkaOffset:    100,
+			KafkaPartition: 3,
+			Attempts:       4,
+		},
+		LastError:      "retry failed",
+		DeadletteredAt: deadletteredAt,
+	}
+
+	msg := newDeadLetterProducerMessage("deadLetter.kafkaGroup.product", dl)
+
+	if msg.Topic != "deadLetter.kafkaGroup.product" || msg.Partition != 3 {
+		t.Errorf("unexpected message: %+v", msg)
+	}
+	if diff := deep.Equal(sarama.ByteEncoder("SKU-1"), msg.Key); diff != nil {
+		t.Error(diff)
+	}
+
+	exp := []sarama.RecordHeader{
+		{Key: []byte("trace"), Value: []byte("a")},
+		{Key: []byte(deadLetterRetryIDHeader), Value: []byte("42")},
+		{Key: []byte(deadLetterAttemptsHeader), Value: []byte("4")},
+		{Key: []byte(deadLetterLastErrorHeader), Value: []byte("retry failed")},
+		{Key: []byte(deadLetterTopicHeader), Value: []byte("product")},
+		{Key: []byte(deadLetterPartitionHeader), Value: []byte("3")},
+		{Key: []byte(deadLetterOffsetHeader), Value: []byte("100")},
+		{Key: []byte(deadLetterDeadletteredAtHeader), Value: []byte("2026-10-18T12:00:00Z")},
+	}
+	if diff := deep.Equal(exp, msg.Headers); diff != nil {
+		t.Error(diff)
+	}
+
+	t.Run("a keyless message is published without a key", func(t *testing.T) {
+		dl.PayloadKey = []byte{}
+		if msg := newDeadLetterProducerMessage("deadLetter.kafkaGroup.product", dl); msg.Key != nil {
+			t.Errorf("expected no key, got %v", msg.Key)
+		}
+	})
+}
+
+func TestDBDeadLetterProducer_ListenForDeadLetters(t *testing.T) {
+	deadLetters := []store.DeadLetter{
+		{Retry: model.Retry{ID: 1, Topic: "product", Payload: []byte("hello")}},
+		{Retry: model.Retry{ID: 2, Topic: "product", Payload: []byte("world")}},
+	}
+
+	t.Run("dead letters are published to the deadLetter topic", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		sp := saramatest.NewMockSyncProducer()
+		outbox := &mockDeadLetterOutbox{deadLetters: deadLetters}
+		wg := &sync.WaitGroup{}
+
+		newDBDeadLetterProducer(sp, outbox, newTestConfig(), log.NullLogger{}).listenForDeadLetters(ctx, wg)
+		time.Sleep(time.Millisecond * 5)
+		cancel()
+		wg.Wait()
+
+		var sent []sarama.Encoder
+		for _, msg := range sp.GetMessagesSent("deadLetter.kafkaGroup.product") {
+			sent = append(sent, msg.Value)
+		}
+		if diff := deep.Equal([]sarama.Encoder{sarama.ByteEncoder("hello"), sarama.ByteEncoder("world")}, sent); diff != nil {
+			t.Error(diff)
+		}
+		if diff := deep.Equal([]string{"product"}, outbox.topics); diff != nil {
+			t.Error(diff)
+		}
+		if outbox.published != 2 {
+			t.Errorf("expected both dead letters to be marked as published, got %d", outbox.published)
+		}
+	})
+
+	t.Run("dead letters that could not be sent are not marked as published", func(t *testing.T) {
+		sp := saramatest.NewMockSyncProducer()
+		sp.ReturnErrorOnSend()
+		outbox := &mockDeadLetterOutbox{deadLetters: deadLetters}
+
+		newDBDeadLetterProducer(sp, outbox, newTestConfig(), nil).publishDeadLetters(context.Background())
+
+		if outbox.published != 0 || outbox.err == nil {
+			t.Errorf("expected the send error to stop publishing, got %d published and error %v", outbox.published, outbox.err)
+		}
+	})
+}
+
+// mockDeadLetterOutbox publishes its dead letters in the same way as a store.DeadLetterOutbox, recording how many
+// were published.
+type mockDeadLetterOutbox struct {
+	deadLetters []store.DeadLetter
+	topics      []string
+	published   int
+	err         error
+}
+
+func (o *mockDeadLetterOutbox) PublishDeadLetters(ctx context.Context, topics []string, publish func(store.DeadLetter) error) (int, error) {
+	o.topics = topics
+
+	var n int
+	for _, dl := range o.deadLetters[o.published:] {
+		if o.err = publish(dl); o.err != nil {
+			break
+		}
+		n++
+	}
+	o.published += n
+
+	return n, o.err
+}
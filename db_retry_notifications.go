@@ -0,0 +1,174 @@
+package consumer
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/revdaalex/kafka-consumer-go/config"
+	"github.com/revdaalex/kafka-consumer-go/data/retry"
+)
+
+// notificationResolution is how close together retries must become due to be processed in one go,
+// which keeps the wakeups that a processor has to remember to one per second at most.
+const notificationResolution = time.Second
+
+type retryNotifier interface {
+	Listen(ctx context.Context, notify func(retry.Notification)) error
+}
+
+// retryWakeups routes retry notifications to the DB retry processors that should act on them.
+// Processors must subscribe before notifications start being routed.
+type retryWakeups struct {
+	retries map[string]map[uint8]chan struct{}
+	parked  map[string]chan struct{}
+}
+
+func newRetryWakeups() *retryWakeups {
+	return &retryWakeups{
+		retries: map[string]map[uint8]chan struct{}{},
+		parked:  map[string]chan struct{}{},
+	}
+}
+
+func (w *retryWakeups) subscribeRetries(topic string, sequence uint8) <-chan struct{} {
+	if w.retries[topic] == nil {
+		w.retries[topic] = map[uint8]chan struct{}{}
+	}
+	ch := make(chan struct{}, 100)
+	w.retries[topic][sequence] = ch
+	return ch
+}
+
+func (w *retryWakeups) subscribeParked(topic string) <-chan struct{} {
+	ch := make(chan struct{}, 1)
+	w.parked[topic] = ch
+	return ch
+}
+
+// notify wakes the processor for the retry's next sequence while it is pending, and the parked processor for
+// its topic when a parked message is published or a retry is finished, which may release a parked message.
+// Wakeups are dropped rather than blocking the listener, as the processors still poll now and then.
+func (w *retryWakeups) notify(n retry.Notification) {
+	if ch, ok := w.retries[n.Topic][n.Attempts]; ok && n.Pending {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+
+	if ch, ok := w.parked[n.Topic]; ok && (!n.Pending || n.Attempts == 0) {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// listenForRetryNotifications routes notifications to the subscribed processors until ctx is done,
+// listening again after any error.
+func (cc *kafkaConsumerDbCollection) listenForRetryNotifications(ctx context.Context, wg *sync.WaitGroup) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			err := cc.notifier.Listen(ctx, cc.wakeups.notify)
+			if ctx.Err() != nil {
+				return
+			}
+			cc.logger.Errorf("error listening for DB retry notifications: %s", err)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(connectionInterval):
+			}
+		}
+	}()
+}
+
+// startNotifiedDbRetryProcessor processes retries for the sequence once the interval has passed since
+// they were published or errored, as notified, rather than polling for them.
+func (cc *kafkaConsumerDbCollection) startNotifiedDbRetryProcessor(ctx context.Context, topic string, rc *config.DBTopicRetry, wg *sync.WaitGroup) {
+	wake := cc.wakeups.subscribeRetries(topic, rc.Sequence)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		// retries are due in the order they were notified, as the interval is the same for all of them
+		var due []time.Time
+		next := time.Now()
+		timer := time.NewTimer(0)
+		for {
+			select {
+			case <-wake:
+				at := time.Now().Add(rc.Interval)
+				if n := len(due); n > 0 && at.Sub(due[n-1]) < notificationResolution {
+					continue
+				}
+				due = append(due, at)
+				if at.Before(next) {
+					if !timer.Stop() {
+						<-timer.C
+					}
+					timer.Reset(time.Until(at))
+					next = at
+				}
+			case <-timer.C:
+				more := cc.processMessagesForRetry(ctx, topic, rc)
+
+				now := time.Now()
+				for len(due) > 0 && !due[0].After(now) {
+					due = due[1:]
+				}
+				next = now.Add(dbRetrySafetyPollInterval)
+				if len(due) > 0 && due[0].Before(next) {
+					next = due[0]
+				}
+				// a backlog bigger than a batch is claimed a batch at a time, without waiting for a notification
+				if more {
+					next = now
+				}
+				timer.Reset(time.Until(next))
+			case <-ctx.Done():
+				if !timer.Stop() {
+					<-timer.C
+				}
+				return
+			}
+		}
+	}()
+}
+
+// startNotifiedDbParkedProcessor processes released parked messages when notified that they may have been
+// released, rather than polling for them.
+func (cc *kafkaConsumerDbCollection) startNotifiedDbParkedProcessor(ctx context.Context, topic string, wg *sync.WaitGroup) {
+	wake := cc.wakeups.subscribeParked(topic)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		timer := time.NewTimer(0)
+		for {
+			select {
+			case <-wake:
+				if !timer.Stop() {
+					<-timer.C
+				}
+				timer.Reset(0)
+			case <-timer.C:
+				if cc.processReleasedParkedMessages(ctx, topic) {
+					timer.Reset(0)
+					continue
+				}
+				timer.Reset(dbRetrySafetyPollInterval)
+			case <-ctx.Done():
+				if !timer.Stop() {
+					<-timer.C
+				}
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,149 @@
+package consumer
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+
+	"github.com/revdaalex/kafka-consumer-go/data/failure/model"
+	"github.com/revdaalex/kafka-consumer-go/data/retry"
+	"github.com/revdaalex/kafka-consumer-go/test/saramatest"
+)
+
+type testRetryNotifier struct {
+	notifications []retry.Notification
+}
+
+func (n testRetryNotifier) Listen(ctx context.Context, notify func(retry.Notification)) error {
+	for _, notification := range n.notifications {
+		notify(notification)
+	}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestRetryWakeups_Notify(t *testing.T) {
+	w := newRetryWakeups()
+	seq1 := w.subscribeRetries("product", 1)
+	seq2 := w.subscribeRetries("product", 2)
+	parked := w.subscribeParked("product")
+
+	woken := func(ch <-chan struct{}) bool {
+		select {
+		case <-ch:
+			return true
+		default:
+			return false
+		}
+	}
+
+	t.Run("a published retry wakes the first sequence", func(t *testing.T) {
+		w.notify(retry.Notification{Topic: "product", Attempts: 1, Pending: true})
+		if !woken(seq1) || woken(seq2) || woken(parked) {
+			t.Error("expected only the first sequence to be woken")
+		}
+	})
+
+	t.Run("an errored retry wakes its next sequence", func(t *testing.T) {
+		w.notify(retry.Notification{Topic: "product", Attempts: 2, Pending: true})
+		if woken(seq1) || !woken(seq2) || woken(parked) {
+			t.Error("expected only the second sequence to be woken")
+		}
+	})
+
+	t.Run("a finished retry wakes the parked processor", func(t *testing.T) {
+		w.notify(retry.Notification{Topic: "product", Attempts: 2, Pending: false})
+		if woken(seq1) || woken(seq2) || !woken(parked) {
+			t.Error("expected only the parked processor to be woken")
+		}
+	})
+
+	t.Run("a parked message wakes the parked processor", func(t *testing.T) {
+		w.notify(retry.Notification{Topic: "product", Attempts: 0, Pending: true})
+		if woken(seq1) || woken(seq2) || !woken(parked) {
+			t.Error("expected only the parked processor to be woken")
+		}
+	})
+
+	t.Run("other topics are ignored", func(t *testing.T) {
+		w.notify(retry.Notification{Topic: "other", Attempts: 1, Pending: true})
+		if woken(seq1) || woken(seq2) || woken(parked) {
+			t.Error("expected no processor to be woken")
+		}
+	})
+}
+
+func TestKafkaConsumerDbCollection_StartWithRetryNotifications(t *testing.T) {
+	defaultSafetyPollInterval := dbRetrySafetyPollInterval
+	dbRetrySafetyPollInterval = time.Hour
+
+	defer func() {
+		dbRetrySafetyPollInterval = defaultSafetyPollInterval
+	}()
+
+	var mu sync.Mutex
+	handled := 0
+	col, repo := testKafkaConsumerDbCollection(saramatest.NewMockConsumerGroup(), func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		mu.Lock()
+		defer mu.Unlock()
+		handled++
+		return nil
+	}, false)
+	repo.recvdFailures["product"] = []model.Failure{{Topic: "product", Message: []byte(`{}`)}}
+	col.setRetryNotifier(testRetryNotifier{notifications: []retry.Notification{{Topic: "product", Attempts: 1, Pending: true}}})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	var wg sync.WaitGroup
+	if err := col.start(ctx, &wg); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	// once when the processor starts, and once when the notified retry is due, without polling in between
+	if handled != 2 {
+		t.Errorf("expected the retry to be handled twice, but was handled %d times", handled)
+	}
+}
+
+func TestKafkaConsumerDbCollection_StartWithRetryNotifications_FullBatch(t *testing.T) {
+	defaultSafetyPollInterval := dbRetrySafetyPollInterval
+	dbRetrySafetyPollInterval = time.Hour
+
+	defer func() {
+		dbRetrySafetyPollInterval = defaultSafetyPollInterval
+	}()
+
+	var mu sync.Mutex
+	handled := 0
+	cfg := newTestConfig()
+	cfg.DBRetries["product"][0].BatchSize = 1
+	col, repo := testKafkaConsumerDbCollectionWithConfig(cfg, saramatest.NewMockConsumerGroup(), func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		mu.Lock()
+		defer mu.Unlock()
+		handled++
+		return nil
+	}, false)
+	repo.recvdFailures["product"] = []model.Failure{{Topic: "product", Message: []byte(`{}`)}}
+	col.setRetryNotifier(testRetryNotifier{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	var wg sync.WaitGroup
+	if err := col.start(ctx, &wg); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	// the mock claims a full batch every time, so the processor keeps claiming without a notification or a poll
+	if handled < 3 {
+		t.Errorf("expected full batches to be claimed one after another, but the retry was handled %d times", handled)
+	}
+}
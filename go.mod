@@ -7,6 +7,7 @@ require (
 	github.com/IBM/sarama v1.42.1
 	github.com/containerd/containerd v1.6.0 // indirect
 	github.com/docker/distribution v2.8.0+incompatible // indirect
+	github.com/go-sql-driver/mysql v1.5.0
 	github.com/go-test/deep v1.0.8
 	github.com/golang-migrate/migrate/v4 v4.15.1
 	github.com/google/uuid v1.3.0
@@ -16,4 +17,5 @@ require (
 	github.com/prometheus/client_golang v1.12.1
 	go.uber.org/atomic v1.9.0 // indirect
 	golang.org/x/tools v0.6.0
+	modernc.org/sqlite v1.10.6
 )
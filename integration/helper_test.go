@@ -50,7 +50,7 @@ func initKafkaProducer(srmcfg *sarama.Config) {
 	var err error
 	attempts := 10
 	for {
-		producer, err = sarama.NewSyncProducer(cfg.Host, srmcfg)
+		producer, err = sarama.NewSyncProducer(cfg.Kafka.Host, srmcfg)
 		attempts--
 		if attempts == 0 {
 			break
@@ -127,12 +127,12 @@ func consumeFromKafkaUsingDbRetriesUntil(done func(chan<- bool), handler consume
 
 func dbRetryWithEventId(eventId string) (*Retry, error) {
 	row := db.QueryRow(
-		`SELECT id, topic, payload_json, payload_headers, payload_key, kafka_offset, kafka_partition, attempts, deadlettered, successful, errored, last_error FROM kafka_consumer_retries WHERE payload_json::text LIKE $1`,
+		`SELECT id, topic, payload, payload_headers, payload_key, kafka_offset, kafka_partition, attempts, deadlettered, successful, errored, last_error FROM kafka_consumer_retries WHERE payload_json::text LIKE $1`,
 		fmt.Sprintf(`%%"event_id":"%s"%%`, eventId),
 	)
 
 	retry := &Retry{}
-	err := row.Scan(&retry.ID, &retry.Topic, &retry.PayloadJSON, &retry.PayloadHeaders, &retry.PayloadKey, &retry.KafkaOffset, &retry.KafkaPartition, &retry.Attempts, &retry.Deadlettered, &retry.Successful, &retry.Errored, &retry.LastError)
+	err := row.Scan(&retry.ID, &retry.Topic, &retry.Payload, &retry.PayloadHeaders, &retry.PayloadKey, &retry.KafkaOffset, &retry.KafkaPartition, &retry.Attempts, &retry.Deadlettered, &retry.Successful, &retry.Errored, &retry.LastError)
 	if err != nil && err != sql.ErrNoRows {
 		return nil, fmt.Errorf("error trying to fetch db retry in integration tests: %w", err)
 	}
@@ -154,7 +154,7 @@ func insertDeadletteredProcessedDbRetry(updatedAt time.Time) {
 
 func insertDbRetry(successful, errored, deadlettered bool, updatedAt time.Time) {
 	_, err := db.Exec(
-		`INSERT INTO kafka_consumer_retries(topic, payload_json, payload_headers, kafka_offset, kafka_partition, payload_key, successful, errored, deadlettered, updated_at) VALUES('foo', '{}', '{}', 0, 0, '', $1, $2, $3, $4);`,
+		`INSERT INTO kafka_consumer_retries(topic, payload, payload_json, payload_headers, kafka_offset, kafka_partition, payload_key, successful, errored, deadlettered, updated_at) VALUES('foo', '{}', '{}', '[]', 0, 0, '', $1, $2, $3, $4);`,
 		successful,
 		errored,
 		deadlettered,
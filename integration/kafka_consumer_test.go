@@ -151,8 +151,8 @@ func testExpectedRetryModelBase(eventId string, id, offset int64) *Retry {
 		Retry: model.Retry{
 			ID:             id,
 			Topic:          "mainTopic",
-			PayloadJSON:    []byte(`{"type":"","data":{},"event_id":"` + eventId + `"}`),
-			PayloadHeaders: []byte(`{"foo":"bar"}`),
+			Payload:        []byte(`{"type":"","data":{},"event_id":"` + eventId + `"}`),
+			PayloadHeaders: []byte(`[{"key":"Zm9v","value":"YmFy"}]`),
 			PayloadKey:     []byte(`message-key`),
 			KafkaOffset:    offset,
 			KafkaPartition: 0,
@@ -0,0 +1,240 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+
+	"github.com/revdaalex/kafka-consumer-go/config"
+	"github.com/revdaalex/kafka-consumer-go/data/failure/model"
+	"github.com/revdaalex/kafka-consumer-go/log"
+)
+
+const (
+	defaultRetryFlushMessages       = 100
+	defaultRetryFlushFrequency      = time.Millisecond * 100
+	defaultRetryMaxDeliveryAttempts = 3
+)
+
+// kafkaAsyncFailureProducer is a producer that listens for failed push attempts from kafka on fch and
+// then sends them in batches to the next kafka retry topic in the chain, without waiting for each one
+// to be acknowledged. Every message carries its failure, so that delivery reports can be tied back to
+// the message it originated from.
+type kafkaAsyncFailureProducer struct {
+	producer sarama.AsyncProducer
+	fch      <-chan model.Failure
+	logger   log.Logger
+	// queue holds the messages waiting to be sent to the producer, up to maxQueued of them
+	queue     []*sarama.ProducerMessage
+	maxQueued int
+
+	// optional fields managed by setters
+	errorHandler config.RetryPublishErrorHandler
+}
+
+// retryDelivery is set as the metadata of every message sent by the kafkaAsyncFailureProducer.
+type retryDelivery struct {
+	failure model.Failure
+}
+
+func newKafkaAsyncFailureProducerWithDefaults(cfg *config.Config, fch <-chan model.Failure, logger log.Logger) (*kafkaAsyncFailureProducer, error) {
+	if logger == nil {
+		logger = log.NullLogger{}
+	}
+
+	var ap sarama.AsyncProducer
+	var err error
+
+	scfg := newAsyncRetryProducerSaramaConfig(cfg)
+	for i := 0; i < maxConnectionAttempts; i++ {
+		ap, err = sarama.NewAsyncProducer(cfg.RetryKafka.Host, scfg)
+		if err == nil {
+			break
+		}
+
+		// the cluster may be temporarily unreachable so if we see ErrOutOfBrokers we continue to the
+		// next iteration to make another attempt to connect
+		if !errors.Is(err, sarama.ErrOutOfBrokers) {
+			return nil, fmt.Errorf("error occurred creating async Kafka producer for retries: %w", err)
+		}
+
+		logger.Info("Kafka cluster is not reachable, retrying...")
+		time.Sleep(connectionInterval)
+	}
+
+	p := newKafkaAsyncFailureProducer(ap, fch, cfg.AsyncRetryProducer.FlushMessages, logger)
+	p.setErrorHandler(cfg.RetryPublishErrorHandler)
+
+	return p, nil
+}
+
+// newAsyncRetryProducerSaramaConfig returns the sarama config of the retry producer, with batching and
+// idempotence enabled so that sarama's own retries cannot duplicate or reorder messages. Failed deliveries
+// are only retried by sarama, which retries them in place.
+func newAsyncRetryProducerSaramaConfig(cfg *config.Config) *sarama.Config {
+	scfg := newRetryProducerSaramaConfig(cfg)
+
+	scfg.Producer.Idempotent = true
+	scfg.Producer.RequiredAcks = sarama.WaitForAll
+	scfg.Net.MaxOpenRequests = 1
+	scfg.Producer.Return.Successes = true
+	scfg.Producer.Return.Errors = true
+
+	scfg.Producer.Flush.Messages = defaultRetryFlushMessages
+	if cfg.AsyncRetryProducer.FlushMessages > 0 {
+		scfg.Producer.Flush.Messages = cfg.AsyncRetryProducer.FlushMessages
+	}
+
+	scfg.Producer.Flush.Frequency = defaultRetryFlushFrequency
+	if cfg.AsyncRetryProducer.FlushFrequency > 0 {
+		scfg.Producer.Flush.Frequency = cfg.AsyncRetryProducer.FlushFrequency
+	}
+
+	attempts := defaultRetryMaxDeliveryAttempts
+	if cfg.AsyncRetryProducer.MaxDeliveryAttempts > 0 {
+		attempts = cfg.AsyncRetryProducer.MaxDeliveryAttempts
+	}
+	// an idempotent producer must be allowed to retry at least once
+	scfg.Producer.Retry.Max = attempts - 1
+	if scfg.Producer.Retry.Max < 1 {
+		scfg.Producer.Retry.Max = 1
+	}
+
+	return scfg
+}
+
+// newKafkaAsyncFailureProducer creates a producer that queues up to maxQueued messages, which should be the
+// number of messages in a batch.
+func newKafkaAsyncFailureProducer(ap sarama.AsyncProducer, fch <-chan model.Failure, maxQueued int, logger log.Logger) *kafkaAsyncFailureProducer {
+	if logger == nil {
+		logger = log.NullLogger{}
+	}
+
+	if maxQueued <= 0 {
+		maxQueued = defaultRetryFlushMessages
+	}
+
+	return &kafkaAsyncFailureProducer{
+		producer:  ap,
+		fch:       fch,
+		logger:    logger,
+		maxQueued: maxQueued,
+	}
+}
+
+func (p *kafkaAsyncFailureProducer) setErrorHandler(h config.RetryPublishErrorHandler) {
+	p.errorHandler = h
+}
+
+func (p *kafkaAsyncFailureProducer) listenForFailures(ctx context.Context, wg *sync.WaitGroup) {
+	p.logger.Info("starting async Kafka retry producer")
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for {
+			// only send to the producer when there is something queued, and stop taking failures while
+			// the queue is full so that a slow cluster applies backpressure to the consumers
+			var input chan<- *sarama.ProducerMessage
+			var next *sarama.ProducerMessage
+			if len(p.queue) > 0 {
+				input, next = p.producer.Input(), p.queue[0]
+			}
+
+			fch := p.fch
+			if len(p.queue) >= p.maxQueued {
+				fch = nil
+			}
+
+			select {
+			case f := <-fch:
+				p.enqueue(&retryDelivery{failure: f})
+			case input <- next:
+				p.queue = p.queue[1:]
+			case msg := <-p.producer.Successes():
+				p.delivered(msg)
+			case pErr := <-p.producer.Errors():
+				p.deliveryFailed(pErr)
+			case <-ctx.Done():
+				p.close()
+				return
+			}
+		}
+	}()
+}
+
+func (p *kafkaAsyncFailureProducer) enqueue(d *retryDelivery) {
+	msg := newRetryProducerMessage(d.failure)
+	msg.Metadata = d
+	p.queue = append(p.queue, msg)
+}
+
+func (p *kafkaAsyncFailureProducer) delivered(msg *sarama.ProducerMessage) {
+	if d, ok := msg.Metadata.(*retryDelivery); ok {
+		p.logger.Debugf(
+			"published retry to Kafka topic '%s' for message from topic '%s' partition %d offset %d",
+			msg.Topic, d.failure.Topic, d.failure.KafkaPartition, d.failure.KafkaOffset,
+		)
+	}
+}
+
+// deliveryFailed passes the failure to the error handler. Sarama has already retried its delivery in place, so
+// publishing it again would put it behind later failures for the same key.
+func (p *kafkaAsyncFailureProducer) deliveryFailed(pErr *sarama.ProducerError) {
+	if d, ok := pErr.Msg.Metadata.(*retryDelivery); ok {
+		p.giveUp(d.failure, pErr.Err)
+		return
+	}
+	p.logger.Errorf("error occurred publishing retry to Kafka topic '%s': %s", pErr.Msg.Topic, pErr.Err)
+}
+
+func (p *kafkaAsyncFailureProducer) giveUp(f model.Failure, err error) {
+	p.logger.Errorf(
+		"error occurred publishing retry to Kafka topic '%s' for message from topic '%s' partition %d offset %d: %s",
+		f.NextTopic, f.Topic, f.KafkaPartition, f.KafkaOffset, err,
+	)
+
+	if p.errorHandler != nil {
+		p.errorHandler(f, err)
+	}
+}
+
+// close sends the queued failures to the producer, then flushes them and reports their delivery.
+func (p *kafkaAsyncFailureProducer) close() {
+	successes, errs := p.producer.Successes(), p.producer.Errors()
+
+	for len(p.queue) > 0 {
+		select {
+		case p.producer.Input() <- p.queue[0]:
+			p.queue = p.queue[1:]
+		case msg := <-successes:
+			p.delivered(msg)
+		case pErr := <-errs:
+			p.deliveryFailed(pErr)
+		}
+	}
+
+	p.producer.AsyncClose()
+
+	for successes != nil || errs != nil {
+		select {
+		case msg, ok := <-successes:
+			if !ok {
+				successes = nil
+				continue
+			}
+			p.delivered(msg)
+		case pErr, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			p.deliveryFailed(pErr)
+		}
+	}
+}
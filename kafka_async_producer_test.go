@@ -0,0 +1,193 @@
+package consumer
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+
+	"github.com/revdaalex/kafka-consumer-go/config"
+	"github.com/revdaalex/kafka-consumer-go/data/failure/model"
+	"github.com/revdaalex/kafka-consumer-go/log"
+	"github.com/revdaalex/kafka-consumer-go/test/saramatest"
+)
+
+func TestNewAsyncRetryProducerSaramaConfig(t *testing.T) {
+	t.Run("it enables idempotence with the default batching", func(t *testing.T) {
+		scfg := newAsyncRetryProducerSaramaConfig(&config.Config{})
+
+		if err := scfg.Validate(); err != nil {
+			t.Fatalf("expected a valid sarama config, got: %s", err)
+		}
+
+		if !scfg.Producer.Idempotent || scfg.Producer.RequiredAcks != sarama.WaitForAll {
+			t.Error("expected an idempotent producer waiting for all replicas")
+		}
+
+		if scfg.Producer.Flush.Messages != defaultRetryFlushMessages || scfg.Producer.Flush.Frequency != defaultRetryFlushFrequency {
+			t.Error("expected the default batching to be used")
+		}
+
+		if scfg.Producer.Retry.Max != defaultRetryMaxDeliveryAttempts-1 {
+			t.Errorf("expected sarama to retry %d times, got %d", defaultRetryMaxDeliveryAttempts-1, scfg.Producer.Retry.Max)
+		}
+	})
+
+	t.Run("it uses the configured batching", func(t *testing.T) {
+		scfg := newAsyncRetryProducerSaramaConfig(&config.Config{
+			AsyncRetryProducer: config.AsyncRetryProducer{FlushMessages: 10, FlushFrequency: time.Second},
+		})
+
+		if scfg.Producer.Flush.Messages != 10 || scfg.Producer.Flush.Frequency != time.Second {
+			t.Error("expected the configured batching to be used")
+		}
+	})
+
+	t.Run("it retries deliveries in sarama up to the configured attempts", func(t *testing.T) {
+		for attempts, exp := range map[int]int{5: 4, 1: 1} {
+			scfg := newAsyncRetryProducerSaramaConfig(&config.Config{
+				AsyncRetryProducer: config.AsyncRetryProducer{MaxDeliveryAttempts: attempts},
+			})
+
+			if err := scfg.Validate(); err != nil {
+				t.Fatalf("expected a valid sarama config, got: %s", err)
+			}
+
+			if scfg.Producer.Retry.Max != exp {
+				t.Errorf("expected sarama to retry %d times for %d attempts, got %d", exp, attempts, scfg.Producer.Retry.Max)
+			}
+		}
+	})
+}
+
+func TestAsyncFailureProducer_ListenForFailures(t *testing.T) {
+	failure := model.Failure{
+		Reason:         "something bad happened",
+		Topic:          "product",
+		NextTopic:      "retry1.product",
+		Message:        []byte("hello"),
+		MessageKey:     []byte("SKU-123"),
+		KafkaPartition: 2,
+		KafkaOffset:    1001,
+	}
+
+	t.Run("it publishes failures with the failure as metadata", func(t *testing.T) {
+		ap := saramatest.NewMockAsyncProducer()
+		fch := make(chan model.Failure)
+		prod := newKafkaAsyncFailureProducer(ap, fch, 0, log.NullLogger{})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		wg := &sync.WaitGroup{}
+		prod.listenForFailures(ctx, wg)
+
+		fch <- failure
+		cancel()
+		wg.Wait()
+
+		sent := ap.GetMessagesSent("retry1.product")
+		if len(sent) != 1 {
+			t.Fatalf("expected 1 message to be published, but got %d", len(sent))
+		}
+
+		d, ok := sent[0].Metadata.(*retryDelivery)
+		if !ok || d.failure.KafkaOffset != 1001 {
+			t.Errorf("expected the failure to be set as the message metadata, got %+v", sent[0].Metadata)
+		}
+
+		if !ap.WasClosed() {
+			t.Error("expected the producer to be closed")
+		}
+	})
+
+	t.Run("it does not publish failed deliveries again", func(t *testing.T) {
+		ap := saramatest.NewMockAsyncProducer()
+		ap.FailSends(1)
+		fch := make(chan model.Failure)
+		prod := newKafkaAsyncFailureProducer(ap, fch, 0, log.NullLogger{})
+
+		handled := make(chan model.Failure, 1)
+		prod.setErrorHandler(func(f model.Failure, err error) {
+			handled <- f
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		wg := &sync.WaitGroup{}
+		prod.listenForFailures(ctx, wg)
+
+		next := failure
+		next.KafkaOffset = 1002
+		fch <- failure
+		fch <- next
+		waitFor(t, func() bool { return len(ap.GetMessagesSent("retry1.product")) == 1 })
+		cancel()
+		wg.Wait()
+
+		if d := ap.GetMessagesSent("retry1.product")[0].Metadata.(*retryDelivery); d.failure.KafkaOffset != 1002 {
+			t.Errorf("expected only the next failure to be published, but got offset %d", d.failure.KafkaOffset)
+		}
+
+		if len(handled) != 1 {
+			t.Errorf("expected the error handler to be called once, but got %d calls", len(handled))
+		}
+	})
+
+	t.Run("it queues up to the batch size", func(t *testing.T) {
+		if prod := newKafkaAsyncFailureProducer(nil, nil, 0, nil); prod.maxQueued != defaultRetryFlushMessages {
+			t.Errorf("expected the default batch size to be queued, got %d", prod.maxQueued)
+		}
+
+		if prod := newKafkaAsyncFailureProducer(nil, nil, 10, nil); prod.maxQueued != 10 {
+			t.Errorf("expected the configured batch size to be queued, got %d", prod.maxQueued)
+		}
+	})
+
+	t.Run("it passes the failure to the error handler", func(t *testing.T) {
+		ap := saramatest.NewMockAsyncProducer()
+		ap.FailSends(1)
+		fch := make(chan model.Failure)
+		prod := newKafkaAsyncFailureProducer(ap, fch, 0, log.NullLogger{})
+
+		handled := make(chan model.Failure, 1)
+		prod.setErrorHandler(func(f model.Failure, err error) {
+			handled <- f
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		wg := &sync.WaitGroup{}
+		prod.listenForFailures(ctx, wg)
+
+		fch <- failure
+
+		select {
+		case f := <-handled:
+			if f.KafkaOffset != failure.KafkaOffset {
+				t.Errorf("expected the failure for offset %d, but got %d", failure.KafkaOffset, f.KafkaOffset)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("expected the error handler to be called")
+		}
+
+		cancel()
+		wg.Wait()
+
+		if len(ap.GetMessagesSent("retry1.product")) != 0 {
+			t.Error("did not expect the failure to be published")
+		}
+	})
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.After(time.Second)
+	for !cond() {
+		select {
+		case <-deadline:
+			t.Fatal("condition was not met in time")
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
@@ -7,4 +7,4 @@ import (
 	"github.com/revdaalex/kafka-consumer-go/log"
 )
 
-type kafkaConnector func(cfg *config.Config, saramaCfg *sarama.Config, logger log.Logger) (sarama.ConsumerGroup, error)
+type kafkaConnector func(cluster config.KafkaCluster, group string, saramaCfg *sarama.Config, logger log.Logger) (sarama.ConsumerGroup, error)
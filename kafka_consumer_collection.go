@@ -23,6 +23,7 @@ type kafkaConsumerCollection struct {
 	producer       failureProducer
 	handler        sarama.ConsumerGroupHandler
 	saramaCfg      *sarama.Config
+	retrySaramaCfg *sarama.Config
 	logger         log.Logger
 	connectToKafka kafkaConnector
 }
@@ -33,6 +34,7 @@ func newKafkaConsumerCollection(
 	fch chan model.Failure,
 	hm HandlerMap,
 	scfg *sarama.Config,
+	rscfg *sarama.Config,
 	logger log.Logger,
 	connector kafkaConnector,
 ) *kafkaConsumerCollection {
@@ -40,12 +42,20 @@ func newKafkaConsumerCollection(
 		logger = log.NullLogger{}
 	}
 
+	// a producer that can publish failures in a transaction with the offset commit is called by the
+	// consumer directly, instead of over the failure channel
+	handler := newConsumer(fch, cfg, hm, logger)
+	if tp, ok := p.(txnPublisher); ok {
+		handler = newTransactionalConsumer(cfg, hm, tp, logger)
+	}
+
 	return &kafkaConsumerCollection{
 		cfg:            cfg,
 		consumers:      []sarama.ConsumerGroup{},
 		producer:       p,
-		handler:        newConsumer(fch, cfg, hm, logger),
+		handler:        handler,
 		saramaCfg:      scfg,
+		retrySaramaCfg: rscfg,
 		logger:         logger,
 		connectToKafka: connector,
 	}
@@ -57,17 +67,33 @@ func (cc *kafkaConsumerCollection) start(ctx context.Context, wg *sync.WaitGroup
 		return errors.New("no Kafka topics are configured, therefore cannot start consumers")
 	}
 
+	// a single consumer group client is shared by all the topics of a cluster, the delay of each retry
+	// topic is applied per message using the NextTimeRetry header
+	var mainTopics, retryTopics []string
 	for _, t := range topics {
-		if !t.IsMainTopic && len(cc.cfg.RetryHost) > 0 {
-			cc.cfg.Host = cc.cfg.RetryHost
+		if t.IsMainTopic {
+			mainTopics = append(mainTopics, t.Name)
+		} else {
+			retryTopics = append(retryTopics, t.Name)
+		}
+	}
+
+	if len(mainTopics) > 0 {
+		group, err := cc.startConsumerGroup(ctx, wg, cc.cfg.Kafka, cc.saramaCfg, mainTopics)
+		if err != nil {
+			return err
 		}
+		cc.consumers = append(cc.consumers, group)
+	}
 
-		group, err := cc.startConsumerGroup(ctx, wg, t)
+	if len(retryTopics) > 0 {
+		group, err := cc.startConsumerGroup(ctx, wg, cc.cfg.RetryKafka, cc.retrySaramaCfg, retryTopics)
 		if err != nil {
 			return err
 		}
 		cc.consumers = append(cc.consumers, group)
 	}
+
 	cc.producer.listenForFailures(ctx, wg)
 
 	return nil
@@ -82,20 +108,15 @@ func (cc *kafkaConsumerCollection) close() {
 	cc.consumers = []sarama.ConsumerGroup{}
 }
 
-func (cc *kafkaConsumerCollection) startConsumerGroup(ctx context.Context, wg *sync.WaitGroup, topic *config.KafkaTopic) (sarama.ConsumerGroup, error) {
-	cc.logger.Infof("starting Kafka consumer group for '%s'", topic.Name)
+// startConsumerGroup starts a sarama.ConsumerGroup on the given cluster to consume messages for the given topic names
+func (cc *kafkaConsumerCollection) startConsumerGroup(ctx context.Context, wg *sync.WaitGroup, cluster config.KafkaCluster, scfg *sarama.Config, topics []string) (sarama.ConsumerGroup, error) {
+	cc.logger.Infof("starting Kafka consumer group for topics: '%s'", topics)
 
-	cl, err := cc.connectToKafka(cc.cfg, cc.saramaCfg, cc.logger)
+	cl, err := cc.connectToKafka(cluster, cc.cfg.Group, scfg, cc.logger)
 	if err != nil {
 		return nil, err
 	}
 
-	cc.startConsumer(cl, ctx, wg, topic)
-
-	return cl, nil
-}
-
-func (cc *kafkaConsumerCollection) startConsumer(cl sarama.ConsumerGroup, ctx context.Context, wg *sync.WaitGroup, topic *config.KafkaTopic) {
 	go func() {
 		for err := range cl.Errors() {
 			cc.logger.Errorf("error occurred in consumer group Handler: %w", err)
@@ -105,24 +126,29 @@ func (cc *kafkaConsumerCollection) startConsumer(cl sarama.ConsumerGroup, ctx co
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		timer := time.NewTimer(topic.Delay)
 		for {
 			select {
-			case <-timer.C:
-				if err := cl.Consume(ctx, []string{topic.Name}, cc.handler); err != nil {
+			case <-ctx.Done():
+				return
+			default:
+				err := cl.Consume(ctx, topics, cc.handler)
+				if err != nil {
 					cc.logger.Errorf("error when consuming from Kafka: %s", err)
 				}
 				if ctx.Err() != nil {
-					timer.Stop()
 					return
 				}
-				timer.Reset(topic.Delay)
-			case <-ctx.Done():
-				if !timer.Stop() {
-					<-timer.C
+				if err != nil {
+					// the cluster may be unreachable, so wait before consuming again rather than spinning
+					select {
+					case <-ctx.Done():
+						return
+					case <-time.After(connectionInterval):
+					}
 				}
-				return
 			}
 		}
 	}()
+
+	return cl, nil
 }
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -26,6 +27,7 @@ func TestNewCollection(t *testing.T) {
 	fp := newKafkaFailureProducer(saramatest.NewMockSyncProducer(), make(chan model.Failure, 10), nil)
 	fch := make(chan model.Failure)
 	scfg := config.NewSaramaConfig(false, false)
+	rscfg := config.NewSaramaConfig(true, false)
 	l := log.NullLogger{}
 	hm := HandlerMap{}
 
@@ -35,16 +37,26 @@ func TestNewCollection(t *testing.T) {
 		producer:       fp,
 		handler:        newConsumer(fch, cfg, hm, l),
 		saramaCfg:      scfg,
+		retrySaramaCfg: rscfg,
 		logger:         l,
 		connectToKafka: defaultKafkaConnector,
 	}
-	got := newKafkaConsumerCollection(cfg, fp, fch, hm, scfg, nil, defaultKafkaConnector)
+	got := newKafkaConsumerCollection(cfg, fp, fch, hm, scfg, rscfg, nil, defaultKafkaConnector)
 
 	if diff := deep.Equal(exp, got); diff != nil {
 		t.Error(diff)
 	}
 }
 
+func TestNewCollection_WithTxnProducer(t *testing.T) {
+	tp := newKafkaTxnFailureProducer(saramatest.NewMockSyncProducer(), "group", log.NullLogger{})
+	col := newKafkaConsumerCollection(&config.Config{}, tp, make(chan model.Failure), HandlerMap{}, sarama.NewConfig(), sarama.NewConfig(), nil, defaultKafkaConnector)
+
+	if c, ok := col.handler.(*consumer); !ok || c.txnPublisher != tp {
+		t.Error("expected the consumer to publish failures with the transactional producer")
+	}
+}
+
 func TestCollection_Close(t *testing.T) {
 	t.Run("it closes consumers", func(t *testing.T) {
 		t.Parallel()
@@ -104,6 +116,64 @@ func TestCollection_Start(t *testing.T) {
 		wg.Wait()
 	})
 
+	t.Run("connects to the retry cluster for retry topics without changing the config", func(t *testing.T) {
+		t.Parallel()
+		cfg := newTestConfig()
+		cfg.Kafka = config.KafkaCluster{Host: []string{"main:9092"}}
+		cfg.RetryKafka = config.KafkaCluster{Host: []string{"retry:9092"}, TLSEnable: true}
+		col, _, connector := testKafkaConsumerCollectionWithConnector(cfg, saramatest.NewMockConsumerGroup(), nil, false)
+		ctx, cancel := context.WithCancel(context.Background())
+		var wg sync.WaitGroup
+		if err := col.start(ctx, &wg); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		cancel()
+		wg.Wait()
+
+		exp := [][]string{{"main:9092"}, {"retry:9092"}}
+		if diff := deep.Equal(exp, connector.connectedTo); diff != nil {
+			t.Error(diff)
+		}
+
+		if diff := deep.Equal([]string{"main:9092"}, cfg.Kafka.Host); diff != nil {
+			t.Errorf("main cluster config was changed: %v", diff)
+		}
+	})
+
+	t.Run("shares a consumer group between the topics of each cluster", func(t *testing.T) {
+		t.Parallel()
+		cfg := newTestConfig()
+		retryProduct2 := &config.KafkaTopic{Name: "retry2.kafkaGroup.product", Delay: 2, Key: "product"}
+		cfg.ConsumableTopics = append(cfg.ConsumableTopics, retryProduct2)
+		cfg.TopicMap[config.TopicKey(retryProduct2.Name)] = retryProduct2
+
+		mcg := saramatest.NewMockConsumerGroup()
+		col, _, connector := testKafkaConsumerCollectionWithConnector(cfg, mcg, nil, false)
+		ctx, cancel := context.WithCancel(context.Background())
+		var wg sync.WaitGroup
+		if err := col.start(ctx, &wg); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if len(connector.connectedTo) != 2 || len(col.consumers) != 2 {
+			t.Errorf("expected 2 consumer groups, but got %d", len(connector.connectedTo))
+		}
+
+		deadline := time.After(time.Second)
+		for _, topic := range []string{"product", "retry.kafkaGroup.product", "retry2.kafkaGroup.product"} {
+			for mcg.GetTopicConsumeCount(topic) == 0 {
+				select {
+				case <-deadline:
+					t.Fatalf("expected topic '%s' to be consumed, but was not", topic)
+				case <-time.After(time.Millisecond):
+				}
+			}
+		}
+
+		cancel()
+		wg.Wait()
+	})
+
 	t.Run("handles errors on kafka consume", func(t *testing.T) {
 		t.Parallel()
 		mcg := saramatest.NewMockConsumerGroup()
@@ -123,6 +193,25 @@ func TestCollection_Start(t *testing.T) {
 		}
 	})
 
+	t.Run("waits before consuming again after an error", func(t *testing.T) {
+		t.Parallel()
+		cg := &erroringConsumerGroupForTests{MockConsumerGroup: saramatest.NewMockConsumerGroup()}
+		col, _, connector := testKafkaConsumerCollectionWithConnector(newTestConfig(), nil, nil, false)
+		connector.consumerGroup = cg
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+		defer cancel()
+		var wg sync.WaitGroup
+		if err := col.start(ctx, &wg); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+		wg.Wait()
+
+		// one attempt from each consumer group, rather than as many as fit in the timeout
+		if n := atomic.LoadInt32(&cg.consumes); n == 0 || n > 2 {
+			t.Errorf("expected each consumer group to consume once before waiting, got %d attempts", n)
+		}
+	})
+
 	t.Run("successful messages are not retried", func(t *testing.T) {
 		t.Parallel()
 		mcg := saramatest.NewMockConsumerGroup()
@@ -188,12 +277,28 @@ func TestCollection_Start(t *testing.T) {
 }
 
 func testKafkaConsumerCollection(mcg *saramatest.MockConsumerGroup, msgHandler Handler, errorOnConnect bool) (*kafkaConsumerCollection, *mockFailureProducer) {
+	col, mockFp, _ := testKafkaConsumerCollectionWithConnector(newTestConfig(), mcg, msgHandler, errorOnConnect)
+	return col, mockFp
+}
+
+func testKafkaConsumerCollectionWithConnector(cfg *config.Config, mcg *saramatest.MockConsumerGroup, msgHandler Handler, errorOnConnect bool) (*kafkaConsumerCollection, *mockFailureProducer, *testKafkaConnector) {
 	fch := make(chan model.Failure, 10)
 
 	hm := HandlerMap{"product": msgHandler}
-	connector := testKafkaConnector{consumerGroup: mcg, willError: errorOnConnect}
+	connector := &testKafkaConnector{consumerGroup: mcg, willError: errorOnConnect}
 
 	mockFp := newMockFailureProducer(fch)
 
-	return newKafkaConsumerCollection(newTestConfig(), mockFp, fch, hm, sarama.NewConfig(), log.NullLogger{}, connector.connectToKafka), mockFp
+	return newKafkaConsumerCollection(cfg, mockFp, fch, hm, sarama.NewConfig(), sarama.NewConfig(), log.NullLogger{}, connector.connectToKafka), mockFp, connector
+}
+
+// erroringConsumerGroupForTests fails every call to Consume, as when the cluster is unreachable.
+type erroringConsumerGroupForTests struct {
+	*saramatest.MockConsumerGroup
+	consumes int32
+}
+
+func (g *erroringConsumerGroupForTests) Consume(ctx context.Context, topics []string, handler sarama.ConsumerGroupHandler) error {
+	atomic.AddInt32(&g.consumes, 1)
+	return errors.New("kafka unreachable")
 }
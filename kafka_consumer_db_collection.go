@@ -30,14 +30,23 @@ type kafkaConsumerDbCollection struct {
 
 	// optional fields managed by setters
 	maintenanceInterval time.Duration
+	notifier            retryNotifier
+	deadLetterProducer  *dbDeadLetterProducer
+
+	wakeups *retryWakeups
 }
 
 type retryManager interface {
 	GetBatch(ctx context.Context, topic string, sequence uint8, interval time.Duration) ([]model.Retry, error)
 	MarkSuccessful(ctx context.Context, retry model.Retry) error
 	MarkErrored(ctx context.Context, retry model.Retry, err error) error
+	Release(ctx context.Context, retries []model.Retry) error
+	RenewLease(ctx context.Context, retries []model.Retry) error
 	PublishFailure(ctx context.Context, f failuremodel.Failure) error
 	RunMaintenance(ctx context.Context) error
+	GetReleasedBatch(ctx context.Context, topic string) ([]model.Retry, error)
+	HasPendingRetry(ctx context.Context, topic string, key []byte) (bool, error)
+	ParkFailure(ctx context.Context, f failuremodel.Failure) error
 }
 
 func newKafkaConsumerDbCollection(
@@ -54,11 +63,16 @@ func newKafkaConsumerDbCollection(
 		logger = log.NullLogger{}
 	}
 
+	handler := newConsumer(fch, cfg, hm, logger)
+	if cfg.BlockingRetriesPerKey {
+		handler = newBlockingConsumer(fch, cfg, hm, rm, logger)
+	}
+
 	return &kafkaConsumerDbCollection{
 		cfg:                 cfg,
 		producer:            p,
 		retryManager:        rm,
-		handler:             newConsumer(fch, cfg, hm, logger),
+		handler:             handler,
 		handlerMap:          hm,
 		saramaCfg:           scfg,
 		logger:              logger,
@@ -79,11 +93,25 @@ func (cc *kafkaConsumerDbCollection) start(ctx context.Context, wg *sync.WaitGro
 		return err
 	}
 
+	if cc.notifier != nil {
+		cc.wakeups = newRetryWakeups()
+	}
+
 	for _, t := range topics {
 		cc.startDbRetryProcessorsForTopic(ctx, t, cc.cfg.DBRetries[t], wg)
+		if cc.cfg.BlockingRetriesPerKey {
+			cc.startDbParkedProcessorForTopic(ctx, t, wg)
+		}
+	}
+
+	if cc.notifier != nil {
+		cc.listenForRetryNotifications(ctx, wg)
 	}
 
 	cc.producer.listenForFailures(ctx, wg)
+	if cc.deadLetterProducer != nil {
+		cc.deadLetterProducer.listenForDeadLetters(ctx, wg)
+	}
 	cc.periodicRetryManagerMaintenance(ctx)
 
 	return nil
@@ -108,7 +136,7 @@ func (cc *kafkaConsumerDbCollection) periodicRetryManagerMaintenance(ctx context
 func (cc *kafkaConsumerDbCollection) startMainTopicConsumer(ctx context.Context, wg *sync.WaitGroup, topics []string) (sarama.ConsumerGroup, error) {
 	cc.logger.Infof("starting Kafka consumer group for topics: '%s'", topics)
 
-	cl, err := cc.connectToKafka(cc.cfg, cc.saramaCfg, cc.logger)
+	cl, err := cc.connectToKafka(cc.cfg.Kafka, cc.cfg.Group, cc.saramaCfg, cc.logger)
 	if err != nil {
 		return nil, err
 	}
@@ -142,6 +170,11 @@ func (cc *kafkaConsumerDbCollection) startMainTopicConsumer(ctx context.Context,
 
 func (cc *kafkaConsumerDbCollection) startDbRetryProcessorsForTopic(ctx context.Context, topic string, retryConfig []*config.DBTopicRetry, wg *sync.WaitGroup) {
 	for _, rc := range retryConfig {
+		if cc.notifier != nil {
+			cc.startNotifiedDbRetryProcessor(ctx, topic, rc, wg)
+			continue
+		}
+
 		wg.Add(1)
 		go func(retryConfig *config.DBTopicRetry) {
 			defer wg.Done()
@@ -149,7 +182,10 @@ func (cc *kafkaConsumerDbCollection) startDbRetryProcessorsForTopic(ctx context.
 			for {
 				select {
 				case <-timer.C:
-					cc.processMessagesForRetry(topic, retryConfig)
+					if cc.processMessagesForRetry(ctx, topic, retryConfig) {
+						timer.Reset(0)
+						continue
+					}
 					timer.Reset(dbRetryPollInterval)
 				case <-ctx.Done():
 					if !timer.Stop() {
@@ -163,41 +199,213 @@ func (cc *kafkaConsumerDbCollection) startDbRetryProcessorsForTopic(ctx context.
 	}
 }
 
-func (cc *kafkaConsumerDbCollection) processMessagesForRetry(topic string, rc *config.DBTopicRetry) {
-	// We use a standalone context here, with a timeout, this is to allow the current retry
-	// processing to complete before we exit from the kafka consumer collection (see the
-	// startDbRetryProcessorsForTopic method for the handling of the main context cancellation).
-	// At the worst, the context timeout would be exceeded and cancelled, stopping the retry
-	// batch from being processed, but it's here to prevent the whole process from becoming
-	// completely locked.
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
-	defer cancel()
+// startDbParkedProcessorForTopic processes messages that were parked behind a pending retry for
+// their key, once that retry has succeeded or been dead-lettered.
+func (cc *kafkaConsumerDbCollection) startDbParkedProcessorForTopic(ctx context.Context, topic string, wg *sync.WaitGroup) {
+	if cc.notifier != nil {
+		cc.startNotifiedDbParkedProcessor(ctx, topic, wg)
+		return
+	}
 
-	msgsForRetry, err := cc.retryManager.GetBatch(ctx, topic, rc.Sequence, rc.Interval)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		timer := time.NewTimer(dbRetryPollInterval)
+		for {
+			select {
+			case <-timer.C:
+				if cc.processReleasedParkedMessages(ctx, topic) {
+					timer.Reset(0)
+					continue
+				}
+				timer.Reset(dbRetryPollInterval)
+			case <-ctx.Done():
+				if !timer.Stop() {
+					<-timer.C
+				}
+				return
+			}
+		}
+	}()
+}
+
+// processReleasedParkedMessages processes a batch of released parked messages, returning true if
+// any were left unprocessed and released, see processRetries.
+func (cc *kafkaConsumerDbCollection) processReleasedParkedMessages(ctx context.Context, topic string) bool {
+	h, ok := cc.handlerMap.handlerForTopic(cc.cfg.FindTopicKey(topic))
+	if !ok {
+		cc.logger.Errorf("no handler found for topic '%s'", topic)
+		return false
+	}
+	if ctx.Err() != nil {
+		return false
+	}
+
+	msgs, err := cc.getBatch(func(ctx context.Context) ([]model.Retry, error) {
+		return cc.retryManager.GetReleasedBatch(ctx, topic)
+	})
+	cc.cfg.Status().ParkedBatchClaimed(topic, len(msgs), err)
 	if err != nil {
-		cc.logger.Errorf("error when fetching messages from the DB for retry: %s", err)
-		return
+		cc.logger.Errorf("error when fetching parked messages from the DB: %s", err)
+		return false
 	}
 
+	return cc.processRetries(ctx, topic, h, msgs)
+}
+
+// processMessagesForRetry processes a batch of the retries that are due for the retry sequence, returning
+// true if more may be due straight away: either the batch was full, or some of it was left unprocessed and
+// released, see processRetries. Nothing is claimed once ctx is done.
+func (cc *kafkaConsumerDbCollection) processMessagesForRetry(ctx context.Context, topic string, rc *config.DBTopicRetry) bool {
 	h, ok := cc.handlerMap.handlerForTopic(rc.Key)
 	if !ok {
 		cc.logger.Errorf("no handler found for topic key '%s'", rc.Key)
-		return
+		return false
+	}
+	if ctx.Err() != nil {
+		return false
 	}
 
-	for _, msg := range msgsForRetry {
-		saramaMsg := msg.ToSaramaConsumerMessage()
-		if err = h(ctx, saramaMsg); err != nil {
-			cc.logger.Errorf("error processing retried message from DB: %s", err)
-			if repoErr := cc.retryManager.MarkErrored(ctx, msg, err); repoErr != nil {
-				cc.logger.Errorf("error marking retried message as errored in the DB: %s", repoErr)
+	msgsForRetry, err := cc.getBatch(func(ctx context.Context) ([]model.Retry, error) {
+		return cc.retryManager.GetBatch(ctx, topic, rc.Sequence, rc.Interval)
+	})
+	cc.cfg.Status().BatchClaimed(topic, rc.Sequence, len(msgsForRetry), err)
+	if err != nil {
+		cc.logger.Errorf("error when fetching messages from the DB for retry: %s", err)
+		return false
+	}
+
+	released := cc.processRetries(ctx, topic, h, msgsForRetry)
+	return ctx.Err() == nil && (released || len(msgsForRetry) >= cc.cfg.DBRetries.BatchSizeForTopic(topic))
+}
+
+// getBatch claims a batch with a standalone context, so that a batch that is claimed as the consumer
+// is stopping can still be released (see processRetries).
+func (cc *kafkaConsumerDbCollection) getBatch(claim func(ctx context.Context) ([]model.Retry, error)) ([]model.Retry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbRetryMessageTimeout)
+	defer cancel()
+
+	return claim(ctx)
+}
+
+// processRetries hands the retries to a pool of workers, each retry being handled with a context of its own.
+// Once ctx is done or the batch has been running for dbRetryBatchTimeout, no more retries are handed out and
+// the claim on the rest is released, so they can be claimed again straight away rather than once the claim
+// has gone stale. Retries that are being handled are left to finish. It returns true if any were released
+// while the consumer is still running, so that the rest can be claimed again straight away.
+func (cc *kafkaConsumerDbCollection) processRetries(ctx context.Context, topic string, h Handler, msgsForRetry []model.Retry) bool {
+	if len(msgsForRetry) == 0 {
+		return false
+	}
+
+	stopping := ctx
+	ctx, cancel := context.WithTimeout(ctx, dbRetryBatchTimeout)
+	defer cancel()
+
+	stopRenewing := cc.renewLeaseUntilDone(msgsForRetry)
+	defer stopRenewing()
+
+	workers := cc.cfg.DBRetryWorkers
+	if workers < 1 {
+		workers = config.DefaultDBRetryWorkers
+	}
+
+	msgs := make(chan model.Retry)
+	var wg sync.WaitGroup
+	for i := 0; i < workers && i < len(msgsForRetry); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for msg := range msgs {
+				cc.processRetry(topic, h, msg)
 			}
-		} else {
-			cc.logger.Infof("successfully processed retried message from topic '%s' with original partition %d and offset %d", topic, msg.KafkaPartition, msg.KafkaOffset)
-			if err = cc.retryManager.MarkSuccessful(ctx, msg); err != nil {
-				cc.logger.Errorf("error marking retried message as successful in the DB: %s", err)
+		}()
+	}
+
+	var unprocessed []model.Retry
+dispatch:
+	for i, msg := range msgsForRetry {
+		// a worker may be ready at the same time, so the context is checked first for the select not to pick it
+		if ctx.Err() != nil {
+			unprocessed = msgsForRetry[i:]
+			break
+		}
+		select {
+		case <-ctx.Done():
+			unprocessed = msgsForRetry[i:]
+			break dispatch
+		case msgs <- msg:
+		}
+	}
+	close(msgs)
+	wg.Wait()
+
+	if len(unprocessed) == 0 {
+		return false
+	}
+
+	cc.logger.Infof("releasing %d unprocessed retries from topic '%s'", len(unprocessed), topic)
+	releaseCtx, releaseCancel := context.WithTimeout(context.Background(), dbRetryMessageTimeout)
+	defer releaseCancel()
+	if err := cc.retryManager.Release(releaseCtx, unprocessed); err != nil {
+		cc.logger.Errorf("error releasing unprocessed retries in the DB: %s", err)
+		return false
+	}
+
+	return stopping.Err() == nil
+}
+
+// renewLeaseUntilDone renews the lease on the batch of retries every dbRetryLeaseRenewInterval, so that other
+// instances do not claim them while they are being processed, until the returned func is called.
+func (cc *kafkaConsumerDbCollection) renewLeaseUntilDone(retries []model.Retry) func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(dbRetryLeaseRenewInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				ctx, cancel := context.WithTimeout(context.Background(), dbRetryLeaseRenewInterval)
+				if err := cc.retryManager.RenewLease(ctx, retries); err != nil {
+					cc.logger.Errorf("error renewing the lease on retries in the DB: %s", err)
+				}
+				cancel()
 			}
 		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// processRetry handles the retry and marks it successful or errored. The handler and the update in the DB each
+// get their own context with a timeout, so that the update is made even if the handler times out.
+func (cc *kafkaConsumerDbCollection) processRetry(topic string, h Handler, msg model.Retry) {
+	handlerCtx, handlerCancel := context.WithTimeout(context.Background(), dbRetryMessageTimeout)
+	err := h(handlerCtx, msg.ToSaramaConsumerMessage())
+	handlerCancel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbRetryMessageTimeout)
+	defer cancel()
+
+	if err != nil {
+		cc.logger.Errorf("error processing retried message from DB: %s", err)
+		if repoErr := cc.retryManager.MarkErrored(ctx, msg, err); repoErr != nil {
+			cc.logger.Errorf("error marking retried message as errored in the DB: %s", repoErr)
+		}
+		return
+	}
+
+	cc.logger.Infof("successfully processed retried message from topic '%s' with original partition %d and offset %d", topic, msg.KafkaPartition, msg.KafkaOffset)
+	if err := cc.retryManager.MarkSuccessful(ctx, msg); err != nil {
+		cc.logger.Errorf("error marking retried message as successful in the DB: %s", err)
 	}
 }
 
@@ -215,3 +423,13 @@ func (cc *kafkaConsumerDbCollection) close() {
 func (cc *kafkaConsumerDbCollection) setMaintenanceInterval(duration time.Duration) {
 	cc.maintenanceInterval = duration
 }
+
+// setRetryNotifier makes the DB retry processors wait for notifications from n, rather than polling.
+func (cc *kafkaConsumerDbCollection) setRetryNotifier(n retryNotifier) {
+	cc.notifier = n
+}
+
+// setDeadLetterProducer publishes the DB retries that are dead-lettered to Kafka with p.
+func (cc *kafkaConsumerDbCollection) setDeadLetterProducer(p *dbDeadLetterProducer) {
+	cc.deadLetterProducer = p
+}
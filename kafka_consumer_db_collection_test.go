@@ -12,6 +12,7 @@ import (
 
 	"github.com/revdaalex/kafka-consumer-go/config"
 	"github.com/revdaalex/kafka-consumer-go/data/failure/model"
+	retrymodel "github.com/revdaalex/kafka-consumer-go/data/retry/model"
 	"github.com/revdaalex/kafka-consumer-go/log"
 	"github.com/revdaalex/kafka-consumer-go/test/saramatest"
 )
@@ -250,6 +251,64 @@ func TestKafkaConsumerDbCollection_Start(t *testing.T) {
 	})
 }
 
+func TestKafkaConsumerDbCollection_StartWithBlockingRetriesPerKey(t *testing.T) {
+	defaultDbRetryPollInterval := dbRetryPollInterval
+	dbRetryPollInterval = time.Millisecond * 25
+
+	defer func() {
+		dbRetryPollInterval = defaultDbRetryPollInterval
+	}()
+
+	t.Run("later messages for a key are parked and processed once released", func(t *testing.T) {
+		mcg := saramatest.NewMockConsumerGroup()
+		mcg.AddMessage(&sarama.ConsumerMessage{Topic: "product", Key: []byte("SKU-123"), Value: []byte(`{"id":1}`)})
+		mcg.AddMessage(&sarama.ConsumerMessage{Topic: "product", Key: []byte("SKU-123"), Value: []byte(`{"id":2}`)})
+
+		var mu sync.Mutex
+		var handled []string
+		cfg := newTestConfig()
+		cfg.BlockingRetriesPerKey = true
+		col, repo := testKafkaConsumerDbCollectionWithConfig(cfg, mcg, func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+			mu.Lock()
+			defer mu.Unlock()
+			handled = append(handled, string(msg.Value))
+			if len(handled) == 1 {
+				return errors.New("something bad happened")
+			}
+			return nil
+		}, false)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+		defer cancel()
+		var wg sync.WaitGroup
+		if err := col.start(ctx, &wg); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+		wg.Wait()
+
+		if got := repo.getPublishedFailureCountByTopic("product"); got != 1 {
+			t.Errorf("expected 1 failure to be produced in database, but got %d", got)
+		}
+
+		if got := len(repo.parkedFailures["product"]); got != 1 {
+			t.Errorf("expected 1 message to be parked in database, but got %d", got)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		released := -1
+		for i, v := range handled {
+			if v == `{"id":2}` {
+				released = i
+				break
+			}
+		}
+		if released < 2 || handled[0] != `{"id":1}` || handled[1] != `{"id":1}` {
+			t.Errorf("expected msg1 and its retry to be handled before the released msg2, but got %v", handled)
+		}
+	})
+}
+
 func TestKafkaConsumerDbCollection_Close(t *testing.T) {
 	t.Run("consumers are closed", func(t *testing.T) {
 		t.Parallel()
@@ -288,12 +347,152 @@ func TestKafkaConsumerDbCollection_Close(t *testing.T) {
 }
 
 func testKafkaConsumerDbCollection(mcg *saramatest.MockConsumerGroup, msgHandler Handler, errorOnConnect bool) (*kafkaConsumerDbCollection, *mockRetryManager) {
+	return testKafkaConsumerDbCollectionWithConfig(newTestConfig(), mcg, msgHandler, errorOnConnect)
+}
+
+func testKafkaConsumerDbCollectionWithConfig(cfg *config.Config, mcg *saramatest.MockConsumerGroup, msgHandler Handler, errorOnConnect bool) (*kafkaConsumerDbCollection, *mockRetryManager) {
 	fch := make(chan model.Failure, 10)
 	repo := newMockRetryManager(false)
 	dp := newDatabaseProducer(repo, fch, log.NullLogger{})
 
 	hm := HandlerMap{"product": msgHandler}
-	connector := testKafkaConnector{consumerGroup: mcg, willError: errorOnConnect}
+	connector := &testKafkaConnector{consumerGroup: mcg, willError: errorOnConnect}
+
+	return newKafkaConsumerDbCollection(cfg, dp, repo, fch, hm, sarama.NewConfig(), log.NullLogger{}, connector.connectToKafka), repo
+}
+
+func TestKafkaConsumerDbCollection_ProcessRetries(t *testing.T) {
+	retries := []retrymodel.Retry{
+		{ID: 1, Topic: "product", Payload: []byte(`{}`)},
+		{ID: 2, Topic: "product", Payload: []byte(`{}`)},
+		{ID: 3, Topic: "product", Payload: []byte(`{}`)},
+	}
+
+	t.Run("retries are handled by several workers at once", func(t *testing.T) {
+		cfg := newTestConfig()
+		cfg.DBRetryWorkers = 3
+		col, repo := testKafkaConsumerDbCollectionWithConfig(cfg, saramatest.NewMockConsumerGroup(), nil, false)
+
+		var inFlight sync.WaitGroup
+		inFlight.Add(len(retries))
+		h := func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+			inFlight.Done()
+			// only returns once every retry is being handled, or the context times out
+			inFlight.Wait()
+			return ctx.Err()
+		}
 
-	return newKafkaConsumerDbCollection(newTestConfig(), dp, repo, fch, hm, sarama.NewConfig(), log.NullLogger{}, connector.connectToKafka), repo
+		defaultMessageTimeout := dbRetryMessageTimeout
+		dbRetryMessageTimeout = time.Second
+		defer func() {
+			dbRetryMessageTimeout = defaultMessageTimeout
+		}()
+
+		if released := col.processRetries(context.Background(), "product", h, retries); released {
+			t.Error("expected no retries to be released")
+		}
+
+		if !repo.retrySuccessful || repo.retryErrored {
+			t.Error("expected the retries to be handled at the same time and marked successful")
+		}
+	})
+
+	t.Run("retries that are not handled before the batch times out are released", func(t *testing.T) {
+		col, repo := testKafkaConsumerDbCollection(saramatest.NewMockConsumerGroup(), nil, false)
+
+		defaultBatchTimeout := dbRetryBatchTimeout
+		dbRetryBatchTimeout = time.Millisecond * 10
+		defer func() {
+			dbRetryBatchTimeout = defaultBatchTimeout
+		}()
+
+		var handled int
+		h := func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+			handled++
+			time.Sleep(time.Millisecond * 30)
+			return nil
+		}
+
+		if released := col.processRetries(context.Background(), "product", h, retries); !released {
+			t.Error("expected retries to be released")
+		}
+
+		if handled != 1 {
+			t.Errorf("expected 1 retry to be handled, but %d were", handled)
+		}
+
+		if len(repo.released) != 2 || repo.released[0].ID != 2 || repo.released[1].ID != 3 {
+			t.Errorf("expected the unhandled retries to be released, got %+v", repo.released)
+		}
+	})
+
+	t.Run("the lease on retries is renewed while they are handled", func(t *testing.T) {
+		col, repo := testKafkaConsumerDbCollection(saramatest.NewMockConsumerGroup(), nil, false)
+
+		defaultLeaseRenewInterval := dbRetryLeaseRenewInterval
+		dbRetryLeaseRenewInterval = time.Millisecond * 10
+		defer func() {
+			dbRetryLeaseRenewInterval = defaultLeaseRenewInterval
+		}()
+
+		col.processRetries(context.Background(), "product", func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+			time.Sleep(time.Millisecond * 15)
+			return nil
+		}, retries)
+
+		repo.Lock()
+		defer repo.Unlock()
+		if repo.leaseRenewals == 0 {
+			t.Error("expected the lease to have been renewed")
+		}
+	})
+
+	t.Run("retries are released once the consumer is stopping", func(t *testing.T) {
+		col, repo := testKafkaConsumerDbCollection(saramatest.NewMockConsumerGroup(), nil, false)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		var handled int
+		released := col.processRetries(ctx, "product", func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+			handled++
+			return nil
+		}, retries)
+
+		if released {
+			t.Error("expected no new batch to be claimed straight away once the consumer is stopping")
+		}
+		if handled != 0 || len(repo.released) != len(retries) {
+			t.Errorf("expected every retry to be released without being handled, got %d handled and %d released", handled, len(repo.released))
+		}
+	})
+}
+
+func TestKafkaConsumerDbCollection_ProcessMessagesForRetry(t *testing.T) {
+	rc := &config.DBTopicRetry{Key: "product", Sequence: 1}
+	h := func(ctx context.Context, msg *sarama.ConsumerMessage) error { return nil }
+
+	t.Run("no batch is claimed without a handler", func(t *testing.T) {
+		col, repo := testKafkaConsumerDbCollection(saramatest.NewMockConsumerGroup(), h, false)
+
+		if more := col.processMessagesForRetry(context.Background(), "product", &config.DBTopicRetry{Key: "unknown", Sequence: 1}); more {
+			t.Error("expected no more retries to be processed")
+		}
+		if repo.getBatchCalls != 0 {
+			t.Errorf("expected no batch to be claimed, got %d", repo.getBatchCalls)
+		}
+	})
+
+	t.Run("no batch is claimed once the consumer is stopping", func(t *testing.T) {
+		col, repo := testKafkaConsumerDbCollection(saramatest.NewMockConsumerGroup(), h, false)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		if more := col.processMessagesForRetry(ctx, "product", rc); more {
+			t.Error("expected no more retries to be processed")
+		}
+		if repo.getBatchCalls != 0 {
+			t.Errorf("expected no batch to be claimed, got %d", repo.getBatchCalls)
+		}
+	})
 }
@@ -20,6 +20,9 @@ type kafkaFailureProducer struct {
 	producer sarama.SyncProducer
 	fch      <-chan model.Failure
 	logger   log.Logger
+
+	// optional fields managed by setters
+	errorHandler config.RetryPublishErrorHandler
 }
 
 func newKafkaFailureProducerWithDefaults(cfg *config.Config, fch <-chan model.Failure, logger log.Logger) (*kafkaFailureProducer, error) {
@@ -30,12 +33,10 @@ func newKafkaFailureProducerWithDefaults(cfg *config.Config, fch <-chan model.Fa
 	var sp sarama.SyncProducer
 	var err error
 
+	// retries and dead-letters are always published to the retry cluster, which is the
+	// same as the main cluster unless a separate one has been configured
 	for i := 0; i < maxConnectionAttempts; i++ {
-		host := cfg.Host
-		if len(cfg.RetryHost) > 0 {
-			host = cfg.RetryHost
-		}
-		sp, err = sarama.NewSyncProducer(host, config.NewSaramaConfig(cfg.TLSEnable, cfg.TLSSkipVerifyPeer))
+		sp, err = sarama.NewSyncProducer(cfg.RetryKafka.Host, newRetryProducerSaramaConfig(cfg))
 		if err == nil {
 			break
 		}
@@ -51,7 +52,24 @@ func newKafkaFailureProducerWithDefaults(cfg *config.Config, fch <-chan model.Fa
 		time.Sleep(connectionInterval)
 	}
 
-	return newKafkaFailureProducer(sp, fch, logger), nil
+	p := newKafkaFailureProducer(sp, fch, logger)
+	p.setErrorHandler(cfg.RetryPublishErrorHandler)
+
+	return p, nil
+}
+
+// newRetryProducerSaramaConfig returns the sarama config for publishing to the retry cluster, using the
+// configured retry partitioner. Sarama hashes the message key by default.
+func newRetryProducerSaramaConfig(cfg *config.Config) *sarama.Config {
+	scfg := config.NewSaramaConfigForCluster(cfg.RetryKafka)
+
+	if cfg.KeepRetryPartition {
+		scfg.Producer.Partitioner = sarama.NewManualPartitioner
+	} else if cfg.RetryPartitioner != nil {
+		scfg.Producer.Partitioner = cfg.RetryPartitioner
+	}
+
+	return scfg
 }
 
 func newKafkaFailureProducer(sp sarama.SyncProducer, fch <-chan model.Failure, logger log.Logger) *kafkaFailureProducer {
@@ -62,6 +80,10 @@ func newKafkaFailureProducer(sp sarama.SyncProducer, fch <-chan model.Failure, l
 	}
 }
 
+func (p *kafkaFailureProducer) setErrorHandler(h config.RetryPublishErrorHandler) {
+	p.errorHandler = h
+}
+
 func (p kafkaFailureProducer) listenForFailures(ctx context.Context, wg *sync.WaitGroup) {
 	p.logger.Info("starting Kafka retry producer")
 
@@ -88,16 +110,33 @@ func (p kafkaFailureProducer) listenForFailures(ctx context.Context, wg *sync.Wa
 func (p kafkaFailureProducer) publishFailure(f model.Failure) {
 	p.logger.Debugf("publishing retry to Kafka topic '%s'", f.NextTopic)
 
-	_, _, err := p.producer.SendMessage(&sarama.ProducerMessage{
-		Topic:   f.NextTopic,
-		Value:   sarama.ByteEncoder(f.Message),
-		Headers: f.MessageHeaders,
-	})
+	_, _, err := p.producer.SendMessage(newRetryProducerMessage(f))
 
 	if err != nil {
 		p.logger.Errorf("error occurred publishing retry to Kafka topic '%s': %w", f.NextTopic, err)
+		if p.errorHandler != nil {
+			p.errorHandler(f, err)
+		}
 		return
 	}
 
 	p.logger.Debugf("published Failure event message to Kafka retry topic '%s' successfully", f.NextTopic)
 }
+
+// newRetryProducerMessage creates the message that publishes the failure to the next topic in its chain.
+func newRetryProducerMessage(f model.Failure) *sarama.ProducerMessage {
+	msg := &sarama.ProducerMessage{
+		Topic:   f.NextTopic,
+		Value:   sarama.ByteEncoder(f.Message),
+		Headers: f.MessageHeaders,
+		// the partition is only used by the manual partitioner, when keeping the retry partition
+		Partition: f.KafkaPartition,
+	}
+
+	// a nil key is left unset, so that keyless messages are still spread over the partitions
+	if f.MessageKey != nil {
+		msg.Key = sarama.ByteEncoder(f.MessageKey)
+	}
+
+	return msg
+}
@@ -7,8 +7,10 @@ import (
 	"testing"
 	"time"
 
+	"github.com/IBM/sarama"
 	"github.com/go-test/deep"
 
+	"github.com/revdaalex/kafka-consumer-go/config"
 	"github.com/revdaalex/kafka-consumer-go/data/failure/model"
 	"github.com/revdaalex/kafka-consumer-go/log"
 	"github.com/revdaalex/kafka-consumer-go/test/saramatest"
@@ -99,3 +101,67 @@ func TestFailureProducer_ListenForFailuresWithProducerError(t *testing.T) {
 	<-time.After(time.Millisecond * 5)
 	cancel()
 }
+
+func TestFailureProducer_PublishFailureWithKeyAndPartition(t *testing.T) {
+	sp := saramatest.NewMockSyncProducer()
+	prod := newKafkaFailureProducer(sp, make(chan model.Failure), log.NullLogger{})
+
+	prod.publishFailure(model.Failure{
+		Message:        []byte("hello"),
+		MessageKey:     []byte("SKU-123"),
+		NextTopic:      "keyed",
+		KafkaPartition: 7,
+	})
+	prod.publishFailure(model.Failure{
+		Message:   []byte("world"),
+		NextTopic: "keyless",
+	})
+
+	keyed := sp.GetMessagesSent("keyed")
+	if len(keyed) != 1 {
+		t.Fatalf("expected 1 message to be published, but got %d", len(keyed))
+	}
+
+	if keyed[0].Key == nil {
+		t.Fatal("expected the message key to be published")
+	}
+
+	if k, _ := keyed[0].Key.Encode(); string(k) != "SKU-123" {
+		t.Errorf("expected key 'SKU-123' to be published, but got '%s'", k)
+	}
+
+	if keyed[0].Partition != 7 {
+		t.Errorf("expected partition 7 to be set, but got %d", keyed[0].Partition)
+	}
+
+	keyless := sp.GetMessagesSent("keyless")
+	if len(keyless) != 1 || keyless[0].Key != nil {
+		t.Error("expected a keyless message to be published without a key")
+	}
+}
+
+func TestNewRetryProducerSaramaConfig(t *testing.T) {
+	partitioner := func(cfg *config.Config) sarama.Partitioner {
+		return newRetryProducerSaramaConfig(cfg).Producer.Partitioner("retry")
+	}
+
+	t.Run("it hashes the key by default", func(t *testing.T) {
+		if !partitioner(&config.Config{}).RequiresConsistency() {
+			t.Error("expected a consistent partitioner by default")
+		}
+	})
+
+	t.Run("it uses the manual partitioner when keeping the retry partition", func(t *testing.T) {
+		p, err := partitioner(&config.Config{KeepRetryPartition: true}).Partition(&sarama.ProducerMessage{Partition: 3}, 10)
+		if err != nil || p != 3 {
+			t.Errorf("expected partition 3, but got %d (%v)", p, err)
+		}
+	})
+
+	t.Run("it uses the configured partitioner", func(t *testing.T) {
+		cfg := &config.Config{RetryPartitioner: sarama.NewRoundRobinPartitioner}
+		if partitioner(cfg).RequiresConsistency() {
+			t.Error("expected the round robin partitioner to be used")
+		}
+	})
+}
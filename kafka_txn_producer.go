@@ -0,0 +1,155 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+
+	"github.com/revdaalex/kafka-consumer-go/config"
+	"github.com/revdaalex/kafka-consumer-go/data/failure/model"
+	"github.com/revdaalex/kafka-consumer-go/log"
+)
+
+// kafkaTxnFailureProducer is a producer that publishes failures to the next kafka retry topic in the chain
+// in a transaction, together with the offset commit of the message that failed. Unlike the other producers
+// it is called directly by the consumer, rather than listening for failures on a channel.
+type kafkaTxnFailureProducer struct {
+	producer sarama.SyncProducer
+	group    string
+	logger   log.Logger
+	// a transactional producer can only have one transaction open at a time, but claims are consumed concurrently
+	sync.Mutex
+}
+
+func newKafkaTxnFailureProducerWithDefaults(cfg *config.Config, logger log.Logger) (*kafkaTxnFailureProducer, error) {
+	if logger == nil {
+		logger = log.NullLogger{}
+	}
+
+	var sp sarama.SyncProducer
+	var err error
+
+	scfg := newTxnRetryProducerSaramaConfig(cfg)
+	for i := 0; i < maxConnectionAttempts; i++ {
+		sp, err = sarama.NewSyncProducer(cfg.RetryKafka.Host, scfg)
+		if err == nil {
+			break
+		}
+
+		// the cluster may be temporarily unreachable so if we see ErrOutOfBrokers we continue to the
+		// next iteration to make another attempt to connect
+		if !errors.Is(err, sarama.ErrOutOfBrokers) {
+			return nil, fmt.Errorf("error occurred creating transactional Kafka producer for retries: %w", err)
+		}
+
+		logger.Info("Kafka cluster is not reachable, retrying...")
+		time.Sleep(connectionInterval)
+	}
+
+	return newKafkaTxnFailureProducer(sp, cfg.Group, logger), nil
+}
+
+// newTxnRetryProducerSaramaConfig returns the sarama config of the transactional retry producer.
+func newTxnRetryProducerSaramaConfig(cfg *config.Config) *sarama.Config {
+	scfg := newRetryProducerSaramaConfig(cfg)
+
+	scfg.Producer.Idempotent = true
+	scfg.Producer.RequiredAcks = sarama.WaitForAll
+	scfg.Net.MaxOpenRequests = 1
+	scfg.Producer.Transaction.ID = transactionalID(cfg)
+
+	return scfg
+}
+
+func transactionalID(cfg *config.Config) string {
+	if cfg.TransactionalID != "" {
+		return cfg.TransactionalID
+	}
+
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s-%s", cfg.Group, host)
+}
+
+func newKafkaTxnFailureProducer(sp sarama.SyncProducer, group string, logger log.Logger) *kafkaTxnFailureProducer {
+	if logger == nil {
+		logger = log.NullLogger{}
+	}
+
+	return &kafkaTxnFailureProducer{
+		producer: sp,
+		group:    group,
+		logger:   logger,
+	}
+}
+
+// listenForFailures only closes the producer once ctx is done, as failures are published by the consumer
+// with PublishFailureInTxn.
+func (p *kafkaTxnFailureProducer) listenForFailures(ctx context.Context, wg *sync.WaitGroup) {
+	p.logger.Info("starting transactional Kafka retry producer")
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		<-ctx.Done()
+
+		p.Lock()
+		defer p.Unlock()
+		if err := p.producer.Close(); err != nil {
+			p.logger.Error("error occurred closing transactional Kafka retry producer")
+		}
+	}()
+}
+
+// PublishFailureInTxn publishes the failure and commits the offset of msg in the same transaction. Any offsets
+// already marked in the session are committed first, so that they cannot be committed after the transaction
+// and move the group's offset back to before msg.
+func (p *kafkaTxnFailureProducer) PublishFailureInTxn(session sarama.ConsumerGroupSession, msg *sarama.ConsumerMessage, f model.Failure) error {
+	p.Lock()
+	defer p.Unlock()
+
+	session.Commit()
+
+	if err := p.producer.BeginTxn(); err != nil {
+		return fmt.Errorf("unable to begin transaction: %w", err)
+	}
+
+	if _, _, err := p.producer.SendMessage(newRetryProducerMessage(f)); err != nil {
+		return p.abort(fmt.Errorf("unable to publish retry to Kafka topic '%s': %w", f.NextTopic, err))
+	}
+
+	if err := p.producer.AddMessageToTxn(msg, p.group, nil); err != nil {
+		return p.abort(fmt.Errorf("unable to add offset to transaction: %w", err))
+	}
+
+	if err := p.producer.CommitTxn(); err != nil {
+		return p.abort(fmt.Errorf("unable to commit transaction: %w", err))
+	}
+
+	p.logger.Debugf("published retry to Kafka topic '%s' in a transaction", f.NextTopic)
+
+	return nil
+}
+
+// abort will abort the open transaction, unless the producer has hit a fatal error and can no longer be used
+func (p *kafkaTxnFailureProducer) abort(err error) error {
+	status := p.producer.TxnStatus()
+	if status&sarama.ProducerTxnFlagFatalError != 0 {
+		p.logger.Errorf("transactional Kafka retry producer has a fatal error and can no longer publish retries")
+		return err
+	}
+
+	if status&(sarama.ProducerTxnFlagInTransaction|sarama.ProducerTxnFlagAbortableError) == 0 {
+		return err
+	}
+
+	if abortErr := p.producer.AbortTxn(); abortErr != nil {
+		p.logger.Errorf("error occurred aborting transaction: %s", abortErr)
+	}
+
+	return err
+}
@@ -0,0 +1,117 @@
+package consumer
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/IBM/sarama"
+
+	"github.com/revdaalex/kafka-consumer-go/config"
+	"github.com/revdaalex/kafka-consumer-go/data/failure/model"
+	"github.com/revdaalex/kafka-consumer-go/log"
+	"github.com/revdaalex/kafka-consumer-go/test/saramatest"
+)
+
+func TestNewTxnRetryProducerSaramaConfig(t *testing.T) {
+	t.Run("it creates a valid transactional config", func(t *testing.T) {
+		scfg := newTxnRetryProducerSaramaConfig(&config.Config{Group: "group", TransactionalID: "consumer-1"})
+
+		if err := scfg.Validate(); err != nil {
+			t.Fatalf("expected a valid sarama config, got: %s", err)
+		}
+
+		if scfg.Producer.Transaction.ID != "consumer-1" {
+			t.Errorf("expected transactional ID 'consumer-1', but got '%s'", scfg.Producer.Transaction.ID)
+		}
+	})
+
+	t.Run("it defaults the transactional ID to the group and hostname", func(t *testing.T) {
+		scfg := newTxnRetryProducerSaramaConfig(&config.Config{Group: "group"})
+
+		if !strings.HasPrefix(scfg.Producer.Transaction.ID, "group-") {
+			t.Errorf("expected transactional ID to start with the group, but got '%s'", scfg.Producer.Transaction.ID)
+		}
+	})
+}
+
+func TestTxnFailureProducer_PublishFailureInTxn(t *testing.T) {
+	msg := &sarama.ConsumerMessage{Topic: "product", Partition: 1, Offset: 20}
+	failure := model.Failure{Topic: "product", NextTopic: "retry1.product", Message: []byte("hello")}
+
+	t.Run("it publishes the failure and the offset in one transaction", func(t *testing.T) {
+		sp := saramatest.NewMockSyncProducer()
+		session := saramatest.NewMockConsumerGroupSession()
+		prod := newKafkaTxnFailureProducer(sp, "group", log.NullLogger{})
+
+		if err := prod.PublishFailureInTxn(session, msg, failure); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if session.CommitCount() != 1 {
+			t.Error("expected the session offsets to be committed before the transaction")
+		}
+
+		txns := sp.GetCommittedTxns()
+		if len(txns) != 1 {
+			t.Fatalf("expected 1 committed transaction, but got %d", len(txns))
+		}
+
+		if len(txns[0].Messages) != 1 || txns[0].Messages[0].Topic != "retry1.product" {
+			t.Error("expected the retry to be published in the transaction")
+		}
+
+		if len(txns[0].Offsets) != 1 || txns[0].Offsets[0] != msg {
+			t.Error("expected the offset of the message to be committed in the transaction")
+		}
+	})
+
+	t.Run("it aborts the transaction if the offset cannot be added", func(t *testing.T) {
+		sp := saramatest.NewMockSyncProducer()
+		sp.ReturnErrorOnAddMessageToTxn()
+		prod := newKafkaTxnFailureProducer(sp, "group", log.NullLogger{})
+
+		if err := prod.PublishFailureInTxn(saramatest.NewMockConsumerGroupSession(), msg, failure); err == nil {
+			t.Error("expected an error but got nil")
+		}
+
+		if sp.GetAbortedTxnCount() != 1 || len(sp.GetCommittedTxns()) != 0 {
+			t.Error("expected the transaction to be aborted")
+		}
+	})
+
+	t.Run("it aborts the transaction if it cannot be committed", func(t *testing.T) {
+		sp := saramatest.NewMockSyncProducer()
+		sp.ReturnErrorOnCommitTxn()
+		prod := newKafkaTxnFailureProducer(sp, "group", log.NullLogger{})
+
+		if err := prod.PublishFailureInTxn(saramatest.NewMockConsumerGroupSession(), msg, failure); err == nil {
+			t.Error("expected an error but got nil")
+		}
+
+		if sp.GetAbortedTxnCount() != 1 {
+			t.Error("expected the transaction to be aborted")
+		}
+	})
+}
+
+func TestTxnFailureProducer_ListenForFailures(t *testing.T) {
+	prod := newKafkaTxnFailureProducer(saramatest.NewMockSyncProducer(), "group", log.NullLogger{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	prod.listenForFailures(ctx, wg)
+	cancel()
+	wg.Wait()
+}
+
+func TestNewRetryConsumerSaramaConfig(t *testing.T) {
+	if newRetryConsumerSaramaConfig(&config.Config{}).Consumer.IsolationLevel != sarama.ReadUncommitted {
+		t.Error("expected retries to be read uncommitted by default")
+	}
+
+	if newRetryConsumerSaramaConfig(&config.Config{ExactlyOnceRetries: true}).Consumer.IsolationLevel != sarama.ReadCommitted {
+		t.Error("expected retries to be read committed with exactly-once retries")
+	}
+}
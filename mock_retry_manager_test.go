@@ -2,7 +2,9 @@ package consumer
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"sync"
 	"time"
 
 	failuremodel "github.com/revdaalex/kafka-consumer-go/data/failure/model"
@@ -12,15 +14,24 @@ import (
 type mockRetryManager struct {
 	// indexed by topic name
 	recvdFailures             map[string][]failuremodel.Failure
+	parkedFailures            map[string][]failuremodel.Failure
 	willErrorOnPublishFailure bool
 	willErrorOnGetBatch       bool
 	retryErrored              bool
 	retrySuccessful           bool
 	runMaintenanceCallCount   int
+	released                  []model.Retry
+	leaseRenewals             int
+	getBatchCalls             int
+	sync.Mutex
 }
 
 // GetBatch will return in-memory received failures as retries
 func (mr *mockRetryManager) GetBatch(ctx context.Context, topic string, sequence uint8, interval time.Duration) ([]model.Retry, error) {
+	mr.Lock()
+	defer mr.Unlock()
+
+	mr.getBatchCalls++
 	if mr.willErrorOnGetBatch {
 		return nil, errors.New("oops")
 	}
@@ -32,9 +43,10 @@ func (mr *mockRetryManager) GetBatch(ctx context.Context, topic string, sequence
 
 	var rts []model.Retry
 	for _, failure := range failures {
+		headers, _ := json.Marshal(failure.MessageHeaders)
 		rts = append(rts, model.Retry{
-			PayloadJSON:    failure.Message,
-			PayloadHeaders: failure.MessageHeaders,
+			Payload:        failure.Message,
+			PayloadHeaders: headers,
 			PayloadKey:     failure.MessageKey,
 			Topic:          failure.Topic,
 			KafkaPartition: failure.KafkaPartition,
@@ -45,17 +57,78 @@ func (mr *mockRetryManager) GetBatch(ctx context.Context, topic string, sequence
 	return rts, nil
 }
 
+// GetReleasedBatch will return in-memory parked failures as retries, once a retry has succeeded
+func (mr *mockRetryManager) GetReleasedBatch(ctx context.Context, topic string) ([]model.Retry, error) {
+	mr.Lock()
+	defer mr.Unlock()
+
+	var rts []model.Retry
+	if !mr.retrySuccessful {
+		return rts, nil
+	}
+
+	for _, failure := range mr.parkedFailures[topic] {
+		rts = append(rts, model.Retry{
+			Payload:    failure.Message,
+			PayloadKey: failure.MessageKey,
+			Topic:      failure.Topic,
+		})
+	}
+
+	return rts, nil
+}
+
+// HasPendingRetry reports a key as blocked if a failure has been published or parked for it
+func (mr *mockRetryManager) HasPendingRetry(ctx context.Context, topic string, key []byte) (bool, error) {
+	mr.Lock()
+	defer mr.Unlock()
+
+	for _, f := range append(mr.recvdFailures[topic], mr.parkedFailures[topic]...) {
+		if string(f.MessageKey) == string(key) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (mr *mockRetryManager) ParkFailure(ctx context.Context, f failuremodel.Failure) error {
+	mr.Lock()
+	defer mr.Unlock()
+	mr.parkedFailures[f.Topic] = append(mr.parkedFailures[f.Topic], f)
+	return nil
+}
+
 func (mr *mockRetryManager) MarkSuccessful(ctx context.Context, retry model.Retry) error {
+	mr.Lock()
+	defer mr.Unlock()
 	mr.retrySuccessful = true
 	return nil
 }
 
 func (mr *mockRetryManager) MarkErrored(ctx context.Context, retry model.Retry, err error) error {
+	mr.Lock()
+	defer mr.Unlock()
 	mr.retryErrored = true
 	return nil
 }
 
+func (mr *mockRetryManager) Release(ctx context.Context, retries []model.Retry) error {
+	mr.Lock()
+	defer mr.Unlock()
+	mr.released = append(mr.released, retries...)
+	return nil
+}
+
+func (mr *mockRetryManager) RenewLease(ctx context.Context, retries []model.Retry) error {
+	mr.Lock()
+	defer mr.Unlock()
+	mr.leaseRenewals++
+	return nil
+}
+
 func (mr *mockRetryManager) PublishFailure(ctx context.Context, f failuremodel.Failure) error {
+	mr.Lock()
+	defer mr.Unlock()
 	if mr.willErrorOnPublishFailure {
 		return errors.New("oops")
 	}
@@ -64,6 +137,8 @@ func (mr *mockRetryManager) PublishFailure(ctx context.Context, f failuremodel.F
 }
 
 func (mr *mockRetryManager) RunMaintenance(ctx context.Context) error {
+	mr.Lock()
+	defer mr.Unlock()
 	mr.runMaintenanceCallCount++
 	return nil
 }
@@ -71,11 +146,15 @@ func (mr *mockRetryManager) RunMaintenance(ctx context.Context) error {
 func newMockRetryManager(willError bool) *mockRetryManager {
 	return &mockRetryManager{
 		recvdFailures:             map[string][]failuremodel.Failure{},
+		parkedFailures:            map[string][]failuremodel.Failure{},
 		willErrorOnPublishFailure: willError,
 	}
 }
 
 func (mr *mockRetryManager) getPublishedFailureCountByTopic(topic string) int {
+	mr.Lock()
+	defer mr.Unlock()
+
 	f, ok := mr.recvdFailures[topic]
 	if !ok {
 		return 0
@@ -84,6 +163,9 @@ func (mr *mockRetryManager) getPublishedFailureCountByTopic(topic string) int {
 }
 
 func (mr *mockRetryManager) getFirstPublishedFailureByTopic(topic string) *failuremodel.Failure {
+	mr.Lock()
+	defer mr.Unlock()
+
 	f, ok := mr.recvdFailures[topic]
 	if !ok {
 		return nil
@@ -0,0 +1,49 @@
+package consumer
+
+import (
+	"github.com/IBM/sarama"
+
+	"github.com/revdaalex/kafka-consumer-go/config"
+	"github.com/revdaalex/kafka-consumer-go/log"
+	"github.com/revdaalex/kafka-consumer-go/topics"
+)
+
+// Preflight checks the topic chains of the config against the Kafka clusters and returns a report of any
+// problems found, without starting any consumers.
+func Preflight(cfg *config.Config) (*topics.Report, error) {
+	return runPreflight(cfg, defaultAdminConnector)
+}
+
+func runPreflight(cfg *config.Config, connect clusterAdminConnector) (*topics.Report, error) {
+	var report *topics.Report
+	err := withClusterAdmins(cfg, connect, func(mainAdmin, retryAdmin sarama.ClusterAdmin) error {
+		var err error
+		report, err = topics.Preflight(cfg, mainAdmin, retryAdmin)
+		return err
+	})
+
+	return report, err
+}
+
+// checkTopics runs the preflight check on startup. Problems are only returned as an error if
+// cfg.Preflight.FailOnProblem is set, otherwise they are logged.
+func checkTopics(cfg *config.Config, connect clusterAdminConnector, logger log.Logger) error {
+	report, err := runPreflight(cfg, connect)
+	if err != nil {
+		return err
+	}
+
+	for _, w := range report.Warnings {
+		logger.Infof("preflight check: %s", w)
+	}
+
+	if cfg.Preflight.FailOnProblem {
+		return report.Err()
+	}
+
+	for _, p := range report.Problems {
+		logger.Errorf("preflight check: %s", p.Message)
+	}
+
+	return nil
+}
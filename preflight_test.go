@@ -0,0 +1,77 @@
+package consumer
+
+import (
+	"testing"
+
+	"github.com/IBM/sarama"
+
+	"github.com/revdaalex/kafka-consumer-go/config"
+	"github.com/revdaalex/kafka-consumer-go/log"
+	"github.com/revdaalex/kafka-consumer-go/test/saramatest"
+)
+
+func TestCheckTopics(t *testing.T) {
+	newConfig := func(fail bool) *config.Config {
+		cfg, err := config.NewBuilder().
+			SetKafkaHost([]string{"main:9092"}).
+			SetKafkaGroup("group").
+			SetSourceTopics([]string{"product"}).
+			SetRetryIntervals([]int{60}).
+			EnablePreflightCheck(true).
+			FailOnPreflightProblems(fail).
+			Config()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cfg
+	}
+
+	newConnector := func() (clusterAdminConnector, *saramatest.MockClusterAdmin) {
+		mainAdmin := saramatest.NewMockClusterAdmin()
+		mainAdmin.AddTopic("product", sarama.TopicDetail{NumPartitions: 6})
+		retryAdmin := saramatest.NewMockClusterAdmin()
+		retryAdmin.AddTopic("retry1.group.product", sarama.TopicDetail{NumPartitions: 6})
+
+		calls := 0
+		return func(cluster config.KafkaCluster, _ *sarama.Config) (sarama.ClusterAdmin, error) {
+			calls++
+			if calls == 1 {
+				return mainAdmin, nil
+			}
+			return retryAdmin, nil
+		}, retryAdmin
+	}
+
+	t.Run("it only logs problems by default", func(t *testing.T) {
+		connect, retryAdmin := newConnector()
+
+		if err := checkTopics(newConfig(false), connect, log.NullLogger{}); err != nil {
+			t.Errorf("did not expect error: %s", err)
+		}
+
+		if !retryAdmin.WasClosed() {
+			t.Error("expected cluster admin to be closed")
+		}
+	})
+
+	t.Run("it errors on problems if configured to fail", func(t *testing.T) {
+		connect, _ := newConnector()
+
+		if err := checkTopics(newConfig(true), connect, log.NullLogger{}); err == nil {
+			t.Error("expected error for missing deadLetter topic")
+		}
+	})
+
+	t.Run("it returns the report", func(t *testing.T) {
+		connect, _ := newConnector()
+
+		report, err := runPreflight(newConfig(false), connect)
+		if err != nil {
+			t.Fatalf("did not expect error: %s", err)
+		}
+
+		if len(report.Problems) != 1 || report.Problems[0].Topic != "deadLetter.group.product" {
+			t.Errorf("expected a single problem for the deadLetter topic, got %+v", report.Problems)
+		}
+	})
+}
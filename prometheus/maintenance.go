@@ -0,0 +1,37 @@
+package prometheus
+
+import (
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/revdaalex/kafka-consumer-go/config"
+)
+
+// NewMaintenanceReportHandler returns a handler for the reports of the DB retry maintenance, to be set with
+// config.Builder.SetMaintenanceReportHandler, that counts the retries it removes and the runs that fail. The
+// counters are registered with reg, e.g. prometheus.DefaultRegisterer.
+func NewMaintenanceReportHandler(reg prom.Registerer) config.MaintenanceReportHandler {
+	factory := promauto.With(reg)
+	deleted := factory.NewCounterVec(prom.CounterOpts{
+		Name: "kafka_consumer_maintenance_deleted_total",
+		Help: "The number of DB retries deleted by maintenance.",
+	}, []string{"topic", "state"})
+	archived := factory.NewCounterVec(prom.CounterOpts{
+		Name: "kafka_consumer_maintenance_archived_total",
+		Help: "The number of DB retries archived by maintenance.",
+	}, []string{"topic", "state"})
+	errs := factory.NewCounter(prom.CounterOpts{
+		Name: "kafka_consumer_maintenance_errors_total",
+		Help: "The number of runs of the DB retry maintenance that failed.",
+	})
+
+	return func(r config.MaintenanceReport) {
+		for _, c := range r.Removed {
+			deleted.WithLabelValues(c.Topic, string(c.State)).Add(float64(c.Deleted))
+			archived.WithLabelValues(c.Topic, string(c.State)).Add(float64(c.Archived))
+		}
+		if r.Err != nil {
+			errs.Inc()
+		}
+	}
+}
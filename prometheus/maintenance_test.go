@@ -0,0 +1,58 @@
+package prometheus
+
+import (
+	"errors"
+	"testing"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+
+	"github.com/revdaalex/kafka-consumer-go/config"
+	"github.com/revdaalex/kafka-consumer-go/data/retry/store"
+)
+
+func TestNewMaintenanceReportHandler(t *testing.T) {
+	reg := prom.NewRegistry()
+	handler := NewMaintenanceReportHandler(reg)
+
+	handler(config.MaintenanceReport{Removed: []config.MaintenanceCount{
+		{Topic: "foo", State: store.StateSuccessful, Deleted: 3, Archived: 3},
+		{Topic: "bar", State: store.StateErrored, Deleted: 2},
+	}})
+	handler(config.MaintenanceReport{
+		Removed: []config.MaintenanceCount{{Topic: "foo", State: store.StateSuccessful, Deleted: 1, Archived: 1}},
+		Err:     errors.New("db error"),
+	})
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	got := map[string]float64{}
+	for _, f := range families {
+		for _, m := range f.GetMetric() {
+			name := f.GetName()
+			for _, l := range m.GetLabel() {
+				name += "," + l.GetValue()
+			}
+			got[name] = m.GetCounter().GetValue()
+		}
+	}
+
+	expected := map[string]float64{
+		"kafka_consumer_maintenance_deleted_total,successful,foo":  4,
+		"kafka_consumer_maintenance_deleted_total,errored,bar":     2,
+		"kafka_consumer_maintenance_archived_total,successful,foo": 4,
+		"kafka_consumer_maintenance_archived_total,errored,bar":    0,
+		"kafka_consumer_maintenance_errors_total":                  1,
+	}
+	for name, want := range expected {
+		if got[name] != want {
+			t.Errorf("expected %s to be %v, but got %v", name, want, got[name])
+		}
+	}
+
+	if len(got) != len(expected) {
+		t.Errorf("expected %d metrics, but got %d", len(expected), len(got))
+	}
+}
@@ -0,0 +1,197 @@
+package status
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// State of a consumer.
+type State string
+
+const (
+	StateNotStarted State = "not_started"
+	StateRunning    State = "running"
+	StateStopped    State = "stopped"
+	StateFailed     State = "failed"
+)
+
+// Tracker records what a consumer is doing, so that it can be reported while it runs, e.g. by the
+// admin API. It is safe for concurrent use, and all of its methods do nothing on a nil Tracker.
+type Tracker struct {
+	mu         sync.Mutex
+	state      State
+	startedAt  time.Time
+	stoppedAt  time.Time
+	err        string
+	claims     map[string][]int32
+	processors map[processorKey]*Processor
+}
+
+type processorKey struct {
+	topic    string
+	sequence uint8
+	parked   bool
+}
+
+// Snapshot is the status of a consumer at a point in time.
+type Snapshot struct {
+	State     State     `json:"state"`
+	StartedAt time.Time `json:"started_at"`
+	StoppedAt time.Time `json:"stopped_at"`
+	// Error that stopped the consumer, if it failed
+	Error string `json:"error,omitempty"`
+	// Claims holds the partitions of each topic that are currently assigned to the consumer
+	Claims map[string][]int32 `json:"claims"`
+	// Processors holds the DB retry processors that have claimed a batch, ordered by topic and sequence
+	Processors []Processor `json:"processors"`
+}
+
+// Processor is the status of the processor of a DB retry sequence, or of the parked messages of a topic.
+type Processor struct {
+	Topic    string `json:"topic"`
+	Sequence uint8  `json:"sequence"`
+	Parked   bool   `json:"parked"`
+	// LastBatchAt is when the processor last tried to claim a batch
+	LastBatchAt   time.Time `json:"last_batch_at"`
+	LastBatchSize int       `json:"last_batch_size"`
+	// Claimed is the number of retries claimed since the consumer started
+	Claimed   int    `json:"claimed"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+// NewTracker returns a Tracker for a consumer that has not started yet.
+func NewTracker() *Tracker {
+	return &Tracker{
+		state:      StateNotStarted,
+		claims:     map[string][]int32{},
+		processors: map[processorKey]*Processor{},
+	}
+}
+
+// Started records that the consumer is running.
+func (t *Tracker) Started() {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.state = StateRunning
+	t.startedAt = time.Now()
+	t.stoppedAt = time.Time{}
+	t.err = ""
+}
+
+// Stopped records that the consumer has stopped, and has failed if err is not nil.
+func (t *Tracker) Stopped(err error) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.state = StateStopped
+	t.stoppedAt = time.Now()
+	if err != nil {
+		t.state = StateFailed
+		t.err = err.Error()
+	}
+	t.claims = map[string][]int32{}
+}
+
+// AddClaims records the partitions assigned to the consumer in a consumer group session.
+func (t *Tracker) AddClaims(claims map[string][]int32) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for topic, partitions := range claims {
+		t.claims[topic] = append([]int32(nil), partitions...)
+	}
+}
+
+// RemoveClaims records that the partitions of a consumer group session are no longer assigned.
+func (t *Tracker) RemoveClaims(claims map[string][]int32) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for topic := range claims {
+		delete(t.claims, topic)
+	}
+}
+
+// BatchClaimed records an attempt by a DB retry processor to claim a batch of size retries.
+func (t *Tracker) BatchClaimed(topic string, sequence uint8, size int, err error) {
+	t.batchClaimed(processorKey{topic: topic, sequence: sequence}, size, err)
+}
+
+// ParkedBatchClaimed records an attempt by the parked message processor of a topic to claim a batch.
+func (t *Tracker) ParkedBatchClaimed(topic string, size int, err error) {
+	t.batchClaimed(processorKey{topic: topic, parked: true}, size, err)
+}
+
+func (t *Tracker) batchClaimed(key processorKey, size int, err error) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p, ok := t.processors[key]
+	if !ok {
+		p = &Processor{Topic: key.topic, Sequence: key.sequence, Parked: key.parked}
+		t.processors[key] = p
+	}
+
+	p.LastBatchAt = time.Now()
+	p.LastBatchSize = size
+	p.Claimed += size
+	p.LastError = ""
+	if err != nil {
+		p.LastError = err.Error()
+	}
+}
+
+// Snapshot returns the current status, which is not started for a nil Tracker.
+func (t *Tracker) Snapshot() Snapshot {
+	if t == nil {
+		return Snapshot{State: StateNotStarted, Claims: map[string][]int32{}, Processors: []Processor{}}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := Snapshot{
+		State:      t.state,
+		StartedAt:  t.startedAt,
+		StoppedAt:  t.stoppedAt,
+		Error:      t.err,
+		Claims:     map[string][]int32{},
+		Processors: []Processor{},
+	}
+
+	for topic, partitions := range t.claims {
+		s.Claims[topic] = append([]int32(nil), partitions...)
+	}
+
+	for _, p := range t.processors {
+		s.Processors = append(s.Processors, *p)
+	}
+	sort.Slice(s.Processors, func(i, j int) bool {
+		a, b := s.Processors[i], s.Processors[j]
+		if a.Topic != b.Topic {
+			return a.Topic < b.Topic
+		}
+		if a.Parked != b.Parked {
+			return b.Parked
+		}
+		return a.Sequence < b.Sequence
+	})
+
+	return s
+}
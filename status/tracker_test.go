@@ -0,0 +1,71 @@
+package status
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+)
+
+func TestTracker(t *testing.T) {
+	t.Run("it tracks the state of the consumer", func(t *testing.T) {
+		tr := NewTracker()
+		if s := tr.Snapshot(); s.State != StateNotStarted {
+			t.Errorf("expected the consumer not to be started, got %s", s.State)
+		}
+
+		tr.Started()
+		tr.AddClaims(map[string][]int32{"product": {0, 1}, "retry1.product": {0}})
+		tr.RemoveClaims(map[string][]int32{"retry1.product": {0}})
+
+		s := tr.Snapshot()
+		if s.State != StateRunning || s.StartedAt.IsZero() {
+			t.Errorf("expected the consumer to be running, got %+v", s)
+		}
+		if diff := deep.Equal(map[string][]int32{"product": {0, 1}}, s.Claims); diff != nil {
+			t.Error(diff)
+		}
+
+		tr.Stopped(errors.New("oops"))
+		if s := tr.Snapshot(); s.State != StateFailed || s.Error != "oops" || len(s.Claims) != 0 {
+			t.Errorf("expected the consumer to have failed, got %+v", s)
+		}
+	})
+
+	t.Run("it tracks the batches claimed by each processor", func(t *testing.T) {
+		tr := NewTracker()
+		tr.BatchClaimed("product", 2, 5, nil)
+		tr.ParkedBatchClaimed("product", 1, nil)
+		tr.BatchClaimed("product", 1, 10, nil)
+		tr.BatchClaimed("product", 1, 3, errors.New("oops"))
+
+		got := tr.Snapshot().Processors
+		for i := range got {
+			if got[i].LastBatchAt.IsZero() {
+				t.Errorf("expected the time of the last batch to be recorded for %+v", got[i])
+			}
+			got[i].LastBatchAt = time.Time{}
+		}
+
+		exp := []Processor{
+			{Topic: "product", Sequence: 1, LastBatchSize: 3, Claimed: 13, LastError: "oops"},
+			{Topic: "product", Sequence: 2, LastBatchSize: 5, Claimed: 5},
+			{Topic: "product", Parked: true, LastBatchSize: 1, Claimed: 1},
+		}
+
+		if diff := deep.Equal(exp, got); diff != nil {
+			t.Error(diff)
+		}
+	})
+
+	t.Run("a nil tracker reports a consumer that has not started", func(t *testing.T) {
+		var tr *Tracker
+		tr.Started()
+		tr.BatchClaimed("product", 1, 1, nil)
+
+		if s := tr.Snapshot(); s.State != StateNotStarted {
+			t.Errorf("expected the consumer not to be started, got %s", s.State)
+		}
+	})
+}
@@ -0,0 +1,131 @@
+package saramatest
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/IBM/sarama"
+)
+
+// MockAsyncProducer delivers every message sent to its input, either as a success or, while it has been told
+// to fail sends, as an error.
+type MockAsyncProducer struct {
+	input     chan *sarama.ProducerMessage
+	successes chan *sarama.ProducerMessage
+	errors    chan *sarama.ProducerError
+	closeOnce sync.Once
+
+	sent        map[string][]*sarama.ProducerMessage
+	failedSends int
+	closed      bool
+	sync.RWMutex
+}
+
+func NewMockAsyncProducer() *MockAsyncProducer {
+	p := &MockAsyncProducer{
+		input:     make(chan *sarama.ProducerMessage),
+		successes: make(chan *sarama.ProducerMessage, 100),
+		errors:    make(chan *sarama.ProducerError, 100),
+		sent:      map[string][]*sarama.ProducerMessage{},
+	}
+
+	go p.deliver()
+
+	return p
+}
+
+func (p *MockAsyncProducer) deliver() {
+	for msg := range p.input {
+		p.Lock()
+		if p.failedSends > 0 {
+			p.failedSends--
+			p.Unlock()
+			p.errors <- &sarama.ProducerError{Msg: msg, Err: errors.New("oops, send errored")}
+			continue
+		}
+		p.sent[msg.Topic] = append(p.sent[msg.Topic], msg)
+		p.Unlock()
+		p.successes <- msg
+	}
+
+	p.Lock()
+	p.closed = true
+	p.Unlock()
+	close(p.successes)
+	close(p.errors)
+}
+
+func (p *MockAsyncProducer) AsyncClose() {
+	p.closeOnce.Do(func() {
+		close(p.input)
+	})
+}
+
+func (p *MockAsyncProducer) Close() error {
+	p.AsyncClose()
+	for range p.successes {
+	}
+	for range p.errors {
+	}
+	return nil
+}
+
+func (p *MockAsyncProducer) Input() chan<- *sarama.ProducerMessage {
+	return p.input
+}
+
+func (p *MockAsyncProducer) Successes() <-chan *sarama.ProducerMessage {
+	return p.successes
+}
+
+func (p *MockAsyncProducer) Errors() <-chan *sarama.ProducerError {
+	return p.errors
+}
+
+// FailSends makes the next n messages fail to be delivered.
+func (p *MockAsyncProducer) FailSends(n int) {
+	p.Lock()
+	defer p.Unlock()
+	p.failedSends = n
+}
+
+// GetMessagesSent returns the messages successfully delivered to the topic, in the order they were sent.
+func (p *MockAsyncProducer) GetMessagesSent(topic string) []*sarama.ProducerMessage {
+	p.RLock()
+	defer p.RUnlock()
+	return p.sent[topic]
+}
+
+func (p *MockAsyncProducer) WasClosed() bool {
+	p.RLock()
+	defer p.RUnlock()
+	return p.closed
+}
+
+func (p *MockAsyncProducer) IsTransactional() bool {
+	return false
+}
+
+func (p *MockAsyncProducer) TxnStatus() sarama.ProducerTxnStatusFlag {
+	return sarama.ProducerTxnFlagReady
+}
+
+func (p *MockAsyncProducer) BeginTxn() error {
+	return nil
+}
+
+func (p *MockAsyncProducer) CommitTxn() error {
+	return nil
+}
+
+func (p *MockAsyncProducer) AbortTxn() error {
+	return nil
+}
+
+func (p *MockAsyncProducer) AddOffsetsToTxn(offsets map[string][]*sarama.PartitionOffsetMetadata, groupId string) error {
+	return nil
+}
+
+func (p *MockAsyncProducer) AddMessageToTxn(msg *sarama.ConsumerMessage, groupId string, metadata *string) error {
+	return nil
+}
@@ -0,0 +1,136 @@
+package saramatest
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/IBM/sarama"
+)
+
+// MockClusterAdmin is an in-memory sarama.ClusterAdmin. Only the methods used by this module are
+// implemented, calling any other method will panic.
+type MockClusterAdmin struct {
+	sarama.ClusterAdmin
+
+	topics        map[string]sarama.TopicDetail
+	acls          map[string][]sarama.ResourceAcls
+	created       []string
+	errorOnList   bool
+	errorOnCreate bool
+	closed        bool
+	sync.RWMutex
+}
+
+func NewMockClusterAdmin() *MockClusterAdmin {
+	return &MockClusterAdmin{
+		topics: map[string]sarama.TopicDetail{},
+		acls:   map[string][]sarama.ResourceAcls{},
+	}
+}
+
+func (ca *MockClusterAdmin) ListTopics() (map[string]sarama.TopicDetail, error) {
+	ca.RLock()
+	defer ca.RUnlock()
+
+	if ca.errorOnList {
+		return nil, errors.New("oops, list errored")
+	}
+
+	topics := make(map[string]sarama.TopicDetail, len(ca.topics))
+	for name, detail := range ca.topics {
+		topics[name] = detail
+	}
+
+	return topics, nil
+}
+
+func (ca *MockClusterAdmin) CreateTopic(topic string, detail *sarama.TopicDetail, validateOnly bool) error {
+	ca.Lock()
+	defer ca.Unlock()
+
+	if ca.errorOnCreate {
+		return errors.New("oops, create errored")
+	}
+
+	if _, ok := ca.topics[topic]; ok {
+		return &sarama.TopicError{Err: sarama.ErrTopicAlreadyExists}
+	}
+
+	if !validateOnly {
+		ca.topics[topic] = *detail
+		ca.created = append(ca.created, topic)
+	}
+
+	return nil
+}
+
+// ListAcls only filters by principal, the ACLs are returned in the order they were added.
+func (ca *MockClusterAdmin) ListAcls(filter sarama.AclFilter) ([]sarama.ResourceAcls, error) {
+	ca.RLock()
+	defer ca.RUnlock()
+
+	if ca.errorOnList {
+		return nil, errors.New("oops, list errored")
+	}
+
+	if filter.Principal == nil {
+		var all []sarama.ResourceAcls
+		for _, acls := range ca.acls {
+			all = append(all, acls...)
+		}
+		return all, nil
+	}
+
+	return ca.acls[*filter.Principal], nil
+}
+
+func (ca *MockClusterAdmin) Close() error {
+	ca.closed = true
+	return nil
+}
+
+func (ca *MockClusterAdmin) AddTopic(name string, detail sarama.TopicDetail) {
+	ca.Lock()
+	defer ca.Unlock()
+	ca.topics[name] = detail
+}
+
+// AddAcl grants or denies an operation on a topic resource to a principal, e.g. "User:alice".
+func (ca *MockClusterAdmin) AddAcl(principal string, resource sarama.Resource, op sarama.AclOperation, permission sarama.AclPermissionType) {
+	ca.Lock()
+	defer ca.Unlock()
+	ca.acls[principal] = append(ca.acls[principal], sarama.ResourceAcls{
+		Resource: resource,
+		Acls: []*sarama.Acl{{
+			Principal:      principal,
+			Host:           "*",
+			Operation:      op,
+			PermissionType: permission,
+		}},
+	})
+}
+
+func (ca *MockClusterAdmin) Topic(name string) (sarama.TopicDetail, bool) {
+	ca.RLock()
+	defer ca.RUnlock()
+	detail, ok := ca.topics[name]
+	return detail, ok
+}
+
+func (ca *MockClusterAdmin) CreatedTopics() []string {
+	ca.RLock()
+	defer ca.RUnlock()
+	return ca.created
+}
+
+func (ca *MockClusterAdmin) ErrorOnList() {
+	ca.errorOnList = true
+}
+
+func (ca *MockClusterAdmin) ErrorOnCreate() {
+	ca.errorOnCreate = true
+}
+
+func (ca *MockClusterAdmin) WasClosed() bool {
+	return ca.closed
+}
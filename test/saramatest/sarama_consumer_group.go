@@ -36,22 +36,37 @@ func NewMockConsumerGroup() *MockConsumerGroup {
 
 func (mg *MockConsumerGroup) Consume(ctx context.Context, topics []string, handler sarama.ConsumerGroupHandler) error {
 	mg.Lock()
-	defer mg.Unlock()
-
-	if mg.consumed {
-		return nil
-	}
-	mg.consumed = true
-
 	if mg.errorOnConsume {
+		if mg.consumed {
+			mg.Unlock()
+			<-ctx.Done()
+			return nil
+		}
+		mg.consumed = true
+		mg.Unlock()
 		return errors.New("something bad happened")
 	}
 
+	// each topic is only consumed once, as the mock may be shared by the consumer groups of several
+	// sets of topics
+	var pending []string
 	for _, topic := range topics {
-		_, ok := mg.consumedTopicCount[topic]
-		if !ok {
-			mg.consumedTopicCount[topic] = 0
+		if _, ok := mg.consumedTopicCount[topic]; !ok {
+			pending = append(pending, topic)
 		}
+	}
+
+	if len(pending) == 0 {
+		mg.Unlock()
+		// a real consumer group blocks for the whole session, so we wait here rather
+		// than have callers spin on Consume once the messages have been consumed
+		<-ctx.Done()
+		return nil
+	}
+	defer mg.Unlock()
+	mg.consumed = true
+
+	for _, topic := range pending {
 		mg.consumedTopicCount[topic]++
 
 		msgsToConsume := mg.messagesToConsumeForTopic(topic)
@@ -120,3 +135,11 @@ func (mg *MockConsumerGroup) messagesToConsumeForTopic(topic string) []*sarama.C
 	}
 	return msgs
 }
+
+func (mg *MockConsumerGroup) Pause(partitions map[string][]int32) {}
+
+func (mg *MockConsumerGroup) Resume(partitions map[string][]int32) {}
+
+func (mg *MockConsumerGroup) PauseAll() {}
+
+func (mg *MockConsumerGroup) ResumeAll() {}
@@ -9,8 +9,9 @@ import (
 
 type MockConsumerGroupSession struct {
 	sync.RWMutex
-	marked []*sarama.ConsumerMessage
-	ctx    context.Context
+	marked  []*sarama.ConsumerMessage
+	commits int
+	ctx     context.Context
 }
 
 func NewMockConsumerGroupSession() *MockConsumerGroupSession {
@@ -19,6 +20,13 @@ func NewMockConsumerGroupSession() *MockConsumerGroupSession {
 	}
 }
 
+// NewMockConsumerGroupSessionWithContext creates a session that ends when ctx is done.
+func NewMockConsumerGroupSessionWithContext(ctx context.Context) *MockConsumerGroupSession {
+	return &MockConsumerGroupSession{
+		ctx: ctx,
+	}
+}
+
 func (gs *MockConsumerGroupSession) Claims() map[string][]int32 {
 	return map[string][]int32{}
 }
@@ -32,6 +40,15 @@ func (gs *MockConsumerGroupSession) GenerationID() int32 {
 }
 
 func (gs *MockConsumerGroupSession) Commit() {
+	gs.Lock()
+	defer gs.Unlock()
+	gs.commits++
+}
+
+func (gs *MockConsumerGroupSession) CommitCount() int {
+	gs.RLock()
+	defer gs.RUnlock()
+	return gs.commits
 }
 
 func (gs *MockConsumerGroupSession) MarkOffset(topic string, partition int32, offset int64, metadata string) {
@@ -8,12 +8,29 @@ import (
 
 type MockSyncProducer struct {
 	recvd       map[string][][]byte
+	sent        map[string][]*sarama.ProducerMessage
 	returnError bool
+
+	// transaction state, the messages and offsets of a transaction are only kept once it is committed
+	inTxn          bool
+	txnMessages    []*sarama.ProducerMessage
+	txnOffsets     []*sarama.ConsumerMessage
+	committedTxns  []MockTxn
+	abortedTxns    int
+	errorOnCommit  bool
+	errorOnAddToTx bool
+}
+
+// MockTxn is a transaction committed by the MockSyncProducer.
+type MockTxn struct {
+	Messages []*sarama.ProducerMessage
+	Offsets  []*sarama.ConsumerMessage
 }
 
 func NewMockSyncProducer() *MockSyncProducer {
 	return &MockSyncProducer{
 		recvd: map[string][][]byte{},
+		sent:  map[string][]*sarama.ProducerMessage{},
 	}
 }
 
@@ -27,7 +44,13 @@ func (p *MockSyncProducer) SendMessage(msg *sarama.ProducerMessage) (partition i
 		panic(err)
 	}
 
+	if p.inTxn {
+		p.txnMessages = append(p.txnMessages, msg)
+		return 0, 0, nil
+	}
+
 	p.recvd[msg.Topic] = append(p.recvd[msg.Topic], b)
+	p.sent[msg.Topic] = append(p.sent[msg.Topic], msg)
 
 	return 0, 0, nil
 }
@@ -51,3 +74,78 @@ func (p *MockSyncProducer) GetLastMessageReceived(topic string) []byte {
 
 	return p.recvd[topic][0]
 }
+
+// GetMessagesSent returns the producer messages sent to the topic, in the order they were sent.
+func (p *MockSyncProducer) GetMessagesSent(topic string) []*sarama.ProducerMessage {
+	return p.sent[topic]
+}
+
+func (p *MockSyncProducer) TxnStatus() sarama.ProducerTxnStatusFlag {
+	if p.inTxn {
+		return sarama.ProducerTxnFlagInTransaction
+	}
+	return sarama.ProducerTxnFlagReady
+}
+
+func (p *MockSyncProducer) IsTransactional() bool {
+	return false
+}
+
+func (p *MockSyncProducer) BeginTxn() error {
+	if p.inTxn {
+		return errors.New("oops, transaction already open")
+	}
+	p.inTxn = true
+	return nil
+}
+
+func (p *MockSyncProducer) CommitTxn() error {
+	if p.errorOnCommit {
+		return errors.New("oops, commit errored")
+	}
+
+	p.committedTxns = append(p.committedTxns, MockTxn{Messages: p.txnMessages, Offsets: p.txnOffsets})
+	p.resetTxn()
+	return nil
+}
+
+func (p *MockSyncProducer) AbortTxn() error {
+	p.abortedTxns++
+	p.resetTxn()
+	return nil
+}
+
+func (p *MockSyncProducer) resetTxn() {
+	p.inTxn = false
+	p.txnMessages = nil
+	p.txnOffsets = nil
+}
+
+func (p *MockSyncProducer) AddOffsetsToTxn(offsets map[string][]*sarama.PartitionOffsetMetadata, groupId string) error {
+	return nil
+}
+
+func (p *MockSyncProducer) AddMessageToTxn(msg *sarama.ConsumerMessage, groupId string, metadata *string) error {
+	if p.errorOnAddToTx {
+		return errors.New("oops, add to transaction errored")
+	}
+	p.txnOffsets = append(p.txnOffsets, msg)
+	return nil
+}
+
+func (p *MockSyncProducer) ReturnErrorOnCommitTxn() {
+	p.errorOnCommit = true
+}
+
+func (p *MockSyncProducer) ReturnErrorOnAddMessageToTxn() {
+	p.errorOnAddToTx = true
+}
+
+// GetCommittedTxns returns the transactions committed, in the order they were committed.
+func (p *MockSyncProducer) GetCommittedTxns() []MockTxn {
+	return p.committedTxns
+}
+
+func (p *MockSyncProducer) GetAbortedTxnCount() int {
+	return p.abortedTxns
+}
@@ -0,0 +1,51 @@
+package consumer
+
+import (
+	"fmt"
+
+	"github.com/IBM/sarama"
+
+	"github.com/revdaalex/kafka-consumer-go/config"
+	"github.com/revdaalex/kafka-consumer-go/log"
+	"github.com/revdaalex/kafka-consumer-go/topics"
+)
+
+type clusterAdminConnector func(cluster config.KafkaCluster, saramaCfg *sarama.Config) (sarama.ClusterAdmin, error)
+
+func connectClusterAdmin(cluster config.KafkaCluster, saramaCfg *sarama.Config) (sarama.ClusterAdmin, error) {
+	return sarama.NewClusterAdmin(cluster.Host, saramaCfg)
+}
+
+// withClusterAdmins connects cluster admins for the main and the retry cluster, and closes them once f returns.
+func withClusterAdmins(cfg *config.Config, connect clusterAdminConnector, f func(main, retry sarama.ClusterAdmin) error) error {
+	mainAdmin, err := connect(cfg.Kafka, config.NewSaramaConfigForCluster(cfg.Kafka))
+	if err != nil {
+		return fmt.Errorf("unable to connect Kafka cluster admin: %w", err)
+	}
+	defer mainAdmin.Close()
+
+	retryAdmin, err := connect(cfg.RetryKafka, config.NewSaramaConfigForCluster(cfg.RetryKafka))
+	if err != nil {
+		return fmt.Errorf("unable to connect retry Kafka cluster admin: %w", err)
+	}
+	defer retryAdmin.Close()
+
+	return f(mainAdmin, retryAdmin)
+}
+
+// createMissingTopics creates the retry and deadLetter topics that do not exist yet in the retry cluster,
+// using the partitions of their source topic in the main cluster.
+func createMissingTopics(cfg *config.Config, connect clusterAdminConnector, logger log.Logger) error {
+	return withClusterAdmins(cfg, connect, func(mainAdmin, retryAdmin sarama.ClusterAdmin) error {
+		missing, err := topics.CreateMissing(cfg, mainAdmin, retryAdmin)
+		for _, t := range missing {
+			if t.Created {
+				logger.Infof("created topic '%s' with %d partitions", t.Name, t.NumPartitions)
+			} else {
+				logger.Infof("topic '%s' is missing and would be created with %d partitions", t.Name, t.NumPartitions)
+			}
+		}
+
+		return err
+	})
+}
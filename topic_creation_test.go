@@ -0,0 +1,68 @@
+package consumer
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/go-test/deep"
+
+	"github.com/revdaalex/kafka-consumer-go/config"
+	"github.com/revdaalex/kafka-consumer-go/log"
+	"github.com/revdaalex/kafka-consumer-go/test/saramatest"
+)
+
+func TestCreateMissingTopics(t *testing.T) {
+	cfg, err := config.NewBuilder().
+		SetKafkaHost([]string{"main:9092"}).
+		SetRetryKafkaHost([]string{"retry:9092"}).
+		SetKafkaGroup("group").
+		SetSourceTopics([]string{"product"}).
+		SetRetryIntervals([]int{60}).
+		EnableTopicCreation(true).
+		Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("it creates the missing topics in the retry cluster", func(t *testing.T) {
+		mainAdmin := saramatest.NewMockClusterAdmin()
+		mainAdmin.AddTopic("product", sarama.TopicDetail{NumPartitions: 6, ReplicationFactor: 3})
+		retryAdmin := saramatest.NewMockClusterAdmin()
+
+		var connectedTo [][]string
+		connect := func(cluster config.KafkaCluster, _ *sarama.Config) (sarama.ClusterAdmin, error) {
+			connectedTo = append(connectedTo, cluster.Host)
+			if len(connectedTo) == 1 {
+				return mainAdmin, nil
+			}
+			return retryAdmin, nil
+		}
+
+		if err := createMissingTopics(cfg, connect, log.NullLogger{}); err != nil {
+			t.Fatalf("did not expect error: %s", err)
+		}
+
+		if diff := deep.Equal([][]string{{"main:9092"}, {"retry:9092"}}, connectedTo); diff != nil {
+			t.Error(diff)
+		}
+
+		if diff := deep.Equal([]string{"retry1.group.product", "deadLetter.group.product"}, retryAdmin.CreatedTopics()); diff != nil {
+			t.Error(diff)
+		}
+
+		if !mainAdmin.WasClosed() || !retryAdmin.WasClosed() {
+			t.Error("expected cluster admins to be closed")
+		}
+	})
+
+	t.Run("it errors if the cluster admin cannot connect", func(t *testing.T) {
+		connect := func(cluster config.KafkaCluster, _ *sarama.Config) (sarama.ClusterAdmin, error) {
+			return nil, errors.New("oops")
+		}
+
+		if err := createMissingTopics(cfg, connect, log.NullLogger{}); err == nil {
+			t.Error("expected error when the cluster admin cannot connect")
+		}
+	})
+}
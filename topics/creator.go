@@ -0,0 +1,116 @@
+package topics
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/IBM/sarama"
+
+	"github.com/revdaalex/kafka-consumer-go/config"
+)
+
+// ClusterAdmin is the subset of sarama.ClusterAdmin that is needed to manage and check topics.
+type ClusterAdmin interface {
+	ListTopics() (map[string]sarama.TopicDetail, error)
+	CreateTopic(topic string, detail *sarama.TopicDetail, validateOnly bool) error
+	ListAcls(filter sarama.AclFilter) ([]sarama.ResourceAcls, error)
+}
+
+// Topic describes a retry or dead-letter topic that was missing from the cluster.
+type Topic struct {
+	Name              string
+	SourceTopic       string
+	NumPartitions     int32
+	ReplicationFactor int16
+	// Created is false if the topic was only reported because of a dry run
+	Created bool
+}
+
+// CreateMissing will create the retry and dead-letter topics from the config's topic chains that
+// do not exist yet in the retry cluster, or only the dead-letter topics if database retries are used and
+// their dead letters are published to Kafka. Each topic gets the same number of partitions as its source
+// topic, which is looked up in the main cluster. The topics that were missing are returned, and when
+// cfg.TopicCreation.DryRun is enabled they are only returned, not created.
+func CreateMissing(cfg *config.Config, main, retry ClusterAdmin) ([]Topic, error) {
+	sourceTopics, err := main.ListTopics()
+	if err != nil {
+		return nil, fmt.Errorf("topics: unable to list topics in the main cluster: %w", err)
+	}
+
+	existing, err := retry.ListTopics()
+	if err != nil {
+		return nil, fmt.Errorf("topics: unable to list topics in the retry cluster: %w", err)
+	}
+
+	var missing []Topic
+	for _, mainTopic := range cfg.MainTopics() {
+		source, ok := sourceTopics[mainTopic]
+		if !ok {
+			return missing, fmt.Errorf("topics: source topic '%s' does not exist", mainTopic)
+		}
+
+		for _, t := range publishedTopics(cfg, mainTopic) {
+			if _, ok := existing[t.Name]; ok {
+				continue
+			}
+
+			topic := Topic{
+				Name:              t.Name,
+				SourceTopic:       mainTopic,
+				NumPartitions:     source.NumPartitions,
+				ReplicationFactor: replicationFactor(cfg.TopicCreation, source),
+			}
+
+			if !cfg.TopicCreation.DryRun {
+				if err = retry.CreateTopic(topic.Name, topicDetail(cfg.TopicCreation, topic), false); err != nil && !errors.Is(err, sarama.ErrTopicAlreadyExists) {
+					return missing, fmt.Errorf("topics: unable to create topic '%s': %w", topic.Name, err)
+				}
+				topic.Created = true
+			}
+
+			missing = append(missing, topic)
+		}
+	}
+
+	return missing, nil
+}
+
+// publishedTopics returns the topics derived from the main topic that are published to in the retry cluster.
+// With database retries, only dead letters are published to Kafka, and only if that is enabled.
+func publishedTopics(cfg *config.Config, mainTopic string) []*config.KafkaTopic {
+	derived := cfg.DerivedTopics(mainTopic)
+	if !cfg.UseDBForRetryQueue {
+		return derived
+	}
+	if cfg.PublishDBDeadLetters && len(derived) > 0 {
+		return derived[len(derived)-1:]
+	}
+	return nil
+}
+
+// publishesToRetryCluster returns true if any topic is published to in the retry cluster.
+func publishesToRetryCluster(cfg *config.Config) bool {
+	return !cfg.UseDBForRetryQueue || cfg.PublishDBDeadLetters
+}
+
+func replicationFactor(tc config.TopicCreation, source sarama.TopicDetail) int16 {
+	if tc.ReplicationFactor > 0 {
+		return tc.ReplicationFactor
+	}
+	return source.ReplicationFactor
+}
+
+func topicDetail(tc config.TopicCreation, t Topic) *sarama.TopicDetail {
+	detail := &sarama.TopicDetail{
+		NumPartitions:     t.NumPartitions,
+		ReplicationFactor: t.ReplicationFactor,
+	}
+
+	if tc.Retention > 0 {
+		retentionMs := strconv.FormatInt(tc.Retention.Milliseconds(), 10)
+		detail.ConfigEntries = map[string]*string{"retention.ms": &retentionMs}
+	}
+
+	return detail
+}
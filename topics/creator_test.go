@@ -0,0 +1,150 @@
+package topics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/go-test/deep"
+
+	"github.com/revdaalex/kafka-consumer-go/config"
+	"github.com/revdaalex/kafka-consumer-go/test/saramatest"
+)
+
+func TestCreateMissing(t *testing.T) {
+	newAdmins := func() (*saramatest.MockClusterAdmin, *saramatest.MockClusterAdmin) {
+		main := saramatest.NewMockClusterAdmin()
+		main.AddTopic("product", sarama.TopicDetail{NumPartitions: 12, ReplicationFactor: 3})
+		retry := saramatest.NewMockClusterAdmin()
+		retry.AddTopic("retry1.group.product", sarama.TopicDetail{NumPartitions: 12, ReplicationFactor: 3})
+		return main, retry
+	}
+
+	t.Run("it creates the missing topics with the partitions of the source topic", func(t *testing.T) {
+		main, retry := newAdmins()
+		cfg := newTestConfig(t, config.TopicCreation{Enable: true})
+
+		got, err := CreateMissing(cfg, main, retry)
+		if err != nil {
+			t.Fatalf("did not expect error: %s", err)
+		}
+
+		exp := []Topic{
+			{Name: "retry2.group.product", SourceTopic: "product", NumPartitions: 12, ReplicationFactor: 3, Created: true},
+			{Name: "deadLetter.group.product", SourceTopic: "product", NumPartitions: 12, ReplicationFactor: 3, Created: true},
+		}
+		if diff := deep.Equal(exp, got); diff != nil {
+			t.Error(diff)
+		}
+
+		if diff := deep.Equal([]string{"retry2.group.product", "deadLetter.group.product"}, retry.CreatedTopics()); diff != nil {
+			t.Error(diff)
+		}
+
+		if len(main.CreatedTopics()) != 0 {
+			t.Error("did not expect topics to be created in the main cluster")
+		}
+	})
+
+	t.Run("it uses the configured replication factor and retention", func(t *testing.T) {
+		main, retry := newAdmins()
+		cfg := newTestConfig(t, config.TopicCreation{Enable: true, ReplicationFactor: 2, Retention: time.Hour * 24})
+
+		if _, err := CreateMissing(cfg, main, retry); err != nil {
+			t.Fatalf("did not expect error: %s", err)
+		}
+
+		detail, ok := retry.Topic("deadLetter.group.product")
+		if !ok {
+			t.Fatal("expected deadLetter topic to be created")
+		}
+
+		if detail.ReplicationFactor != 2 {
+			t.Errorf("expected replication factor of 2, but got %d", detail.ReplicationFactor)
+		}
+
+		retention, ok := detail.ConfigEntries["retention.ms"]
+		if !ok || *retention != "86400000" {
+			t.Error("expected retention.ms to be set to 86400000")
+		}
+	})
+
+	t.Run("it only reports the missing topics on a dry run", func(t *testing.T) {
+		main, retry := newAdmins()
+		cfg := newTestConfig(t, config.TopicCreation{Enable: true, DryRun: true})
+
+		got, err := CreateMissing(cfg, main, retry)
+		if err != nil {
+			t.Fatalf("did not expect error: %s", err)
+		}
+
+		if len(got) != 2 || got[0].Created || got[1].Created {
+			t.Errorf("expected 2 topics to be reported but not created, got %+v", got)
+		}
+
+		if len(retry.CreatedTopics()) != 0 {
+			t.Error("did not expect topics to be created on a dry run")
+		}
+	})
+
+	t.Run("it only creates the deadLetter topics when publishing DB dead letters", func(t *testing.T) {
+		main, retry := newAdmins()
+		cfg := newTestConfig(t, config.TopicCreation{Enable: true})
+		cfg.UseDBForRetryQueue = true
+		cfg.PublishDBDeadLetters = true
+
+		if _, err := CreateMissing(cfg, main, retry); err != nil {
+			t.Fatalf("did not expect error: %s", err)
+		}
+
+		if diff := deep.Equal([]string{"deadLetter.group.product"}, retry.CreatedTopics()); diff != nil {
+			t.Error(diff)
+		}
+	})
+
+	t.Run("it errors if the source topic does not exist", func(t *testing.T) {
+		_, retry := newAdmins()
+		cfg := newTestConfig(t, config.TopicCreation{Enable: true})
+
+		if _, err := CreateMissing(cfg, saramatest.NewMockClusterAdmin(), retry); err == nil {
+			t.Error("expected error for missing source topic")
+		}
+	})
+
+	t.Run("it errors if the topics cannot be listed", func(t *testing.T) {
+		main, retry := newAdmins()
+		retry.ErrorOnList()
+		cfg := newTestConfig(t, config.TopicCreation{Enable: true})
+
+		if _, err := CreateMissing(cfg, main, retry); err == nil {
+			t.Error("expected error when listing topics fails")
+		}
+	})
+
+	t.Run("it errors if a topic cannot be created", func(t *testing.T) {
+		main, retry := newAdmins()
+		retry.ErrorOnCreate()
+		cfg := newTestConfig(t, config.TopicCreation{Enable: true})
+
+		if _, err := CreateMissing(cfg, main, retry); err == nil {
+			t.Error("expected error when creating a topic fails")
+		}
+	})
+}
+
+func newTestConfig(t *testing.T, tc config.TopicCreation) *config.Config {
+	cfg, err := config.NewBuilder().
+		SetKafkaHost([]string{"localhost"}).
+		SetKafkaGroup("group").
+		SetSourceTopics([]string{"product"}).
+		SetRetryIntervals([]int{60, 120}).
+		EnableTopicCreation(tc.Enable).
+		TopicCreationDryRun(tc.DryRun).
+		SetTopicReplicationFactor(tc.ReplicationFactor).
+		SetTopicRetention(tc.Retention).
+		Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
@@ -0,0 +1,207 @@
+package topics
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/IBM/sarama"
+
+	"github.com/revdaalex/kafka-consumer-go/config"
+)
+
+// ProblemKind identifies the type of problem found with a topic by the preflight check.
+type ProblemKind string
+
+const (
+	ProblemMissingTopic      ProblemKind = "missing_topic"
+	ProblemPartitionMismatch ProblemKind = "partition_mismatch"
+	ProblemNoProduceRights   ProblemKind = "no_produce_rights"
+)
+
+// Problem is a single issue with a topic that will stop messages flowing through the topic chain.
+type Problem struct {
+	Topic       string
+	SourceTopic string
+	Kind        ProblemKind
+	Message     string
+}
+
+// TopicStatus is the state of a single topic as seen by the preflight check.
+type TopicStatus struct {
+	Name        string
+	SourceTopic string
+	// Retry is true if the topic lives in the retry cluster
+	Retry         bool
+	Exists        bool
+	NumPartitions int32
+}
+
+// Report is the result of a preflight check of the configured topic chains.
+type Report struct {
+	Topics   []TopicStatus
+	Problems []Problem
+	// Warnings are checks that could not be completed, they are not counted as problems
+	Warnings []string
+}
+
+// OK returns true if no problems were found.
+func (r *Report) OK() bool {
+	return len(r.Problems) == 0
+}
+
+// Err returns an error describing all the problems found, or nil if there are none.
+func (r *Report) Err() error {
+	if r.OK() {
+		return nil
+	}
+
+	msgs := make([]string, len(r.Problems))
+	for i, p := range r.Problems {
+		msgs[i] = p.Message
+	}
+
+	return fmt.Errorf("topics: preflight check found %d problem(s): %s", len(r.Problems), strings.Join(msgs, "; "))
+}
+
+func (r *Report) addProblem(t TopicStatus, kind ProblemKind, format string, args ...interface{}) {
+	r.Problems = append(r.Problems, Problem{
+		Topic:       t.Name,
+		SourceTopic: t.SourceTopic,
+		Kind:        kind,
+		Message:     fmt.Sprintf(format, args...),
+	})
+}
+
+// Preflight inspects every topic in the config's topic chains. Source topics must exist in the main cluster.
+// The topics that are published to in the retry cluster must exist there with the same number of partitions as
+// their source topic, and the retry cluster SASL user must be allowed to produce to them. These are the retry and
+// deadLetter topics, or with database retries only the deadLetter topics if dead letters are published to Kafka.
+// Produce rights are only checked when a SASL user is configured and has ACLs in the retry cluster. The returned
+// error is only set if the check itself could not be run.
+func Preflight(cfg *config.Config, main, retry ClusterAdmin) (*Report, error) {
+	report := &Report{}
+
+	sourceTopics, err := main.ListTopics()
+	if err != nil {
+		return nil, fmt.Errorf("topics: unable to list topics in the main cluster: %w", err)
+	}
+
+	var retryTopics map[string]sarama.TopicDetail
+	var acls *topicAcls
+	if publishesToRetryCluster(cfg) {
+		if retryTopics, err = retry.ListTopics(); err != nil {
+			return nil, fmt.Errorf("topics: unable to list topics in the retry cluster: %w", err)
+		}
+
+		if acls, err = listTopicAcls(retry, cfg.RetryKafka.SASLUser); err != nil {
+			return nil, fmt.Errorf("topics: unable to list ACLs in the retry cluster: %w", err)
+		}
+
+		if cfg.RetryKafka.SASLUser != "" && acls == nil {
+			report.Warnings = append(report.Warnings, fmt.Sprintf("no ACLs found for SASL user '%s', produce rights were not checked", cfg.RetryKafka.SASLUser))
+		}
+	}
+
+	for _, mainTopic := range cfg.MainTopics() {
+		source, ok := sourceTopics[mainTopic]
+		status := TopicStatus{Name: mainTopic, SourceTopic: mainTopic, Exists: ok, NumPartitions: source.NumPartitions}
+		report.Topics = append(report.Topics, status)
+
+		if !ok {
+			report.addProblem(status, ProblemMissingTopic, "source topic '%s' does not exist", mainTopic)
+		}
+
+		for _, t := range publishedTopics(cfg, mainTopic) {
+			detail, ok := retryTopics[t.Name]
+			status := TopicStatus{Name: t.Name, SourceTopic: mainTopic, Retry: true, Exists: ok, NumPartitions: detail.NumPartitions}
+			report.Topics = append(report.Topics, status)
+
+			if !ok {
+				report.addProblem(status, ProblemMissingTopic, "topic '%s' does not exist", t.Name)
+				continue
+			}
+
+			if source.NumPartitions > 0 && detail.NumPartitions != source.NumPartitions {
+				report.addProblem(status, ProblemPartitionMismatch, "topic '%s' has %d partitions but source topic '%s' has %d", t.Name, detail.NumPartitions, mainTopic, source.NumPartitions)
+			}
+
+			if acls != nil && !acls.canProduce(t.Name) {
+				report.addProblem(status, ProblemNoProduceRights, "SASL user '%s' cannot produce to topic '%s'", cfg.RetryKafka.SASLUser, t.Name)
+			}
+		}
+	}
+
+	return report, nil
+}
+
+// topicAcls are the topic ACLs of a single principal, including those granted to every user.
+type topicAcls struct {
+	resources []sarama.ResourceAcls
+}
+
+// listTopicAcls returns nil if there is no user, or the user has no topic ACLs. Sarama does not return an error
+// when the cluster has no authorizer, so no ACLs at all cannot be told apart from no rights.
+func listTopicAcls(admin ClusterAdmin, user string) (*topicAcls, error) {
+	if user == "" {
+		return nil, nil
+	}
+
+	// ACLs are filtered by an exact match on the principal, so those of the wildcard principal are listed separately
+	var resources []sarama.ResourceAcls
+	for _, principal := range []string{"User:" + user, "User:*"} {
+		principal := principal
+		acls, err := admin.ListAcls(sarama.AclFilter{
+			ResourceType:              sarama.AclResourceTopic,
+			ResourcePatternTypeFilter: sarama.AclPatternAny,
+			Principal:                 &principal,
+			Operation:                 sarama.AclOperationAny,
+			PermissionType:            sarama.AclPermissionAny,
+		})
+		if err != nil {
+			return nil, err
+		}
+		resources = append(resources, acls...)
+	}
+
+	if len(resources) == 0 {
+		return nil, nil
+	}
+
+	return &topicAcls{resources: resources}, nil
+}
+
+// canProduce follows the Kafka authorizer rules, where a matching deny wins over any allow.
+func (a *topicAcls) canProduce(topic string) bool {
+	allowed := false
+	for _, r := range a.resources {
+		if !resourceMatches(r.Resource, topic) {
+			continue
+		}
+
+		for _, acl := range r.Acls {
+			if acl.Operation != sarama.AclOperationWrite && acl.Operation != sarama.AclOperationAll {
+				continue
+			}
+
+			switch acl.PermissionType {
+			case sarama.AclPermissionDeny:
+				return false
+			case sarama.AclPermissionAllow:
+				allowed = true
+			}
+		}
+	}
+
+	return allowed
+}
+
+func resourceMatches(r sarama.Resource, topic string) bool {
+	switch r.ResourcePatternType {
+	case sarama.AclPatternLiteral:
+		return r.ResourceName == "*" || r.ResourceName == topic
+	case sarama.AclPatternPrefixed:
+		return strings.HasPrefix(topic, r.ResourceName)
+	default:
+		return false
+	}
+}
@@ -0,0 +1,190 @@
+package topics
+
+import (
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/go-test/deep"
+
+	"github.com/revdaalex/kafka-consumer-go/config"
+	"github.com/revdaalex/kafka-consumer-go/test/saramatest"
+)
+
+func TestPreflight(t *testing.T) {
+	newAdmins := func() (*saramatest.MockClusterAdmin, *saramatest.MockClusterAdmin) {
+		main := saramatest.NewMockClusterAdmin()
+		main.AddTopic("product", sarama.TopicDetail{NumPartitions: 12})
+		retry := saramatest.NewMockClusterAdmin()
+		retry.AddTopic("retry1.group.product", sarama.TopicDetail{NumPartitions: 12})
+		retry.AddTopic("retry2.group.product", sarama.TopicDetail{NumPartitions: 12})
+		retry.AddTopic("deadLetter.group.product", sarama.TopicDetail{NumPartitions: 12})
+		return main, retry
+	}
+
+	t.Run("it reports no problems for a healthy topic chain", func(t *testing.T) {
+		main, retry := newAdmins()
+
+		report, err := Preflight(newTestConfig(t, config.TopicCreation{}), main, retry)
+		if err != nil {
+			t.Fatalf("did not expect error: %s", err)
+		}
+
+		if !report.OK() || report.Err() != nil {
+			t.Errorf("expected no problems, got %+v", report.Problems)
+		}
+
+		exp := []TopicStatus{
+			{Name: "product", SourceTopic: "product", Exists: true, NumPartitions: 12},
+			{Name: "retry1.group.product", SourceTopic: "product", Retry: true, Exists: true, NumPartitions: 12},
+			{Name: "retry2.group.product", SourceTopic: "product", Retry: true, Exists: true, NumPartitions: 12},
+			{Name: "deadLetter.group.product", SourceTopic: "product", Retry: true, Exists: true, NumPartitions: 12},
+		}
+		if diff := deep.Equal(exp, report.Topics); diff != nil {
+			t.Error(diff)
+		}
+	})
+
+	t.Run("it reports missing topics and mismatched partitions", func(t *testing.T) {
+		main := saramatest.NewMockClusterAdmin()
+		retry := saramatest.NewMockClusterAdmin()
+		main.AddTopic("product", sarama.TopicDetail{NumPartitions: 12})
+		retry.AddTopic("retry1.group.product", sarama.TopicDetail{NumPartitions: 6})
+		retry.AddTopic("deadLetter.group.product", sarama.TopicDetail{NumPartitions: 12})
+
+		report, err := Preflight(newTestConfig(t, config.TopicCreation{}), main, retry)
+		if err != nil {
+			t.Fatalf("did not expect error: %s", err)
+		}
+
+		exp := []Problem{
+			{Topic: "retry1.group.product", SourceTopic: "product", Kind: ProblemPartitionMismatch, Message: "topic 'retry1.group.product' has 6 partitions but source topic 'product' has 12"},
+			{Topic: "retry2.group.product", SourceTopic: "product", Kind: ProblemMissingTopic, Message: "topic 'retry2.group.product' does not exist"},
+		}
+		if diff := deep.Equal(exp, report.Problems); diff != nil {
+			t.Error(diff)
+		}
+
+		if report.Err() == nil {
+			t.Error("expected report error")
+		}
+	})
+
+	t.Run("it reports a missing source topic", func(t *testing.T) {
+		_, retry := newAdmins()
+
+		report, err := Preflight(newTestConfig(t, config.TopicCreation{}), saramatest.NewMockClusterAdmin(), retry)
+		if err != nil {
+			t.Fatalf("did not expect error: %s", err)
+		}
+
+		if len(report.Problems) != 1 || report.Problems[0].Kind != ProblemMissingTopic || report.Problems[0].Topic != "product" {
+			t.Errorf("expected missing source topic problem, got %+v", report.Problems)
+		}
+	})
+
+	t.Run("it only checks source topics when using database retries", func(t *testing.T) {
+		main, _ := newAdmins()
+		cfg := newTestConfig(t, config.TopicCreation{})
+		cfg.UseDBForRetryQueue = true
+
+		report, err := Preflight(cfg, main, saramatest.NewMockClusterAdmin())
+		if err != nil {
+			t.Fatalf("did not expect error: %s", err)
+		}
+
+		if !report.OK() || len(report.Topics) != 1 {
+			t.Errorf("expected only the source topic to be checked, got %+v", report)
+		}
+	})
+
+	t.Run("it checks the deadLetter topics when publishing DB dead letters", func(t *testing.T) {
+		main, _ := newAdmins()
+		cfg := newTestConfig(t, config.TopicCreation{})
+		cfg.UseDBForRetryQueue = true
+		cfg.PublishDBDeadLetters = true
+
+		report, err := Preflight(cfg, main, saramatest.NewMockClusterAdmin())
+		if err != nil {
+			t.Fatalf("did not expect error: %s", err)
+		}
+
+		if len(report.Problems) != 1 || report.Problems[0].Topic != "deadLetter.group.product" || report.Problems[0].Kind != ProblemMissingTopic {
+			t.Errorf("expected only the missing deadLetter topic to be reported, got %+v", report.Problems)
+		}
+	})
+
+	t.Run("it reports topics that the SASL user cannot produce to", func(t *testing.T) {
+		main, retry := newAdmins()
+		principal := "User:consumer"
+		retry.AddAcl(principal, sarama.Resource{ResourceType: sarama.AclResourceTopic, ResourceName: "retry", ResourcePatternType: sarama.AclPatternPrefixed}, sarama.AclOperationWrite, sarama.AclPermissionAllow)
+		retry.AddAcl(principal, sarama.Resource{ResourceType: sarama.AclResourceTopic, ResourceName: "retry2.group.product", ResourcePatternType: sarama.AclPatternLiteral}, sarama.AclOperationAll, sarama.AclPermissionDeny)
+
+		cfg := newTestConfig(t, config.TopicCreation{})
+		cfg.RetryKafka.SASLUser = "consumer"
+
+		report, err := Preflight(cfg, main, retry)
+		if err != nil {
+			t.Fatalf("did not expect error: %s", err)
+		}
+
+		var got []string
+		for _, p := range report.Problems {
+			if p.Kind == ProblemNoProduceRights {
+				got = append(got, p.Topic)
+			}
+		}
+
+		if diff := deep.Equal([]string{"retry2.group.product", "deadLetter.group.product"}, got); diff != nil {
+			t.Error(diff)
+		}
+	})
+
+	t.Run("it allows produce rights granted to every user", func(t *testing.T) {
+		main, retry := newAdmins()
+		retry.AddAcl("User:*", sarama.Resource{ResourceType: sarama.AclResourceTopic, ResourceName: "*", ResourcePatternType: sarama.AclPatternLiteral}, sarama.AclOperationWrite, sarama.AclPermissionAllow)
+		retry.AddAcl("User:consumer", sarama.Resource{ResourceType: sarama.AclResourceTopic, ResourceName: "deadLetter", ResourcePatternType: sarama.AclPatternPrefixed}, sarama.AclOperationWrite, sarama.AclPermissionDeny)
+
+		cfg := newTestConfig(t, config.TopicCreation{})
+		cfg.RetryKafka.SASLUser = "consumer"
+
+		report, err := Preflight(cfg, main, retry)
+		if err != nil {
+			t.Fatalf("did not expect error: %s", err)
+		}
+
+		var got []string
+		for _, p := range report.Problems {
+			if p.Kind == ProblemNoProduceRights {
+				got = append(got, p.Topic)
+			}
+		}
+
+		if diff := deep.Equal([]string{"deadLetter.group.product"}, got); diff != nil {
+			t.Error(diff)
+		}
+	})
+
+	t.Run("it warns when produce rights cannot be checked", func(t *testing.T) {
+		main, retry := newAdmins()
+		cfg := newTestConfig(t, config.TopicCreation{})
+		cfg.RetryKafka.SASLUser = "consumer"
+
+		report, err := Preflight(cfg, main, retry)
+		if err != nil {
+			t.Fatalf("did not expect error: %s", err)
+		}
+
+		if !report.OK() || len(report.Warnings) != 1 {
+			t.Errorf("expected a warning and no problems, got %+v", report)
+		}
+	})
+
+	t.Run("it errors if the topics cannot be listed", func(t *testing.T) {
+		main, retry := newAdmins()
+		main.ErrorOnList()
+
+		if _, err := Preflight(newTestConfig(t, config.TopicCreation{}), main, retry); err == nil {
+			t.Error("expected error when listing topics fails")
+		}
+	})
+}
@@ -6,6 +6,11 @@ var (
 	maxConnectionAttempts      = 20
 	connectionInterval         = time.Second * 1
 	dbRetryPollInterval        = time.Second * 5
+	dbRetrySafetyPollInterval  = time.Minute * 1
+	dbRetryMessageTimeout      = time.Second * 30
+	dbRetryBatchTimeout        = time.Minute * 5
+	dbRetryLeaseRenewInterval  = time.Second * 10
 	defaultMaintenanceInterval = time.Hour * 1
 	defaultKafkaConnector      = connectToKafka
+	defaultAdminConnector      = connectClusterAdmin
 )